@@ -24,6 +24,7 @@ import (
 	"github.com/gohugoio/hashstructure"
 
 	"github.com/elastic/beats/v7/filebeat/input"
+	"github.com/elastic/beats/v7/libbeat/api"
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/cfgfile"
 	conf "github.com/elastic/elastic-agent-libs/config"
@@ -113,6 +114,17 @@ func (c *crawler) Start(
 	return nil
 }
 
+// addReloaders registers the reloaders of the input and module config
+// files with server, so that they can be reloaded on request.
+func (c *crawler) addReloaders(server *api.Server) {
+	if c.inputReloader != nil && c.inputReloader.ReloadEnabled() {
+		server.AddReloader("inputs", c.inputReloader)
+	}
+	if c.modulesReloader != nil && c.modulesReloader.ReloadEnabled() {
+		server.AddReloader("modules", c.modulesReloader)
+	}
+}
+
 func (c *crawler) startInput(
 	pipeline beat.PipelineConnector,
 	config *conf.C,
@@ -499,6 +499,9 @@ func (fb *Filebeat) Run(b *beat.Beat) error {
 		cancelPipelineFactoryCtx()
 		return fmt.Errorf("Failed to start crawler: %w", err) //nolint:staticcheck //Keep old behavior
 	}
+	if b.API != nil {
+		crawler.addReloaders(b.API)
+	}
 
 	// If run once, add crawler completion check as alternative to done signal
 	if *once {
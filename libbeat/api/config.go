@@ -17,7 +17,10 @@
 
 package api
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 // StateInspectorConfig holds the configuration for the state store inspector.
 type StateInspectorConfig struct {
@@ -29,14 +32,65 @@ type DebugConfig struct {
 	StateInspector StateInspectorConfig `config:"state_inspector"`
 }
 
+// HealthConfig holds the thresholds above which the health endpoint reports
+// the Beat as degraded. A threshold of 0 disables its check.
+type HealthConfig struct {
+	// MaxActiveEvents is the number of events in the pipeline waiting to
+	// be acknowledged by the output.
+	MaxActiveEvents int `config:"max_active_events" validate:"min=0"`
+
+	// MaxOutputErrorRate is the ratio of the events failed or dropped by
+	// the output to the events it received during the last complete
+	// ErrorRateWindow.
+	MaxOutputErrorRate float64 `config:"max_output_error_rate" validate:"min=0, max=1"`
+
+	// ErrorRateWindow is the interval over which the output error rate
+	// is computed.
+	ErrorRateWindow time.Duration `config:"error_rate_window" validate:"positive"`
+}
+
+// StatsResetConfig holds the configuration for the endpoint resetting the
+// counters of the stats registry.
+type StatsResetConfig struct {
+	Enabled bool `config:"enabled"`
+}
+
+// ReloadConfig holds the configuration for the endpoint triggering a
+// reload of the Beat configuration.
+type ReloadConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// Token, if set, must be sent as a bearer token in the Authorization
+	// header of reload requests.
+	Token string `config:"token"`
+}
+
+// AccessLogConfig holds the configuration for logging the requests served
+// by the API endpoint.
+type AccessLogConfig struct {
+	Enabled bool `config:"enabled"`
+}
+
+// CORSConfig holds the origins of the web pages allowed to read the
+// responses of the API endpoint. If no origins are set, no CORS headers are
+// sent. The "*" origin allows all origins.
+type CORSConfig struct {
+	AllowedOrigins []string `config:"allowed_origins"`
+}
+
 // Config is the configuration for the API endpoint.
 type Config struct {
-	Enabled            bool        `config:"enabled"`
-	Host               string      `config:"host"`
-	Port               int         `config:"port"`
-	User               string      `config:"named_pipe.user"`
-	SecurityDescriptor string      `config:"named_pipe.security_descriptor"`
-	Debug              DebugConfig `config:"debug"`
+	Enabled            bool             `config:"enabled"`
+	Host               string           `config:"host"`
+	Port               int              `config:"port"`
+	User               string           `config:"named_pipe.user"`
+	SecurityDescriptor string           `config:"named_pipe.security_descriptor"`
+	Debug              DebugConfig      `config:"debug"`
+	Health             HealthConfig     `config:"health"`
+	StatsReset         StatsResetConfig `config:"stats_reset"`
+	AccessLog          AccessLogConfig  `config:"access_log"`
+	Reload             ReloadConfig     `config:"reload"`
+	CORS               CORSConfig       `config:"cors"`
 }
 
 // DefaultConfig is the default configuration used by the API endpoint.
@@ -44,6 +98,9 @@ var DefaultConfig = Config{
 	Enabled: false,
 	Host:    "localhost",
 	Port:    5066,
+	Health: HealthConfig{
+		ErrorRateWindow: time.Minute,
+	},
 }
 
 // File mode for the socket file, owner of the process can do everything, member of the group can read.
@@ -0,0 +1,75 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// corsMaxAge is the time browsers may cache the result of a preflight
+// request.
+const corsMaxAge = 10 * time.Minute
+
+// allowCORS wraps h to let web pages from the given origins read its
+// responses. Preflight OPTIONS requests are answered without calling h.
+// Requests from other origins are served without CORS headers, so browsers
+// block the pages from reading the responses, and their preflight requests
+// are rejected. POST requests change the state of the Beat, so they are only
+// allowed from the origins listed explicitly, not from the "*" wildcard.
+func allowCORS(origins []string, h http.Handler) http.Handler {
+	anyOrigin := slices.Contains(origins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		w.Header().Add("Vary", "Origin")
+		if !anyOrigin && !slices.Contains(origins, origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			h.ServeHTTP(w, r)
+			return
+		}
+		methods := "GET, OPTIONS"
+		if slices.Contains(origins, origin) {
+			methods = "GET, POST, OPTIONS"
+		} else if r.Header.Get("Access-Control-Request-Method") == http.MethodPost {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,125 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestCORS(t *testing.T) {
+	newServer := func(t *testing.T, origins ...string) *Server {
+		cfg := config.MustNewConfigFrom(map[string]any{
+			"host":                 "http://localhost:0",
+			"cors.allowed_origins": origins,
+		})
+		logger := logptest.NewTestingLogger(t, "")
+		s, err := NewWithDefaultRoutes(logger, cfg, beatmonitoring.NewMonitoring())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.Stop() })
+		return s
+	}
+
+	request := func(s *Server, method, path, origin string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://"+s.l.Addr().String()+path, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("allowed origin", func(t *testing.T) {
+		s := newServer(t, "https://dashboard.example.com")
+		resp := request(s, http.MethodGet, "/stats", "https://dashboard.example.com", nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "https://dashboard.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, resp.Header().Values("Vary"), "Origin")
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		s := newServer(t, "*")
+		resp := request(s, http.MethodGet, "/", "https://other.example.com", nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "https://other.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		s := newServer(t, "https://dashboard.example.com")
+		resp := request(s, http.MethodGet, "/stats", "https://evil.example.com", nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+
+		resp = request(s, http.MethodOptions, "/stats", "https://evil.example.com", map[string]string{
+			"Access-Control-Request-Method": http.MethodGet,
+		})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		s := newServer(t, "https://dashboard.example.com")
+		resp := request(s, http.MethodOptions, "/stats", "https://dashboard.example.com", map[string]string{
+			"Access-Control-Request-Method":  http.MethodGet,
+			"Access-Control-Request-Headers": "Content-Type",
+		})
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "https://dashboard.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST, OPTIONS", resp.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type", resp.Header().Get("Access-Control-Allow-Headers"))
+		assert.Empty(t, resp.Body.String())
+	})
+
+	t.Run("preflight from any origin", func(t *testing.T) {
+		s := newServer(t, "*")
+		resp := request(s, http.MethodOptions, "/stats", "https://other.example.com", map[string]string{
+			"Access-Control-Request-Method": http.MethodGet,
+		})
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "GET, OPTIONS", resp.Header().Get("Access-Control-Allow-Methods"))
+
+		// POST requests change the state of the Beat, so they are not
+		// allowed from any origin.
+		resp = request(s, http.MethodOptions, "/stats/reset", "https://other.example.com", map[string]string{
+			"Access-Control-Request-Method":  http.MethodPost,
+			"Access-Control-Request-Headers": "Content-Type",
+		})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		s := newServer(t)
+		resp := request(s, http.MethodGet, "/stats", "https://dashboard.example.com", nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.NotContains(t, resp.Header().Values("Vary"), "Origin")
+	})
+}
@@ -0,0 +1,145 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// Keys of the stats registry metrics the health checks are based on.
+const (
+	healthActiveEventsKey  = "libbeat.pipeline.events.active"
+	healthOutputTotalKey   = "libbeat.output.events.total"
+	healthOutputFailedKey  = "libbeat.output.events.failed"
+	healthOutputDroppedKey = "libbeat.output.events.dropped"
+)
+
+// healthHandler reports whether the Beat is healthy, based on the metrics
+// of the stats registry and the thresholds of its configuration.
+type healthHandler struct {
+	registry *monitoring.Registry
+	config   HealthConfig
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// The output event counters at the start of the current error rate
+	// window, and the error rate of the last complete window. The rate
+	// is sampled on a timer so that it doesn't depend on how often the
+	// health is checked.
+	mu              sync.Mutex
+	lastTotal       int64
+	lastOutputError int64
+	errorRate       float64
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newHealthHandler(registry *monitoring.Registry, config HealthConfig) *healthHandler {
+	h := &healthHandler{registry: registry, config: config, done: make(chan struct{})}
+	h.lastTotal, h.lastOutputError = h.outputCounters()
+	if config.MaxOutputErrorRate > 0 {
+		h.wg.Add(1)
+		go h.run()
+	}
+	return h
+}
+
+// run samples the output error rate once per window until stop is called.
+func (h *healthHandler) run() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.config.ErrorRateWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			h.sample()
+		}
+	}
+}
+
+// stop stops the sampling of the output error rate.
+func (h *healthHandler) stop() {
+	close(h.done)
+	h.wg.Wait()
+}
+
+// sample ends the current error rate window and starts a new one.
+func (h *healthHandler) sample() {
+	total, outputErrors := h.outputCounters()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	newTotal, newErrors := total-h.lastTotal, outputErrors-h.lastOutputError
+	h.lastTotal, h.lastOutputError = total, outputErrors
+	h.errorRate = 0
+	if newTotal > 0 {
+		// The error counters go down if they are reset, which must not
+		// yield a negative rate.
+		h.errorRate = max(float64(newErrors)/float64(newTotal), 0)
+	}
+}
+
+// outputCounters returns the number of events received by the output and
+// the number of events it failed or dropped.
+func (h *healthHandler) outputCounters() (total, outputErrors int64) {
+	snapshot := monitoring.CollectFlatSnapshot(h.registry, monitoring.Full, false)
+	return snapshot.Ints[healthOutputTotalKey], snapshot.Ints[healthOutputFailedKey] + snapshot.Ints[healthOutputDroppedKey]
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	resp := healthResponse{Status: "healthy"}
+	if reason := h.check(); reason != "" {
+		resp = healthResponse{Status: "degraded", Reason: reason}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// check returns the reason the Beat is degraded, or an empty string if it
+// is healthy.
+func (h *healthHandler) check() string {
+	if limit := h.config.MaxActiveEvents; limit > 0 {
+		snapshot := monitoring.CollectFlatSnapshot(h.registry, monitoring.Full, false)
+		if active := snapshot.Ints[healthActiveEventsKey]; active > int64(limit) {
+			return fmt.Sprintf("%d active events in the pipeline exceed the limit of %d", active, limit)
+		}
+	}
+	if limit := h.config.MaxOutputErrorRate; limit > 0 {
+		h.mu.Lock()
+		rate := h.errorRate
+		h.mu.Unlock()
+		if rate > limit {
+			return fmt.Sprintf("output error rate of %.2f exceeds the limit of %.2f", rate, limit)
+		}
+	}
+	return ""
+}
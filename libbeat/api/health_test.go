@@ -0,0 +1,175 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestHealthRoute(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host":                         "http://localhost:0",
+		"health.max_active_events":     100,
+		"health.max_output_error_rate": 0.1,
+		// The error rate is sampled explicitly below.
+		"health.error_rate_window": "1h",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	libbeat := mon.StatsRegistry().GetOrCreateRegistry("libbeat")
+	active := monitoring.NewUint(libbeat.GetOrCreateRegistry("pipeline"), "events.active")
+	output := libbeat.GetOrCreateRegistry("output")
+	total := monitoring.NewUint(output, "events.total")
+	failed := monitoring.NewUint(output, "events.failed")
+	dropped := monitoring.NewUint(output, "events.dropped")
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	health := func() (int, healthResponse) {
+		req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/health", nil)
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+		var body healthResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		return resp.Code, body
+	}
+
+	// Healthy snapshot.
+	active.Set(10)
+	total.Set(100)
+	failed.Set(5)
+	code, body := health()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: "healthy"}, body)
+
+	// Too many active events.
+	active.Set(101)
+	code, body = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "degraded", body.Status)
+	assert.Contains(t, body.Reason, "active events")
+
+	// Errors are not reported until the end of the error rate window.
+	active.Set(10)
+	total.Add(100)
+	failed.Add(10)
+	dropped.Add(10)
+	code, _ = health()
+	assert.Equal(t, http.StatusOK, code)
+
+	// Too many output errors in the last window. Repeated checks report
+	// the same rate.
+	s.health.sample()
+	for range 2 {
+		code, body = health()
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "degraded", body.Status)
+		assert.Contains(t, body.Reason, "output error rate")
+	}
+
+	// The output recovers once new events are published without errors.
+	total.Add(100)
+	s.health.sample()
+	code, body = health()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: "healthy"}, body)
+}
+
+func TestHealthRouteSampling(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host":                         "http://localhost:0",
+		"health.max_output_error_rate": 0.1,
+		"health.error_rate_window":     "10ms",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	output := mon.StatsRegistry().GetOrCreateRegistry("libbeat").GetOrCreateRegistry("output")
+	total := monitoring.NewUint(output, "events.total")
+	failed := monitoring.NewUint(output, "events.failed")
+	total.Set(100)
+	failed.Set(100)
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	// Keep failing events so that every window has a high error rate.
+	assert.Eventually(t, func() bool {
+		total.Add(10)
+		failed.Add(10)
+		req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/health", nil)
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+		return resp.Code == http.StatusServiceUnavailable
+	}, 5*time.Second, 10*time.Millisecond, "the error rate should be sampled on a timer")
+}
+
+func TestHealthErrorRateCountersReset(t *testing.T) {
+	registry := monitoring.NewRegistry()
+	output := registry.GetOrCreateRegistry("libbeat").GetOrCreateRegistry("output")
+	total := monitoring.NewUint(output, "events.total")
+	failed := monitoring.NewUint(output, "events.failed")
+	total.Set(100)
+	failed.Set(50)
+
+	h := newHealthHandler(registry, HealthConfig{})
+	total.Add(100)
+	failed.Set(0)
+	h.sample()
+	assert.Zero(t, h.errorRate, "resetting the error counters should not yield a negative error rate")
+}
+
+func TestHealthRouteDisabledChecks(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	libbeat := mon.StatsRegistry().GetOrCreateRegistry("libbeat")
+	monitoring.NewUint(libbeat.GetOrCreateRegistry("pipeline"), "events.active").Set(1e6)
+	output := libbeat.GetOrCreateRegistry("output")
+	monitoring.NewUint(output, "events.total").Set(100)
+	monitoring.NewUint(output, "events.failed").Set(100)
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/health", nil)
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code, "no threshold is set by default")
+	assert.JSONEq(t, `{"status":"healthy"}`, resp.Body.String())
+}
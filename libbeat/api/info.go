@@ -0,0 +1,43 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/version"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// makeInfoHandler returns the handler of the /info route, which serves the
+// name and version of the Beat with the commit and time it was built from.
+// Unlike the info registry served by the root route, its fields are fixed,
+// so tooling can rely on them.
+func makeInfoHandler(beatName, beatVersion string) http.HandlerFunc {
+	data := mapstr.M{
+		"beat":       beatName,
+		"version":    beatVersion,
+		"commit":     version.Commit(),
+		"build_time": version.BuildTime().UTC().Format(time.RFC3339),
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		prettyPrint(w, data, r)
+	}
+}
@@ -0,0 +1,81 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
+	"github.com/elastic/beats/v7/libbeat/version"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestInfoRoute(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutesAndInfo(logger, cfg, beatmonitoring.NewMonitoring(), "testbeat", "9.1.0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/info", nil)
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header().Get("Content-Type"))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	assert.Len(t, got, 4)
+	for _, key := range []string{"beat", "version", "commit", "build_time"} {
+		require.Contains(t, got, key)
+		assert.IsType(t, "", got[key], "%s must be a string", key)
+	}
+	assert.Equal(t, "testbeat", got["beat"])
+	assert.Equal(t, "9.1.0", got["version"])
+	assert.Equal(t, version.Commit(), got["commit"])
+	buildTime, err := time.Parse(time.RFC3339, got["build_time"].(string))
+	require.NoError(t, err)
+	assert.True(t, version.BuildTime().Truncate(time.Second).Equal(buildTime))
+}
+
+func TestInfoRouteNotRegistered(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, beatmonitoring.NewMonitoring())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/info", nil)
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code, "the /info route should only be served when the beat info is given")
+}
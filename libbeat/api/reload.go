@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Reloader reloads a part of the Beat configuration, such as the inputs
+// or modules loaded from external config files.
+type Reloader interface {
+	Reload() error
+}
+
+type reloadResponse struct {
+	Reloaded []string          `json:"reloaded,omitempty"`
+	Failed   map[string]string `json:"failed,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// makeReloadHandler runs all the reloaders returned by reloaders on a POST
+// request and reports which of them succeeded or failed. Requests are
+// rejected unless config.Enabled is set, and must carry config.Token if it is
+// set. Requests must have the application/json content type, so that
+// browsers send a CORS preflight request before cross-origin requests.
+func makeReloadHandler(reloaders func() ([]string, []Reloader), config ReloadConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !config.Enabled {
+			writeReload(w, http.StatusForbidden, reloadResponse{
+				Error: "reloading the configuration is disabled, set http.reload.enabled to enable it",
+			})
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeReload(w, http.StatusMethodNotAllowed, reloadResponse{
+				Error: "only POST requests are allowed",
+			})
+			return
+		}
+		if config.Token != "" && !hasBearerToken(r, config.Token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeReload(w, http.StatusUnauthorized, reloadResponse{
+				Error: "a valid token is required to reload the configuration",
+			})
+			return
+		}
+		if !isJSONRequest(r) {
+			writeReload(w, http.StatusUnsupportedMediaType, reloadResponse{
+				Error: "the request must have the application/json content type",
+			})
+			return
+		}
+
+		names, rls := reloaders()
+		if len(rls) == 0 {
+			writeReload(w, http.StatusNotFound, reloadResponse{
+				Error: "no configuration can be reloaded, enable config reloading in the Beat settings",
+			})
+			return
+		}
+
+		var resp reloadResponse
+		for i, rl := range rls {
+			if err := rl.Reload(); err != nil {
+				if resp.Failed == nil {
+					resp.Failed = map[string]string{}
+				}
+				resp.Failed[names[i]] = err.Error()
+				continue
+			}
+			resp.Reloaded = append(resp.Reloaded, names[i])
+		}
+
+		status := http.StatusOK
+		if len(resp.Failed) > 0 {
+			status = http.StatusInternalServerError
+			resp.Error = "reloading the configuration failed"
+		}
+		writeReload(w, status, resp)
+	}
+}
+
+func writeReload(w http.ResponseWriter, status int, resp reloadResponse) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// hasBearerToken reports whether the Authorization header of r holds token
+// as a bearer token.
+func hasBearerToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,166 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+type stubReloader struct {
+	calls int
+	err   error
+}
+
+func (r *stubReloader) Reload() error {
+	r.calls++
+	return r.err
+}
+
+func TestReloadRoute(t *testing.T) {
+	newServerWith := func(t *testing.T, settings map[string]any) *Server {
+		settings["host"] = "http://localhost:0"
+		cfg := config.MustNewConfigFrom(settings)
+		logger := logptest.NewTestingLogger(t, "")
+		s, err := NewWithDefaultRoutes(logger, cfg, beatmonitoring.NewMonitoring())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.Stop() })
+		return s
+	}
+	newServer := func(t *testing.T, enabled bool) *Server {
+		return newServerWith(t, map[string]any{"reload.enabled": enabled})
+	}
+
+	reloadWith := func(t *testing.T, s *Server, method string, header map[string]string) (int, reloadResponse) {
+		req := httptest.NewRequest(method, "http://"+s.l.Addr().String()+"/reload", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+		var got reloadResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+		return resp.Code, got
+	}
+	reload := func(t *testing.T, s *Server, method string) (int, reloadResponse) {
+		return reloadWith(t, s, method, map[string]string{"Content-Type": "application/json"})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		s := newServer(t, true)
+		inputs, modules := &stubReloader{}, &stubReloader{}
+		s.AddReloader("modules", modules)
+		s.AddReloader("inputs", inputs)
+
+		code, body := reload(t, s, http.MethodPost)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"inputs", "modules"}, body.Reloaded)
+		assert.Empty(t, body.Failed)
+		assert.Empty(t, body.Error)
+		assert.Equal(t, 1, inputs.calls)
+		assert.Equal(t, 1, modules.calls)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		s := newServer(t, true)
+		inputs := &stubReloader{}
+		modules := &stubReloader{err: errors.New("invalid module config")}
+		s.AddReloader("inputs", inputs)
+		s.AddReloader("modules", modules)
+
+		code, body := reload(t, s, http.MethodPost)
+		assert.Equal(t, http.StatusInternalServerError, code)
+		assert.Equal(t, []string{"inputs"}, body.Reloaded)
+		assert.Equal(t, map[string]string{"modules": "invalid module config"}, body.Failed)
+		assert.NotEmpty(t, body.Error)
+		assert.Equal(t, 1, modules.calls)
+	})
+
+	t.Run("no reloaders", func(t *testing.T) {
+		s := newServer(t, true)
+		code, _ := reload(t, s, http.MethodPost)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		s := newServer(t, true)
+		inputs := &stubReloader{}
+		s.AddReloader("inputs", inputs)
+
+		code, _ := reload(t, s, http.MethodGet)
+		assert.Equal(t, http.StatusMethodNotAllowed, code)
+		assert.Zero(t, inputs.calls)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := newServer(t, false)
+		inputs := &stubReloader{}
+		s.AddReloader("inputs", inputs)
+
+		code, _ := reload(t, s, http.MethodPost)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Zero(t, inputs.calls)
+	})
+
+	t.Run("simple cross-origin request", func(t *testing.T) {
+		s := newServer(t, true)
+		inputs := &stubReloader{}
+		s.AddReloader("inputs", inputs)
+
+		// Browsers send text/plain POST requests from any page without a
+		// CORS preflight request.
+		code, _ := reloadWith(t, s, http.MethodPost, map[string]string{
+			"Origin":       "https://evil.example.com",
+			"Content-Type": "text/plain",
+		})
+		assert.Equal(t, http.StatusUnsupportedMediaType, code)
+		assert.Zero(t, inputs.calls)
+	})
+
+	t.Run("token", func(t *testing.T) {
+		s := newServerWith(t, map[string]any{"reload.enabled": true, "reload.token": "secret"})
+		inputs := &stubReloader{}
+		s.AddReloader("inputs", inputs)
+
+		code, _ := reload(t, s, http.MethodPost)
+		assert.Equal(t, http.StatusUnauthorized, code, "a request without the token should be rejected")
+		code, _ = reloadWith(t, s, http.MethodPost, map[string]string{
+			"Content-Type":  "application/json",
+			"Authorization": "Bearer wrong",
+		})
+		assert.Equal(t, http.StatusUnauthorized, code, "a request with a wrong token should be rejected")
+		assert.Zero(t, inputs.calls)
+
+		code, _ = reloadWith(t, s, http.MethodPost, map[string]string{
+			"Content-Type":  "application/json",
+			"Authorization": "Bearer secret",
+		})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, inputs.calls)
+	})
+}
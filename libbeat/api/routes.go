@@ -18,10 +18,19 @@
 package api
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
 	"github.com/elastic/elastic-agent-libs/config"
@@ -34,18 +43,42 @@ type LookupFunc func(string) *monitoring.Registry
 
 // NewWithDefaultRoutes creates a new server with default API routes.
 func NewWithDefaultRoutes(log *logp.Logger, config *config.C, mon beatmonitoring.Monitoring) (*Server, error) {
+	return newWithDefaultRoutes(log, config, mon, nil)
+}
+
+// NewWithDefaultRoutesAndInfo creates a new server with default API routes
+// and the /info route, which serves beatName and beatVersion.
+func NewWithDefaultRoutesAndInfo(log *logp.Logger, config *config.C, mon beatmonitoring.Monitoring, beatName, beatVersion string) (*Server, error) {
+	return newWithDefaultRoutes(log, config, mon, makeInfoHandler(beatName, beatVersion))
+}
+
+// newWithDefaultRoutes creates a new server with default API routes, and
+// the /info route if info is not nil.
+func newWithDefaultRoutes(log *logp.Logger, config *config.C, mon beatmonitoring.Monitoring, info http.HandlerFunc) (*Server, error) {
 	api, err := New(log, config)
 	if err != nil {
 		return nil, err
 	}
 
+	api.health = newHealthHandler(mon.StatsRegistry(), api.config.Health)
 	err = errors.Join(
 		api.AttachHandler("/", makeRootAPIHandler(makeAPIHandler(mon.InfoRegistry()))),
 		api.AttachHandler("/state", makeAPIHandler(mon.StateRegistry())),
 		api.AttachHandler("/stats", makeAPIHandler(mon.StatsRegistry())),
+		api.AttachHandler("/stats/", makeLookupAPIHandler("/stats/", mon.StatsRegistry().GetRegistry)),
+		api.AttachHandler("/stats/reset", makeStatsResetHandler(mon.StatsRegistry(), api.config.StatsReset.Enabled)),
+		api.AttachHandler("/reload", makeReloadHandler(api.getReloaders, api.config.Reload)),
 		api.AttachHandler("/dataset", makeAPIHandler(mon.InputsRegistry())),
+		api.AttachHandler("/metrics", makePrometheusHandler(mon.StatsRegistry())),
+		api.AttachHandler("/debug/vars", makeExpvarHandler(mon.StatsRegistry())),
+		api.AttachHandler("/health", api.health),
 	)
+	if err == nil && info != nil {
+		err = api.AttachHandler("/info", info)
+	}
 	if err != nil {
+		// Stop the health sampling and close the listener.
+		_ = api.Stop()
 		return nil, err
 	}
 
@@ -72,15 +105,225 @@ func makeAPIHandler(registry *monitoring.Registry) http.HandlerFunc {
 			false,
 		)
 
-		prettyPrint(w, data, r.URL)
+		prettyPrint(w, filterSnapshot(data, r), r)
+	}
+}
+
+// filterSnapshot returns the subtrees of data named by the comma-separated
+// dotted keys of the filter query parameter of r, or data if there is no
+// filter. Keys that are not in data are ignored.
+func filterSnapshot(data mapstr.M, r *http.Request) mapstr.M {
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		return data
+	}
+	filtered := mapstr.M{}
+	for _, key := range strings.Split(filter, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if v, err := data.GetValue(key); err == nil {
+			_, _ = filtered.Put(key, v)
+		}
+	}
+	return filtered
+}
+
+// makeLookupAPIHandler serves the snapshot of the registry that lookup returns
+// for the path of the request below prefix, whose segments are the names of
+// the nested registries. For example, with the /stats/ prefix, the path
+// /stats/libbeat/output serves the libbeat.output registry. If there is no
+// such registry, 404 Not Found is returned.
+func makeLookupAPIHandler(prefix string, lookup LookupFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		registry := lookup(strings.ReplaceAll(name, "/", "."))
+		if registry == nil {
+			http.NotFound(w, r)
+			return
+		}
+		makeAPIHandler(registry)(w, r)
 	}
 }
 
-func prettyPrint(w http.ResponseWriter, data mapstr.M, u *url.URL) {
-	query := u.Query()
-	if _, ok := query["pretty"]; ok {
-		fmt.Fprint(w, data.StringToPrint())
+// gzipMinSize is the size in bytes below which responses are sent
+// uncompressed, as compressing them isn't worth the overhead.
+const gzipMinSize = 1024
+
+func prettyPrint(w http.ResponseWriter, data mapstr.M, r *http.Request) {
+	var body string
+	if _, ok := r.URL.Query()["pretty"]; ok {
+		body = data.StringToPrint()
 	} else {
-		fmt.Fprint(w, data.String())
+		body = data.String()
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < gzipMinSize || !acceptsGzip(r) {
+		fmt.Fprint(w, body)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	_, _ = io.WriteString(gz, body)
+	_ = gz.Close()
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of r allows a gzip
+// encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !ok {
+				return true
+			}
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+	}
+	return false
+}
+
+// makePrometheusHandler serves the metrics of registry in the Prometheus text
+// exposition format.
+func makePrometheusHandler(registry *monitoring.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		data := monitoring.CollectStructSnapshot(
+			registry,
+			monitoring.Full,
+			false,
+		)
+
+		writePrometheus(w, data)
 	}
 }
+
+// makeExpvarHandler serves the metrics of registry in the layout of the
+// expvar package, with a JSON value for each top-level key of the registry
+// and the cmdline and memstats variables published by expvar.
+func makeExpvarHandler(registry *monitoring.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		data := monitoring.CollectStructSnapshot(
+			registry,
+			monitoring.Full,
+			false,
+		)
+		vars := map[string]any{"cmdline": os.Args}
+		var memStats runtime.MemStats
+		runtime.ReadMemStats(&memStats)
+		vars["memstats"] = memStats
+		for key, value := range data {
+			vars[key] = value
+		}
+
+		writeExpvar(w, vars)
+	}
+}
+
+// writeExpvar writes vars sorted by key, one per line, like the handler of
+// the expvar package.
+func writeExpvar(w io.Writer, vars map[string]any) {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	fmt.Fprint(w, "{\n")
+	first := true
+	for _, key := range keys {
+		value, err := json.Marshal(vars[key])
+		if err != nil {
+			continue
+		}
+		if !first {
+			fmt.Fprint(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", key, value)
+	}
+	fmt.Fprint(w, "\n}\n")
+}
+
+// invalidPrometheusChars matches the characters not allowed in Prometheus
+// metric names.
+var invalidPrometheusChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// writePrometheus writes the numeric and boolean metrics in data, sorted by
+// name. Nested keys are joined with underscores. Integer metrics are counters
+// unless they are known gauges, other metrics are gauges, and strings are
+// skipped.
+func writePrometheus(w io.Writer, data mapstr.M) {
+	flat := data.Flatten()
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		var value, metricType string
+		switch v := flat[key].(type) {
+		case int64:
+			value, metricType = strconv.FormatInt(v, 10), "counter"
+			if beatmonitoring.IsGauge(key) {
+				metricType = "gauge"
+			}
+		case float64:
+			value, metricType = strconv.FormatFloat(v, 'g', -1, 64), "gauge"
+		case bool:
+			value, metricType = "0", "gauge"
+			if v {
+				value = "1"
+			}
+		default:
+			continue
+		}
+
+		name := invalidPrometheusChars.ReplaceAllString(strings.ReplaceAll(key, ".", "_"), "_")
+		fmt.Fprintf(w, "# TYPE %s %s\n%s %s\n", name, metricType, name, value)
+	}
+}
+
+// logRequests wraps h to log the method, path, status and duration of each
+// request it serves. Request bodies and query parameters are not logged.
+func logRequests(log *logp.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		log.Infow("API request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// statusRecorder records the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap allows http.ResponseController to reach the wrapped ResponseWriter.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,293 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestPrometheusRoute(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	output := mon.StatsRegistry().GetOrCreateRegistry("libbeat").GetOrCreateRegistry("output")
+	monitoring.NewUint(output, "events.acked").Set(42)
+	monitoring.NewUint(output, "events.active").Set(3)
+	monitoring.NewBool(output, "circuit_breaker.open").Set(true)
+	monitoring.NewFloat(output, "ratio").Set(0.5)
+	monitoring.NewString(output, "name").Set("elasticsearch")
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/metrics", nil)
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Type"), "text/plain")
+	assert.Equal(t, `# TYPE libbeat_output_circuit_breaker_open gauge
+libbeat_output_circuit_breaker_open 1
+# TYPE libbeat_output_events_acked counter
+libbeat_output_events_acked 42
+# TYPE libbeat_output_events_active gauge
+libbeat_output_events_active 3
+# TYPE libbeat_output_ratio gauge
+libbeat_output_ratio 0.5
+`, resp.Body.String())
+}
+
+func TestExpvarRoute(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	output := mon.StatsRegistry().GetOrCreateRegistry("libbeat").GetOrCreateRegistry("output")
+	monitoring.NewUint(output, "events.acked").Set(42)
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/debug/vars", nil)
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Type"), "application/json")
+
+	var vars map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &vars))
+	assert.Contains(t, vars, "cmdline")
+	assert.Contains(t, vars, "memstats")
+
+	var libbeat struct {
+		Output struct {
+			Events struct {
+				Acked uint64 `json:"acked"`
+			} `json:"events"`
+		} `json:"output"`
+	}
+	require.NoError(t, json.Unmarshal(vars["libbeat"], &libbeat))
+	assert.Equal(t, uint64(42), libbeat.Output.Events.Acked)
+}
+
+func TestStatsLookupRoute(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	output := mon.StatsRegistry().GetOrCreateRegistry("libbeat").GetOrCreateRegistry("output")
+	monitoring.NewUint(output, "events.acked").Set(42)
+	monitoring.NewString(output, "type").Set("elasticsearch")
+	monitoring.NewUint(mon.StatsRegistry().GetOrCreateRegistry("libbeat"), "config.reloads").Set(1)
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+path, nil)
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("nested registry", func(t *testing.T) {
+		resp := get("/stats/libbeat/output")
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Header().Get("Content-Type"), "application/json")
+		assert.JSONEq(t, `{"events":{"acked":42},"type":"elasticsearch"}`, resp.Body.String())
+	})
+
+	t.Run("missing registry", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/stats/libbeat/missing").Code)
+	})
+
+	t.Run("metric", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/stats/libbeat/output/type").Code, "only registries should be served")
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("/stats/reset").Code, "the reset route should still be used, and is disabled by default")
+	})
+}
+
+func TestAPIRouteFilter(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	libbeat := mon.StatsRegistry().GetOrCreateRegistry("libbeat")
+	monitoring.NewUint(libbeat, "output.events.acked").Set(42)
+	monitoring.NewUint(libbeat, "output.events.failed").Set(1)
+	monitoring.NewUint(libbeat, "pipeline.events.total").Set(43)
+	monitoring.NewUint(libbeat, "config.reloads").Set(2)
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	tests := map[string]struct {
+		filter string
+		want   string
+	}{
+		"single key": {
+			filter: "libbeat.output.events",
+			want:   `{"libbeat":{"output":{"events":{"acked":42,"failed":1}}}}`,
+		},
+		"multiple keys": {
+			filter: "libbeat.output.events.acked, libbeat.pipeline",
+			want:   `{"libbeat":{"output":{"events":{"acked":42}},"pipeline":{"events":{"total":43}}}}`,
+		},
+		"unknown key": {
+			filter: "libbeat.config.reloads,libbeat.missing,,",
+			want:   `{"libbeat":{"config":{"reloads":2}}}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/stats?filter="+url.QueryEscape(tc.filter), nil)
+			resp := httptest.NewRecorder()
+			s.mux.ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.want, resp.Body.String())
+		})
+	}
+}
+
+func TestAPIRouteGzip(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	libbeat := mon.StatsRegistry().GetOrCreateRegistry("libbeat")
+	for i := 0; i < 100; i++ {
+		monitoring.NewUint(libbeat, fmt.Sprintf("counter_%d", i)).Set(uint64(i))
+	}
+	monitoring.NewString(mon.StateRegistry(), "name").Set("test")
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	for _, path := range []string{"/stats", "/stats?pretty"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			s.mux.ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			require.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+
+			gz, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(gz)
+			require.NoError(t, err)
+
+			var got map[string]map[string]uint64
+			require.NoError(t, json.Unmarshal(body, &got))
+			assert.Len(t, got["libbeat"], 100)
+			assert.Equal(t, uint64(42), got["libbeat"]["counter_42"])
+		})
+	}
+
+	t.Run("small payload", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/state", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get("Content-Encoding"))
+		assert.JSONEq(t, `{"name":"test"}`, resp.Body.String())
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/stats", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=0, identity")
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get("Content-Encoding"))
+		assert.Contains(t, resp.Body.String(), `"counter_42":42`)
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			cfg := config.MustNewConfigFrom(map[string]any{
+				"host":               "http://localhost:0",
+				"access_log.enabled": enabled,
+			})
+
+			logger, logs := logptest.NewTestingLoggerWithObserver(t, "")
+			s, err := NewWithDefaultRoutes(logger, cfg, beatmonitoring.NewMonitoring())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = s.Stop() })
+
+			req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/stats?pretty", nil)
+			resp := httptest.NewRecorder()
+			s.mux.ServeHTTP(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			entries := logs.FilterMessage("API request served").All()
+			if !enabled {
+				assert.Empty(t, entries)
+				return
+			}
+			require.Len(t, entries, 1)
+			fields := entries[0].ContextMap()
+			assert.Equal(t, http.MethodGet, fields["method"])
+			assert.Equal(t, "/stats", fields["path"])
+			assert.EqualValues(t, http.StatusOK, fields["status"])
+			assert.Contains(t, fields, "duration")
+		})
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -52,6 +53,8 @@ type Server struct {
 	httpServer *http.Server
 	state      serverState
 	inspector  *inspector.Handler
+	health     *healthHandler
+	reloaders  map[string]Reloader
 }
 
 // New creates a new API Server with no routes attached.
@@ -112,6 +115,10 @@ func (s *Server) Stop() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if s.health != nil && s.state != stateStopped {
+		s.health.stop()
+	}
+
 	switch s.state {
 	case stateNew:
 		s.state = stateStopped
@@ -141,6 +148,12 @@ func (s *Server) Stop() error {
 // matched in the order in which that are attached.
 // Attaching the same route twice will panic
 func (s *Server) AttachHandler(route string, h http.Handler) (err error) {
+	if len(s.config.CORS.AllowedOrigins) > 0 {
+		h = allowCORS(s.config.CORS.AllowedOrigins, h)
+	}
+	if s.config.AccessLog.Enabled {
+		h = logRequests(s.log.Named("access"), h)
+	}
 	s.mux.Handle(route, h)
 	if !strings.HasSuffix(route, "/") && !strings.HasSuffix(route, "{$}") {
 		// register /route/ handler
@@ -153,6 +166,33 @@ func (s *Server) AttachHandler(route string, h http.Handler) (err error) {
 	return nil
 }
 
+// AddReloader registers r to be run by the reload endpoint under name.
+// Adding a reloader with the same name replaces the previous one.
+func (s *Server) AddReloader(name string, r Reloader) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reloaders == nil {
+		s.reloaders = map[string]Reloader{}
+	}
+	s.reloaders[name] = r
+}
+
+// getReloaders returns the registered reloaders sorted by name.
+func (s *Server) getReloaders() ([]string, []Reloader) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.reloaders))
+	for name := range s.reloaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reloaders := make([]Reloader, len(names))
+	for i, name := range names {
+		reloaders[i] = s.reloaders[name]
+	}
+	return names, reloaders
+}
+
 // AttachStateInspector creates and registers the state store inspector
 // handler if enabled in config. Calling it more than once or when the
 // inspector is disabled is a no-op.
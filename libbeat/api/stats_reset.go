@@ -0,0 +1,135 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// maxStatsResetBodySize limits the size of the body of a stats reset request.
+const maxStatsResetBodySize = 64 * 1024
+
+type statsResetRequest struct {
+	Counters []string `json:"counters"`
+}
+
+type statsResetResponse struct {
+	Reset []string `json:"reset,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// makeStatsResetHandler resets the counters of registry listed in the body
+// of a POST request to zero. Requests are rejected unless enabled is set.
+// The body must be sent with the application/json content type, so that
+// browsers send a CORS preflight request before cross-origin requests.
+func makeStatsResetHandler(registry *monitoring.Registry, enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !enabled {
+			writeStatsReset(w, http.StatusForbidden, statsResetResponse{
+				Error: "resetting stats is disabled, set http.stats_reset.enabled to enable it",
+			})
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeStatsReset(w, http.StatusMethodNotAllowed, statsResetResponse{
+				Error: "only POST requests are allowed",
+			})
+			return
+		}
+		if !isJSONRequest(r) {
+			writeStatsReset(w, http.StatusUnsupportedMediaType, statsResetResponse{
+				Error: "the request body must have the application/json content type",
+			})
+			return
+		}
+
+		var req statsResetRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStatsResetBodySize)).Decode(&req); err != nil {
+			writeStatsReset(w, http.StatusBadRequest, statsResetResponse{
+				Error: fmt.Sprintf("failed to decode request body: %v", err),
+			})
+			return
+		}
+		if len(req.Counters) == 0 {
+			writeStatsReset(w, http.StatusBadRequest, statsResetResponse{
+				Error: "no counters to reset",
+			})
+			return
+		}
+
+		// Check all the counters before resetting any, so that a request
+		// either resets all of them or none.
+		resets := make([]func(), 0, len(req.Counters))
+		for _, name := range req.Counters {
+			reset, err := counterReset(registry, name)
+			if err != nil {
+				writeStatsReset(w, http.StatusBadRequest, statsResetResponse{Error: err.Error()})
+				return
+			}
+			resets = append(resets, reset)
+		}
+		for _, reset := range resets {
+			reset()
+		}
+
+		writeStatsReset(w, http.StatusOK, statsResetResponse{Reset: req.Counters})
+	}
+}
+
+// counterReset returns a function setting the numeric metric at the dotted
+// path name of registry to zero. Gauges report a current value rather than a
+// count, so they cannot be reset.
+func counterReset(registry *monitoring.Registry, name string) (func(), error) {
+	if beatmonitoring.IsGauge(name) {
+		return nil, fmt.Errorf("metric %q is a gauge and cannot be reset", name)
+	}
+	switch v := registry.Get(name).(type) {
+	case *monitoring.Uint:
+		return func() { v.Set(0) }, nil
+	case *monitoring.Int:
+		return func() { v.Set(0) }, nil
+	case *monitoring.Float:
+		return func() { v.Set(0) }, nil
+	case nil:
+		return nil, fmt.Errorf("counter %q not found", name)
+	default:
+		return nil, fmt.Errorf("metric %q of type %T cannot be reset", name, v)
+	}
+}
+
+func writeStatsReset(w http.ResponseWriter, status int, resp statsResetResponse) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// isJSONRequest reports whether the body of r has the application/json
+// content type. Browsers can send cross-origin requests with other content
+// types, such as text/plain, without a CORS preflight request.
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
@@ -0,0 +1,140 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beatmonitoring"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestStatsResetRoute(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host":                "http://localhost:0",
+		"stats_reset.enabled": true,
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	output := mon.StatsRegistry().GetOrCreateRegistry("libbeat").GetOrCreateRegistry("output")
+	failed := monitoring.NewUint(output, "events.failed")
+	acked := monitoring.NewUint(output, "events.acked")
+	latency := monitoring.NewFloat(output, "latency")
+	monitoring.NewString(output, "type").Set("elasticsearch")
+	active := monitoring.NewUint(output, "events.active")
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	resetWith := func(contentType, body string) (int, statsResetResponse) {
+		req := httptest.NewRequest(http.MethodPost, "http://"+s.l.Addr().String()+"/stats/reset", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp := httptest.NewRecorder()
+		s.mux.ServeHTTP(resp, req)
+		var got statsResetResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+		return resp.Code, got
+	}
+	reset := func(body string) (int, statsResetResponse) {
+		return resetWith("application/json", body)
+	}
+
+	failed.Set(5)
+	acked.Set(10)
+	latency.Set(1.5)
+	code, body := reset(`{"counters":["libbeat.output.events.failed","libbeat.output.latency"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"libbeat.output.events.failed", "libbeat.output.latency"}, body.Reset)
+	assert.Zero(t, failed.Get())
+	assert.Zero(t, latency.Get())
+	assert.Equal(t, uint64(10), acked.Get(), "counters not listed must be kept")
+
+	// A request with an invalid counter resets none of them.
+	failed.Set(5)
+	code, body = reset(`{"counters":["libbeat.output.events.failed","libbeat.output.type"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body.Error, "cannot be reset")
+	assert.Equal(t, uint64(5), failed.Get())
+
+	// Gauges report a current value, so they cannot be reset.
+	active.Set(3)
+	code, body = reset(`{"counters":["libbeat.output.events.failed","libbeat.output.events.active"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body.Error, "is a gauge")
+	assert.Equal(t, uint64(3), active.Get())
+	assert.Equal(t, uint64(5), failed.Get())
+
+	code, body = reset(`{"counters":["libbeat.output.events.missing"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body.Error, "not found")
+
+	code, _ = reset(`not json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Requests that browsers send cross-origin without a preflight request
+	// are rejected.
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		code, _ = resetWith(contentType, `{"counters":["libbeat.output.events.failed"]}`)
+		assert.Equal(t, http.StatusUnsupportedMediaType, code, "content type %q", contentType)
+	}
+	code, _ = resetWith("application/json; charset=utf-8", `{"counters":["libbeat.output.events.acked"]}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+s.l.Addr().String()+"/stats/reset", nil)
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+	assert.Equal(t, uint64(5), failed.Get())
+}
+
+func TestStatsResetRouteDisabled(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]any{
+		"host": "http://localhost:0",
+	})
+
+	mon := beatmonitoring.NewMonitoring()
+	output := mon.StatsRegistry().GetOrCreateRegistry("libbeat").GetOrCreateRegistry("output")
+	failed := monitoring.NewUint(output, "events.failed")
+	failed.Set(5)
+
+	logger := logptest.NewTestingLogger(t, "")
+	s, err := NewWithDefaultRoutes(logger, cfg, mon)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Stop() })
+
+	req := httptest.NewRequest(http.MethodPost, "http://"+s.l.Addr().String()+"/stats/reset",
+		strings.NewReader(`{"counters":["libbeat.output.events.failed"]}`))
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, uint64(5), failed.Get())
+}
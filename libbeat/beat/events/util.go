@@ -42,6 +42,27 @@ const (
 	// Bulk API encoding of the event. The key's value can be an empty string, `create`, `index`, or `delete`.
 	// If empty, `create` will be used if FieldMetaID is set; otherwise `index` will be used.
 	FieldMetaOpType = "op_type"
+
+	// FieldMetaIfSeqNo and FieldMetaIfPrimaryTerm define the sequence number and
+	// primary term the target document must have for the operation to succeed,
+	// for optimistic concurrency control. Both must be set to take effect.
+	FieldMetaIfSeqNo       = "if_seq_no"
+	FieldMetaIfPrimaryTerm = "if_primary_term"
+
+	// FieldMetaVersion and FieldMetaVersionType define the version of the
+	// document written by the operation and its versioning type, such as
+	// `external`, so that Elasticsearch rejects stale writes. Both must be set
+	// to take effect, and they can't be used with `create` operations.
+	FieldMetaVersion     = "_version"
+	FieldMetaVersionType = "_version_type"
+
+	// FieldMetaRequireAlias defines whether the event index must be an alias. It
+	// overrides the require_alias setting of the Elasticsearch output.
+	FieldMetaRequireAlias = "require_alias"
+
+	// FieldMetaDynamicTemplates defines the dynamic templates to use for the event
+	// fields, as a map of field paths to template names.
+	FieldMetaDynamicTemplates = "_dynamic_templates"
 )
 
 // GetMetaStringValue returns the value of the given event metadata string field
@@ -108,6 +108,10 @@ type Reloader struct {
 	done     chan struct{}
 	wg       sync.WaitGroup
 	logger   *logp.Logger
+
+	// requests receives the reloads triggered by Reload, the result of
+	// each is sent back on the channel it carries.
+	requests chan chan error
 }
 
 // NewReloader creates new Reloader instance for the given config
@@ -126,6 +130,7 @@ func NewReloader(logger *logp.Logger, pipeline beat.PipelineConnector, cfg *conf
 		path:     path,
 		done:     make(chan struct{}),
 		logger:   logger,
+		requests: make(chan chan error),
 	}
 }
 
@@ -232,6 +237,22 @@ func (rl *Reloader) Run(runnerFactory RunnerFactory) {
 					rl.logger.Debugf("error '%v' cannot retried. Modify any input file to reload.", err)
 				}
 			}
+
+		case result := <-rl.requests:
+			rl.logger.Info("Reloading config files on request")
+			configScans.Add(1)
+
+			files, _, err := gw.Scan()
+			if err != nil {
+				result <- fmt.Errorf("fetching config files: %w", err)
+				continue
+			}
+			configReloads.Add(1)
+
+			configs, loadErr := rl.loadConfigs(files)
+			err = list.Reload(configs)
+			forceReload = common.IsInputReloadable(err)
+			result <- errors.Join(loadErr, err)
 		}
 
 		// Path loading is enabled but not reloading. Loads files only once and then stops.
@@ -244,6 +265,35 @@ func (rl *Reloader) Run(runnerFactory RunnerFactory) {
 	}
 }
 
+// ReloadEnabled reports whether the config files are reloaded while the
+// Beat runs.
+func (rl *Reloader) ReloadEnabled() bool {
+	return rl.config.Reload.Enabled
+}
+
+// Reload scans the config files and reloads the runners immediately,
+// without waiting for the next reload period. It fails if reloading is
+// disabled, if the reloader is stopped, or if any config could not be
+// loaded or started.
+func (rl *Reloader) Reload() error {
+	if !rl.config.Reload.Enabled {
+		return errors.New("config reloading is disabled")
+	}
+
+	result := make(chan error, 1)
+	select {
+	case rl.requests <- result:
+	case <-rl.done:
+		return errors.New("config reloader is stopped")
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-rl.done:
+		return errors.New("config reloader is stopped")
+	}
+}
+
 // Load loads configuration files once.
 func (rl *Reloader) Load(runnerFactory RunnerFactory) {
 	list := NewRunnerList("load", runnerFactory, rl.pipeline, rl.logger)
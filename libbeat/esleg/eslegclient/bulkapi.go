@@ -26,6 +26,7 @@ import (
 	"maps"
 	"net/http"
 	"strings"
+	"time"
 
 	apmHttpV2 "go.elastic.co/apm/module/apmhttp/v2"
 	"go.elastic.co/apm/v2"
@@ -54,6 +55,22 @@ type BulkMeta struct {
 	DocType  string `json:"_type,omitempty" struct:"_type,omitempty"`
 	Pipeline string `json:"pipeline,omitempty" struct:"pipeline,omitempty"`
 	ID       string `json:"_id,omitempty" struct:"_id,omitempty"`
+
+	// IfSeqNo and IfPrimaryTerm are only set for optimistic concurrency
+	// control, where 0 is a valid value.
+	IfSeqNo       *int64 `json:"if_seq_no,omitempty" struct:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64 `json:"if_primary_term,omitempty" struct:"if_primary_term,omitempty"`
+
+	// Version and VersionType are only set for documents versioned by the
+	// sender, where 0 is a valid version.
+	Version     *int64 `json:"version,omitempty" struct:"version,omitempty"`
+	VersionType string `json:"version_type,omitempty" struct:"version_type,omitempty"`
+
+	// RequireAlias is only set when true, so that the action line stays
+	// unchanged for the default behavior.
+	RequireAlias *bool `json:"require_alias,omitempty" struct:"require_alias,omitempty"`
+
+	DynamicTemplates map[string]string `json:"dynamic_templates,omitempty" struct:"dynamic_templates,omitempty"`
 }
 
 type bulkRequest struct {
@@ -71,13 +88,42 @@ func (conn *Connection) Bulk(
 	index, docType string,
 	header http.Header,
 	params map[string]string, body []any,
+) (int, BulkResponse, error) {
+	return conn.bulk(ctx, conn.Encoder, index, docType, header, params, body)
+}
+
+// BulkUncompressed is like Bulk, but sends the request body uncompressed
+// even if compression is enabled for the connection.
+func (conn *Connection) BulkUncompressed(
+	ctx context.Context,
+	index, docType string,
+	header http.Header,
+	params map[string]string, body []any,
+) (int, BulkResponse, error) {
+	if _, ok := conn.Encoder.(*gzipEncoder); !ok {
+		return conn.bulk(ctx, conn.Encoder, index, docType, header, params, body)
+	}
+	if conn.plainEncoder == nil {
+		conn.plainEncoder = NewJSONEncoder(nil, conn.EscapeHTML)
+	}
+	return conn.bulk(ctx, conn.plainEncoder, index, docType, header, params, body)
+}
+
+func (conn *Connection) bulk(
+	ctx context.Context,
+	enc BodyEncoder,
+	index, docType string,
+	header http.Header,
+	params map[string]string, body []any,
 ) (int, BulkResponse, error) {
 	if len(body) == 0 {
 		return 0, nil, nil
 	}
 
-	enc := conn.Encoder
+	conn.uncompressedSize, conn.compressedSize = 0, 0
+	_, conn.bulkCompressed = enc.(*gzipEncoder)
 	enc.Reset()
+	begin := time.Now()
 	if err := bulkEncode(conn.log, enc, body); err != nil {
 		apm.CaptureError(ctx, err).Send()
 		return 0, nil, err
@@ -90,15 +136,67 @@ func (conn *Connection) Bulk(
 		apm.CaptureError(ctx, err).Send()
 		return 0, nil, err
 	}
+	conn.uncompressedSize, conn.compressedSize = bodySize(enc)
+	conn.tuneCompression(enc, time.Since(begin))
 	requ.requ = apmHttpV2.RequestWithContext(ctx, requ.requ)
 	// multiple values per header are not supported
 	for name := range header {
 		requ.requ.Header.Set(name, header.Get(name))
 	}
+	// Bulk responses listing many item errors are large, ask Elasticsearch
+	// to compress them. Setting the header disables the transparent
+	// decompression of the transport, execHTTPRequest decompresses them.
+	requ.requ.Header.Set("Accept-Encoding", "gzip")
 
 	return conn.sendBulkRequest(requ)
 }
 
+// LastBulkSize returns the size in bytes of the body of the last bulk
+// request, before and after compression. Both sizes are the same if the
+// request was not compressed, and zero if the request could not be
+// encoded.
+func (conn *Connection) LastBulkSize() (uncompressed, compressed int64) {
+	return conn.uncompressedSize, conn.compressedSize
+}
+
+// LastBulkCompressed returns whether the body of the last bulk request was
+// compressed.
+func (conn *Connection) LastBulkCompressed() bool {
+	return conn.bulkCompressed
+}
+
+// bodySize returns the size of an encoded body before and after
+// compression. It must be called once the body reader was created, so
+// that the compressed size is final.
+func bodySize(enc BodyEncoder) (uncompressed, compressed int64) {
+	switch enc := enc.(type) {
+	case *gzipEncoder:
+		return enc.counter.WrittenBytes, int64(enc.buf.Len())
+	case *jsonEncoder:
+		return int64(enc.buf.Len()), int64(enc.buf.Len())
+	}
+	return 0, 0
+}
+
+// tuneCompression reports the size and encoding time of a bulk request
+// body to the compression tuner, and applies the level it selects to the
+// following requests.
+func (conn *Connection) tuneCompression(enc BodyEncoder, spent time.Duration) {
+	gz, ok := enc.(*gzipEncoder)
+	if conn.tuner == nil || !ok {
+		return
+	}
+	level := conn.tuner.observe(time.Now(), gz.counter.WrittenBytes, int64(gz.buf.Len()), spent)
+	if level == gz.level {
+		return
+	}
+	if err := gz.setLevel(level); err != nil {
+		conn.log.Errorf("failed to change the compression level to %d: %v", level, err)
+		return
+	}
+	conn.log.Debugf("changed the compression level to %d", level)
+}
+
 func newBulkRequest(
 	urlStr string,
 	index, docType string,
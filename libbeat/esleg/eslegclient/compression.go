@@ -0,0 +1,106 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package eslegclient
+
+import (
+	"time"
+)
+
+// CompressionTuningSettings configures adjusting the gzip compression level
+// to the observed data, instead of using a fixed level.
+type CompressionTuningSettings struct {
+	Enabled bool
+
+	// MinLevel and MaxLevel bound the compression levels that are used.
+	MinLevel int
+	MaxLevel int
+
+	// Interval is the period over which each compression level is measured
+	// before the level is changed.
+	Interval time.Duration
+}
+
+// compressionTuner searches for the gzip compression level saving the most
+// bytes per unit of time spent encoding request bodies. The savings of the
+// current level are measured over an interval, then the level is moved one
+// step in the current direction. The direction is reversed when a level
+// turns out to be less efficient than the previous one, or when it would
+// leave the configured bounds.
+//
+// compressionTuner is not thread-safe, it is used by a single connection.
+type compressionTuner struct {
+	minLevel int
+	maxLevel int
+	interval time.Duration
+
+	level     int
+	direction int // +1 or -1
+
+	// Measurements of the current interval.
+	start time.Time
+	saved int64
+	spent time.Duration
+
+	// Bytes saved per second at the previous level, zero if it hasn't been
+	// measured yet.
+	lastEfficiency float64
+}
+
+// newCompressionTuner returns a tuner starting at the given level, or nil
+// if tuning is disabled.
+func newCompressionTuner(settings CompressionTuningSettings, level int, now time.Time) *compressionTuner {
+	if !settings.Enabled {
+		return nil
+	}
+	return &compressionTuner{
+		minLevel:  settings.MinLevel,
+		maxLevel:  settings.MaxLevel,
+		interval:  settings.Interval,
+		level:     min(max(level, settings.MinLevel), settings.MaxLevel),
+		direction: 1,
+		start:     now,
+	}
+}
+
+// observe records a request body whose encoding took spent and shrunk it
+// from uncompressed to compressed bytes, and returns the compression level
+// to use for the next bodies.
+func (t *compressionTuner) observe(now time.Time, uncompressed, compressed int64, spent time.Duration) int {
+	t.saved += uncompressed - compressed
+	t.spent += spent
+	if now.Sub(t.start) < t.interval || t.spent <= 0 {
+		return t.level
+	}
+
+	efficiency := float64(t.saved) / t.spent.Seconds()
+	if efficiency < t.lastEfficiency {
+		t.direction = -t.direction
+	}
+	t.lastEfficiency = efficiency
+	next := t.level + t.direction
+	if next < t.minLevel || next > t.maxLevel {
+		t.direction = -t.direction
+		next = t.level + t.direction
+	}
+	if next >= t.minLevel && next <= t.maxLevel {
+		t.level = next
+	}
+
+	t.start, t.saved, t.spent = now, 0, 0
+	return t.level
+}
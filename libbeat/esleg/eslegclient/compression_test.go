@@ -0,0 +1,117 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package eslegclient
+
+import (
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tuneSynthetic runs the tuner over the given number of intervals, with
+// each level saving efficiency(level) bytes per second of encoding, and
+// returns the level used during each interval.
+func tuneSynthetic(t *testing.T, tuner *compressionTuner, intervals int, efficiency func(level int) int64) []int {
+	t.Helper()
+	now := tuner.start
+	level := tuner.level
+	levels := make([]int, 0, intervals)
+	for range intervals {
+		levels = append(levels, level)
+
+		// The level is kept until the interval has ended.
+		now = now.Add(tuner.interval / 2)
+		require.Equal(t, level, tuner.observe(now, 10000+efficiency(level)/2, 10000, time.Second/2), "the level should not change during an interval")
+		now = now.Add(tuner.interval / 2)
+		level = tuner.observe(now, 10000+efficiency(level)/2, 10000, time.Second/2)
+	}
+	return levels
+}
+
+func TestCompressionTuner(t *testing.T) {
+	settings := CompressionTuningSettings{
+		Enabled:  true,
+		MinLevel: 2,
+		MaxLevel: 6,
+		Interval: time.Minute,
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Nil(t, newCompressionTuner(CompressionTuningSettings{}, 5, time.Now()))
+	})
+
+	t.Run("finds the most efficient level", func(t *testing.T) {
+		tuner := newCompressionTuner(settings, 4, time.Now())
+		levels := tuneSynthetic(t, tuner, 20, func(level int) int64 {
+			return 1000 - 100*int64(max(level-3, 3-level))
+		})
+
+		assert.Equal(t, []int{4, 5, 4, 3}, levels[:4], "the tuner should move away from a less efficient level")
+		for i, level := range levels[4:] {
+			assert.InDelta(t, 3, level, 1, "interval %d should stay around the most efficient level", i+4)
+		}
+		assert.Contains(t, levels[4:], 3)
+	})
+
+	t.Run("stays within the bounds", func(t *testing.T) {
+		tuner := newCompressionTuner(settings, 6, time.Now())
+		levels := tuneSynthetic(t, tuner, 10, func(level int) int64 {
+			return 100 * int64(level)
+		})
+
+		assert.Equal(t, []int{6, 5, 6, 5, 6, 5, 6, 5, 6, 5}, levels)
+	})
+
+	t.Run("clamps the initial level", func(t *testing.T) {
+		assert.Equal(t, 6, newCompressionTuner(settings, 9, time.Now()).level)
+		assert.Equal(t, 2, newCompressionTuner(settings, 1, time.Now()).level)
+	})
+
+	t.Run("keeps a single allowed level", func(t *testing.T) {
+		single := settings
+		single.MinLevel, single.MaxLevel = 4, 4
+		tuner := newCompressionTuner(single, 4, time.Now())
+		levels := tuneSynthetic(t, tuner, 3, func(level int) int64 {
+			return 100 * int64(level)
+		})
+
+		assert.Equal(t, []int{4, 4, 4}, levels)
+	})
+}
+
+func TestGzipEncoderSetLevel(t *testing.T) {
+	encoder, err := NewGzipEncoder(1, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, encoder.setLevel(9))
+	assert.Equal(t, 9, encoder.level)
+
+	encoder.Reset()
+	require.NoError(t, encoder.Add(map[string]any{"index": map[string]any{}}, map[string]any{"field": "value"}))
+	reader, err := gzip.NewReader(encoder.Reader())
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"index\":{}}\n{\"field\":\"value\"}\n", string(body))
+
+	assert.Error(t, encoder.setLevel(42), "an invalid level should be rejected")
+	assert.Equal(t, 9, encoder.level, "the level should be kept after an error")
+}
@@ -24,10 +24,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/klauspost/compress/gzip"
 	"go.elastic.co/apm/module/apmelasticsearch/v2"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
@@ -65,6 +69,26 @@ type Connection struct {
 
 	isServerless bool
 
+	// tuner adjusts the compression level of the encoder if compression
+	// tuning is enabled.
+	tuner *compressionTuner
+
+	// plainEncoder encodes the bulk requests sent uncompressed although
+	// compression is enabled. It is created on first use.
+	plainEncoder BodyEncoder
+
+	// uncompressedSize and compressedSize are the sizes of the body of
+	// the last bulk request.
+	uncompressedSize int64
+	compressedSize   int64
+	// bulkCompressed is whether the body of the last bulk request was
+	// compressed.
+	bulkCompressed bool
+
+	// responseGzip decompresses the gzip-encoded response bodies. It is
+	// created on first use.
+	responseGzip *gzip.Reader
+
 	// requests will share the same cancellable context
 	// so they can be aborted on Close()
 	reqsContext context.Context
@@ -93,6 +117,18 @@ type ConnectionSettings struct {
 
 	Transport httpcommon.HTTPTransportSettings
 
+	// CompressionTuning configures adjusting the compression level to the
+	// observed data. CompressionLevel is used as the initial level.
+	CompressionTuning CompressionTuningSettings
+
+	// DNSRoundRobin configures distributing requests across all the
+	// addresses the URL host name resolves to.
+	DNSRoundRobin DNSRoundRobinSettings
+
+	// ExistsCacheTTL is for how long the index setup run by connect
+	// callbacks may remember the templates and data streams that exist.
+	ExistsCacheTTL time.Duration
+
 	// UserAgent can be used to report the agent running mode
 	// to ES via the User Agent string. If running under Agent (management.UnderAgent() == true)
 	// then this string will be appended to the user agent.
@@ -156,7 +192,7 @@ func NewConnection(s ConnectionSettings, log *logp.Logger) (*Connection, error)
 		s.Headers[productorigin.Header] = productorigin.Beats
 	}
 
-	httpClient, err := s.Transport.Client(
+	transportOpts := []httpcommon.TransportOption{
 		httpcommon.WithLogger(logger),
 		httpcommon.WithIOStats(s.Observer),
 		httpcommon.WithKeepaliveSettings{IdleConnTimeout: s.IdleConnTimeout},
@@ -166,9 +202,20 @@ func NewConnection(s ConnectionSettings, log *logp.Logger) (*Connection, error)
 			return apmelasticsearch.WrapRoundTripper(rt)
 		}),
 		httpcommon.WithHeaderRoundTripper(map[string]string{"User-Agent": s.UserAgent}),
-	)
-	if err != nil {
-		return nil, err
+	}
+	var httpClient *http.Client
+	if host := u.Hostname(); s.DNSRoundRobin.Enabled && net.ParseIP(host) == nil {
+		logger.Infof("distributing requests across all addresses of %s", host)
+		rt := newRoundRobinTransport(host, s.DNSRoundRobin, func(addr string) (http.RoundTripper, error) {
+			dialer := pinnedDialer(transport.NetDialer(s.Transport.Timeout), host, addr)
+			return s.Transport.RoundTripper(append(slices.Clip(transportOpts), httpcommon.WithBaseDialer(dialer))...)
+		}, logger)
+		httpClient = &http.Client{Transport: rt, Timeout: s.Transport.Timeout}
+	} else {
+		httpClient, err = s.Transport.Client(transportOpts...)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	esClient := esHTTPClient(httpClient)
@@ -187,6 +234,9 @@ func NewConnection(s ConnectionSettings, log *logp.Logger) (*Connection, error)
 		log:                logger,
 		responseBuffer:     bytes.NewBuffer(nil),
 	}
+	if compression != 0 {
+		conn.tuner = newCompressionTuner(s.CompressionTuning, compression, time.Now())
+	}
 
 	if s.APIKey != "" {
 		conn.apiKeyAuthHeader = "ApiKey " + base64.StdEncoding.EncodeToString([]byte(s.APIKey))
@@ -514,19 +564,46 @@ func (conn *Connection) execHTTPRequest(req *http.Request) (int, []byte, error)
 
 	status := resp.StatusCode
 	conn.responseBuffer.Reset()
-	_, err = io.Copy(conn.responseBuffer, resp.Body)
+	err = conn.readResponseBody(resp)
 	if err != nil {
-		return status, nil, err
+		return status, nil, &PartialResponseError{Err: err, Status: status}
 	}
 
 	if status >= 300 {
 		// add the response body with the error returned by Elasticsearch
 		err = fmt.Errorf("%v: %s", resp.Status, conn.responseBuffer.Bytes())
+		if status == http.StatusTooManyRequests {
+			err = &TooManyRequestsError{Err: err, RetryAfter: resp.Header.Get("Retry-After")}
+		}
 	}
 
 	return status, conn.responseBuffer.Bytes(), err
 }
 
+// readResponseBody copies the body of resp into the response buffer,
+// decompressing it if Elasticsearch gzip-encoded it. Responses sent in
+// plain text although the request accepted gzip are copied as is.
+func (conn *Connection) readResponseBody(resp *http.Response) error {
+	if resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		_, err := io.Copy(conn.responseBuffer, resp.Body)
+		return err
+	}
+
+	var err error
+	if conn.responseGzip == nil {
+		conn.responseGzip, err = gzip.NewReader(resp.Body)
+	} else {
+		err = conn.responseGzip.Reset(resp.Body)
+	}
+	if err != nil {
+		return fmt.Errorf("decompressing response: %w", err)
+	}
+	if _, err = io.Copy(conn.responseBuffer, conn.responseGzip); err != nil {
+		return fmt.Errorf("decompressing response: %w", err)
+	}
+	return nil
+}
+
 func closing(c io.Closer, logger *logp.Logger) {
 	err := c.Close()
 	if err != nil {
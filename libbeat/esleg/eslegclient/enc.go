@@ -90,6 +90,7 @@ type jsonEncoder struct {
 type gzipEncoder struct {
 	buf     *bytes.Buffer
 	gzip    *gzip.Writer
+	level   int
 	counter *countingWriter
 	folder  *gotype.Iterator
 
@@ -198,6 +199,7 @@ func NewGzipEncoder(level int, buf *bytes.Buffer, escapeHTML bool) (*gzipEncoder
 	g := &gzipEncoder{
 		buf:        buf,
 		gzip:       w,
+		level:      level,
 		counter:    writerWithCounter(w),
 		escapeHTML: escapeHTML,
 	}
@@ -219,6 +221,19 @@ func (g *gzipEncoder) resetState() {
 	}
 }
 
+// setLevel changes the compression level of the bodies encoded after the
+// next Reset. The current body is not affected.
+func (g *gzipEncoder) setLevel(level int) error {
+	w, err := gzip.NewWriterLevel(g.buf, level)
+	if err != nil {
+		return err
+	}
+	g.gzip = w
+	g.level = level
+	g.counter.w = w
+	return nil
+}
+
 func (g *gzipEncoder) Reset() {
 	g.buf.Reset()
 	g.gzip.Reset(g.buf)
@@ -17,7 +17,10 @@
 
 package eslegclient
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrNotConnected indicates failure due to client having no valid connection
@@ -29,3 +32,39 @@ var (
 	// ErrResponseRead indicates error parsing Elasticsearch response
 	ErrResponseRead = errors.New("bulk item status parse failed")
 )
+
+// TooManyRequestsError is returned when Elasticsearch responds with
+// 429 Too Many Requests.
+type TooManyRequestsError struct {
+	Err error
+
+	// RetryAfter holds the value of the Retry-After response header, or
+	// an empty string if the header was not set.
+	RetryAfter string
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TooManyRequestsError) Unwrap() error {
+	return e.Err
+}
+
+// PartialResponseError is returned when the connection fails while reading
+// the response body, after Elasticsearch already replied with a status. The
+// request may or may not have been applied.
+type PartialResponseError struct {
+	Err error
+
+	// Status is the http status of the truncated response.
+	Status int
+}
+
+func (e *PartialResponseError) Error() string {
+	return fmt.Sprintf("partial response read (status=%d): %v", e.Status, e.Err)
+}
+
+func (e *PartialResponseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,220 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package eslegclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport"
+)
+
+// Resolver looks up the addresses a host name resolves to.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSRoundRobinSettings configures distributing requests across all the
+// addresses the connection URL host name resolves to.
+type DNSRoundRobinSettings struct {
+	Enabled bool
+
+	// RefreshInterval is the time after which the host name is resolved
+	// again to pick up changes to the set of addresses.
+	RefreshInterval time.Duration
+
+	// Resolver is used to resolve the host name. If nil, the default
+	// resolver is used.
+	Resolver Resolver
+}
+
+// roundRobinTransport is an http.RoundTripper sending each request to the
+// next address the host resolves to. Every address has its own underlying
+// transport so that connections to each address are kept alive
+// independently.
+type roundRobinTransport struct {
+	host            string
+	resolver        Resolver
+	refreshInterval time.Duration
+	newTransport    func(addr string) (http.RoundTripper, error)
+	log             *logp.Logger
+
+	mu         sync.Mutex
+	addrs      []string
+	transports map[string]http.RoundTripper
+	next       int
+	resolved   time.Time
+	refreshing bool
+}
+
+func newRoundRobinTransport(
+	host string,
+	s DNSRoundRobinSettings,
+	newTransport func(addr string) (http.RoundTripper, error),
+	log *logp.Logger,
+) *roundRobinTransport {
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &roundRobinTransport{
+		host:            host,
+		resolver:        resolver,
+		refreshInterval: s.RefreshInterval,
+		newTransport:    newTransport,
+		log:             log,
+		transports:      make(map[string]http.RoundTripper),
+	}
+}
+
+func (t *roundRobinTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, err := t.nextTransport(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	return rt.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the transports for
+// all addresses.
+func (t *roundRobinTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, rt := range t.transports {
+		closeIdleConnections(rt)
+	}
+}
+
+// nextTransport returns the transport for the next address, resolving the
+// host again if the refresh interval has passed. The host is resolved
+// without holding t.mu, and while it is being refreshed other requests
+// keep using the previously resolved addresses.
+func (t *roundRobinTransport) nextTransport(ctx context.Context) (http.RoundTripper, error) {
+	if t.startRefresh() {
+		addrs, err := t.resolver.LookupHost(ctx, t.host)
+		if err == nil && len(addrs) == 0 {
+			err = fmt.Errorf("no addresses found for %s", t.host)
+		}
+		if err := t.finishRefresh(addrs, err); err != nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addr := t.addrs[t.next%len(t.addrs)]
+	t.next++
+	return t.transports[addr], nil
+}
+
+// startRefresh returns whether the caller must resolve the host and pass
+// the result to finishRefresh. Until the host has been resolved, every
+// caller resolves it. After that, only one caller at a time resolves it,
+// once the refresh interval has passed.
+func (t *roundRobinTransport) startRefresh() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.addrs) == 0 {
+		return true
+	}
+	if t.refreshing || time.Since(t.resolved) < t.refreshInterval {
+		return false
+	}
+	t.refreshing = true
+	return true
+}
+
+// finishRefresh updates the set of addresses and their transports with
+// the result of resolving the host. It returns an error only if no
+// addresses are known.
+func (t *roundRobinTransport) finishRefresh(addrs []string, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refreshing = false
+	// The refresh time is set even if the host could not be resolved, so
+	// that while DNS is failing the host is resolved once per refresh
+	// interval instead of before every request.
+	t.resolved = time.Now()
+	if err == nil {
+		err = t.update(addrs)
+	}
+	if err != nil {
+		if len(t.addrs) == 0 {
+			return err
+		}
+		t.log.Warnf("Failed to re-resolve %s, using previously resolved addresses %v: %v", t.host, t.addrs, err)
+	}
+	return nil
+}
+
+// update sets the addresses of the host and their transports. t.mu must
+// be held.
+func (t *roundRobinTransport) update(addrs []string) error {
+	slices.Sort(addrs)
+	addrs = slices.Compact(addrs)
+
+	transports := make(map[string]http.RoundTripper, len(addrs))
+	for _, addr := range addrs {
+		if rt, ok := t.transports[addr]; ok {
+			transports[addr] = rt
+			continue
+		}
+		rt, err := t.newTransport(addr)
+		if err != nil {
+			return fmt.Errorf("failed to create transport for %s: %w", addr, err)
+		}
+		transports[addr] = rt
+	}
+	for addr, rt := range t.transports {
+		if _, ok := transports[addr]; !ok {
+			closeIdleConnections(rt)
+		}
+	}
+
+	if !slices.Equal(addrs, t.addrs) {
+		t.log.Infof("%s resolved to %v", t.host, addrs)
+	}
+	t.addrs = addrs
+	t.transports = transports
+	return nil
+}
+
+func closeIdleConnections(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// pinnedDialer returns a dialer that connects to addr whenever host is
+// dialed. Connections to any other host, such as a proxy, are dialed
+// unchanged.
+func pinnedDialer(base transport.Dialer, host, addr string) transport.Dialer {
+	return transport.DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		if h, port, err := net.SplitHostPort(address); err == nil && h == host {
+			address = net.JoinHostPort(addr, port)
+		}
+		return base.DialContext(ctx, network, address)
+	})
+}
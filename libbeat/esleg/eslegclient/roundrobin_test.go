@@ -0,0 +1,208 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package eslegclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+type staticResolver struct {
+	mu      sync.Mutex
+	addrs   map[string][]string
+	lookups int
+
+	// If block is set, lookups wait for it to be closed.
+	block chan struct{}
+}
+
+func (r *staticResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	r.lookups++
+	block := r.block
+	r.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addrs, ok := r.addrs[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	return addrs, nil
+}
+
+func (r *staticResolver) set(host string, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs[host] = addrs
+}
+
+func TestDNSRoundRobin(t *testing.T) {
+	// Serve on the same port on several loopback addresses so that the
+	// address each request was sent to can be identified.
+	addrs := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	var (
+		mu   sync.Mutex
+		hits = make(map[string]int)
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		host, _, _ := net.SplitHostPort(local.String())
+		mu.Lock()
+		hits[host]++
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"version":{"number":"8.15.0"}}`))
+	})
+	var port string
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", net.JoinHostPort(addr, port))
+		if err != nil {
+			t.Skipf("cannot listen on %s: %v", addr, err)
+		}
+		srv := httptest.NewUnstartedServer(handler)
+		srv.Listener = l
+		srv.Start()
+		t.Cleanup(srv.Close)
+		_, port, _ = net.SplitHostPort(l.Addr().String())
+	}
+
+	resolver := &staticResolver{addrs: map[string][]string{}}
+	resolver.set("es.example", addrs...)
+
+	conn, err := NewConnection(ConnectionSettings{
+		URL: "http://es.example:" + port,
+		DNSRoundRobin: DNSRoundRobinSettings{
+			Enabled:  true,
+			Resolver: resolver,
+		},
+	}, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.Connect(context.Background()))
+	clear(hits)
+
+	const rounds = 4
+	for range rounds * len(addrs) {
+		status, _, err := conn.Request(http.MethodGet, "/", "", nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	}
+	for _, addr := range addrs {
+		assert.Equal(t, rounds, hits[addr], "requests should be distributed evenly, got %v", hits)
+	}
+
+	// With a zero refresh interval the host is resolved again before every
+	// request, so a removed address must stop receiving requests.
+	clear(hits)
+	resolver.set("es.example", addrs[:2]...)
+	for range rounds * 2 {
+		status, _, err := conn.Request(http.MethodGet, "/", "", nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	}
+	assert.Equal(t, map[string]int{addrs[0]: rounds, addrs[1]: rounds}, hits, "requests should only go to the re-resolved addresses")
+}
+
+func TestDNSRoundRobinResolveError(t *testing.T) {
+	conn, err := NewConnection(ConnectionSettings{
+		URL: "http://unknown.example:9200",
+		DNSRoundRobin: DNSRoundRobinSettings{
+			Enabled:  true,
+			Resolver: &staticResolver{addrs: map[string][]string{}},
+		},
+	}, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = conn.Connect(context.Background())
+	assert.ErrorContains(t, err, "no such host unknown.example", "connecting should fail when the host cannot be resolved")
+}
+
+func TestDNSRoundRobinRefresh(t *testing.T) {
+	resolver := &staticResolver{addrs: map[string][]string{}}
+	resolver.set("es.example", "127.0.0.1", "127.0.0.2")
+	rr := newRoundRobinTransport("es.example", DNSRoundRobinSettings{
+		RefreshInterval: time.Hour,
+		Resolver:        resolver,
+	}, func(addr string) (http.RoundTripper, error) {
+		return http.DefaultTransport, nil
+	}, logptest.NewTestingLogger(t, ""))
+	expire := func() {
+		rr.mu.Lock()
+		rr.resolved = time.Now().Add(-2 * time.Hour)
+		rr.mu.Unlock()
+	}
+
+	_, err := rr.nextTransport(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, resolver.lookups)
+
+	t.Run("failed refresh", func(t *testing.T) {
+		resolver.set("es.example")
+		expire()
+		for range 10 {
+			_, err := rr.nextTransport(context.Background())
+			require.NoError(t, err, "the previously resolved addresses should be used")
+		}
+		assert.Equal(t, 2, resolver.lookups, "a failed refresh should not be retried before the refresh interval")
+		assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, rr.addrs)
+	})
+
+	t.Run("concurrent refresh", func(t *testing.T) {
+		resolver.set("es.example", "127.0.0.3")
+		resolver.mu.Lock()
+		resolver.block = make(chan struct{})
+		resolver.mu.Unlock()
+		expire()
+
+		done := make(chan error)
+		go func() {
+			_, err := rr.nextTransport(context.Background())
+			done <- err
+		}()
+		require.Eventually(t, func() bool {
+			resolver.mu.Lock()
+			defer resolver.mu.Unlock()
+			return resolver.lookups == 3
+		}, 5*time.Second, time.Millisecond, "the host should be resolved again")
+
+		// Requests are not blocked by the refresh in progress.
+		for range 10 {
+			_, err := rr.nextTransport(context.Background())
+			require.NoError(t, err)
+		}
+		close(resolver.block)
+		require.NoError(t, <-done)
+		assert.Equal(t, 3, resolver.lookups, "only one request should resolve the host")
+		assert.Equal(t, []string{"127.0.0.3"}, rr.addrs)
+	})
+}
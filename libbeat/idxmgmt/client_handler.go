@@ -59,12 +59,14 @@ func NewClientHandler(ilm lifecycle.ClientHandler, template template.Loader) Cli
 
 // NewESClientHandler returns a new ESLoader instance,
 // initialized with an ilm and template client handler based on the passed in client.
-func NewESClientHandler(client ESClient, info beat.Info, cfg lifecycle.RawConfig) (ClientHandler, error) {
+// If existsCache is not nil, the template loader uses it to avoid checking the
+// existence of templates and data streams on every load.
+func NewESClientHandler(client ESClient, info beat.Info, cfg lifecycle.RawConfig, existsCache *template.ExistsCache) (ClientHandler, error) {
 	esHandler, err := lifecycle.NewESClientHandler(client, info, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error creating ES handler: %w", err)
 	}
-	loader, err := template.NewESLoader(client, esHandler, info.Logger, info.Paths)
+	loader, err := template.NewESLoader(client, esHandler, existsCache, info.Logger, info.Paths)
 	if err != nil {
 		return nil, fmt.Errorf("error creating ES loader: %w", err)
 	}
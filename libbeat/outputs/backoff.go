@@ -52,11 +52,38 @@ func (b *backoffClient) Close() error {
 	return b.client.Close()
 }
 
+// RetryAfterError is returned by a client's Publish method when the
+// server requested a specific delay before the failed events are retried.
+type RetryAfterError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
 func (b *backoffClient) Publish(ctx context.Context, batch publisher.Batch) error {
 	err := b.client.Publish(ctx, batch)
 	if err != nil {
 		b.client.Close()
 	}
+	var retryErr *RetryAfterError
+	if errors.As(err, &retryErr) && retryErr.Delay > 0 {
+		// The server requested a delay, so wait for it instead of the
+		// exponential backoff.
+		timer := time.NewTimer(retryErr.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		return err
+	}
 	backoff.WaitOnError(ctx, b.publishBackoff, err)
 	return err
 }
@@ -19,7 +19,9 @@ package elasticsearch
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/elastic/elastic-agent-libs/logp"
 )
@@ -30,28 +32,43 @@ var (
 	errExpectedStatusCode    = errors.New("expected item status code")
 	errUnexpectedEmptyObject = errors.New("empty object")
 	errExpectedObjectEnd     = errors.New("expected end of object")
+	errBulkResponseError     = errors.New("bulk response has a top-level error")
 
 	nameItems        = []byte("items")
 	nameStatus       = []byte("status")
 	nameError        = []byte("error")
 	nameFailureStore = []byte("failure_store")
+	nameResult       = []byte("result")
+	nameErrors       = []byte("errors")
 )
 
-// bulkReadToItems reads the bulk response up to (but not including) items
+// bulkReadToItems reads the bulk response up to (but not including) items.
+// Items are then read in place one at a time with bulkReadItemStatus, which
+// allocates far less than decoding them with encoding/json, whose tokenizer
+// allocates for every token (see BenchmarkCollectPublishFailLarge).
 func bulkReadToItems(reader *jsonReader) error {
+	_, err := bulkReadToItemsNoErrors(reader)
+	return err
+}
+
+// bulkReadToItemsNoErrors reads the bulk response up to items, as
+// bulkReadToItems does, and reports whether the response set errors to
+// false before items, which means that all items succeeded.
+func bulkReadToItemsNoErrors(reader *jsonReader) (bool, error) {
 	if err := reader.ExpectDict(); err != nil {
-		return errExpectedObject
+		return false, errExpectedObject
 	}
 
 	// find 'items' field in response
+	noErrors := false
 	for {
 		kind, name, err := reader.nextFieldName()
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		if kind == dictEnd {
-			return errExpectedItemsArray
+			return false, errExpectedItemsArray
 		}
 
 		// found items array -> continue
@@ -59,65 +76,98 @@ func bulkReadToItems(reader *jsonReader) error {
 			break
 		}
 
-		reader.ignoreNext()
+		value, _ := reader.ignoreNext()
+		if bytes.Equal(name, nameErrors) {
+			noErrors = bytes.Equal(value, []byte("false"))
+		}
+		if bytes.Equal(name, nameError) {
+			// The request failed as a whole, as reported by some proxies
+			// with a 200 status, so the items can't be trusted.
+			return false, fmt.Errorf("%w: %s", errBulkResponseError, value)
+		}
 	}
 
 	// check items field is an array
 	if err := reader.ExpectArray(); err != nil {
-		return errExpectedItemsArray
+		return false, errExpectedItemsArray
 	}
 
-	return nil
+	return noErrors, nil
+}
+
+// bulkCountItems skips the remaining items of the bulk response without
+// reading their fields, and returns how many there were.
+func bulkCountItems(reader *jsonReader) (int, error) {
+	n := 0
+	for {
+		kind, _, err := reader.step()
+		if err != nil {
+			return n, err
+		}
+		switch kind {
+		case arrEnd:
+			return n, nil
+		case dictStart:
+			if err := ignoreKind(reader, dictEnd); err != nil {
+				return n, err
+			}
+			n++
+		default:
+			return n, errExpectedItemObject
+		}
+	}
 }
 
-// bulkReadItemStatus reads the status and error fields from the bulk item
-func bulkReadItemStatus(logger *logp.Logger, reader *jsonReader) (int, []byte, bool, error) {
+// bulkReadItemStatus reads the status and error fields from the bulk item,
+// and whether the item used the failure store or resulted in a noop.
+func bulkReadItemStatus(logger *logp.Logger, reader *jsonReader) (int, []byte, bool, bool, error) {
 	// skip outer dictionary
 	if err := reader.ExpectDict(); err != nil {
-		return 0, nil, false, errExpectedItemObject
+		return 0, nil, false, false, errExpectedItemObject
 	}
 
 	// find first field in outer dictionary (e.g. 'create')
 	kind, _, err := reader.nextFieldName()
 	if err != nil {
 		logger.Errorf("Failed to parse bulk response item: %s", err)
-		return 0, nil, false, err
+		return 0, nil, false, false, err
 	}
 	if kind == dictEnd {
 		err = errUnexpectedEmptyObject
 		logger.Errorf("Failed to parse bulk response item: %s", err)
-		return 0, nil, false, err
+		return 0, nil, false, false, err
 	}
 
 	// parse actual item response code and error message
-	status, msg, failureStoreUsed, err := itemStatusInner(reader, logger)
+	status, msg, failureStoreUsed, noop, err := itemStatusInner(reader, logger)
 	if err != nil {
 		logger.Errorf("Failed to parse bulk response item: %s", err)
-		return 0, nil, false, err
+		return 0, nil, false, false, err
 	}
 
 	// close dictionary. Expect outer dictionary to have only one element
 	kind, _, err = reader.step()
 	if err != nil {
 		logger.Errorf("Failed to parse bulk response item: %s", err)
-		return 0, nil, false, err
+		return 0, nil, false, false, err
 	}
 	if kind != dictEnd {
 		err = errExpectedObjectEnd
 		logger.Errorf("Failed to parse bulk response item: %s", err)
-		return 0, nil, false, err
+		return 0, nil, false, false, err
 	}
 
-	return status, msg, failureStoreUsed, nil
+	return status, msg, failureStoreUsed, noop, nil
 }
 
-func itemStatusInner(reader *jsonReader, logger *logp.Logger) (int, []byte, bool, error) {
+func itemStatusInner(reader *jsonReader, logger *logp.Logger) (int, []byte, bool, bool, error) {
 	if err := reader.ExpectDict(); err != nil {
-		return 0, nil, false, errExpectedItemObject
+		return 0, nil, false, false, errExpectedItemObject
 	}
 
 	status := -1
 	failureStoreUsed := false
+	noop := false
 	var msg []byte
 	for {
 		kind, name, err := reader.nextFieldName()
@@ -133,36 +183,92 @@ func itemStatusInner(reader *jsonReader, logger *logp.Logger) (int, []byte, bool
 			status, err = reader.nextInt()
 			if err != nil {
 				logger.Errorf("Failed to parse bulk response item: %s", err)
-				return 0, nil, false, err
+				return 0, nil, false, false, err
 			}
 
 		case bytes.Equal(name, nameError): // name == "error"
 			msg, err = reader.ignoreNext() // collect raw string for "error" field
 			if err != nil {
-				return 0, nil, false, err
+				return 0, nil, false, false, err
 			}
 
 		case bytes.Equal(name, nameFailureStore):
 			msg, err := reader.ignoreNext()
 			if err != nil {
-				return 0, nil, false, err
+				return 0, nil, false, false, err
 			}
 
 			if bytes.Equal(msg, []byte(`"used"`)) {
 				failureStoreUsed = true
 			}
 
+		case bytes.Equal(name, nameResult): // only returned if requested in filter_path
+			result, err := reader.ignoreNext()
+			if err != nil {
+				return 0, nil, false, false, err
+			}
+			noop = bytes.Equal(result, []byte(`"noop"`))
+
 		default: // ignore unknown fields
 			_, err = reader.ignoreNext()
 			if err != nil {
-				return 0, nil, false, err
+				return 0, nil, false, false, err
 			}
 		}
 	}
 
 	if status < 0 {
-		return 0, nil, false, errExpectedStatusCode
+		return 0, nil, false, false, errExpectedStatusCode
 	}
 
-	return status, msg, failureStoreUsed, nil
+	return status, msg, failureStoreUsed, noop, nil
+}
+
+// pipelineFailure describes the failure of an ingest pipeline processor
+// reported in the error of a bulk item.
+type pipelineFailure struct {
+	processorType string
+	pipeline      string // empty if Elasticsearch doesn't report it
+}
+
+// bulkItemPipelineFailure returns the ingest pipeline processor failure
+// reported in itemMessage, the error of a bulk item, if there is one.
+// Elasticsearch reports the type of the failing processor in the
+// processor_type header of the error, and the pipelines it ran in, from
+// the innermost one, in the pipeline_origin header. Header values are
+// strings, or arrays of strings if there are several of them.
+func bulkItemPipelineFailure(itemMessage []byte) (pipelineFailure, bool) {
+	var itemError struct {
+		Header struct {
+			ProcessorType  json.RawMessage `json:"processor_type"`
+			PipelineOrigin json.RawMessage `json:"pipeline_origin"`
+		} `json:"header"`
+	}
+	if len(itemMessage) == 0 || json.Unmarshal(itemMessage, &itemError) != nil {
+		return pipelineFailure{}, false
+	}
+	processorType := headerValues(itemError.Header.ProcessorType)
+	if len(processorType) == 0 {
+		return pipelineFailure{}, false
+	}
+	failure := pipelineFailure{processorType: processorType[0]}
+	if origin := headerValues(itemError.Header.PipelineOrigin); len(origin) > 0 {
+		failure.pipeline = origin[0]
+	}
+	return failure, true
+}
+
+// headerValues returns the values of an error header, which is either a
+// string or an array of strings.
+func headerValues(raw json.RawMessage) []string {
+	var value string
+	if json.Unmarshal(raw, &value) == nil {
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+	var values []string
+	_ = json.Unmarshal(raw, &values)
+	return values
 }
@@ -0,0 +1,76 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// bulkLimiter bounds the number of bulk requests in flight across the
+// clients of an output, so that adding workers or hosts doesn't multiply
+// the number of concurrent requests sent to Elasticsearch.
+//
+// bulkLimiter is thread-safe, it is shared by all the clients of an output
+// and their clones.
+type bulkLimiter struct {
+	slots    chan struct{}
+	active   atomic.Int64
+	observer Observer
+}
+
+// newBulkLimiter returns a limiter allowing up to limit bulk requests in
+// flight, or nil if limit is not positive. A nil limiter never blocks. If
+// observer is nil, the number of requests in flight is not reported.
+func newBulkLimiter(limit int, observer Observer) *bulkLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	if observer == nil {
+		observer = asObserver(nil)
+	}
+	return &bulkLimiter{
+		slots:    make(chan struct{}, limit),
+		observer: observer,
+	}
+}
+
+// acquire blocks until a bulk request can be sent, or returns the context
+// error if ctx is done first. Every successful acquire must be followed
+// by a release.
+func (l *bulkLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.observer.BulkInFlight(int(l.active.Add(1)))
+	return nil
+}
+
+// release frees the slot taken by a bulk request once it is done.
+func (l *bulkLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.observer.BulkInFlight(int(l.active.Add(-1)))
+	<-l.slots
+}
@@ -78,7 +78,7 @@ func TestBulkReadItemStatus(t *testing.T) {
 	logger := logptest.NewTestingLogger(t, "")
 
 	reader := newJSONReader(response)
-	code, _, _, err := bulkReadItemStatus(logger, reader)
+	code, _, _, _, err := bulkReadItemStatus(logger, reader)
 	assert.NoError(t, err)
 	assert.Equal(t, 200, code)
 }
@@ -137,7 +137,7 @@ func TestES2StyleExtendedErrorStatus(t *testing.T) {
 
 func readStatusItem(in []byte, logger *logp.Logger) (int, string, error) {
 	reader := newJSONReader(in)
-	code, msg, _, err := bulkReadItemStatus(logger, reader)
+	code, msg, _, _, err := bulkReadItemStatus(logger, reader)
 	return code, string(msg), err
 }
 
@@ -184,7 +184,7 @@ func TestBulkReadItemStatusWithFailureStore(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			logger := logptest.NewTestingLogger(t, "")
 			reader := newJSONReader(tt.response)
-			status, msg, failureStoreUsed, err := bulkReadItemStatus(logger, reader)
+			status, msg, failureStoreUsed, _, err := bulkReadItemStatus(logger, reader)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedStatus, status)
 			assert.Equal(t, tt.expectedFailure, failureStoreUsed)
@@ -194,3 +194,50 @@ func TestBulkReadItemStatusWithFailureStore(t *testing.T) {
 		})
 	}
 }
+
+func TestBulkItemPipelineFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    pipelineFailure
+		wantOK  bool
+	}{
+		{
+			name:    "processor type and pipeline origin arrays",
+			message: `{"type": "exception", "header": {"processor_type": ["lowercase"], "pipeline_origin": ["inner", "outer"]}}`,
+			want:    pipelineFailure{processorType: "lowercase", pipeline: "inner"},
+			wantOK:  true,
+		},
+		{
+			name:    "single valued headers",
+			message: `{"type": "exception", "header": {"processor_type": "lowercase", "pipeline_origin": "inner"}}`,
+			want:    pipelineFailure{processorType: "lowercase", pipeline: "inner"},
+			wantOK:  true,
+		},
+		{
+			name:    "no pipeline origin",
+			message: `{"type": "exception", "header": {"processor_type": "lowercase"}}`,
+			want:    pipelineFailure{processorType: "lowercase"},
+			wantOK:  true,
+		},
+		{
+			name:    "mapping error",
+			message: `{"type": "mapper_parsing_exception", "reason": "failed to parse field [bar]"}`,
+		},
+		{
+			name:    "string error",
+			message: `"mapping error"`,
+		},
+		{
+			name: "no error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := bulkItemPipelineFailure([]byte(tt.message))
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -43,6 +43,54 @@ var connectCallbackRegistry = newCallbacksRegistry()
 // to an ES cluster executes a callback.
 var globalCallbackRegistry = newCallbacksRegistry()
 
+// indexTransformRegistry holds the IndexTransform of the outputs created
+// after it is registered.
+var indexTransformRegistry struct {
+	transform IndexTransform
+	mutex     sync.Mutex
+}
+
+// RegisterIndexTransform registers a transform applied to the index of each
+// event by the Elasticsearch outputs created after the call. Registering nil
+// removes the transform.
+func RegisterIndexTransform(transform IndexTransform) {
+	indexTransformRegistry.mutex.Lock()
+	defer indexTransformRegistry.mutex.Unlock()
+
+	indexTransformRegistry.transform = transform
+}
+
+func registeredIndexTransform() IndexTransform {
+	indexTransformRegistry.mutex.Lock()
+	defer indexTransformRegistry.mutex.Unlock()
+
+	return indexTransformRegistry.transform
+}
+
+// dropCallbackRegistry holds the DropCallback of the outputs created after
+// it is registered.
+var dropCallbackRegistry struct {
+	callback DropCallback
+	mutex    sync.Mutex
+}
+
+// RegisterDropCallback registers a callback called with each event that the
+// Elasticsearch outputs created after the call permanently drop, and the
+// reason it was dropped. Registering nil removes the callback.
+func RegisterDropCallback(callback DropCallback) {
+	dropCallbackRegistry.mutex.Lock()
+	defer dropCallbackRegistry.mutex.Unlock()
+
+	dropCallbackRegistry.callback = callback
+}
+
+func registeredDropCallback() DropCallback {
+	dropCallbackRegistry.mutex.Lock()
+	defer dropCallbackRegistry.mutex.Unlock()
+
+	return dropCallbackRegistry.callback
+}
+
 func newCallbacksRegistry() callbacksRegistry {
 	return callbacksRegistry{
 		callbacks: make(map[uuid.UUID]ConnectCallback),
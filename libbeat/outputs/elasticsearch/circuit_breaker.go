@@ -0,0 +1,121 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// circuitBreaker stops sending batches to Elasticsearch for a cooldown
+// period once a number of consecutive batches have been entirely rejected
+// with 429 Too Many Requests, so that retries don't add load to a saturated
+// cluster. Once the cooldown has elapsed the breaker is half-open: a single
+// probe batch is sent while the other batches keep waiting, and the breaker
+// closes if the probe is accepted or opens again for another cooldown if it
+// is rejected. If the outcome of the probe is not recorded within a
+// cooldown, for example because it was dropped, another probe is allowed.
+//
+// circuitBreaker is thread-safe, it is shared by all the clients of an
+// output and their clones, so that they all pause when the cluster is
+// saturated and its state is reported once.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	observer  Observer
+	log       *logp.Logger
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int       // number of consecutive batches rejected with 429
+	openedAt time.Time // zero while the breaker is closed
+	probedAt time.Time // zero unless a probe batch is in flight
+}
+
+// newCircuitBreaker returns a breaker for the given settings, or nil if
+// the threshold is not positive. A nil breaker never opens.
+func newCircuitBreaker(settings CircuitBreaker, observer Observer, log *logp.Logger) *circuitBreaker {
+	if settings.Threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: settings.Threshold,
+		cooldown:  settings.Cooldown,
+		observer:  observer,
+		log:       log,
+		now:       time.Now,
+	}
+}
+
+// wait returns the time to wait before sending the next batch, or zero if
+// it can be sent. Once the cooldown has elapsed, only the caller sending the
+// probe batch gets zero, and the other callers wait until the outcome of the
+// probe is known.
+func (b *circuitBreaker) wait() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return 0
+	}
+	now := b.now()
+	if remaining := b.cooldown - now.Sub(b.openedAt); remaining > 0 {
+		return remaining
+	}
+	if !b.probedAt.IsZero() {
+		if remaining := b.cooldown - now.Sub(b.probedAt); remaining > 0 {
+			return remaining
+		}
+	}
+	b.probedAt = now
+	return 0
+}
+
+// record updates the breaker with the outcome of a sent batch. throttled
+// reports whether all the events of the batch were rejected with 429.
+func (b *circuitBreaker) record(throttled bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	open := !b.openedAt.IsZero()
+	b.probedAt = time.Time{}
+	if !throttled {
+		b.failures = 0
+		if open {
+			b.openedAt = time.Time{}
+			b.observer.CircuitOpen(false)
+			b.log.Info("Elasticsearch accepted a batch again, closing the circuit breaker")
+		}
+		return
+	}
+
+	b.failures++
+	if open || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		if !open {
+			b.observer.CircuitOpen(true)
+			b.log.Warnf("Elasticsearch rejected %d consecutive batches with 429 Too Many Requests, opening the circuit breaker for %v", b.failures, b.cooldown)
+		}
+	}
+}
@@ -18,10 +18,16 @@
 package elasticsearch
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httptrace"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -30,10 +36,12 @@ import (
 
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/beat/events"
+	"github.com/elastic/beats/v7/libbeat/common/backoff"
 	"github.com/elastic/beats/v7/libbeat/esleg/eslegclient"
 	"github.com/elastic/beats/v7/libbeat/outputs"
 	"github.com/elastic/beats/v7/libbeat/outputs/outil"
 	"github.com/elastic/beats/v7/libbeat/publisher"
+	beatversion "github.com/elastic/beats/v7/libbeat/version"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/periodic"
@@ -48,9 +56,35 @@ var (
 
 	errTooMany = errors.New("Elasticsearch returned error 429 Too Many Requests, throttling connection") //nolint:staticcheck //false positive (Elasticsearch should be capitalized)
 
+	errCircuitOpen = errors.New("circuit breaker is open after repeated 429 Too Many Requests responses, retrying later")
+
+	errEmptyResponse = errors.New("Elasticsearch returned an empty bulk response body, retrying later") //nolint:staticcheck //false positive (Elasticsearch should be capitalized)
+
+	errResponseError = errors.New("bulk response has a top-level error, retrying later")
+
 	HeaderEventCount = "X-Elastic-Event-Count"
 )
 
+// Reasons passed to the DropCallback of a client.
+const (
+	dropReasonEncoding        = "encoding"
+	dropReasonIndexNotAllowed = "index_not_allowed"
+	dropReasonEmptyIndex      = "empty_index"
+	dropReasonTooComplex      = "too_complex"
+	dropReasonExpired         = "expired"
+	dropReasonTooLarge        = "too_large"
+	dropReasonNonIndexable    = "nonindexable"
+	dropReasonMaxRetries      = "max_retries"
+	dropReasonRetryBudget     = "retry_budget"
+	dropReasonEmptyResponse   = "empty_response"
+)
+
+// DropCallback is called with each event that the client permanently
+// drops, and the reason it was dropped. It is called synchronously while
+// the batch holding the event is published, so it must not block.
+// It is set on the clients of the outputs with RegisterDropCallback.
+type DropCallback func(event publisher.Event, reason string)
+
 // Client is an elasticsearch client.
 type Client struct {
 	conn eslegclient.Connection
@@ -58,12 +92,124 @@ type Client struct {
 	indexSelector    outputs.IndexSelector
 	pipelineSelector *outil.Selector
 
-	observer outputs.Observer
+	observer Observer
 
 	// If deadLetterIndex is set, events with bulk-ingest errors will be
 	// forwarded to this index. Otherwise, they will be dropped.
 	deadLetterIndex string
 
+	// deadLetterDS indicates that deadLetterIndex is a data stream, so
+	// events are forwarded to it with the create op_type.
+	deadLetterDS bool
+
+	// deadLetterFields holds the names of the error fields of documents
+	// forwarded to deadLetterIndex.
+	deadLetterFields deadLetterFields
+
+	// If maxBulkBytes is positive, batches whose encoded events exceed it
+	// are sent in multiple bulk requests.
+	maxBulkBytes int
+
+	// If maxEventRetries is positive, events that failed to be ingested
+	// are dropped instead of being retried once they have been retried
+	// this many times.
+	maxEventRetries int
+
+	// itemRetryRounds is the number of bulk requests in which failed items
+	// are sent again before being returned to the pipeline.
+	itemRetryRounds int
+
+	// itemRetryBackoff is the backoff waited before each of the
+	// itemRetryRounds.
+	itemRetryBackoff Backoff
+
+	// maxEmptyResponseRetries is the number of empty bulk response bodies
+	// after which an event is dropped. 0 means no limit.
+	maxEmptyResponseRetries int
+
+	// fastAck enables acknowledging bulk responses without errors without
+	// reading their items.
+	fastAck bool
+
+	// retryJitter is the maximum random delay added before failed events
+	// are retried.
+	retryJitter time.Duration
+
+	// If maxEventAge is positive, events whose timestamp is older than it
+	// are dropped instead of being sent.
+	maxEventAge time.Duration
+
+	// retryBudget is shared with clones of the client.
+	retryBudget *retryBudget
+
+	// partialResponse is the policy applied to the events of a bulk
+	// request whose response could not be fully read.
+	partialResponse string
+
+	// filterPath is kept to configure clones of the client.
+	filterPath BulkFilterPath
+	bulkParams map[string]string
+
+	// compressionExempt holds the index patterns for which bulk requests
+	// are sent uncompressed.
+	compressionExempt []string
+
+	// compressionMinEvents and compressionMinBytes are the number of
+	// events and the size of the documents from which bulk requests are
+	// compressed. If neither is set, all requests are compressed.
+	compressionMinEvents int
+	compressionMinBytes  int
+
+	// statusActions overrides the action applied to events whose bulk
+	// item failed with a given status.
+	statusActions map[int]string
+
+	// versionConflictAction is the handling of events whose bulk item
+	// failed with 409 Conflict, as duplicates or as other client errors.
+	versionConflictAction string
+
+	// If requireAlias is set, index and create actions fail unless their
+	// target is an alias. Events can override it in their metadata.
+	requireAlias bool
+
+	// dropSummary configures indexing a summary of each dropped batch.
+	dropSummary DropSummary
+
+	// auditIndex, if set, receives a copy of each event.
+	auditIndex string
+
+	// parallelEncoding configures encoding large batches concurrently.
+	parallelEncoding ParallelEncoding
+
+	// sameIDEvents is the handling of the events of a batch writing the
+	// same document: ordered or collapse.
+	sameIDEvents string
+
+	// If dryRun is set, batches are encoded and acknowledged without
+	// being sent.
+	dryRun bool
+
+	// onDrop is called with each event that is permanently dropped.
+	onDrop DropCallback
+
+	// errorLogDedupWindow is kept to configure clones of the client.
+	errorLogDedupWindow time.Duration
+	errorLogs           *errorLogDeduper
+
+	breaker *circuitBreaker
+	jitter  *retryJitter
+
+	clockSkew ClockSkew
+
+	// bulkLimiter and deadLetterLimiter are shared with clones of the
+	// client.
+	bulkLimiter       *bulkLimiter
+	deadLetterLimiter *bulkLimiter
+
+	// If perIndexMetrics is set, the outcome of events is also reported
+	// for each target index.
+	perIndexMetrics bool
+
 	log                    *logp.Logger
 	pLogIndex              *periodic.Doer
 	pLogIndexTryDeadLetter *periodic.Doer
@@ -84,6 +230,147 @@ type clientSettings struct {
 	// If deadLetterIndex is set, events with bulk-ingest errors will be
 	// forwarded to this index. Otherwise, they will be dropped.
 	deadLetterIndex string
+
+	// deadLetterDS indicates that deadLetterIndex is a data stream, so
+	// events are forwarded to it with the create op_type.
+	deadLetterDS bool
+
+	// deadLetterFields holds the names of the error fields of documents
+	// forwarded to deadLetterIndex.
+	deadLetterFields deadLetterFields
+
+	// If maxBulkBytes is positive, batches whose encoded events exceed it
+	// are sent in multiple bulk requests.
+	maxBulkBytes int
+
+	// If maxEventRetries is positive, events that failed to be ingested
+	// are dropped instead of being retried once they have been retried
+	// this many times.
+	maxEventRetries int
+
+	// If itemRetryRounds is positive, the events of a bulk request that
+	// failed with retryable item errors are sent again in a smaller bulk
+	// request, up to this many times, before being returned to the
+	// pipeline for retry.
+	itemRetryRounds int
+
+	// If itemRetryBackoff.Init is positive, each of the itemRetryRounds
+	// waits for an exponential backoff with jitter, from
+	// itemRetryBackoff.Init up to itemRetryBackoff.Max, before sending the
+	// failed events again.
+	itemRetryBackoff Backoff
+
+	// If maxEmptyResponseRetries is positive, events are dropped instead of
+	// being retried once the bulk requests holding them got an empty
+	// response body this many times.
+	maxEmptyResponseRetries int
+
+	// If fastAck is true, all the events of a bulk request are acknowledged
+	// without reading the status of each item when the response reports
+	// errors as false and holds an item for each bulk action. Items that
+	// used the failure store or resulted in a noop are not counted then.
+	fastAck bool
+
+	// If retryJitter is positive, a random delay of up to retryJitter is
+	// added to the delay requested by Elasticsearch or the circuit breaker
+	// before failed events are retried, and is waited before returning
+	// failed events for retry when they are retried without a backoff.
+	retryJitter time.Duration
+
+	// If maxEventAge is positive, events whose timestamp is older than it
+	// are dropped instead of being sent.
+	maxEventAge time.Duration
+
+	// If retryBudget sets a budget for a pipeline, the failed events of
+	// the pipeline are not retried once it has used up that many retries
+	// since one of its events was last ingested. It is shared by all the
+	// clients of an output.
+	retryBudget *retryBudget
+
+	// partialResponse is the policy applied to the events of a bulk
+	// request whose response could not be fully read.
+	partialResponse string
+
+	// filterPath configures the filter_path of Bulk API requests.
+	filterPath BulkFilterPath
+
+	// If compressionExempt is not empty, bulk requests whose events all
+	// target an index matching one of its patterns are sent uncompressed.
+	compressionExempt []string
+
+	// If compressionMinEvents or compressionMinBytes is not zero, bulk
+	// requests are only compressed when they hold at least
+	// compressionMinEvents events, or documents of at least
+	// compressionMinBytes bytes.
+	compressionMinEvents int
+	compressionMinBytes  int
+
+	// statusActions maps bulk item statuses to the action applied to
+	// their events: retry, drop or dead_letter. Statuses that are not in
+	// the map get the default action, see itemStatusAction.
+	statusActions map[int]string
+
+	// If dryRun is set, batches are encoded and acknowledged without
+	// being sent, and the client never connects to Elasticsearch.
+	dryRun bool
+
+	// If onDrop is set, it is called with each event that is permanently
+	// dropped.
+	onDrop DropCallback
+
+	// If versionConflictAction is versionConflictFail, events whose bulk
+	// item failed with 409 Conflict are handled as other client errors
+	// instead of being counted as duplicates.
+	versionConflictAction string
+
+	// If requireAlias is set, index and create actions fail unless their
+	// target is an alias, instead of creating a concrete index.
+	requireAlias bool
+
+	// If dropSummary is enabled, a document summarizing each dropped batch
+	// is indexed in its index.
+	dropSummary DropSummary
+
+	// If auditIndex is set, a copy of each event is created in it. Failing
+	// to create the copy doesn't prevent the event from being acknowledged.
+	auditIndex string
+
+	// If parallelEncoding has several workers, the bulk requests of
+	// batches with at least MinEvents events are encoded by that many
+	// workers.
+	parallelEncoding ParallelEncoding
+
+	// sameIDEvents is the handling of the events of a batch writing the
+	// same document. With ordered, the default, a failed event is not
+	// retried once a later event writing the same document was indexed.
+	// With collapse, only the last of these events is sent.
+	sameIDEvents string
+
+	// If errorLogDedupWindow is positive, identical ingestion errors are
+	// logged once per window across all indices.
+	errorLogDedupWindow time.Duration
+
+	// If breaker is set, batches are retried without being sent while it
+	// is open. It is shared by all the clients of an output.
+	breaker *circuitBreaker
+
+	// If clockSkew has a positive maximum, the local time is compared to
+	// the Elasticsearch time when connecting.
+	clockSkew ClockSkew
+
+	// If bulkLimiter is set, it bounds the number of bulk requests in
+	// flight across all the clients sharing it.
+	bulkLimiter *bulkLimiter
+
+	// If deadLetterLimiter is set, it bounds the number of bulk requests
+	// sending events to the dead letter index in flight across all the
+	// clients sharing it. These requests also count against bulkLimiter.
+	deadLetterLimiter *bulkLimiter
+
+	// If perIndexMetrics is set, the outcome of events is also reported
+	// for each target index. Each index adds metrics that are kept for
+	// the lifetime of the output.
+	perIndexMetrics bool
 }
 
 type bulkResultStats struct {
@@ -94,6 +381,60 @@ type bulkResultStats struct {
 	deadLetter       int // number of failed events ingested to the dead letter index.
 	tooMany          int // number of events receiving HTTP 429 Too Many Requests
 	failureStoreUsed int // number of events sent to the Failure store
+	emptyResponse    int // number of events retried after an empty bulk response body
+	responseError    int // number of events retried after a bulk response with a top-level error
+	noop             int // number of acked events whose result was a noop
+	auditAcked       int // number of audit copies of events created
+	auditFailed      int // number of audit copies of events that failed to be created
+
+	// If indices is not nil, the acked, fails, nonIndexable and tooMany
+	// counts are also broken down by the index the events targeted when
+	// they were sent, which is held in targets.
+	indices map[string]*indexResultStats
+	targets map[*encodedEvent]string
+}
+
+// indexResultStats holds the bulkResultStats counts of an index.
+type indexResultStats struct {
+	acked        int
+	fails        int
+	nonIndexable int
+	tooMany      int
+}
+
+// newBulkResultStats returns empty stats for the events of a bulk request,
+// which are broken down by index if the client reports per index metrics.
+func (client *Client) newBulkResultStats(events []publisher.Event) bulkResultStats {
+	if !client.perIndexMetrics {
+		return bulkResultStats{}
+	}
+	stats := bulkResultStats{
+		indices: make(map[string]*indexResultStats),
+		targets: make(map[*encodedEvent]string, len(events)),
+	}
+	for _, event := range events {
+		encoded := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		stats.targets[encoded] = encoded.index
+	}
+	return stats
+}
+
+// addIndex attributes the counts stats gained since before to the index
+// event targeted, if stats are broken down by index.
+func (stats *bulkResultStats) addIndex(event publisher.Event, before bulkResultStats) {
+	if stats.indices == nil {
+		return
+	}
+	index := stats.targets[event.EncodedEvent.(*encodedEvent)] //nolint:errcheck //safe to ignore type check
+	s, ok := stats.indices[index]
+	if !ok {
+		s = &indexResultStats{}
+		stats.indices[index] = s
+	}
+	s.acked += stats.acked - before.acked
+	s.fails += stats.fails - before.fails
+	s.nonIndexable += stats.nonIndexable - before.nonIndexable
+	s.tooMany += stats.tooMany - before.tooMany
 }
 
 type bulkResult struct {
@@ -118,10 +459,40 @@ const (
 	defaultEventType = "doc"
 )
 
-// Flags passed with the Bulk API request: we filter the response to include
-// only the fields we need for checking request/item state.
-var bulkRequestParams = map[string]string{
-	"filter_path": "errors,items.*.error,items.*.status,items.*.failure_store",
+const (
+	partialResponseRetry      = "retry"
+	partialResponseDeadLetter = "dead_letter"
+)
+
+const (
+	filterPathAppend  = "append"
+	filterPathReplace = "replace"
+)
+
+// standardFilterPath is the default filter_path of Bulk API requests.
+var standardFilterPath = []string{"errors", "items.*.error", "items.*.status", "items.*.failure_store"}
+
+// requiredFilterPath lists the filter_path entries that reading the bulk
+// response depends on. They are kept when the standard entries are
+// replaced.
+var requiredFilterPath = []string{"items.*.error", "items.*.status", "items.*.failure_store"}
+
+// bulkParams returns the flags passed with Bulk API requests: we filter the
+// response to include only the fields we need for checking request/item
+// state, and the entries from filterPath, which are added to the standard
+// entries or replace them.
+func bulkParams(filterPath BulkFilterPath) map[string]string {
+	entries := standardFilterPath
+	if filterPath.Mode == filterPathReplace {
+		entries = requiredFilterPath
+	}
+	entries = slices.Clone(entries)
+	for _, entry := range filterPath.Entries {
+		if !slices.Contains(entries, entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return map[string]string{"filter_path": strings.Join(entries, ",")}
 }
 
 // NewClient instantiates a new client.
@@ -166,10 +537,7 @@ func NewClient(
 	}
 
 	// Make sure there's a non-nil observer
-	observer := s.observer
-	if observer == nil {
-		observer = outputs.NewNilObserver()
-	}
+	observer := asObserver(s.observer)
 
 	pLogDeadLetter := periodic.NewDoer(10*time.Second,
 		func(count uint64, d time.Duration) {
@@ -196,6 +564,47 @@ func NewClient(
 		pipelineSelector: pipeline,
 		observer:         observer,
 		deadLetterIndex:  s.deadLetterIndex,
+		deadLetterDS:     s.deadLetterDS,
+		deadLetterFields: s.deadLetterFields,
+		maxBulkBytes:     s.maxBulkBytes,
+		maxEventRetries:  s.maxEventRetries,
+		itemRetryRounds:  s.itemRetryRounds,
+		itemRetryBackoff: s.itemRetryBackoff,
+
+		maxEmptyResponseRetries: s.maxEmptyResponseRetries,
+		fastAck:                 s.fastAck,
+		retryJitter:             s.retryJitter,
+		maxEventAge:             s.maxEventAge,
+		partialResponse:         s.partialResponse,
+		filterPath:              s.filterPath,
+		bulkParams:              bulkParams(s.filterPath),
+		versionConflictAction:   s.versionConflictAction,
+		perIndexMetrics:         s.perIndexMetrics,
+		requireAlias:            s.requireAlias,
+		dropSummary:             s.dropSummary,
+		auditIndex:              s.auditIndex,
+
+		compressionExempt:    s.compressionExempt,
+		compressionMinEvents: s.compressionMinEvents,
+		compressionMinBytes:  s.compressionMinBytes,
+		statusActions:        s.statusActions,
+		dryRun:               s.dryRun,
+		onDrop:               s.onDrop,
+		parallelEncoding:     s.parallelEncoding,
+		sameIDEvents:         s.sameIDEvents,
+
+		retryBudget: s.retryBudget,
+
+		errorLogDedupWindow: s.errorLogDedupWindow,
+		errorLogs:           newErrorLogDeduper(s.errorLogDedupWindow, logger),
+
+		breaker: s.breaker,
+		jitter:  newRetryJitter(s.retryJitter, rand.Uint64()), //nolint:gosec //the jitter doesn't need a secure generator
+
+		clockSkew: s.clockSkew,
+
+		bulkLimiter:       s.bulkLimiter,
+		deadLetterLimiter: s.deadLetterLimiter,
 
 		log:                    logger,
 		pLogDeadLetter:         pLogDeadLetter,
@@ -222,6 +631,9 @@ func (client *Client) Clone() *Client {
 		Parameters:        nil, // XXX: do not pass params?
 		Headers:           client.conn.Headers,
 		CompressionLevel:  client.conn.CompressionLevel,
+		CompressionTuning: client.conn.CompressionTuning,
+		DNSRoundRobin:     client.conn.DNSRoundRobin,
+		ExistsCacheTTL:    client.conn.ExistsCacheTTL,
 		OnConnectCallback: nil,
 		Observer:          nil,
 		EscapeHTML:        false,
@@ -239,6 +651,39 @@ func (client *Client) Clone() *Client {
 			indexSelector:    client.indexSelector,
 			pipelineSelector: client.pipelineSelector,
 			deadLetterIndex:  client.deadLetterIndex,
+			deadLetterDS:     client.deadLetterDS,
+			deadLetterFields: client.deadLetterFields,
+			maxBulkBytes:     client.maxBulkBytes,
+			maxEventRetries:  client.maxEventRetries,
+			itemRetryRounds:  client.itemRetryRounds,
+			itemRetryBackoff: client.itemRetryBackoff,
+
+			maxEmptyResponseRetries: client.maxEmptyResponseRetries,
+			fastAck:                 client.fastAck,
+			retryJitter:             client.retryJitter,
+			maxEventAge:             client.maxEventAge,
+			retryBudget:             client.retryBudget,
+			partialResponse:         client.partialResponse,
+			filterPath:              client.filterPath,
+			versionConflictAction:   client.versionConflictAction,
+			perIndexMetrics:         client.perIndexMetrics,
+			requireAlias:            client.requireAlias,
+
+			compressionExempt:    client.compressionExempt,
+			compressionMinEvents: client.compressionMinEvents,
+			compressionMinBytes:  client.compressionMinBytes,
+			statusActions:        client.statusActions,
+			dryRun:               client.dryRun,
+			onDrop:               client.onDrop,
+			errorLogDedupWindow:  client.errorLogDedupWindow,
+			breaker:              client.breaker,
+			clockSkew:            client.clockSkew,
+			bulkLimiter:          client.bulkLimiter,
+			deadLetterLimiter:    client.deadLetterLimiter,
+			dropSummary:          client.dropSummary,
+			auditIndex:           client.auditIndex,
+			parallelEncoding:     client.parallelEncoding,
+			sameIDEvents:         client.sameIDEvents,
 		},
 		nil, // XXX: do not pass connection callback?
 		client.log,
@@ -252,10 +697,40 @@ func (client *Client) Publish(ctx context.Context, batch publisher.Batch) error
 	span.Context.SetLabel("events_original", len(batch.Events()))
 	client.observer.NewBatch(len(batch.Events()))
 
+	if client.dryRun {
+		client.publishDryRun(batch)
+		return nil
+	}
+
+	// While the circuit breaker is open, retry the batch without sending
+	// it and ask for the retry to be delayed until the cooldown ends.
+	if wait := client.breaker.wait(); wait > 0 {
+		batch.RetryEvents(batch.Events())
+		client.observer.RetryableErrors(len(batch.Events()))
+		return &outputs.RetryAfterError{Err: errCircuitOpen, Delay: wait + client.jitter.delay()}
+	}
+
+	events, order := client.sameIDBatch(batch.Events())
+
+	// Split the batch up front if it would exceed the maximum request
+	// size, rather than waiting for Elasticsearch to reject it.
+	if chunks := client.splitByBytes(events); len(chunks) > 1 {
+		span.Context.SetLabel("bulk_requests", len(chunks))
+		client.observer.BatchPreSplit()
+		return client.publishChunks(ctx, batch, chunks, order)
+	}
+
 	// Create and send the bulk request.
-	bulkResult := client.doBulkRequest(ctx, batch)
+	bulkResult := client.sendBulkRequest(ctx, events)
 	span.Context.SetLabel("events_encoded", len(bulkResult.events))
 	if bulkResult.connErr != nil {
+		if bulkResult.status == http.StatusRequestEntityTooLarge && order != nil && len(bulkResult.events) > 1 {
+			// The halves of a batch split by the pipeline may be sent
+			// concurrently, so split it here to keep the events writing
+			// the same document in order.
+			client.observer.BatchSplit()
+			return client.publishChunks(ctx, batch, halves(bulkResult.events), order)
+		}
 		// If there was a connection-level error there is no per-item response,
 		// handle it and return.
 		return client.handleBulkResultError(ctx, batch, bulkResult)
@@ -266,22 +741,250 @@ func (client *Client) Publish(ctx context.Context, batch publisher.Batch) error
 	// check and report the per-item results.
 	eventsToRetry, stats := client.bulkCollectPublishFails(bulkResult)
 	stats.reportToObserver(client.observer)
+	client.breaker.record(stats.tooMany > 0 && stats.tooMany == len(bulkResult.events))
+	eventsToRetry = client.skipSuperseded(order, eventsToRetry)
 
+	eventsToRetry, stats, connErr := client.retryFailedItems(ctx, eventsToRetry, stats, order)
+	err := publishResultForStats(stats)
+	if connErr != nil {
+		err = client.withRetryAfter(connErr)
+	}
 	if len(eventsToRetry) > 0 {
 		span.Context.SetLabel("events_failed", len(eventsToRetry))
+		client.waitRetry(ctx, err)
 		batch.RetryEvents(eventsToRetry)
 	} else {
 		batch.ACK()
 	}
-	return publishResultForStats(stats)
+	return err
+}
+
+// waitRetry waits for the retry jitter before failed events are returned
+// for retry, unless err makes the retry wait for a backoff already.
+func (client *Client) waitRetry(ctx context.Context, err error) {
+	if err == nil {
+		client.jitter.wait(ctx)
+	}
+}
+
+// retryFailedItems sends the events that failed with retryable item errors
+// again, in up to itemRetryRounds bulk requests holding only those events,
+// so that partial failures don't wait for the whole batch to be retried.
+// It returns the events that still need to be retried by the pipeline, the
+// stats of the last bulk request, and the connection error that ended the
+// rounds, if any. Each round waits for itemRetryBackoff first, so that
+// items rejected by an overloaded cluster are not sent again right away.
+// Events superseded by a later event of the batch, as
+// given by order, are not sent again.
+func (client *Client) retryFailedItems(
+	ctx context.Context,
+	events []publisher.Event,
+	stats bulkResultStats,
+	order *sameIDOrder,
+) ([]publisher.Event, bulkResultStats, error) {
+	var retryBackoff backoff.Backoff
+	if client.itemRetryBackoff.Init > 0 {
+		retryBackoff = backoff.NewEqualJitterBackoff(client.itemRetryBackoff.Init, client.itemRetryBackoff.Max)
+	}
+	for round := 1; round <= client.itemRetryRounds && len(events) > 0 && ctx.Err() == nil; round++ {
+		if retryBackoff != nil && !retryBackoff.Wait(ctx) {
+			break
+		}
+		client.log.Debugf("Sending %d failed events again (round %d of %d)", len(events), round, client.itemRetryRounds)
+		bulkResult := client.sendBulkRequest(ctx, events)
+		if bulkResult.connErr != nil {
+			// Hand the events back to the pipeline, which handles
+			// connection-level errors, including requests that are too
+			// large.
+			client.log.Errorf("Failed to send failed events again: %v", bulkResult.connErr)
+			client.applyPartialResponsePolicy(bulkResult)
+			client.observer.RetryableErrors(len(bulkResult.events))
+			if bulkResult.status == http.StatusRequestEntityTooLarge {
+				return bulkResult.events, bulkResultStats{}, nil
+			}
+			return bulkResult.events, bulkResultStats{}, bulkResult.connErr
+		}
+		events, stats = client.bulkCollectPublishFails(bulkResult)
+		stats.reportToObserver(client.observer)
+		events = client.skipSuperseded(order, events)
+	}
+	return events, stats, nil
+}
+
+// splitByBytes splits events into consecutive chunks whose bulk request
+// size, as estimated by bulkItemSize, does not exceed the client's
+// maxBulkBytes. An event larger than the limit is placed in a chunk by
+// itself. If the limit is not set, or all events fit in a single request,
+// a single chunk is returned.
+func (client *Client) splitByBytes(events []publisher.Event) [][]publisher.Event {
+	if client.maxBulkBytes <= 0 || len(events) < 2 {
+		return [][]publisher.Event{events}
+	}
+	var (
+		chunks [][]publisher.Event
+		start  int
+		size   int
+	)
+	version := client.conn.GetVersion()
+	for i := range events {
+		n := 0
+		if enc, ok := events[i].EncodedEvent.(*encodedEvent); ok {
+			n = client.bulkItemSize(version, enc)
+		}
+		if i > start && size+n > client.maxBulkBytes {
+			chunks = append(chunks, events[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	return append(chunks, events[start:])
+}
+
+// bulkItemSize returns the number of bytes event adds to a bulk request
+// for the given Elasticsearch version: its action line and, unless it is
+// deleted, its source line, followed by the lines of its audit copy if
+// there is one. Events whose action can't be encoded are dropped when the
+// request is encoded, so they only count their source.
+func (client *Client) bulkItemSize(version version.V, event *encodedEvent) int {
+	n := 0
+	if event.opType != events.OpTypeDelete {
+		n += sourceLineSize(event.encoding)
+	}
+	if meta, err := client.createEventBulkMeta(version, event); err == nil {
+		if b, err := json.Marshal(meta); err == nil {
+			n += len(b) + 1
+		}
+	}
+	if client.auditCopy(event) {
+		if b, err := json.Marshal(auditBulkMeta(version, client.auditIndex)); err == nil {
+			n += len(b) + 1
+		}
+		n += sourceLineSize(event.encoding)
+	}
+	return n
+}
+
+// sourceLineSize returns the size of the bulk request line holding the
+// encoded source of an event, which the encoder terminates with a newline
+// unless the encoding already ends with one.
+func sourceLineSize(encoding []byte) int {
+	if len(encoding) > 0 && encoding[len(encoding)-1] == '\n' {
+		return len(encoding)
+	}
+	return len(encoding) + 1
+}
+
+// publishChunks sends each chunk of the batch's events in its own bulk
+// request and reports the combined result to the batch. Once a request
+// fails with a connection-level error, the events of the remaining chunks
+// are retried without being sent. A chunk of several events too large to
+// be sent is split in halves rather than retried, as the retried events
+// would be split into the same chunks again. If order is set, events
+// superseded by a later event of the batch are not retried.
+func (client *Client) publishChunks(ctx context.Context, batch publisher.Batch, chunks [][]publisher.Event, order *sameIDOrder) error {
+	var (
+		retry   []publisher.Event
+		stats   bulkResultStats
+		connErr error
+
+		// The number of events sent, and of those rejected with 429.
+		sent, throttled int
+	)
+	for len(chunks) > 0 {
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		if connErr != nil {
+			retry = append(retry, chunk...)
+			client.observer.RetryableErrors(len(chunk))
+			continue
+		}
+		bulkResult := client.sendBulkRequest(ctx, chunk)
+		if bulkResult.status == http.StatusRequestEntityTooLarge && len(bulkResult.events) > 1 {
+			client.observer.BatchSplit()
+			chunks = append(halves(bulkResult.events), chunks...)
+			continue
+		}
+		sent += len(bulkResult.events)
+		if bulkResult.connErr != nil {
+			if bulkResult.status == http.StatusTooManyRequests {
+				throttled += len(bulkResult.events)
+			}
+			if bulkResult.status == http.StatusRequestEntityTooLarge && len(bulkResult.events) == 1 {
+				// A single event too large for the server can never be
+				// ingested, so drop it as the batch would be dropped.
+				client.observer.PermanentErrors(1)
+				client.observer.EventTooLarge(1)
+				client.dropped(bulkResult.events[0], dropReasonTooLarge)
+				client.log.Error(errPayloadTooLarge)
+				client.publishDropSummary(ctx, bulkResult.events, dropReasonTooLarge, bulkResult.connErr)
+				continue
+			}
+			err := apm.CaptureError(ctx, fmt.Errorf("failed to perform any bulk index operations: %w", bulkResult.connErr))
+			err.Send()
+			client.log.Error(err)
+			client.applyPartialResponsePolicy(bulkResult)
+			retry = append(retry, bulkResult.events...)
+			client.observer.RetryableErrors(len(bulkResult.events))
+			connErr = bulkResult.connErr
+			continue
+		}
+		chunkRetry, chunkStats := client.bulkCollectPublishFails(bulkResult)
+		chunkStats.reportToObserver(client.observer)
+		throttled += chunkStats.tooMany
+		chunkRetry = client.skipSuperseded(order, chunkRetry)
+		chunkRetry, chunkStats, connErr = client.retryFailedItems(ctx, chunkRetry, chunkStats, order)
+		stats.tooMany += chunkStats.tooMany
+		stats.emptyResponse += chunkStats.emptyResponse
+		stats.responseError += chunkStats.responseError
+		retry = append(retry, chunkRetry...)
+	}
+	client.breaker.record(throttled > 0 && throttled == sent)
+
+	// A failed event may be superseded by an event of a later chunk.
+	retry = client.skipSuperseded(order, retry)
+	err := publishResultForStats(stats)
+	if connErr != nil {
+		err = client.withRetryAfter(connErr)
+	}
+	if len(retry) > 0 {
+		client.waitRetry(ctx, err)
+		batch.RetryEvents(retry)
+	} else {
+		batch.ACK()
+	}
+	return err
+}
+
+// publishDryRun encodes the bulk request for batch, as Publish would, and
+// acknowledges the batch without sending the request. As there is no
+// cluster to get the version from, the request is encoded for a cluster
+// running the same version as the Beat.
+func (client *Client) publishDryRun(batch publisher.Batch) {
+	rawEvents := batch.Events()
+	events, _ := client.bulkEncodePublishRequest(*version.MustNew(beatversion.GetDefaultVersion()), rawEvents)
+	client.observer.PermanentErrors(len(rawEvents) - len(events))
+	client.observer.WouldSendEvents(len(events))
+	batch.ACK()
 }
 
 func publishResultForStats(stats bulkResultStats) error {
+	// tooMany only counts the events rejected with 429 that are retried,
+	// so a status action that drops them doesn't trigger the backoff.
 	if stats.tooMany > 0 {
 		// We're being throttled by Elasticsearch, return an error so we
 		// retry the connection with exponential backoff
 		return errTooMany
 	}
+	if stats.emptyResponse > 0 {
+		// Back off as for a connection error, the body was probably lost
+		// on the way from Elasticsearch.
+		return errEmptyResponse
+	}
+	if stats.responseError > 0 {
+		// The request was rejected as a whole, most likely by a proxy,
+		// so back off as well.
+		return errResponseError
+	}
 	return nil
 }
 
@@ -295,9 +998,16 @@ func (client *Client) doBulkRequest(
 	ctx context.Context,
 	batch publisher.Batch,
 ) bulkResult {
-	var result bulkResult
+	return client.sendBulkRequest(ctx, batch.Events())
+}
 
-	rawEvents := batch.Events()
+// sendBulkRequest encodes rawEvents into a bulk publish request and sends
+// it to Elasticsearch, as described for doBulkRequest.
+func (client *Client) sendBulkRequest(
+	ctx context.Context,
+	rawEvents []publisher.Event,
+) bulkResult {
+	var result bulkResult
 
 	// encode events into bulk request buffer, dropping failed elements from
 	// events slice
@@ -307,11 +1017,51 @@ func (client *Client) doBulkRequest(
 
 	// If we encoded any events, send the network request.
 	if len(result.events) > 0 {
+		// Wait for a free slot if the number of bulk requests in flight
+		// is limited. The events are retried if ctx is done first. The
+		// dead letter slot is always taken first, so that a request
+		// waiting for it doesn't hold a slot other requests could use.
+		if client.deadLetterLimiter != nil && hasDeadLetterEvents(result.events) {
+			if err := client.deadLetterLimiter.acquire(ctx); err != nil {
+				result.connErr = err
+				return result
+			}
+			defer client.deadLetterLimiter.release()
+		}
+		if err := client.bulkLimiter.acquire(ctx); err != nil {
+			result.connErr = err
+			return result
+		}
+		defer client.bulkLimiter.release()
+
 		begin := time.Now()
+		// The bulk latency excludes encoding the request body, so it is
+		// measured from when the request starts being sent.
+		var sent time.Time
+		ctx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GetConn: func(string) {
+				if sent.IsZero() {
+					sent = time.Now()
+				}
+			},
+		})
 		h := make(http.Header)
 		h.Set(HeaderEventCount, strconv.Itoa(len(result.events)))
+		bulk := client.conn.Bulk
+		if client.belowCompressionThresholds(result.events) || client.compressionExemptEvents(result.events) {
+			bulk = client.conn.BulkUncompressed
+		}
 		result.status, result.response, result.connErr =
-			client.conn.Bulk(ctx, "", "", h, bulkRequestParams, bulkItems)
+			bulk(ctx, "", "", h, client.bulkParams, bulkItems)
+		if uncompressed, compressed := client.conn.LastBulkSize(); uncompressed > 0 {
+			client.observer.BulkSize(int(uncompressed), int(compressed))
+			if client.conn.LastBulkCompressed() {
+				client.observer.BulkCompressionRatio(float64(compressed) / float64(uncompressed))
+			}
+		}
+		if result.status != 0 && !sent.IsZero() {
+			client.observer.BulkLatency(time.Since(sent))
+		}
 		if result.connErr == nil {
 			duration := time.Since(begin)
 			client.observer.ReportLatency(duration)
@@ -324,6 +1074,58 @@ func (client *Client) doBulkRequest(
 	return result
 }
 
+// hasDeadLetterEvents returns whether any of the events is sent to the dead
+// letter index.
+func hasDeadLetterEvents(events []publisher.Event) bool {
+	return slices.ContainsFunc(events, func(event publisher.Event) bool {
+		return event.EncodedEvent.(*encodedEvent).deadLetter //nolint:errcheck //safe to ignore type check
+	})
+}
+
+// belowCompressionThresholds returns whether a bulk request holding events
+// is too small to be compressed: a compression threshold is set, and the
+// request reaches none of them.
+func (client *Client) belowCompressionThresholds(events []publisher.Event) bool {
+	if client.compressionMinEvents <= 0 && client.compressionMinBytes <= 0 {
+		return false
+	}
+	if client.compressionMinEvents > 0 && len(events) >= client.compressionMinEvents {
+		return false
+	}
+	if client.compressionMinBytes > 0 {
+		size := 0
+		for _, event := range events {
+			if encoded, ok := event.EncodedEvent.(*encodedEvent); ok {
+				size += len(encoded.encoding)
+			}
+		}
+		if size >= client.compressionMinBytes {
+			return false
+		}
+	}
+	return true
+}
+
+// compressionExemptEvents returns whether all the events target an index
+// exempt from compression. A request mixing exempt and other indices is
+// compressed.
+func (client *Client) compressionExemptEvents(events []publisher.Event) bool {
+	if len(client.compressionExempt) == 0 {
+		return false
+	}
+	for _, event := range events {
+		encoded := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		if !slices.ContainsFunc(client.compressionExempt, func(pattern string) bool {
+			// Patterns are validated when the configuration is loaded.
+			ok, _ := path.Match(pattern, encoded.index)
+			return ok
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
 func (client *Client) handleBulkResultError(
 	ctx context.Context, batch publisher.Batch, bulkResult bulkResult,
 ) error {
@@ -335,17 +1137,28 @@ func (client *Client) handleBulkResultError(
 		} else {
 			// If the batch could not be split, there is no option left but
 			// to drop it and log the error state.
+			for _, event := range bulkResult.events {
+				client.dropped(event, dropReasonTooLarge)
+			}
 			batch.Drop()
 			client.observer.PermanentErrors(len(bulkResult.events))
+			if len(bulkResult.events) == 1 {
+				// Report an event that can't be ingested on its own apart
+				// from other drops, as no batch size can fix it.
+				client.observer.EventTooLarge(1)
+			}
 			client.log.Error(errPayloadTooLarge)
+			client.publishDropSummary(ctx, bulkResult.events, dropReasonTooLarge, bulkResult.connErr)
 		}
 		// Don't propagate a too-large error since it doesn't indicate a problem
 		// with the connection.
 		return nil
 	}
+	client.breaker.record(bulkResult.status == http.StatusTooManyRequests)
 	err := apm.CaptureError(ctx, fmt.Errorf("failed to perform any bulk index operations: %w", bulkResult.connErr))
 	err.Send()
 	client.log.Error(err)
+	client.applyPartialResponsePolicy(bulkResult)
 
 	if len(bulkResult.events) > 0 {
 		// At least some events failed, retry them
@@ -355,44 +1168,169 @@ func (client *Client) handleBulkResultError(
 		batch.ACK()
 	}
 	client.observer.RetryableErrors(len(bulkResult.events))
-	return bulkResult.connErr
+	return client.withRetryAfter(bulkResult.connErr)
+}
+
+// applyPartialResponsePolicy handles the events of a bulk request whose
+// response was cut off while being read. Elasticsearch may or may not have
+// applied such a request, so retrying its events can create duplicates.
+// Events that are created with an explicit ID are always retried, as a
+// duplicate is then rejected by Elasticsearch. With the dead_letter policy,
+// the remaining events are sent to the dead letter index for reconciliation
+// instead of being retried as is.
+func (client *Client) applyPartialResponsePolicy(bulkResult bulkResult) {
+	var partial *eslegclient.PartialResponseError
+	if client.partialResponse != partialResponseDeadLetter || !errors.As(bulkResult.connErr, &partial) {
+		return
+	}
+	for _, event := range bulkResult.events {
+		encodedEvent := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		if encodedEvent.deadLetter || retrySafe(encodedEvent) {
+			continue
+		}
+		client.pLogIndexTryDeadLetter.Add()
+		client.log.Warnw(fmt.Sprintf("Delivery of event '%s' is uncertain (status=%v): %v, trying dead letter index", encodedEvent, partial.Status, partial.Err), logp.TypeKey, logp.EventType)
+		encodedEvent.setDeadLetter(client.deadLetterIndex, client.deadLetterDS, client.deadLetterFields, partial.Status, "uncertain delivery: "+partial.Error())
+	}
+}
+
+// retrySafe returns whether sending the event again cannot create a
+// duplicate document.
+func retrySafe(event *encodedEvent) bool {
+	return event.id != "" && event.opType != events.OpTypeIndex && event.opType != events.OpTypeDelete
+}
+
+// withRetryAfter returns err wrapped with the delay requested by
+// Elasticsearch if it is throttling us and told us how long to wait, so
+// that the retry is delayed accordingly instead of using the exponential
+// backoff. Otherwise err is returned unchanged.
+func (client *Client) withRetryAfter(err error) error {
+	var tooMany *eslegclient.TooManyRequestsError
+	if errors.As(err, &tooMany) {
+		if delay, ok := parseRetryAfter(tooMany.RetryAfter, time.Now()); ok {
+			client.log.Debugf("Elasticsearch requested a retry after %v (Retry-After: %q)", delay, tooMany.RetryAfter)
+			return &outputs.RetryAfterError{Err: err, Delay: delay + client.jitter.delay()}
+		}
+	}
+	return err
+}
+
+// parseRetryAfter returns the delay specified by a Retry-After header
+// value, which may be either a number of seconds or an HTTP date. ok is
+// false if the value is empty or invalid.
+func parseRetryAfter(value string, now time.Time) (delay time.Duration, ok bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	delay = date.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
 }
 
 // bulkEncodePublishRequest encodes all bulk requests and returns slice of events
 // successfully added to the list of bulk items and the list of bulk items.
 func (client *Client) bulkEncodePublishRequest(version version.V, data []publisher.Event) ([]publisher.Event, []any) {
+	if client.parallelEncoding.enabled(len(data)) {
+		return client.bulkEncodePublishRequestParallel(version, data)
+	}
 	okEvents := data[:0]
 	bulkItems := make([]any, 0, len(data)*2)
+	now := time.Now()
+	expired := 0
 	for i := range data {
-		if data[i].EncodedEvent == nil {
-			client.log.Error("Elasticsearch output received unencoded publisher.Event")
-			continue
-		}
-		event := data[i].EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
-		if event.err != nil {
-			// This means there was an error when encoding the event and it isn't
-			// ingestable, so report the error and continue.
-			client.log.Error(event.err)
-			continue
-		}
-		meta, err := client.createEventBulkMeta(version, event)
-		if err != nil {
-			client.log.Errorf("Failed to encode event meta data: %+v", err)
+		var (
+			dropReason string
+			ok         bool
+		)
+		bulkItems, dropReason, ok = client.appendBulkItems(bulkItems, version, now, data[i])
+		if !ok {
+			if dropReason == dropReasonExpired {
+				expired++
+			}
+			if dropReason != "" {
+				client.dropped(data[i], dropReason)
+			}
 			continue
 		}
-		if event.opType == events.OpTypeDelete {
-			// We don't include the event source in a bulk DELETE
-			bulkItems = append(bulkItems, meta)
-		} else {
-			// Wrap the encoded event in a RawEncoding so the Elasticsearch client
-			// knows not to re-encode it
-			bulkItems = append(bulkItems, meta, eslegclient.RawEncoding{Encoding: event.encoding})
-		}
 		okEvents = append(okEvents, data[i])
 	}
+	client.observer.ExpiredEvents(expired)
 	return okEvents, bulkItems
 }
 
+// appendBulkItems appends the bulk items of an event to bulkItems: its
+// action and, unless it is deleted, its source, followed by the action and
+// source of its audit copy if there is one. The event's age is checked
+// against now. If the event can't be sent, ok is false and dropReason is
+// the reason it is dropped, or empty if it is skipped without being
+// dropped.
+func (client *Client) appendBulkItems(
+	bulkItems []any,
+	version version.V,
+	now time.Time,
+	data publisher.Event,
+) (_ []any, dropReason string, ok bool) {
+	if data.EncodedEvent == nil {
+		client.log.Error("Elasticsearch output received unencoded publisher.Event")
+		return bulkItems, "", false
+	}
+	event := data.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+	if event.err != nil {
+		// This means there was an error when encoding the event and it isn't
+		// ingestable, so report the error and continue.
+		client.log.Error(event.err)
+		if event.dropReason != "" {
+			return bulkItems, event.dropReason, false
+		}
+		return bulkItems, dropReasonEncoding, false
+	}
+	if client.maxEventAge > 0 && now.Sub(event.timestamp) > client.maxEventAge {
+		// The event is too old to be worth delivering, e.g. after
+		// being retried for a long time.
+		client.pLogIndex.Add()
+		client.log.Warnw(fmt.Sprintf("Event '%s' is older than %v, dropping event!", event, client.maxEventAge), logp.TypeKey, logp.EventType)
+		return bulkItems, dropReasonExpired, false
+	}
+	meta, err := client.createEventBulkMeta(version, event)
+	if err != nil {
+		client.log.Errorf("Failed to encode event meta data: %+v", err)
+		return bulkItems, dropReasonEncoding, false
+	}
+	if event.opType == events.OpTypeDelete {
+		// We don't include the event source in a bulk DELETE
+		bulkItems = append(bulkItems, meta)
+	} else {
+		// Wrap the encoded event in a RawEncoding so the Elasticsearch client
+		// knows not to re-encode it
+		bulkItems = append(bulkItems, meta, eslegclient.RawEncoding{Encoding: event.encoding})
+	}
+	event.audit = client.auditCopy(event)
+	if event.audit {
+		bulkItems = append(bulkItems, auditBulkMeta(version, client.auditIndex), eslegclient.RawEncoding{Encoding: event.encoding})
+	}
+	return bulkItems, "", true
+}
+
+// dropped reports an event that is permanently dropped to the client's
+// drop callback, if there is one.
+func (client *Client) dropped(event publisher.Event, reason string) {
+	if client.onDrop != nil {
+		client.onDrop(event, reason)
+	}
+}
+
 func (client *Client) createEventBulkMeta(version version.V, event *encodedEvent) (any, error) {
 	eventType := ""
 	if version.Major < 7 {
@@ -405,6 +1343,16 @@ func (client *Client) createEventBulkMeta(version version.V, event *encodedEvent
 		Pipeline: event.pipeline,
 		ID:       event.id,
 	}
+	if (event.ifSeqNo == nil) != (event.ifPrimaryTerm == nil) {
+		return nil, fmt.Errorf("%s and %s must be set together", events.FieldMetaIfSeqNo, events.FieldMetaIfPrimaryTerm)
+	}
+	meta.IfSeqNo = event.ifSeqNo
+	meta.IfPrimaryTerm = event.ifPrimaryTerm
+	if (event.version == nil) != (event.versionType == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", events.FieldMetaVersion, events.FieldMetaVersionType)
+	}
+	meta.Version = event.version
+	meta.VersionType = event.versionType
 
 	if event.opType == events.OpTypeDelete {
 		if event.id != "" {
@@ -413,26 +1361,66 @@ func (client *Client) createEventBulkMeta(version version.V, event *encodedEvent
 			return nil, fmt.Errorf("%s %s requires _id", events.FieldMetaOpType, events.OpTypeDelete)
 		}
 	}
+	// The dead letter index is a concrete index, whatever the original
+	// target of the event.
+	if !event.deadLetter {
+		requireAlias := client.requireAlias
+		if event.requireAlias != nil {
+			requireAlias = *event.requireAlias
+		}
+		if requireAlias {
+			meta.RequireAlias = &requireAlias
+		}
+		meta.DynamicTemplates = event.dynamicTemplates
+	}
 	if event.id != "" || version.Major > 7 || (version.Major == 7 && version.Minor >= 5) {
 		if event.opType == events.OpTypeIndex {
 			return eslegclient.BulkIndexAction{Index: meta}, nil
 		}
+		if meta.Version != nil {
+			// Elasticsearch only supports internal versioning for create
+			// actions.
+			return nil, fmt.Errorf("%s requires %s %s", events.FieldMetaVersion, events.FieldMetaOpType, events.OpTypeIndex)
+		}
 		return eslegclient.BulkCreateAction{Create: meta}, nil
 	}
 	return eslegclient.BulkIndexAction{Index: meta}, nil
 }
 
+// auditCopy reports whether the bulk request event is sent in also creates
+// its copy in the audit index. Delete actions and events sent to the dead
+// letter index are not copied, nor events whose copy was already handled.
+func (client *Client) auditCopy(event *encodedEvent) bool {
+	return client.auditIndex != "" && !event.audited && !event.deadLetter && event.opType != events.OpTypeDelete
+}
+
+// auditBulkMeta returns the bulk action creating the audit copy of an event
+// in auditIndex. Copies get their own generated ID and are never sent
+// through an ingest pipeline.
+func auditBulkMeta(version version.V, auditIndex string) any {
+	meta := eslegclient.BulkMeta{Index: auditIndex}
+	if version.Major < 7 {
+		meta.DocType = defaultEventType
+	}
+	if version.Major > 7 || (version.Major == 7 && version.Minor >= 5) {
+		return eslegclient.BulkCreateAction{Create: meta}
+	}
+	// Create actions require an ID before 7.5.
+	return eslegclient.BulkIndexAction{Index: meta}
+}
+
+// getPipeline returns the ingest pipeline of event. The pipeline set in the
+// event's @metadata.pipeline field overrides the one selected by
+// defaultSelector, which is used if the field is missing or empty.
 func getPipeline(event *beat.Event, defaultSelector *outil.Selector) (string, error) {
 	if event.Meta != nil {
 		pipeline, err := events.GetMetaStringValue(*event, events.FieldMetaPipeline)
-		if errors.Is(err, mapstr.ErrKeyNotFound) {
-			return "", nil
-		}
-		if err != nil {
+		if err != nil && !errors.Is(err, mapstr.ErrKeyNotFound) {
 			return "", errors.New("pipeline metadata is no string")
 		}
-
-		return strings.ToLower(pipeline), nil
+		if pipeline != "" {
+			return strings.ToLower(pipeline), nil
+		}
 	}
 
 	if defaultSelector != nil {
@@ -454,28 +1442,59 @@ func (client *Client) bulkCollectPublishFails(bulkResult bulkResult) ([]publishe
 		// No events to process
 		return nil, bulkResultStats{}
 	}
+	stats := client.newBulkResultStats(events)
 	if bulkResult.status != 200 {
-		return events, bulkResultStats{fails: len(events)}
+		stats.failAll(events)
+		return client.limitRetries(events, &stats), stats
+	}
+	if len(bytes.TrimSpace(bulkResult.response)) == 0 {
+		// Nothing can be told about the items, most likely because a proxy
+		// dropped the body, so handle it like a connection failure rather
+		// than a malformed response.
+		client.log.Errorf("Bulk request returned status %d with an empty response body, retrying %d events", bulkResult.status, len(events))
+		return client.retryEmptyResponse(events, &stats), stats
 	}
 	reader := newJSONReader(bulkResult.response)
-	if err := bulkReadToItems(reader); err != nil {
+	noErrors, err := bulkReadToItemsNoErrors(reader)
+	if errors.Is(err, errBulkResponseError) {
+		// Unlike item errors, the error applies to the whole request.
+		client.log.Errorf("Bulk request returned status %d with a top-level error instead of items, retrying %d events: %v", bulkResult.status, len(events), err)
+		stats.failAll(events)
+		retry := client.limitRetries(events, &stats)
+		stats.responseError = len(retry)
+		return retry, stats
+	}
+	if err != nil {
 		client.log.Errorf("failed to parse bulk response: %v", err.Error())
-		return events, bulkResultStats{fails: len(events)}
+		stats.failAll(events)
+		return client.limitRetries(events, &stats), stats
+	}
+	if client.fastAck && noErrors {
+		if client.fastAckItems(events, reader, &stats) {
+			return nil, stats
+		}
+		// The items don't match the bulk actions, so read their status
+		// one by one from the start.
+		client.log.Debugf("Bulk response without errors has unexpected items, reading each item")
+		reader = newJSONReader(bulkResult.response)
+		_ = bulkReadToItems(reader)
 	}
 
 	count := len(events)
 	eventsToRetry := events[:0]
-	stats := bulkResultStats{}
 	for i := range count {
-		itemStatus, itemMessage, failureStoreUsed, err := bulkReadItemStatus(client.log, reader)
+		itemStatus, itemMessage, failureStoreUsed, noop, err := bulkReadItemStatus(client.log, reader)
 		if err != nil {
 			// The response json is invalid, mark the remaining events for retry.
-			stats.fails += count - i
+			stats.failAll(events[i:])
 			eventsToRetry = append(eventsToRetry, events[i:]...)
 			break
 		}
 
-		if client.applyItemStatus(events[i], itemStatus, itemMessage, &stats) {
+		before := stats
+		retry := client.applyItemStatus(events[i], itemStatus, itemMessage, &stats)
+		stats.addIndex(events[i], before)
+		if retry {
 			eventsToRetry = append(eventsToRetry, events[i])
 			client.log.Debugf("Bulk item insert failed (i=%v, status=%v): %s", i, itemStatus, itemMessage)
 		}
@@ -483,9 +1502,151 @@ func (client *Client) bulkCollectPublishFails(bulkResult bulkResult) ([]publishe
 		if failureStoreUsed {
 			stats.failureStoreUsed += 1
 		}
+
+		if noop && itemStatus < 300 {
+			stats.noop++
+		}
+
+		if events[i].EncodedEvent.(*encodedEvent).audit { //nolint:errcheck //safe to ignore type check
+			if err := client.applyAuditItemStatus(events[i], reader, &stats); err != nil {
+				// The response json is invalid, mark the remaining events
+				// for retry.
+				stats.failAll(events[i+1:])
+				eventsToRetry = append(eventsToRetry, events[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return client.limitRetries(eventsToRetry, &stats), stats
+}
+
+// fastAckItems counts the items left in reader, and if there is one for each
+// event and each audit copy of an event, counts all of them as succeeded.
+// It returns whether the events were counted.
+func (client *Client) fastAckItems(events []publisher.Event, reader *jsonReader, stats *bulkResultStats) bool {
+	want := len(events)
+	for _, event := range events {
+		if event.EncodedEvent.(*encodedEvent).audit { //nolint:errcheck //safe to ignore type check
+			want++
+		}
+	}
+	if n, err := bulkCountItems(reader); err != nil || n != want {
+		return false
+	}
+	for _, event := range events {
+		before := *stats
+		client.applyItemStatus(event, http.StatusOK, nil, stats)
+		stats.addIndex(event, before)
+		if encodedEvent := event.EncodedEvent.(*encodedEvent); encodedEvent.audit { //nolint:errcheck //safe to ignore type check
+			encodedEvent.audited = true
+			stats.auditAcked++
+		}
+	}
+	return true
+}
+
+// applyAuditItemStatus reads the status of the bulk item that created the
+// audit copy of event. Audit copies are counted apart from the event, and
+// a failed copy never causes the event to be retried. It is only created
+// again if the event is retried for its own item.
+func (client *Client) applyAuditItemStatus(event publisher.Event, reader *jsonReader, stats *bulkResultStats) error {
+	status, msg, _, _, err := bulkReadItemStatus(client.log, reader)
+	if err != nil {
+		return err
+	}
+	encodedEvent := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+	if status < 300 || status == http.StatusConflict {
+		encodedEvent.audited = true
+		stats.auditAcked++
+		return nil
+	}
+	stats.auditFailed++
+	if client.errorLogs.allow(client.auditIndex, msg) {
+		client.log.Warnw(fmt.Sprintf("Cannot create audit copy of event '%s' in %s (status=%v): %s", encodedEvent, client.auditIndex, status, msg), logp.TypeKey, logp.EventType)
+	}
+	return nil
+}
+
+// retryEmptyResponse counts the events of a bulk request that got an empty
+// response body as retryable failures and returns those to be retried.
+// Events that got more than maxEmptyResponseRetries empty responses are
+// dropped instead.
+func (client *Client) retryEmptyResponse(events []publisher.Event, stats *bulkResultStats) []publisher.Event {
+	eventsToRetry := events[:0]
+	for _, event := range events {
+		encodedEvent := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		encodedEvent.emptyResponses++
+		before := *stats
+		if client.maxEmptyResponseRetries > 0 && encodedEvent.emptyResponses > client.maxEmptyResponseRetries {
+			client.pLogIndex.Add()
+			client.log.Warnw(fmt.Sprintf("Event '%s' got %d empty bulk responses, dropping event!", encodedEvent, encodedEvent.emptyResponses), logp.TypeKey, logp.EventType)
+			client.dropped(event, dropReasonEmptyResponse)
+			stats.nonIndexable++
+			stats.addIndex(event, before)
+			continue
+		}
+		stats.fails++
+		stats.addIndex(event, before)
+		eventsToRetry = append(eventsToRetry, event)
 	}
+	eventsToRetry = client.limitRetries(eventsToRetry, stats)
+	stats.emptyResponse = len(eventsToRetry)
+	return eventsToRetry
+}
 
-	return eventsToRetry, stats
+// failAll counts all the events as retryable failures.
+func (stats *bulkResultStats) failAll(events []publisher.Event) {
+	for _, event := range events {
+		before := *stats
+		stats.fails++
+		stats.addIndex(event, before)
+	}
+}
+
+// limitRetries increments the retry count of the events to be retried and
+// removes the events that exceeded the client's maximum number of retries.
+// The removed events are moved from the fails to the nonIndexable count in
+// stats. Events whose pipeline used up its retry budget are sent to the
+// dead letter index instead, or removed as well if there is none.
+func (client *Client) limitRetries(events []publisher.Event, stats *bulkResultStats) []publisher.Event {
+	if client.maxEventRetries <= 0 && client.retryBudget == nil {
+		return events
+	}
+	eventsToRetry := events[:0]
+	for _, event := range events {
+		encodedEvent := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		encodedEvent.retries++
+		before := *stats
+		if client.maxEventRetries > 0 && encodedEvent.retries > client.maxEventRetries {
+			client.pLogIndex.Add()
+			client.log.Warnw(fmt.Sprintf("Event '%s' failed after %d retries, dropping event!", encodedEvent, client.maxEventRetries), logp.TypeKey, logp.EventType)
+			client.dropped(event, dropReasonMaxRetries)
+			stats.fails--
+			stats.nonIndexable++
+			stats.addIndex(event, before)
+			continue
+		}
+		if !encodedEvent.deadLetter && !client.retryBudget.spend(encodedEvent.pipeline) {
+			if client.deadLetterIndex == "" {
+				client.pLogIndex.Add()
+				client.log.Warnw(fmt.Sprintf("Event '%s' failed after pipeline %s used up its retry budget, dropping event!", encodedEvent, encodedEvent.pipeline), logp.TypeKey, logp.EventType)
+				client.dropped(event, dropReasonRetryBudget)
+				stats.fails--
+				stats.nonIndexable++
+				stats.addIndex(event, before)
+				continue
+			}
+			client.pLogIndexTryDeadLetter.Add()
+			client.log.Warnw(fmt.Sprintf("Event '%s' failed after pipeline %s used up its retry budget, trying dead letter index", encodedEvent, encodedEvent.pipeline), logp.TypeKey, logp.EventType)
+			encodedEvent.setDeadLetter(client.deadLetterIndex, client.deadLetterDS, client.deadLetterFields, 0, fmt.Sprintf("retry budget of pipeline %s used up", encodedEvent.pipeline))
+			// The pipeline is failing, so don't run the dead letter
+			// document through it.
+			encodedEvent.pipeline = ""
+		}
+		eventsToRetry = append(eventsToRetry, event)
+	}
+	return eventsToRetry
 }
 
 // applyItemStatus processes the ingestion status of one event from a bulk request.
@@ -505,59 +1666,100 @@ func (client *Client) applyItemStatus(
 			stats.deadLetter++
 		} else {
 			stats.acked++
+			encodedEvent.indexed = true
+			client.retryBudget.ingested(encodedEvent.pipeline)
 		}
 		return false // no retry needed
 	}
 
-	if itemStatus == 409 {
-		// 409 is used to indicate there is already an event with the same ID, or
-		// with identical Time Series Data Stream dimensions when TSDS is active.
+	action := client.itemStatusAction(itemStatus)
+	if action == statusActionDuplicate {
+		encodedEvent.indexed = !encodedEvent.deadLetter
 		stats.duplicates++
 		return false // no retry needed
 	}
 
-	if itemStatus == http.StatusTooManyRequests {
+	if action == statusActionRetry {
 		stats.fails++
-		stats.tooMany++
+		if itemStatus == http.StatusTooManyRequests {
+			stats.tooMany++
+		}
 		return true
 	}
 
-	if itemStatus < 500 {
-		// hard failure, apply policy action
-		if encodedEvent.deadLetter {
-			// Fatal error while sending an already-failed event to the dead letter
-			// index, drop.
-			client.pLogDeadLetter.Add()
-			client.log.Errorw(fmt.Sprintf("Can't deliver to dead letter index event '%s' (status=%v): %s", encodedEvent, itemStatus, itemMessage), logp.TypeKey, logp.EventType)
-			stats.nonIndexable++
-			return false
-		}
-		if client.deadLetterIndex == "" {
-			// Fatal error and no dead letter index, drop.
-			client.pLogIndex.Add()
+	// hard failure, apply policy action
+	if encodedEvent.deadLetter {
+		// Fatal error while sending an already-failed event to the dead letter
+		// index, drop.
+		client.pLogDeadLetter.Add()
+		client.log.Errorw(fmt.Sprintf("Can't deliver to dead letter index event '%s' (status=%v): %s", encodedEvent, itemStatus, itemMessage), logp.TypeKey, logp.EventType)
+		client.dropped(event, dropReasonNonIndexable)
+		stats.nonIndexable++
+		return false
+	}
+	if action == statusActionDrop || client.deadLetterIndex == "" {
+		// Fatal error and no dead letter index, drop.
+		client.pLogIndex.Add()
+		if client.errorLogs.allow(encodedEvent.index, itemMessage) {
 			client.log.Warnw(fmt.Sprintf("Cannot index event '%s' (status=%v): %s, dropping event!", encodedEvent, itemStatus, itemMessage), logp.TypeKey, logp.EventType)
-			stats.nonIndexable++
-			return false
 		}
-		// Send this failure to the dead letter index and "retry".
-		// We count this as a "retryable failure", and then if the dead letter
-		// ingestion succeeds it is counted in the "deadLetter" counter
-		// rather than the "acked" counter.
-		client.pLogIndexTryDeadLetter.Add()
+		client.dropped(event, dropReasonNonIndexable)
+		stats.nonIndexable++
+		return false
+	}
+	// Send this failure to the dead letter index and "retry".
+	// We count this as a "retryable failure", and then if the dead letter
+	// ingestion succeeds it is counted in the "deadLetter" counter
+	// rather than the "acked" counter.
+	client.pLogIndexTryDeadLetter.Add()
+	if client.errorLogs.allow(encodedEvent.index, itemMessage) {
 		client.log.Warnw(fmt.Sprintf("Cannot index event '%s' (status=%v): %s, trying dead letter index", encodedEvent, itemStatus, itemMessage), logp.TypeKey, logp.EventType)
-		encodedEvent.setDeadLetter(client.deadLetterIndex, itemStatus, string(itemMessage))
 	}
-
-	// Everything else gets retried.
+	if failure, ok := bulkItemPipelineFailure(itemMessage); ok {
+		encodedEvent.setPipelineDeadLetter(client.deadLetterIndex, client.deadLetterDS, client.deadLetterFields, itemStatus, string(itemMessage), failure)
+	} else {
+		encodedEvent.setDeadLetter(client.deadLetterIndex, client.deadLetterDS, client.deadLetterFields, itemStatus, string(itemMessage))
+	}
 	stats.fails++
 	return true
 }
 
+// itemStatusAction returns the action applied to events whose bulk item
+// failed with status. Actions configured in item_status_actions take
+// precedence. Otherwise, 409 Conflict means that a document with the same
+// ID, or with identical Time Series Data Stream dimensions when TSDS is
+// active, was already indexed, so the event is counted as a duplicate
+// unless version_conflict_action is fail. 429 Too Many Requests and
+// server errors are retried. Other errors are caused by the event itself,
+// so it is sent to the dead letter index if there is one, or dropped.
+func (client *Client) itemStatusAction(status int) string {
+	if action, ok := client.statusActions[status]; ok {
+		return action
+	}
+	switch {
+	case status == http.StatusConflict && client.versionConflictAction != versionConflictFail:
+		return statusActionDuplicate
+	case status == http.StatusTooManyRequests, status >= 500:
+		return statusActionRetry
+	case client.deadLetterIndex == "":
+		return statusActionDrop
+	default:
+		return statusActionDeadLetter
+	}
+}
+
 func (client *Client) Connect(ctx context.Context) error {
-	return client.conn.Connect(ctx)
+	if client.dryRun {
+		return nil
+	}
+	if err := client.conn.Connect(ctx); err != nil {
+		return err
+	}
+	return client.checkClockSkew()
 }
 
 func (client *Client) Close() error {
+	client.errorLogs.close()
 	return client.conn.Close()
 }
 
@@ -569,13 +1771,19 @@ func (client *Client) Test(d testing.Driver) {
 	client.conn.Test(d)
 }
 
-func (stats bulkResultStats) reportToObserver(ob outputs.Observer) {
+func (stats bulkResultStats) reportToObserver(ob Observer) {
 	ob.AckedEvents(stats.acked)
 	ob.RetryableErrors(stats.fails)
 	ob.PermanentErrors(stats.nonIndexable)
 	ob.DuplicateEvents(stats.duplicates)
 	ob.DeadLetterEvents(stats.deadLetter)
 	ob.FailureStoreEvents(stats.failureStoreUsed)
+	ob.NoopEvents(stats.noop)
+	ob.AuditEvents(stats.auditAcked, stats.auditFailed)
 
 	ob.ErrTooMany(stats.tooMany)
+
+	for index, s := range stats.indices {
+		ob.IndexEvents(index, s.acked, s.fails, s.nonIndexable, s.tooMany)
+	}
 }
@@ -22,27 +22,34 @@
 package elasticsearch
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
 	e "github.com/elastic/beats/v7/libbeat/beat/events"
 	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/common/fmtstr"
 	"github.com/elastic/beats/v7/libbeat/esleg/eslegclient"
 	"github.com/elastic/beats/v7/libbeat/idxmgmt"
 	"github.com/elastic/beats/v7/libbeat/internal/testutil"
@@ -150,6 +157,7 @@ func TestPublish(t *testing.T) {
 		assert.True(t, batch.didSplit, "batch should be split")
 		assertRegistryUint(t, reg, "events.failed", 1, "Splitting a batch should report the event as failed/retried")
 		assertRegistryUint(t, reg, "events.dropped", 0, "Splitting a batch should not report any dropped events")
+		assertRegistryUint(t, reg, "events.too_large", 0, "Splitting a batch should not report any events as too large")
 
 		// Try publishing a batch that cannot be split
 		batch = encodeBatch(client, &batchMock{
@@ -163,9 +171,64 @@ func TestPublish(t *testing.T) {
 		assert.True(t, batch.drop, "unsplittable batch should be dropped")
 		assertRegistryUint(t, reg, "events.failed", 1, "Failed batch split should not report any more retryable failures")
 		assertRegistryUint(t, reg, "events.dropped", 1, "Failed batch split should report a dropped event")
+		assertRegistryUint(t, reg, "events.too_large", 1, "Failed batch split of a single event should report it as too large")
 
 	})
 
+	t.Run("indexes a summary of dropped batches", func(t *testing.T) {
+		var summaries []string
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if !bytes.Contains(body, []byte(`"drop-summaries"`)) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				_, _ = w.Write([]byte("Request failed to get to the server (status code: 413)"))
+				return
+			}
+			summaries = append(summaries, string(body))
+			_, _ = io.WriteString(w, `{"items":[{"create":{"status":201}}]}`)
+		}))
+		defer esMock.Close()
+		client, err := NewClient(
+			clientSettings{
+				observer:      outputs.NewNilObserver(),
+				connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+				indexSelector: testIndexSelector{},
+				dropSummary:   DropSummary{Enabled: true, Index: "drop-summaries"},
+			},
+			nil,
+			logger,
+		)
+		require.NoError(t, err)
+
+		batch := encodeBatch(client, &batchMock{
+			events: []publisher.Event{event1, event2},
+		})
+		err = client.Publish(ctx, batch)
+
+		require.NoError(t, err)
+		assert.True(t, batch.drop, "unsplittable batch should be dropped")
+		require.Len(t, summaries, 1, "a summary of the dropped batch should be indexed")
+		lines := strings.Split(strings.TrimSpace(summaries[0]), "\n")
+		require.Len(t, lines, 2, "the summary should be the only document")
+		assert.Contains(t, lines[0], `"_index":"drop-summaries"`)
+		var summary map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &summary))
+		assert.Equal(t, dropReasonTooLarge, summary["drop.reason"])
+		assert.EqualValues(t, 2, summary["drop.event_count"])
+		assert.Equal(t, []any{"test"}, summary["drop.indices"])
+		assert.Contains(t, summary["drop.error"], "413")
+
+		// Summaries are not indexed unless enabled.
+		summaries = nil
+		client.dropSummary.Enabled = false
+		batch = encodeBatch(client, &batchMock{
+			events: []publisher.Event{event1},
+		})
+		require.NoError(t, client.Publish(ctx, batch))
+		assert.True(t, batch.drop, "unsplittable batch should be dropped")
+		assert.Empty(t, summaries, "no summary should be indexed")
+	})
+
 	t.Run("retries the batch if bad HTTP status", func(t *testing.T) {
 		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -185,6 +248,105 @@ func TestPublish(t *testing.T) {
 		assertRegistryUint(t, reg, "events.failed", 2, "HTTP failure should report failed events")
 	})
 
+	t.Run("delays the retry by Retry-After on status code 429", func(t *testing.T) {
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer esMock.Close()
+		client, _ := makePublishTestClient(t, esMock.URL)
+
+		batch := encodeBatch(client, &batchMock{
+			events: []publisher.Event{event1, event2},
+		})
+
+		err := client.Publish(ctx, batch)
+
+		var retryErr *outputs.RetryAfterError
+		require.ErrorAs(t, err, &retryErr, "Publish should return the requested retry delay")
+		assert.Equal(t, 7*time.Second, retryErr.Delay, "the retry delay should be taken from the Retry-After header")
+		assert.Len(t, batch.retryEvents, 2, "all events should be retried")
+	})
+
+	t.Run("falls back to backoff on status code 429 without Retry-After", func(t *testing.T) {
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer esMock.Close()
+		client, _ := makePublishTestClient(t, esMock.URL)
+
+		batch := encodeBatch(client, &batchMock{
+			events: []publisher.Event{event1, event2},
+		})
+
+		err := client.Publish(ctx, batch)
+
+		require.Error(t, err)
+		var retryErr *outputs.RetryAfterError
+		assert.NotErrorAs(t, err, &retryErr, "Publish should not request a retry delay without a Retry-After header")
+		assert.Len(t, batch.retryEvents, 2, "all events should be retried")
+	})
+
+	for name, gzipped := range map[string]bool{
+		"decompresses gzip-encoded bulk responses":            true,
+		"reads plain bulk responses when gzip is not applied": false,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var acceptEncoding string
+			esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				resp := `{"items":[` +
+					`{"create":{"status":201}},` +
+					`{"create":{"status":503,"error":{"type":"unavailable_shards_exception"}}},` +
+					`{"create":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`
+				if !gzipped {
+					_, _ = io.WriteString(w, resp)
+					return
+				}
+				w.Header().Set("Content-Encoding", "gzip")
+				gz := gzip.NewWriter(w)
+				_, _ = io.WriteString(gz, resp)
+				_ = gz.Close()
+			}))
+			defer esMock.Close()
+			client, reg := makePublishTestClient(t, esMock.URL)
+
+			batch := encodeBatch(client, &batchMock{
+				events: []publisher.Event{event1, event2, event3},
+			})
+
+			err := client.Publish(ctx, batch)
+
+			require.NoError(t, err)
+			assert.Equal(t, "gzip", acceptEncoding, "bulk requests should accept gzip-encoded responses")
+			require.Len(t, batch.retryEvents, 1, "the unavailable event should be retried")
+			assertRegistryUint(t, reg, "events.acked", 1, "the created event should be acknowledged")
+			assertRegistryUint(t, reg, "events.failed", 1, "the unavailable event should be reported as failed")
+			assertRegistryUint(t, reg, "events.dropped", 1, "the invalid event should be dropped")
+		})
+	}
+
+	t.Run("records the bulk request latency", func(t *testing.T) {
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			time.Sleep(10 * time.Millisecond)
+			_, _ = io.WriteString(w, `{"items": [{"create":{"status":201}},{"create":{"status":201}}]}`)
+		}))
+		defer esMock.Close()
+		client, reg := makePublishTestClient(t, esMock.URL)
+
+		batch := encodeBatch(client, &batchMock{
+			events: []publisher.Event{event1, event2},
+		})
+		err := client.Publish(ctx, batch)
+		require.NoError(t, err)
+
+		snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+		assert.Equal(t, int64(1), snapshot.Ints["bulk_requests.latency.histogram.count"], "the bulk request should be observed once")
+		assert.GreaterOrEqual(t, snapshot.Floats["bulk_requests.latency.histogram.p99"], 10.0, "the observed latency should include the server response time")
+	})
+
 	t.Run("live batches, still too big after split", func(t *testing.T) {
 		// Test a live (non-mocked) batch where all three events by themselves are
 		// rejected by the server as too large after the initial batch splits.
@@ -230,10 +392,12 @@ func TestPublish(t *testing.T) {
 		// Metrics should report:
 		// 8 total events (3 + 1 + 2 + 1 + 1 from the batches described above)
 		// 3 dropped events (each event is dropped once)
+		// 3 too large events (each dropped event is in a single-event batch)
 		// 5 failed events (8 - 3, for each event's attempted publish calls before being dropped)
 		// 0 active events (because Publish is complete)
 		assertRegistryUint(t, reg, "events.total", 8, "Publish is called on 8 events total")
 		assertRegistryUint(t, reg, "events.dropped", 3, "All 3 events should be dropped")
+		assertRegistryUint(t, reg, "events.too_large", 3, "All 3 events should be reported as too large")
 		assertRegistryUint(t, reg, "events.failed", 5, "Split batches should retry 5 events before dropping them")
 		assertRegistryUint(t, reg, "events.active", 0, "Active events should be zero when Publish returns")
 	})
@@ -293,15 +457,139 @@ func TestPublish(t *testing.T) {
 		// The metrics should show:
 		// 6 total events (3 + 1 + 2)
 		// 1 dropped event (because only one event is uningestable)
+		// 1 too large event (the dropped event, in a single-event batch)
 		// 2 acked events (because the other two ultimately succeed)
 		// 3 failed events (because all events fail and are retried on the first call)
 		// 0 active events (because Publish is finished)
 		assertRegistryUint(t, reg, "events.total", 6, "Publish is called on 6 events total")
 		assertRegistryUint(t, reg, "events.dropped", 1, "One event should be dropped")
+		assertRegistryUint(t, reg, "events.too_large", 1, "The dropped event should be reported as too large")
 		assertRegistryUint(t, reg, "events.failed", 3, "Split batches should retry 3 events before dropping them")
 		assertRegistryUint(t, reg, "events.active", 0, "Active events should be zero when Publish returns")
 	})
 
+	t.Run("pre-splits batches exceeding max_bulk_bytes", func(t *testing.T) {
+		large := strings.Repeat("x", 500)
+		var requests atomic.Int64
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			b, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(b), large) {
+				// Only the oversized event is too large for the server.
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				_, _ = w.Write([]byte("Request failed to get to the server (status code: 413)")) // actual response from ES
+				return
+			}
+			// Each event has a metadata line and a document line.
+			items := make([]string, strings.Count(string(b), "\n")/2)
+			for i := range items {
+				items[i] = `{"index":{"status":200}}`
+			}
+			_, _ = io.WriteString(w, `{"items":[`+strings.Join(items, ",")+`]}`)
+		}))
+		defer esMock.Close()
+
+		reg := monitoring.NewRegistry()
+		client, err := NewClient(
+			clientSettings{
+				observer:      outputs.NewStats(reg, logp.NewNopLogger()),
+				connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+				indexSelector: testIndexSelector{},
+				maxBulkBytes:  400,
+			},
+			nil,
+			logger,
+		)
+		require.NoError(t, err)
+
+		var events []publisher.Event
+		for i := range 6 {
+			events = append(events, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": i, "padding": strings.Repeat("p", 20)}}})
+		}
+		events = append(events[:3], append([]publisher.Event{{Content: beat.Event{Fields: mapstr.M{"field": "large", "padding": large}}}}, events[3:]...)...)
+		batch := encodeBatch(client, &batchMock{events: events})
+
+		err = client.Publish(ctx, batch)
+
+		assert.NoError(t, err, "Publish should succeed")
+		assert.True(t, batch.ack, "batch should be acknowledged")
+		assert.Empty(t, batch.retryEvents, "no events should be retried")
+		// The small events are sent in two chunks of three events whose
+		// action and source lines fit in 400 bytes, and the large event is
+		// sent alone.
+		assert.Equal(t, int64(3), requests.Load(), "the batch should be sent in multiple requests")
+		assertRegistryUint(t, reg, "batches.presplit", 1, "the batch should be reported as pre-split")
+		assertRegistryUint(t, reg, "batches.split", 0, "the batch should not be reported as reactively split")
+		assertRegistryUint(t, reg, "events.acked", 6, "the small events should be acknowledged")
+		assertRegistryUint(t, reg, "events.dropped", 1, "the oversized event should be dropped")
+		assertRegistryUint(t, reg, "events.too_large", 1, "the oversized event should be reported as too large")
+		assertRegistryUint(t, reg, "events.active", 0, "Active events should be zero when Publish returns")
+	})
+
+	t.Run("halves pre-split chunks rejected with 413", func(t *testing.T) {
+		const maxBulkBytes = 400
+		var (
+			mu    sync.Mutex
+			sizes []int
+		)
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			sizes = append(sizes, len(b))
+			mu.Unlock()
+			// Each event has a metadata line and a document line.
+			items := make([]string, strings.Count(string(b), "\n")/2)
+			if len(items) > 2 {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				_, _ = w.Write([]byte("Request failed to get to the server (status code: 413)")) // actual response from ES
+				return
+			}
+			for i := range items {
+				items[i] = `{"index":{"status":200}}`
+			}
+			_, _ = io.WriteString(w, `{"items":[`+strings.Join(items, ",")+`]}`)
+		}))
+		defer esMock.Close()
+
+		reg := monitoring.NewRegistry()
+		client, err := NewClient(
+			clientSettings{
+				observer:      outputs.NewStats(reg, logp.NewNopLogger()),
+				connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+				indexSelector: testIndexSelector{},
+				maxBulkBytes:  maxBulkBytes,
+			},
+			nil,
+			logger,
+		)
+		require.NoError(t, err)
+
+		var events []publisher.Event
+		for i := range 8 {
+			events = append(events, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": i, "padding": strings.Repeat("p", 20)}}})
+		}
+		batch := encodeBatch(client, &batchMock{events: events})
+
+		err = client.Publish(ctx, batch)
+
+		assert.NoError(t, err, "Publish should succeed")
+		assert.True(t, batch.ack, "batch should be acknowledged")
+		assert.Empty(t, batch.retryEvents, "no events should be retried")
+		// The events are pre-split in chunks of three, three and two events
+		// whose action and source lines fit in maxBulkBytes, and the chunks
+		// of three events are halved when they are rejected.
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, sizes, 7, "the rejected chunks should be sent again in halves")
+		for _, size := range sizes {
+			assert.LessOrEqual(t, size, maxBulkBytes, "requests should not exceed max_bulk_bytes")
+		}
+		assertRegistryUint(t, reg, "batches.presplit", 1, "the batch should be reported as pre-split")
+		assertRegistryUint(t, reg, "batches.split", 2, "each rejected chunk should be reported as split")
+		assertRegistryUint(t, reg, "events.acked", 8, "all events should be acknowledged")
+		assertRegistryUint(t, reg, "events.active", 0, "Active events should be zero when Publish returns")
+	})
+
 	t.Run("sends telemetry headers", func(t *testing.T) {
 		events := []publisher.Event{event1, event2, event3}
 		eventsRaw := `{"index":{"_index":"test","_type":"doc"}}
@@ -315,10 +603,11 @@ func TestPublish(t *testing.T) {
 			events: events,
 		}
 
-		var requestCount, uncompressedLength, eventCount atomic.Int64
+		var requestCount, uncompressedLength, contentLength, eventCount atomic.Int64
 		buf := bytes.NewBuffer(nil)
 		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			requestCount.Add(1)
+			contentLength.Store(r.ContentLength)
 
 			ul := r.Header.Get(eslegclient.HeaderUncompressedLength)
 			if ul != "" {
@@ -341,20 +630,25 @@ func TestPublish(t *testing.T) {
 			w.Write([]byte(`{"took": 30, "errors": false, "items": [] }`))
 		}))
 		defer esMock.Close()
-		client, _ := makePublishTestClient(t, esMock.URL)
-		gzipClient, _ := makePublishGzipTestClient(t, esMock.URL, 5)
+		client, reg := makePublishTestClient(t, esMock.URL)
+		gzipClient, gzipReg := makePublishGzipTestClient(t, esMock.URL, 5)
 
 		cases := []struct {
-			name   string
-			client *Client
+			name       string
+			client     *Client
+			reg        *monitoring.Registry
+			compressed bool
 		}{
 			{
 				name:   "uncompressed",
 				client: client,
+				reg:    reg,
 			},
 			{
-				name:   "compressed",
-				client: gzipClient,
+				name:       "compressed",
+				client:     gzipClient,
+				reg:        gzipReg,
+				compressed: true,
 			},
 		}
 
@@ -374,6 +668,76 @@ func TestPublish(t *testing.T) {
 				assert.Equal(t, int64(len(eventsRaw)), uncompressedLength.Load(), "Should have the correct %s header", eslegclient.HeaderUncompressedLength)
 				assert.Equal(t, int64(3), eventCount.Load(), "Should have the correct %s header", HeaderEventCount)
 				assert.Equal(t, eventsRaw, buf.String(), "Should have the correct body")
+
+				snapshot := monitoring.CollectFlatSnapshot(tc.reg, monitoring.Full, false)
+				assert.Equal(t, int64(1), snapshot.Ints["bulk_requests.bytes.uncompressed.histogram.count"], "the uncompressed request size should be observed once")
+				assert.Equal(t, int64(1), snapshot.Ints["bulk_requests.bytes.compressed.histogram.count"], "the compressed request size should be observed once")
+				assert.Equal(t, int64(len(eventsRaw)), snapshot.Ints["bulk_requests.bytes.uncompressed.histogram.max"], "the uncompressed request size should match the request body")
+				assert.Equal(t, contentLength.Load(), snapshot.Ints["bulk_requests.bytes.compressed.histogram.max"], "the compressed request size should match the sent request body")
+				if tc.compressed {
+					assert.Less(t, contentLength.Load(), int64(len(eventsRaw)), "the compressed request body should be smaller")
+				}
+			})
+		}
+	})
+
+	t.Run("reports the compression ratio", func(t *testing.T) {
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"took": 30, "errors": false, "items": [] }`))
+		}))
+		defer esMock.Close()
+
+		// Random bytes encoded in base64 only compress to about three
+		// quarters of their size.
+		random := make([]byte, 4096)
+		_, _ = rand.Read(random)
+		cases := []struct {
+			name       string
+			message    string
+			compressed bool
+			minRatio   float64
+			maxRatio   float64
+		}{
+			{
+				name:       "compressible",
+				message:    strings.Repeat("a", 4096),
+				compressed: true,
+				minRatio:   0,
+				maxRatio:   0.1,
+			},
+			{
+				name:       "incompressible",
+				message:    base64.StdEncoding.EncodeToString(random),
+				compressed: true,
+				minRatio:   0.7,
+				maxRatio:   1.1,
+			},
+			{
+				name:    "uncompressed",
+				message: strings.Repeat("a", 4096),
+			},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				client, reg := makePublishTestClient(t, esMock.URL)
+				if tc.compressed {
+					client, reg = makePublishGzipTestClient(t, esMock.URL, 5)
+				}
+				batch := encodeBatch(client, &batchMock{events: []publisher.Event{
+					{Content: beat.Event{Fields: mapstr.M{"message": tc.message}}},
+				}})
+				require.NoError(t, client.Publish(ctx, batch))
+
+				snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+				ratio := snapshot.Floats["bulk_requests.compression_ratio"]
+				if !tc.compressed {
+					assert.Zero(t, ratio, "the compression ratio should not be reported for uncompressed requests")
+					return
+				}
+				assert.Greater(t, ratio, tc.minRatio, "the compression ratio should be within the expected range")
+				assert.Less(t, ratio, tc.maxRatio, "the compression ratio should be within the expected range")
 			})
 		}
 	})
@@ -415,6 +779,97 @@ func TestCollectPublishFailsNone(t *testing.T) {
 	assert.Equal(t, 0, len(res))
 }
 
+func TestCollectPublishFailsFastAck(t *testing.T) {
+	// Successful items that used the failure store are only counted when
+	// the items are read, which tells whether the fast path was taken.
+	const (
+		used    = `{"create": {"status": 201, "failure_store": "used"}}`
+		created = `{"create": {"status": 201}}`
+		tooMany = `{"create": {"status": 429, "error": "ups"}}`
+	)
+	tests := map[string]struct {
+		fastAck   bool
+		audit     bool
+		response  string
+		wantStats bulkResultStats
+		wantRetry int
+	}{
+		"fast path": {
+			fastAck:   true,
+			response:  `{"took": 3, "errors": false, "items": [` + used + `,` + created + `]}`,
+			wantStats: bulkResultStats{acked: 2},
+		},
+		"fast path with audit items": {
+			fastAck:   true,
+			audit:     true,
+			response:  `{"errors": false, "items": [` + used + `,` + created + `,` + created + `,` + created + `]}`,
+			wantStats: bulkResultStats{acked: 2, auditAcked: 2},
+		},
+		"disabled": {
+			response:  `{"errors": false, "items": [` + used + `,` + created + `]}`,
+			wantStats: bulkResultStats{acked: 2, failureStoreUsed: 1},
+		},
+		"errors": {
+			fastAck:   true,
+			response:  `{"errors": true, "items": [` + used + `,` + tooMany + `]}`,
+			wantStats: bulkResultStats{acked: 1, fails: 1, tooMany: 1, failureStoreUsed: 1},
+			wantRetry: 1,
+		},
+		"no errors field": {
+			fastAck:   true,
+			response:  `{"items": [` + used + `,` + created + `]}`,
+			wantStats: bulkResultStats{acked: 2, failureStoreUsed: 1},
+		},
+		"errors after items": {
+			fastAck:   true,
+			response:  `{"items": [` + used + `,` + created + `], "errors": false}`,
+			wantStats: bulkResultStats{acked: 2, failureStoreUsed: 1},
+		},
+		"missing item": {
+			fastAck:   true,
+			response:  `{"errors": false, "items": [` + used + `]}`,
+			wantStats: bulkResultStats{acked: 1, fails: 1, failureStoreUsed: 1},
+			wantRetry: 1,
+		},
+		"missing audit item": {
+			fastAck:   true,
+			audit:     true,
+			response:  `{"errors": false, "items": [` + used + `,` + created + `,` + created + `]}`,
+			wantStats: bulkResultStats{acked: 2, auditAcked: 1, failureStoreUsed: 1},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, err := NewClient(
+				clientSettings{
+					observer:   outputs.NewNilObserver(),
+					auditIndex: "audit",
+					fastAck:    tc.fastAck,
+				},
+				nil,
+				logptest.NewTestingLogger(t, ""),
+			)
+			require.NoError(t, err)
+
+			events := encodeEvents(client, []publisher.Event{
+				{Content: beat.Event{Fields: mapstr.M{"field": 1}}},
+				{Content: beat.Event{Fields: mapstr.M{"field": 2}}},
+			})
+			for _, event := range events {
+				event.EncodedEvent.(*encodedEvent).audit = tc.audit //nolint:errcheck //safe to ignore type check
+			}
+
+			res, stats := client.bulkCollectPublishFails(bulkResult{
+				events:   events,
+				status:   200,
+				response: []byte(tc.response),
+			})
+			assert.Equal(t, tc.wantStats, stats)
+			assert.Len(t, res, tc.wantRetry)
+		})
+	}
+}
+
 func TestCollectPublishFailMiddle(t *testing.T) {
 	logger := logptest.NewTestingLogger(t, "")
 	client, err := NewClient(
@@ -451,6 +906,58 @@ func TestCollectPublishFailMiddle(t *testing.T) {
 	assert.Equal(t, bulkResultStats{acked: 2, fails: 1, tooMany: 1}, stats)
 }
 
+func TestCollectPublishFailVersionConflict(t *testing.T) {
+	response := []byte(`
+    { "items": [
+      {"create": {"status": 200}},
+      {"create": {"status": 409, "error": {"type": "version_conflict_engine_exception"}}},
+      {"create": {"status": 429, "error": "ups"}}
+    ]}
+  `)
+
+	tests := map[string]struct {
+		action    string
+		wantStats bulkResultStats
+	}{
+		"conflicts are duplicates by default": {
+			wantStats: bulkResultStats{acked: 1, duplicates: 1, fails: 1, tooMany: 1},
+		},
+		"conflicts are duplicates": {
+			action:    versionConflictDuplicate,
+			wantStats: bulkResultStats{acked: 1, duplicates: 1, fails: 1, tooMany: 1},
+		},
+		"conflicts are failures": {
+			action:    versionConflictFail,
+			wantStats: bulkResultStats{acked: 1, nonIndexable: 1, fails: 1, tooMany: 1},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, err := NewClient(
+				clientSettings{
+					observer:              outputs.NewNilObserver(),
+					versionConflictAction: tc.action,
+				},
+				nil,
+				logptest.NewTestingLogger(t, ""),
+			)
+			require.NoError(t, err)
+
+			eventOK := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 1}}})
+			eventConflict := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 2}}})
+			eventTooMany := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 3}}})
+
+			res, stats := client.bulkCollectPublishFails(bulkResult{
+				events:   []publisher.Event{eventOK, eventConflict, eventTooMany},
+				status:   200,
+				response: response,
+			})
+			assert.Equal(t, []publisher.Event{eventTooMany}, res, "only the 429 item should be retried")
+			assert.Equal(t, tc.wantStats, stats)
+		})
+	}
+}
+
 func TestCollectPublishFailDeadLetterSuccess(t *testing.T) {
 	const deadLetterIndex = "test_index"
 	logger := logptest.NewTestingLogger(t, "")
@@ -458,6 +965,7 @@ func TestCollectPublishFailDeadLetterSuccess(t *testing.T) {
 		clientSettings{
 			observer:        outputs.NewNilObserver(),
 			deadLetterIndex: deadLetterIndex,
+			deadLetterDS:    true,
 		},
 		nil,
 		logger,
@@ -468,10 +976,21 @@ func TestCollectPublishFailDeadLetterSuccess(t *testing.T) {
 	// Return a successful response
 	response := []byte(`{"items": [{"create": {"status": 200}}]}`)
 
-	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 1}}})
-	event1.EncodedEvent.(*encodedEvent).setDeadLetter(deadLetterIndex, 123, errorMessage)
+	// The original event is indexed with the index op_type, which data
+	// streams don't accept.
+	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{
+		Fields: mapstr.M{"bar": 1},
+		Meta:   mapstr.M{e.FieldMetaID: "id1", e.FieldMetaOpType: "index"},
+	}})
+	event1.EncodedEvent.(*encodedEvent).setDeadLetter(deadLetterIndex, client.deadLetterDS, client.deadLetterFields, 123, errorMessage)
 	events := []publisher.Event{event1}
 
+	// The dead letter index is a data stream, so the event must be sent to
+	// it with a create action.
+	_, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+	require.Len(t, bulkItems, 2, "the event should be encoded with its action")
+	assert.Equal(t, eslegclient.BulkCreateAction{Create: eslegclient.BulkMeta{Index: deadLetterIndex, ID: "id1"}}, bulkItems[0], "the dead letter action should be create")
+
 	// The event should be successful after being set to dead letter, so it
 	// should be reported in the metrics as deadLetter
 	res, stats := client.bulkCollectPublishFails(bulkResult{
@@ -503,7 +1022,7 @@ func TestCollectPublishFailFatalErrorNotRetried(t *testing.T) {
 	response := []byte(`{"items": [{"create": {"status": 499}}]}`)
 
 	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 1}}})
-	event1.EncodedEvent.(*encodedEvent).setDeadLetter(deadLetterIndex, 123, errorMessage)
+	event1.EncodedEvent.(*encodedEvent).setDeadLetter(deadLetterIndex, false, deadLetterFields{}, 123, errorMessage)
 	events := []publisher.Event{event1}
 
 	// The event should fail permanently while being sent to the dead letter
@@ -548,12 +1067,93 @@ func TestCollectPublishFailInvalidBulkIndexResponse(t *testing.T) {
 	}
 }
 
+func TestCollectPublishFailEmptyBulkResponse(t *testing.T) {
+	logger, logs := logptest.NewTestingLoggerWithObserver(t, "")
+	var dropped []string
+	client, err := NewClient(
+		clientSettings{
+			observer:                outputs.NewNilObserver(),
+			maxEmptyResponseRetries: 2,
+			onDrop: func(_ publisher.Event, reason string) {
+				dropped = append(dropped, reason)
+			},
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+
+	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 1}}})
+	event2 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 2}}})
+
+	for i := 1; i <= 2; i++ {
+		res, stats := client.bulkCollectPublishFails(bulkResult{
+			events:   []publisher.Event{event1, event2},
+			status:   200,
+			response: []byte(" \n"),
+		})
+		assert.Equal(t, bulkResultStats{fails: 2, emptyResponse: 2}, stats)
+		assert.Equal(t, []publisher.Event{event1, event2}, res, "the events should be returned for retry")
+		assert.ErrorIs(t, publishResultForStats(stats), errEmptyResponse)
+	}
+	assert.Equal(t, 2, logs.FilterMessageSnippet("empty response body").Len(), "an empty response should be logged as such")
+	assert.Zero(t, logs.FilterMessageSnippet("failed to parse bulk response").Len(), "an empty response is not a malformed one")
+
+	// Past the limit, the events are dropped.
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events: []publisher.Event{event1, event2},
+		status: 200,
+	})
+	assert.Equal(t, bulkResultStats{nonIndexable: 2}, stats)
+	assert.Empty(t, res)
+	assert.NoError(t, publishResultForStats(stats))
+	assert.Equal(t, []string{dropReasonEmptyResponse, dropReasonEmptyResponse}, dropped)
+}
+
+func TestCollectPublishFailTopLevelError(t *testing.T) {
+	logger, logs := logptest.NewTestingLoggerWithObserver(t, "")
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewNilObserver(),
+			fastAck:  true,
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+
+	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 1}}})
+	event2 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 2}}})
+
+	responses := map[string]string{
+		"no items":         `{"error":{"type":"proxy_error","reason":"upstream unavailable"},"status":502}`,
+		"no errors":        `{"took":1,"errors":false,"error":{"type":"proxy_error","reason":"upstream unavailable"},"items":[]}`,
+		"before the items": `{"error":"upstream unavailable","items":[{"create":{"status":201}},{"create":{"status":201}}]}`,
+	}
+	for name, response := range responses {
+		t.Run(name, func(t *testing.T) {
+			logs.TakeAll()
+			res, stats := client.bulkCollectPublishFails(bulkResult{
+				events:   []publisher.Event{event1, event2},
+				status:   200,
+				response: []byte(response),
+			})
+			assert.Equal(t, bulkResultStats{fails: 2, responseError: 2}, stats, "the events should not be acked")
+			assert.Equal(t, []publisher.Event{event1, event2}, res, "the events should be returned for retry")
+			assert.ErrorIs(t, publishResultForStats(stats), errResponseError)
+			assert.Equal(t, 1, logs.FilterMessageSnippet("upstream unavailable").Len(), "the top-level error should be logged")
+			assert.Zero(t, logs.FilterMessageSnippet("failed to parse bulk response").Len(), "a top-level error is not a malformed response")
+		})
+	}
+}
+
 func TestCollectPublishFailDeadLetterIndex(t *testing.T) {
 	logger := logptest.NewTestingLogger(t, "")
 	const deadLetterIndex = "test_index"
 	client, err := NewClient(
 		clientSettings{
 			observer:        outputs.NewNilObserver(),
+			indexSelector:   testIndexSelector{},
 			deadLetterIndex: deadLetterIndex,
 		},
 		nil,
@@ -572,29 +1172,71 @@ func TestCollectPublishFailDeadLetterIndex(t *testing.T) {
 				"status" : 400
 			}
 		},
-		{"create": {"status": 200}}
-	]
-}`)
-
+		{"create": {"status": 200}},
+		{
+			"create": {
+				"error": {
+					"type": "illegal_argument_exception",
+					"reason": "field [fail_on_purpose] not present as part of path [fail_on_purpose]",
+					"header": {
+						"processor_type": "lowercase",
+						"pipeline_origin": ["inner-pipeline", "outer-pipeline"]
+					}
+				},
+				"status": 400
+			}
+		}
+	]
+}`)
+
 	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 1}}})
 	event2 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 2}}})
-	eventFail := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": "bar1"}}})
-	events := []publisher.Event{event1, eventFail, event2}
+	eventFail := encodeEvent(client, publisher.Event{Content: beat.Event{
+		Fields: mapstr.M{"bar": "bar1"},
+		Meta:   mapstr.M{e.FieldMetaID: "id1"},
+	}})
+	eventPipelineFail := encodeEvent(client, publisher.Event{Content: beat.Event{
+		Fields: mapstr.M{"bar": 3},
+		Meta:   mapstr.M{e.FieldMetaPipeline: "outer-pipeline"},
+	}})
+	events := []publisher.Event{event1, eventFail, event2, eventPipelineFail}
 
 	res, stats := client.bulkCollectPublishFails(bulkResult{
 		events:   events,
 		status:   200,
 		response: response,
 	})
-	assert.Equal(t, bulkResultStats{acked: 2, fails: 1, nonIndexable: 0}, stats)
-	assert.Equal(t, 1, len(res))
-	if len(res) == 1 {
+	assert.Equal(t, bulkResultStats{acked: 2, fails: 2, nonIndexable: 0}, stats)
+	require.Equal(t, 2, len(res))
+
+	// A processor error of an ingest pipeline is described in the dead
+	// letter document, which isn't sent through the pipeline again.
+	pipelineFail := res[1].EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+	assert.True(t, pipelineFail.deadLetter, "failed event's dead letter flag should be set")
+	assert.Empty(t, pipelineFail.pipeline, "dead letter event should not be sent through the failing pipeline")
+	var pipelineDoc mapstr.M
+	require.NoError(t, json.Unmarshal(pipelineFail.encoding, &pipelineDoc), "dead letter event should be valid JSON")
+	processorType, _ := pipelineDoc.GetValue("error.processor_type")
+	assert.Equal(t, "lowercase", processorType, "dead letter event should include the failing processor type")
+	pipeline, _ := pipelineDoc.GetValue("error.pipeline")
+	assert.Equal(t, "inner-pipeline", pipeline, "dead letter event should include the failing pipeline")
+
+	if len(res) == 2 {
 		assert.Equalf(t, eventFail, res[0], "bulkCollectPublishFails should return failed event")
 		encodedEvent, ok := res[0].EncodedEvent.(*encodedEvent)
 		require.True(t, ok, "event must be encoded as *encodedEvent")
 		assert.True(t, encodedEvent.deadLetter, "failed event's dead letter flag should be set")
 		assert.Equalf(t, deadLetterIndex, encodedEvent.index, "failed event's index should match dead letter index")
 		assert.Contains(t, string(encodedEvent.encoding), errorMessage, "dead letter event should include associated error message")
+
+		var doc mapstr.M
+		require.NoError(t, json.Unmarshal(encodedEvent.encoding, &doc), "dead letter event should be valid JSON")
+		originalIndex, _ := doc.GetValue("error.original_index")
+		assert.Equal(t, "test", originalIndex, "dead letter event should include the original index")
+		documentID, _ := doc.GetValue("error.document_id")
+		assert.Equal(t, "id1", documentID, "dead letter event should include the original document ID")
+		_, err := doc.GetValue("error.processor_type")
+		assert.ErrorIs(t, err, mapstr.ErrKeyNotFound, "only pipeline errors should include a processor type")
 	}
 }
 
@@ -680,105 +1322,1656 @@ func TestCollectPublishFailAll(t *testing.T) {
 	assert.Equal(t, stats, bulkResultStats{fails: 3, tooMany: 3})
 }
 
-func TestCollectPipelinePublishFail(t *testing.T) {
-	logger := logptest.NewTestingLogger(t, "")
+func TestCollectPublishFailMaxEventRetries(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	reg := monitoring.NewRegistry()
+	observer := outputs.NewStats(reg, logp.NewNopLogger())
+	client, err := NewClient(
+		clientSettings{
+			observer:        observer,
+			maxEventRetries: 2,
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+
+	response := []byte(`
+    { "items": [
+      {"create": {"status": 503, "error": "ups"}}
+    ]}
+  `)
+
+	event := publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 1}}}
+	events := encodeEvents(client, []publisher.Event{event})
+
+	// The event is retried up to the maximum number of retries.
+	for attempt := 1; attempt <= 2; attempt++ {
+		observer.NewBatch(len(events))
+		res, stats := client.bulkCollectPublishFails(bulkResult{
+			events:   events,
+			status:   200,
+			response: response,
+		})
+		stats.reportToObserver(observer)
+		require.Len(t, res, 1, "the event should be retried on attempt %d", attempt)
+		assert.Equal(t, bulkResultStats{fails: 1}, stats, "the failure should be retryable on attempt %d", attempt)
+		events = res
+	}
+
+	// Once the maximum is exceeded, the event is dropped.
+	observer.NewBatch(len(events))
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   events,
+		status:   200,
+		response: response,
+	})
+	stats.reportToObserver(observer)
+	assert.Empty(t, res, "the event should not be retried after the maximum number of retries")
+	assert.Equal(t, bulkResultStats{nonIndexable: 1}, stats, "the event should be counted as non-indexable")
+	assertRegistryUint(t, reg, "events.dropped", 1, "the event should be reported as dropped")
+	assertRegistryUint(t, reg, "events.failed", 2, "the earlier attempts should be reported as failed")
+}
+
+func TestCollectPublishFailErrorLogDedup(t *testing.T) {
+	logger, logs := logptest.NewTestingLoggerWithObserver(t, "")
+	client, err := NewClient(
+		clientSettings{
+			observer:            outputs.NewNilObserver(),
+			errorLogDedupWindow: time.Minute,
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client.errorLogs.now = func() time.Time { return now }
+	t.Cleanup(client.errorLogs.close)
+
+	const mapperError = `{"type":"document_parsing_exception","reason":"failed to parse field [bar] of type [long]"}`
+	response := []byte(`{"items": [
+		{"create": {"status": 400, "error": ` + mapperError + `}},
+		{"create": {"status": 400, "error": ` + mapperError + `}},
+		{"create": {"status": 400, "error": ` + mapperError + `}},
+		{"create": {"status": 400, "error": {"type":"illegal_argument_exception","reason":"bad value"}}}
+	]}`)
+	var events []publisher.Event
+	for i, index := range []string{"logs-a", "logs-b", "logs-c", "logs-a"} {
+		event := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": i}}})
+		event.EncodedEvent.(*encodedEvent).index = index //nolint:errcheck //safe to ignore type check
+		events = append(events, event)
+	}
+
+	_, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   events,
+		status:   200,
+		response: response,
+	})
+	assert.Equal(t, bulkResultStats{nonIndexable: 4}, stats, "all events should be dropped")
+	assert.Equal(t, 1, logs.FilterMessageSnippet("document_parsing_exception").Len(), "the repeated error should be logged once")
+	assert.Equal(t, 1, logs.FilterMessageSnippet("illegal_argument_exception").Len(), "a different error should be logged separately")
+	assert.Zero(t, logs.FilterMessageSnippet("Suppressed").Len(), "no summary should be logged before the window ends")
+
+	// The summary is logged once the window has ended.
+	now = now.Add(time.Minute + time.Second)
+	client.errorLogs.flushExpired()
+	summaries := logs.FilterMessageSnippet("Suppressed").All()
+	require.Len(t, summaries, 1, "the suppressed occurrences should be summarized once")
+	assert.Contains(t, summaries[0].Message, "Suppressed 2 more occurrences of error (type=document_parsing_exception)", "the summary should count the suppressed occurrences")
+	assert.Contains(t, summaries[0].Message, "in the last 1m1s", "the summary should report the elapsed time")
+	assert.Contains(t, summaries[0].Message, "[logs-a logs-b logs-c]", "the summary should list the affected indices")
+
+	// After the window the error is logged again.
+	_, _ = client.bulkCollectPublishFails(bulkResult{
+		events:   events[:1],
+		status:   200,
+		response: []byte(`{"items": [{"create": {"status": 400, "error": ` + mapperError + `}}]}`),
+	})
+	assert.Equal(t, 2, logs.FilterMessageSnippet("Cannot index event").FilterMessageSnippet("document_parsing_exception").Len(), "the error should be logged again in a new window")
+}
+
+func TestErrorLogDedupTimer(t *testing.T) {
+	logger, logs := logptest.NewTestingLoggerWithObserver(t, "")
+	dedup := newErrorLogDeduper(10*time.Millisecond, logger)
+	t.Cleanup(dedup.close)
+
+	const mapperError = `{"type":"document_parsing_exception","reason":"failed to parse field [bar] of type [long]"}`
+	assert.True(t, dedup.allow("logs-a", []byte(mapperError)), "the first occurrence should be logged")
+	assert.False(t, dedup.allow("logs-b", []byte(mapperError)), "a repeated occurrence should be suppressed")
+
+	// The summary is logged when the window ends, without further errors.
+	assert.Eventually(t, func() bool {
+		return logs.FilterMessageSnippet("Suppressed 1 more occurrences").Len() == 1
+	}, 5*time.Second, time.Millisecond, "the summary should be logged when the window ends")
+}
+
+func TestCollectPublishFailPerIndexMetrics(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	observer := outputs.NewStats(reg, logp.NewNopLogger())
+	expr, err := outil.FmtSelectorExpr(fmtstr.MustCompileEvent("%{[target]}"), "", outil.SelectorKeepCase)
+	require.NoError(t, err)
+	client, err := NewClient(
+		clientSettings{
+			observer:        observer,
+			indexSelector:   outil.MakeSelector(expr),
+			perIndexMetrics: true,
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	response := []byte(`
+    { "items": [
+      {"create": {"status": 200}},
+      {"create": {"status": 429, "error": "ups"}},
+      {"create": {"status": 200}},
+      {"create": {"status": 400, "error": "mapping"}},
+      {"create": {"status": 200}}
+    ]}
+  `)
+
+	var events []publisher.Event
+	for _, index := range []string{"logs-app", "logs-app", "metrics.app", "metrics.app", "metrics.app"} {
+		events = append(events, publisher.Event{Content: beat.Event{Fields: mapstr.M{"target": index}}})
+	}
+	events = encodeEvents(client, events)
+
+	observer.NewBatch(len(events))
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   events,
+		status:   200,
+		response: response,
+	})
+	stats.reportToObserver(observer)
+	require.Len(t, res, 1, "the event rejected with 429 should be retried")
+
+	assertRegistryUint(t, reg, "indices.logs-app.events.acked", 1, "acked events of logs-app")
+	assertRegistryUint(t, reg, "indices.logs-app.events.failed", 1, "failed events of logs-app")
+	assertRegistryUint(t, reg, "indices.logs-app.events.toomany", 1, "events of logs-app rejected with 429")
+	assertRegistryUint(t, reg, "indices.logs-app.events.dropped", 0, "dropped events of logs-app")
+	assertRegistryUint(t, reg, "indices.metrics_app.events.acked", 2, "acked events of metrics.app")
+	assertRegistryUint(t, reg, "indices.metrics_app.events.failed", 0, "failed events of metrics.app")
+	assertRegistryUint(t, reg, "indices.metrics_app.events.dropped", 1, "dropped events of metrics.app")
+	assertRegistryUint(t, reg, "events.acked", 3, "the output totals should be unchanged")
+
+	// Without the option, no per index metrics are reported.
+	client.perIndexMetrics = false
+	_, stats = client.bulkCollectPublishFails(bulkResult{
+		events:   encodeEvents(client, []publisher.Event{{Content: beat.Event{Fields: mapstr.M{"target": "other"}}}}),
+		status:   200,
+		response: []byte(`{"items": [{"create": {"status": 200}}]}`),
+	})
+	stats.reportToObserver(observer)
+	assert.Nil(t, reg.Get("indices.other"), "no metrics should be registered for other")
+}
+
+func TestCollectPipelinePublishFail(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewNilObserver(),
+		},
+		nil,
+		logger,
+	)
+	assert.NoError(t, err)
+
+	response := []byte(`{
+      "took": 0, "ingest_took": 0, "errors": true,
+      "items": [
+        {
+          "index": {
+            "_index": "filebeat-2016.08.10",
+            "_type": "log",
+            "_id": null,
+            "status": 500,
+            "error": {
+              "type": "exception",
+              "reason": "java.lang.IllegalArgumentException: java.lang.IllegalArgumentException: field [fail_on_purpose] not present as part of path [fail_on_purpose]",
+              "caused_by": {
+                "type": "illegal_argument_exception",
+                "reason": "java.lang.IllegalArgumentException: field [fail_on_purpose] not present as part of path [fail_on_purpose]",
+                "caused_by": {
+                  "type": "illegal_argument_exception",
+                  "reason": "field [fail_on_purpose] not present as part of path [fail_on_purpose]"
+                }
+              },
+              "header": {
+                "processor_type": "lowercase"
+              }
+            }
+          }
+        }
+      ]
+    }`)
+
+	event := publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 2}}}
+	events := encodeEvents(client, []publisher.Event{event})
+
+	res, _ := client.bulkCollectPublishFails(bulkResult{
+		events:   events,
+		status:   200,
+		response: response,
+	})
+	assert.Equal(t, 1, len(res))
+	assert.Equal(t, events, res)
+}
+
+func TestCollectPublishFailWithFailureStore(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	reg := monitoring.NewRegistry()
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewStats(reg, logp.NewNopLogger()),
+		},
+		nil,
+		logger,
+	)
+	assert.NoError(t, err)
+
+	response := []byte(`{
+      "took": 42,
+      "errors": false,
+      "items": [
+        {
+          "create": {
+            "status": 201,
+            "failure_store": "used"
+          }
+        },
+        {
+          "create": {
+            "status": 201
+          }
+        }
+      ]
+    }`)
+
+	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 1}}})
+	event2 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 2}}})
+	events := []publisher.Event{event1, event2}
+
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   events,
+		status:   200,
+		response: response,
+	})
+	assert.Equal(t, 0, len(res))
+	assert.Equal(t, bulkResultStats{acked: 2, failureStoreUsed: 1}, stats)
+
+	stats.reportToObserver(client.observer)
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, true)
+	assert.EqualValues(t, 1, snapshot.Ints["events.failure_store"])
+	assert.EqualValues(t, 2, snapshot.Ints["events.acked"])
+}
+
+func TestCollectPublishFailWithNoop(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	reg := monitoring.NewRegistry()
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewStats(reg, logp.NewNopLogger()),
+		},
+		nil,
+		logger,
+	)
+	assert.NoError(t, err)
+
+	response := []byte(`{
+      "errors": false,
+      "items": [
+        {
+          "update": {
+            "status": 200,
+            "result": "noop"
+          }
+        },
+        {
+          "update": {
+            "status": 200,
+            "result": "updated"
+          }
+        }
+      ]
+    }`)
+
+	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 1}}})
+	event2 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 2}}})
+	events := []publisher.Event{event1, event2}
+
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   events,
+		status:   200,
+		response: response,
+	})
+	assert.Equal(t, 0, len(res))
+	assert.Equal(t, bulkResultStats{acked: 2, noop: 1}, stats)
+
+	stats.reportToObserver(client.observer)
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, true)
+	assert.EqualValues(t, 1, snapshot.Ints["events.noop"])
+	assert.EqualValues(t, 2, snapshot.Ints["events.acked"])
+}
+
+func TestCollectPublishFailStatusActions(t *testing.T) {
+	response := []byte(`
+{
+	"items": [
+		{"create": {"status": 200}},
+		{"create": {"status": 403, "error": "security_exception"}},
+		{"create": {"status": 400, "error": "mapper_parsing_exception"}},
+		{"create": {"status": 429, "error": "es_rejected_execution_exception"}},
+		{"create": {"status": 503, "error": "unavailable_shards_exception"}}
+	]
+}`)
+
+	tests := map[string]struct {
+		statusActions map[int]string
+		wantRetried   []int
+		wantStats     bulkResultStats
+	}{
+		"defaults": {
+			wantRetried: []int{3, 4},
+			wantStats:   bulkResultStats{acked: 1, nonIndexable: 2, fails: 2, tooMany: 1},
+		},
+		"retry a normally dropped status": {
+			statusActions: map[int]string{403: statusActionRetry},
+			wantRetried:   []int{1, 3, 4},
+			wantStats:     bulkResultStats{acked: 1, nonIndexable: 1, fails: 3, tooMany: 1},
+		},
+		"drop normally retried statuses": {
+			statusActions: map[int]string{429: statusActionDrop, 503: statusActionDrop},
+			wantStats:     bulkResultStats{acked: 1, nonIndexable: 4},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, err := NewClient(
+				clientSettings{
+					observer:      outputs.NewNilObserver(),
+					statusActions: tc.statusActions,
+				},
+				nil,
+				logptest.NewTestingLogger(t, ""),
+			)
+			require.NoError(t, err)
+
+			var events []publisher.Event
+			for i := range 5 {
+				events = append(events, encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": i}}}))
+			}
+			want := []publisher.Event{}
+			for _, i := range tc.wantRetried {
+				want = append(want, events[i])
+			}
+
+			res, stats := client.bulkCollectPublishFails(bulkResult{
+				events:   slices.Clone(events),
+				status:   200,
+				response: response,
+			})
+			assert.Equal(t, tc.wantStats, stats, "unexpected bulk result stats")
+			assert.Equal(t, want, res, "unexpected retried events")
+			assert.Equal(t, tc.wantStats.tooMany > 0, publishResultForStats(stats) == errTooMany,
+				"publishResultForStats should only report throttling for retried 429 responses")
+		})
+	}
+}
+
+func TestCollectPublishFailStatusActionDeadLetter(t *testing.T) {
+	const deadLetterIndex = "dead_letter"
+	client, err := NewClient(
+		clientSettings{
+			observer:        outputs.NewNilObserver(),
+			deadLetterIndex: deadLetterIndex,
+			statusActions:   map[int]string{400: statusActionDrop, 503: statusActionDeadLetter},
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	response := []byte(`{"items": [{"create": {"status": 400}}, {"create": {"status": 503}}]}`)
+	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 1}}})
+	event2 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 2}}})
+
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   []publisher.Event{event1, event2},
+		status:   200,
+		response: response,
+	})
+	assert.Equal(t, bulkResultStats{nonIndexable: 1, fails: 1}, stats)
+	require.Len(t, res, 1, "only the event with a dead_letter action should be retried")
+	encoded, ok := res[0].EncodedEvent.(*encodedEvent)
+	require.True(t, ok, "event must be encoded as *encodedEvent")
+	assert.True(t, encoded.deadLetter, "the 503 event should be sent to the dead letter index")
+	assert.Equal(t, deadLetterIndex, encoded.index, "the 503 event should target the dead letter index")
+}
+
+func TestCollectPublishFailOnDrop(t *testing.T) {
+	type drop struct {
+		event  publisher.Event
+		reason string
+	}
+	var drops []drop
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewNilObserver(),
+			onDrop: func(event publisher.Event, reason string) {
+				drops = append(drops, drop{event: event, reason: reason})
+			},
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	response := []byte(`{"items": [{"create": {"status": 200}}, {"create": {"status": 400, "error": "mapper_parsing_exception"}}, {"create": {"status": 503}}]}`)
+	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 1}}})
+	eventFail := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": "fail"}}})
+	eventRetry := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"bar": 3}}})
+
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   []publisher.Event{event1, eventFail, eventRetry},
+		status:   200,
+		response: response,
+	})
+	assert.Equal(t, bulkResultStats{acked: 1, nonIndexable: 1, fails: 1}, stats)
+	assert.Equal(t, []publisher.Event{eventRetry}, res, "only the event with a server error should be retried")
+	require.Len(t, drops, 1, "the drop callback should be called once for the dropped event")
+	assert.Equal(t, eventFail, drops[0].event, "the drop callback should receive the dropped event")
+	assert.Equal(t, dropReasonNonIndexable, drops[0].reason, "the drop callback should receive the drop reason")
+
+	// Without a callback, dropping an event is still handled.
+	client.onDrop = nil
+	_, stats = client.bulkCollectPublishFails(bulkResult{
+		events:   []publisher.Event{eventFail},
+		status:   200,
+		response: []byte(`{"items": [{"create": {"status": 400}}]}`),
+	})
+	assert.Equal(t, bulkResultStats{nonIndexable: 1}, stats)
+}
+
+func TestCollectPublishFailAuditIndex(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	client, err := NewClient(
+		clientSettings{
+			observer:   outputs.NewStats(reg, logp.NewNopLogger()),
+			auditIndex: "audit",
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	response := []byte(`{"items": [
+		{"create": {"status": 201}},
+		{"create": {"status": 201}},
+		{"create": {"status": 201}},
+		{"create": {"status": 400, "error": "audit mapping error"}},
+		{"create": {"status": 503}},
+		{"create": {"status": 201}},
+		{"create": {"status": 400, "error": "mapping error"}},
+		{"create": {"status": 201}}
+	]}`)
+
+	events := encodeEvents(client, []publisher.Event{
+		{Content: beat.Event{Fields: mapstr.M{"field": 1}}},
+		{Content: beat.Event{Fields: mapstr.M{"field": 2}}},
+		{Content: beat.Event{Fields: mapstr.M{"field": 3}}},
+		{Content: beat.Event{Fields: mapstr.M{"field": 4}}},
+	})
+	for _, event := range events {
+		event.EncodedEvent.(*encodedEvent).audit = true
+	}
+	retry := events[2]
+
+	res, stats := client.bulkCollectPublishFails(bulkResult{
+		events:   events,
+		status:   200,
+		response: response,
+	})
+	assert.Equal(t, []publisher.Event{retry}, res, "only the event failing its own item should be retried")
+	assert.Equal(t, bulkResultStats{acked: 2, fails: 1, nonIndexable: 1, auditAcked: 3, auditFailed: 1}, stats)
+
+	audited := []bool{true, false, true, true}
+	for i, event := range []publisher.Event{events[0], events[1], retry, events[3]} {
+		assert.Equal(t, audited[i], event.EncodedEvent.(*encodedEvent).audited, "event %d", i)
+	}
+
+	stats.reportToObserver(client.observer)
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, true)
+	assert.EqualValues(t, 3, snapshot.Ints["events.audit.acked"])
+	assert.EqualValues(t, 1, snapshot.Ints["events.audit.failed"])
+	assert.EqualValues(t, 2, snapshot.Ints["events.acked"])
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		value     string
+		wantDelay time.Duration
+		wantOK    bool
+	}{
+		"empty":          {value: "", wantOK: false},
+		"delta seconds":  {value: "120", wantDelay: 2 * time.Minute, wantOK: true},
+		"zero seconds":   {value: "0", wantDelay: 0, wantOK: true},
+		"negative":       {value: "-5", wantOK: false},
+		"http date":      {value: "Wed, 01 May 2024 12:00:30 GMT", wantDelay: 30 * time.Second, wantOK: true},
+		"past http date": {value: "Wed, 01 May 2024 11:59:00 GMT", wantDelay: 0, wantOK: true},
+		"invalid":        {value: "soon", wantOK: false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tc.value, now)
+			assert.Equal(t, tc.wantOK, ok, "unexpected validity for Retry-After %q", tc.value)
+			assert.Equal(t, tc.wantDelay, delay, "unexpected delay for Retry-After %q", tc.value)
+		})
+	}
+}
+
+func TestPublishPartialResponse(t *testing.T) {
+	// The mock server announces a longer body than it sends, so the
+	// connection is closed while the client reads the response.
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Length", "1000")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `{"items":[{"create":{"status":201}}`)
+	}))
+	defer esMock.Close()
+
+	tests := map[string]struct {
+		policy         string
+		wantDeadLetter []bool
+	}{
+		"retry": {
+			policy:         partialResponseRetry,
+			wantDeadLetter: []bool{false, false, false},
+		},
+		"dead letter": {
+			policy:         partialResponseDeadLetter,
+			wantDeadLetter: []bool{false, true, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, err := NewClient(
+				clientSettings{
+					observer:        outputs.NewNilObserver(),
+					connection:      eslegclient.ConnectionSettings{URL: esMock.URL},
+					indexSelector:   testIndexSelector{},
+					deadLetterIndex: "reconcile",
+					partialResponse: tc.policy,
+				},
+				nil,
+				logptest.NewTestingLogger(t, ""),
+			)
+			require.NoError(t, err)
+
+			batch := encodeBatch(client, &batchMock{
+				events: []publisher.Event{
+					// Retrying a create with an ID can't create a duplicate.
+					{Content: beat.Event{Fields: mapstr.M{"field": 1}, Meta: mapstr.M{e.FieldMetaID: "id1"}}},
+					{Content: beat.Event{Fields: mapstr.M{"field": 2}, Meta: mapstr.M{e.FieldMetaID: "id2", e.FieldMetaOpType: "index"}}},
+					{Content: beat.Event{Fields: mapstr.M{"field": 3}}},
+				},
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err = client.Publish(ctx, batch)
+
+			var partial *eslegclient.PartialResponseError
+			require.ErrorAs(t, err, &partial, "Publish should report the truncated response")
+			assert.False(t, batch.ack, "batch should not be acknowledged")
+			require.Len(t, batch.retryEvents, 3, "all events should be retried")
+			for i, event := range batch.retryEvents {
+				encoded := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+				assert.Equal(t, tc.wantDeadLetter[i], encoded.deadLetter, "unexpected dead letter state for event %d", i)
+				if tc.wantDeadLetter[i] {
+					assert.Equal(t, "reconcile", encoded.index, "uncertain event %d should be sent to the dead letter index", i)
+					assert.Contains(t, string(encoded.encoding), "uncertain delivery", "uncertain event %d should be marked as such", i)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishCircuitBreaker(t *testing.T) {
+	var (
+		requests atomic.Int32
+		response atomic.Value
+	)
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		requests.Add(1)
+		body, _ := response.Load().(string)
+		if body == "" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	defer esMock.Close()
+
+	reg := monitoring.NewRegistry()
+	observer := outputs.NewStats(reg, logp.NewNopLogger())
+	logger := logptest.NewTestingLogger(t, "")
+	breaker := newCircuitBreaker(CircuitBreaker{Threshold: 2, Cooldown: time.Minute}, observer, logger)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	client, err := NewClient(
+		clientSettings{
+			observer:      observer,
+			connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+			indexSelector: testIndexSelector{},
+			breaker:       breaker,
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+	// The clone shares the breaker, as the clients of an output do.
+	clone := client.Clone()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	publishWith := func(client *Client) (*batchMock, error) {
+		batch := encodeBatch(client, &batchMock{
+			events: []publisher.Event{
+				{Content: beat.Event{Fields: mapstr.M{"field": 1}}},
+				{Content: beat.Event{Fields: mapstr.M{"field": 2}}},
+			},
+		})
+		return batch, client.Publish(ctx, batch)
+	}
+	publish := func() (*batchMock, error) {
+		return publishWith(client)
+	}
+	assertOpen := func(expected bool, message string) {
+		t.Helper()
+		assert.Equal(t, expected, reg.Get("circuit_breaker.open").(*monitoring.Bool).Get(), message)
+	}
+	const (
+		allTooMany = `{"items": [{"create":{"status":429}},{"create":{"status":429}}]}`
+		someOK     = `{"items": [{"create":{"status":201}},{"create":{"status":429}}]}`
+		allOK      = `{"items": [{"create":{"status":201}},{"create":{"status":201}}]}`
+	)
+
+	// A batch partially rejected with 429 resets the count of consecutive
+	// rejected batches.
+	response.Store("")
+	_, err = publish()
+	require.Error(t, err)
+	response.Store(someOK)
+	_, err = publish()
+	require.ErrorIs(t, err, errTooMany)
+	assertOpen(false, "the breaker should stay closed while batches are partially accepted")
+
+	// Two consecutive batches entirely rejected with 429, either for the
+	// whole request or for every item, open the breaker.
+	response.Store("")
+	_, err = publish()
+	require.Error(t, err)
+	assertOpen(false, "the breaker should stay closed below the threshold")
+	response.Store(allTooMany)
+	_, err = publish()
+	require.ErrorIs(t, err, errTooMany)
+	assertOpen(true, "the breaker should open at the threshold")
+	require.Equal(t, int32(4), requests.Load())
+
+	// While open, batches are retried without contacting Elasticsearch.
+	now = now.Add(20 * time.Second)
+	batch, err := publish()
+	var retryErr *outputs.RetryAfterError
+	require.ErrorAs(t, err, &retryErr, "Publish should delay the retry while the breaker is open")
+	assert.ErrorIs(t, err, errCircuitOpen)
+	assert.Equal(t, 40*time.Second, retryErr.Delay, "the retry should be delayed until the end of the cooldown")
+	assert.Len(t, batch.retryEvents, 2, "all events should be retried")
+	assert.Equal(t, int32(4), requests.Load(), "no request should be sent while the breaker is open")
+	_, err = publishWith(clone)
+	require.ErrorIs(t, err, errCircuitOpen, "the clients sharing the breaker should not send batches either")
+	assert.Equal(t, int32(4), requests.Load(), "no request should be sent while the breaker is open")
+
+	// After the cooldown, a rejected batch opens the breaker again right away.
+	now = now.Add(time.Minute)
+	_, err = publish()
+	require.ErrorIs(t, err, errTooMany)
+	assert.Equal(t, int32(5), requests.Load(), "a batch should be sent once the cooldown ended")
+	_, err = publish()
+	require.ErrorIs(t, err, errCircuitOpen)
+	assert.Equal(t, int32(5), requests.Load(), "no request should be sent after the breaker opened again")
+	assertOpen(true, "the breaker should open again when the trial batch is rejected")
+
+	// An accepted batch closes the breaker.
+	now = now.Add(time.Minute)
+	response.Store(allOK)
+	batch, err = publish()
+	require.NoError(t, err)
+	assert.True(t, batch.ack, "batch should be acknowledged")
+	assertOpen(false, "the breaker should close when the trial batch is accepted")
+	_, err = publish()
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), requests.Load())
+}
+
+func TestPublishCircuitBreakerHalfOpen(t *testing.T) {
+	var requests atomic.Int32
+	probeSent := make(chan struct{}, 16)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	releaseProbes := func() { releaseOnce.Do(func() { close(release) }) }
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		// Hold the probe until all the other clients tried to send.
+		probeSent <- struct{}{}
+		<-release
+		_, _ = io.WriteString(w, `{"items": [{"create":{"status":201}}]}`)
+	}))
+	defer esMock.Close()
+	defer releaseProbes()
+
+	reg := monitoring.NewRegistry()
+	observer := outputs.NewStats(reg, logp.NewNopLogger())
+	logger := logptest.NewTestingLogger(t, "")
+	breaker := newCircuitBreaker(CircuitBreaker{Threshold: 1, Cooldown: time.Minute}, observer, logger)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	client, err := NewClient(
+		clientSettings{
+			observer:      observer,
+			connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+			indexSelector: testIndexSelector{},
+			breaker:       breaker,
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	publishWith := func(client *Client) error {
+		batch := encodeBatch(client, &batchMock{
+			events: []publisher.Event{{Content: beat.Event{Fields: mapstr.M{"field": 1}}}},
+		})
+		return client.Publish(ctx, batch)
+	}
+	require.Error(t, publishWith(client))
+	require.True(t, reg.Get("circuit_breaker.open").(*monitoring.Bool).Get(), "the breaker should open")
+
+	// Once the cooldown has elapsed, all the workers try to send at once.
+	now = now.Add(2 * time.Minute)
+	const workers = 8
+	errs := make(chan error, workers)
+	for range workers {
+		clone := client.Clone()
+		go func() { errs <- publishWith(clone) }()
+	}
+	<-probeSent
+	for range workers - 1 {
+		var err error
+		select {
+		case err = <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the workers should not send while the probe is in flight, %d requests sent", requests.Load())
+		}
+		var retryErr *outputs.RetryAfterError
+		require.ErrorAs(t, err, &retryErr, "the workers should wait for the probe")
+		assert.ErrorIs(t, err, errCircuitOpen)
+		assert.Equal(t, time.Minute, retryErr.Delay, "the workers should wait until the probe times out")
+	}
+	assert.Equal(t, int32(2), requests.Load(), "only one probe should be sent")
+
+	releaseProbes()
+	require.NoError(t, <-errs, "the probe should be accepted")
+	assert.False(t, reg.Get("circuit_breaker.open").(*monitoring.Bool).Get(), "the accepted probe should close the breaker")
+}
+
+func TestPublishRetryJitter(t *testing.T) {
+	const maxJitter = time.Second
+	var response atomic.Value
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		body, _ := response.Load().(string)
+		if body == "" {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	defer esMock.Close()
+
+	client, err := NewClient(
+		clientSettings{
+			observer:      outputs.NewNilObserver(),
+			connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+			indexSelector: testIndexSelector{},
+			retryJitter:   maxJitter,
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, client.jitter, "a jitter should be set up")
+
+	// Draw the jitter from a seeded generator, and compare it with the
+	// sequence drawn by another generator with the same seed.
+	const seed = 42
+	client.jitter = newRetryJitter(maxJitter, seed)
+	var slept []time.Duration
+	client.jitter.sleep = func(_ context.Context, d time.Duration) { slept = append(slept, d) }
+	want := newRetryJitter(maxJitter, seed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	publish := func(body string) (*batchMock, error) {
+		response.Store(body)
+		batch := encodeBatch(client, &batchMock{
+			events: []publisher.Event{
+				{Content: beat.Event{Fields: mapstr.M{"field": 1}}},
+				{Content: beat.Event{Fields: mapstr.M{"field": 2}}},
+			},
+		})
+		return batch, client.Publish(ctx, batch)
+	}
+
+	// Events retried without a backoff wait for the jitter first.
+	for range 3 {
+		batch, err := publish(`{"items": [{"create":{"status":201}},{"create":{"status":503}}]}`)
+		require.NoError(t, err)
+		require.Len(t, batch.retryEvents, 1)
+		require.NotEmpty(t, slept, "the retry should wait for the jitter")
+		got := slept[len(slept)-1]
+		assert.Equal(t, want.delay(), got, "the jitter should be drawn from the seeded generator")
+		assert.True(t, got >= 0 && got <= maxJitter, "the jitter %v should be within [0, %v]", got, maxJitter)
+	}
+	require.Len(t, slept, 3)
+
+	// Acknowledged batches and retries followed by a backoff don't wait.
+	batch, err := publish(`{"items": [{"create":{"status":201}},{"create":{"status":201}}]}`)
+	require.NoError(t, err)
+	assert.True(t, batch.ack)
+	_, err = publish(`{"items": [{"create":{"status":201}},{"create":{"status":429}}]}`)
+	require.ErrorIs(t, err, errTooMany)
+	assert.Len(t, slept, 3, "only retries without a backoff should wait for the jitter")
+
+	// The jitter is added to the delay requested by Elasticsearch.
+	_, err = publish("")
+	var retryErr *outputs.RetryAfterError
+	require.ErrorAs(t, err, &retryErr)
+	assert.Equal(t, 7*time.Second+want.delay(), retryErr.Delay, "the jitter should be added to the Retry-After delay")
+	assert.True(t, retryErr.Delay >= 7*time.Second && retryErr.Delay <= 7*time.Second+maxJitter,
+		"the delay %v should be within the Retry-After delay plus [0, %v]", retryErr.Delay, maxJitter)
+
+	var noJitter *retryJitter
+	assert.Zero(t, noJitter.delay(), "a nil jitter should not delay retries")
+}
+
+func TestPublishPipelineRetryBudget(t *testing.T) {
+	// The mock server fails every item sent through the "failing" pipeline.
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var items []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var meta map[string]eslegclient.BulkMeta
+			if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+				t.Errorf("failed to decode bulk meta: %v", err)
+			}
+			item := `{"index":{"status":201}}`
+			for _, m := range meta {
+				if m.Pipeline == "failing" {
+					item = `{"index":{"status":503,"error":{"type":"unavailable"}}}`
+				}
+			}
+			items = append(items, item)
+			scanner.Scan() // skip the document
+		}
+		_, _ = io.WriteString(w, `{"items":[`+strings.Join(items, ",")+`]}`)
+	}))
+	defer esMock.Close()
+
+	reg := monitoring.NewRegistry()
+	client, err := NewClient(
+		clientSettings{
+			observer:        outputs.NewStats(reg, logp.NewNopLogger()),
+			connection:      eslegclient.ConnectionSettings{URL: esMock.URL},
+			indexSelector:   testIndexSelector{},
+			deadLetterIndex: "dead",
+			retryBudget:     newRetryBudget(RetryBudget{Default: 4}),
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	event := func(pipeline string) publisher.Event {
+		return publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 1}, Meta: mapstr.M{e.FieldMetaPipeline: pipeline}}}
+	}
+	publishWith := func(client *Client, events []publisher.Event) *batchMock {
+		batch := &batchMock{events: events}
+		_ = client.Publish(ctx, batch)
+		return batch
+	}
+	publish := func(events []publisher.Event) *batchMock {
+		return publishWith(client, events)
+	}
+
+	// Each batch uses two of the four retries of the failing pipeline.
+	batch := publish(encodeEvents(client, []publisher.Event{event("failing"), event("healthy"), event("failing"), event("healthy")}))
+	require.Len(t, batch.retryEvents, 2, "the events of the failing pipeline should be retried")
+	assertRegistryUint(t, reg, "events.acked", 2, "the events of the healthy pipeline should be ingested")
+
+	// The clients of an output share the budget.
+	batch = publishWith(client.Clone(), batch.retryEvents)
+	require.Len(t, batch.retryEvents, 2, "the events of the failing pipeline should be retried")
+	for i, event := range batch.retryEvents {
+		encoded := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		assert.False(t, encoded.deadLetter, "event %d should be retried within the budget", i)
+	}
+
+	// Once the budget is used up, the events are sent to the dead letter
+	// index without the pipeline.
+	batch = publish(batch.retryEvents)
+	require.Len(t, batch.retryEvents, 2, "the events of the failing pipeline should be retried")
+	for i, event := range batch.retryEvents {
+		encoded := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		assert.True(t, encoded.deadLetter, "event %d should be sent to the dead letter index", i)
+		assert.Equal(t, "dead", encoded.index, "event %d should be sent to the dead letter index", i)
+		assert.Empty(t, encoded.pipeline, "event %d should not be sent through the failing pipeline", i)
+	}
+	batch = publish(batch.retryEvents)
+	assert.True(t, batch.ack, "the dead letter events should be ingested")
+	assertRegistryUint(t, reg, "events.dead_letter", 2, "the events of the failing pipeline should be dead-lettered")
+
+	// The healthy pipeline still gets its full budget.
+	batch = publish(encodeEvents(client, []publisher.Event{event("healthy"), event("healthy")}))
+	assert.True(t, batch.ack, "the events of the healthy pipeline should be ingested")
+	assertRegistryUint(t, reg, "events.acked", 4, "the events of the healthy pipeline should be ingested")
+}
+
+func TestPublishItemRetryRounds(t *testing.T) {
+	// The mock rejects each document with 429 as many times as its "fail"
+	// field says, and records the number of documents in each request.
+	newMock := func(t *testing.T) (*httptest.Server, *[]int) {
+		var requests []int
+		attempts := map[string]int{}
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+			var items []string
+			for i := 1; i < len(lines); i += 2 {
+				var doc struct {
+					Message string `json:"message"`
+					Fail    int    `json:"fail"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(lines[i]), &doc))
+				attempts[doc.Message]++
+				item := `{"create":{"status":201}}`
+				if attempts[doc.Message] <= doc.Fail {
+					item = `{"create":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}`
+				}
+				items = append(items, item)
+			}
+			requests = append(requests, len(items))
+			_, _ = io.WriteString(w, `{"items":[`+strings.Join(items, ",")+`]}`)
+		}))
+		t.Cleanup(esMock.Close)
+		return esMock, &requests
+	}
+	publishContext := func(ctx context.Context, t *testing.T, url string, rounds int, retryBackoff Backoff, fails ...int) (*batchMock, *monitoring.Registry, error) {
+		reg := monitoring.NewRegistry()
+		client, err := NewClient(
+			clientSettings{
+				observer:         outputs.NewStats(reg, logp.NewNopLogger()),
+				connection:       eslegclient.ConnectionSettings{URL: url},
+				indexSelector:    testIndexSelector{},
+				itemRetryRounds:  rounds,
+				itemRetryBackoff: retryBackoff,
+			},
+			nil,
+			logptest.NewTestingLogger(t, ""),
+		)
+		require.NoError(t, err)
+
+		var events []publisher.Event
+		for i, fail := range fails {
+			events = append(events, publisher.Event{Content: beat.Event{Fields: mapstr.M{"message": strconv.Itoa(i), "fail": fail}}})
+		}
+		batch := &batchMock{events: encodeEvents(client, events)}
+		err = client.Publish(ctx, batch)
+		return batch, reg, err
+	}
+	publish := func(t *testing.T, url string, rounds int, fails ...int) (*batchMock, *monitoring.Registry, error) {
+		return publishContext(context.Background(), t, url, rounds, Backoff{}, fails...)
+	}
+
+	t.Run("failed items are sent again", func(t *testing.T) {
+		esMock, requests := newMock(t)
+		batch, reg, err := publish(t, esMock.URL, 2, 0, 1, 0, 2)
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{4, 2, 1}, *requests, "only the failed items should be sent again")
+		assert.True(t, batch.ack, "the batch should be acknowledged once all items succeeded")
+		assert.Empty(t, batch.retryEvents)
+		assertRegistryUint(t, reg, "events.acked", 4, "all events should be acked")
+		assertRegistryUint(t, reg, "events.failed", 3, "each failed attempt should be counted")
+	})
+
+	t.Run("remaining failures are handed back", func(t *testing.T) {
+		esMock, requests := newMock(t)
+		batch, reg, err := publish(t, esMock.URL, 2, 0, 5, 0)
+		require.ErrorIs(t, err, errTooMany)
+
+		assert.Equal(t, []int{3, 1, 1}, *requests, "the failed item should be sent in each round")
+		assert.False(t, batch.ack)
+		require.Len(t, batch.retryEvents, 1, "the event still failing should be retried by the pipeline")
+		assertRegistryUint(t, reg, "events.acked", 2, "the other events should be acked")
+	})
+
+	t.Run("rounds wait for the backoff", func(t *testing.T) {
+		esMock, requests := newMock(t)
+		start := time.Now()
+		batch, _, err := publishContext(context.Background(), t, esMock.URL, 2, Backoff{Init: 50 * time.Millisecond, Max: time.Second}, 0, 2)
+		require.NoError(t, err)
+
+		// The first round waits at least Init, and the second at least
+		// twice as long.
+		assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond, "each round should wait for the backoff")
+		assert.Equal(t, []int{2, 1, 1}, *requests)
+		assert.True(t, batch.ack)
+	})
+
+	t.Run("cancelled backoff hands failures back", func(t *testing.T) {
+		esMock, requests := newMock(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		batch, _, err := publishContext(ctx, t, esMock.URL, 2, Backoff{Init: time.Minute, Max: time.Minute}, 0, 1)
+		require.ErrorIs(t, err, errTooMany)
+
+		assert.Equal(t, []int{2}, *requests, "no round should be sent once the context is done")
+		require.Len(t, batch.retryEvents, 1, "the failed event should be retried by the pipeline")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		esMock, requests := newMock(t)
+		batch, _, err := publish(t, esMock.URL, 0, 0, 1, 0)
+		require.ErrorIs(t, err, errTooMany)
+
+		assert.Equal(t, []int{3}, *requests, "failed items should not be sent again")
+		require.Len(t, batch.retryEvents, 1)
+	})
+}
+
+func TestPublishSameIDEvents(t *testing.T) {
+	// The mock rejects each document with 429 as many times as its "fail"
+	// field says, rejects requests of more than maxItems documents with
+	// 413, and records the messages of the documents of each request.
+	newMock := func(t *testing.T, maxItems int) (*httptest.Server, *[][]string) {
+		var requests [][]string
+		attempts := map[string]int{}
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+			var (
+				messages []string
+				items    []string
+			)
+			for i := 1; i < len(lines); i += 2 {
+				var doc struct {
+					Message string `json:"message"`
+					Fail    int    `json:"fail"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(lines[i]), &doc))
+				messages = append(messages, doc.Message)
+				item := `{"create":{"status":201}}`
+				if len(lines)/2 <= maxItems {
+					attempts[doc.Message]++
+					if attempts[doc.Message] <= doc.Fail {
+						item = `{"create":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}`
+					}
+				}
+				items = append(items, item)
+			}
+			requests = append(requests, messages)
+			if len(items) > maxItems {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			_, _ = io.WriteString(w, `{"items":[`+strings.Join(items, ",")+`]}`)
+		}))
+		t.Cleanup(esMock.Close)
+		return esMock, &requests
+	}
+	type doc struct {
+		message string
+		id      string
+		fail    int
+	}
+	publish := func(t *testing.T, url, mode string, docs ...doc) (*batchMock, *monitoring.Registry, error) {
+		reg := monitoring.NewRegistry()
+		client, err := NewClient(
+			clientSettings{
+				observer:      outputs.NewStats(reg, logp.NewNopLogger()),
+				connection:    eslegclient.ConnectionSettings{URL: url},
+				indexSelector: testIndexSelector{},
+				sameIDEvents:  mode,
+			},
+			nil,
+			logptest.NewTestingLogger(t, ""),
+		)
+		require.NoError(t, err)
+
+		var events []publisher.Event
+		for _, d := range docs {
+			event := beat.Event{Fields: mapstr.M{"message": d.message, "fail": d.fail}}
+			if d.id != "" {
+				event.Meta = mapstr.M{e.FieldMetaID: d.id}
+			}
+			events = append(events, publisher.Event{Content: event})
+		}
+		batch := &batchMock{events: encodeEvents(client, events), canSplit: true}
+		err = client.Publish(context.Background(), batch)
+		return batch, reg, err
+	}
+	messages := func(t *testing.T, events []publisher.Event) []string {
+		var out []string
+		for _, event := range events {
+			var doc struct {
+				Message string `json:"message"`
+			}
+			require.NoError(t, json.Unmarshal(event.EncodedEvent.(*encodedEvent).encoding, &doc))
+			out = append(out, doc.Message)
+		}
+		return out
+	}
+
+	t.Run("failed event superseded by a later indexed event", func(t *testing.T) {
+		esMock, requests := newMock(t, 10)
+		batch, reg, err := publish(t, esMock.URL, sameIDOrdered,
+			doc{message: "a1", id: "a", fail: 1},
+			doc{message: "a2", id: "a"},
+			doc{message: "b"},
+		)
+		require.ErrorIs(t, err, errTooMany, "the request was still throttled")
+
+		assert.Equal(t, [][]string{{"a1", "a2", "b"}}, *requests)
+		assert.True(t, batch.ack, "the older event should not be retried over the newer one")
+		assert.Empty(t, batch.retryEvents)
+		assertRegistryUint(t, reg, "events.superseded", 1, "the older event should be counted as superseded")
+	})
+
+	t.Run("failed events are retried in order", func(t *testing.T) {
+		esMock, _ := newMock(t, 10)
+		batch, reg, err := publish(t, esMock.URL, sameIDOrdered,
+			doc{message: "a1", id: "a", fail: 1},
+			doc{message: "b"},
+			doc{message: "a2", id: "a", fail: 1},
+		)
+		require.ErrorIs(t, err, errTooMany)
+
+		assert.Equal(t, []string{"a1", "a2"}, messages(t, batch.retryEvents))
+		assertRegistryUint(t, reg, "events.superseded", 0, "no event should be superseded")
+	})
+
+	t.Run("too large batch is split in order", func(t *testing.T) {
+		esMock, requests := newMock(t, 2)
+		batch, _, err := publish(t, esMock.URL, sameIDOrdered,
+			doc{message: "a1", id: "a", fail: 1},
+			doc{message: "b"},
+			doc{message: "c"},
+			doc{message: "a2", id: "a"},
+		)
+		require.ErrorIs(t, err, errTooMany, "the request was still throttled")
+
+		assert.False(t, batch.didSplit, "the batch should not be split by the pipeline")
+		assert.Equal(t, [][]string{{"a1", "b", "c", "a2"}, {"a1", "b"}, {"c", "a2"}}, *requests,
+			"the halves should be sent one after the other")
+		assert.True(t, batch.ack, "the older event should not be retried after the newer one was indexed")
+		assert.Empty(t, batch.retryEvents)
+	})
+
+	t.Run("too large batch without shared IDs is split by the pipeline", func(t *testing.T) {
+		esMock, requests := newMock(t, 2)
+		batch, _, err := publish(t, esMock.URL, sameIDOrdered,
+			doc{message: "a", id: "a"},
+			doc{message: "b", id: "b"},
+			doc{message: "c"},
+		)
+		require.NoError(t, err)
+
+		assert.True(t, batch.didSplit)
+		assert.Len(t, *requests, 1)
+	})
+
+	t.Run("collapse", func(t *testing.T) {
+		esMock, requests := newMock(t, 10)
+		batch, reg, err := publish(t, esMock.URL, sameIDCollapse,
+			doc{message: "a1", id: "a"},
+			doc{message: "b"},
+			doc{message: "a2", id: "a"},
+			doc{message: "c", id: "c"},
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, [][]string{{"b", "a2", "c"}}, *requests, "only the last event with each ID should be sent")
+		assert.True(t, batch.ack)
+		assertRegistryUint(t, reg, "events.superseded", 1, "the collapsed event should be counted")
+		assertRegistryUint(t, reg, "events.acked", 4, "the collapsed event should be acknowledged")
+		assertRegistryUint(t, reg, "events.active", 0, "no event should be left active")
+	})
+}
+
+func TestPublishMaxEventAge(t *testing.T) {
+	var sent []string
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		// Collect the documents, skipping the bulk meta lines.
+		for i := 1; i < len(lines); i += 2 {
+			sent = append(sent, lines[i])
+		}
+		_, _ = io.WriteString(w, `{"items":[{"create":{"status":201}}]}`)
+	}))
+	defer esMock.Close()
+
+	reg := monitoring.NewRegistry()
+	client, err := NewClient(
+		clientSettings{
+			observer:      outputs.NewStats(reg, logp.NewNopLogger()),
+			connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+			indexSelector: testIndexSelector{},
+			maxEventAge:   time.Hour,
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	batch := encodeBatch(client, &batchMock{
+		events: []publisher.Event{
+			{Content: beat.Event{Timestamp: time.Now().Add(-2 * time.Hour), Fields: mapstr.M{"field": "stale"}}},
+			{Content: beat.Event{Timestamp: time.Now(), Fields: mapstr.M{"field": "fresh"}}},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = client.Publish(ctx, batch)
+	require.NoError(t, err)
+
+	require.Len(t, sent, 1, "only the fresh event should be sent")
+	assert.Contains(t, sent[0], "fresh")
+	assert.True(t, batch.ack, "batch should be acknowledged")
+	assertRegistryUint(t, reg, "events.expired", 1, "the stale event should be reported as expired")
+	assertRegistryUint(t, reg, "events.dropped", 1, "the stale event should be reported as dropped")
+	assertRegistryUint(t, reg, "events.acked", 1, "the fresh event should be acknowledged")
+}
+
+func TestPublishMaxConcurrentBulk(t *testing.T) {
+	const limit = 2
+
+	reg := monitoring.NewRegistry()
+	observer := outputs.NewStats(reg, logp.NewNopLogger())
+	gauge := func() int64 {
+		return int64(reg.Get("bulk_requests.in_flight").(*monitoring.Uint).Get()) //nolint:errcheck,gosec //safe in tests
+	}
+
+	var inFlight, maxInFlight, maxGauge atomic.Int64
+	updateMax := func(m *atomic.Int64, n int64) {
+		for cur := m.Load(); n > cur && !m.CompareAndSwap(cur, n); cur = m.Load() {
+		}
+	}
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		updateMax(&maxInFlight, inFlight.Add(1))
+		updateMax(&maxGauge, gauge())
+		// Hold the request so that the other clients try to send theirs.
+		time.Sleep(50 * time.Millisecond)
+		inFlight.Add(-1)
+		_, _ = io.WriteString(w, `{"items":[{"create":{"status":201}}]}`)
+	}))
+	defer esMock.Close()
+
+	limiter := newBulkLimiter(limit, observer)
+	newClient := func() *Client {
+		client, err := NewClient(
+			clientSettings{
+				observer:      observer,
+				connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+				indexSelector: testIndexSelector{},
+				bulkLimiter:   limiter,
+			},
+			nil,
+			logptest.NewTestingLogger(t, ""),
+		)
+		require.NoError(t, err)
+		return client
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Publish from more clients than the limit at once, as the workers of
+	// an output do.
+	const workers = 3 * limit
+	batches := make([]*batchMock, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		client := newClient()
+		batches[i] = encodeBatch(client, &batchMock{
+			events: []publisher.Event{{Content: beat.Event{Fields: mapstr.M{"field": i}}}},
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = client.Publish(ctx, batches[i])
+		}()
+	}
+	wg.Wait()
+
+	for i := range workers {
+		require.NoError(t, errs[i], "publish %d should succeed", i)
+		assert.True(t, batches[i].ack, "batch %d should be acknowledged", i)
+	}
+	assert.LessOrEqual(t, maxInFlight.Load(), int64(limit), "no more bulk requests than the limit should be in flight")
+	assert.LessOrEqual(t, maxGauge.Load(), int64(limit), "the in flight gauge should not exceed the limit")
+	assert.Equal(t, int64(limit), maxInFlight.Load(), "the limit should be reached")
+	assert.Zero(t, gauge(), "no bulk request should be in flight once all batches are published")
+
+	// When no slot frees up before the context is done, the batch is
+	// retried without being sent.
+	for range limit {
+		require.NoError(t, limiter.acquire(ctx))
+	}
+	client := newClient()
+	batch := encodeBatch(client, &batchMock{
+		events: []publisher.Event{{Content: beat.Event{Fields: mapstr.M{"field": "blocked"}}}},
+	})
+	blockedCtx, blockedCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer blockedCancel()
+	err := client.Publish(blockedCtx, batch)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, batch.retryEvents, 1, "the event should be retried")
+	assert.Equal(t, int64(limit), gauge(), "only the held slots should be in flight")
+}
+
+func TestPublishMaxConcurrentDeadLetterBulk(t *testing.T) {
+	const (
+		deadLetterIndex = "dead_letter"
+		limit           = 1
+	)
+
+	var inFlight, maxInFlight, deadLetterInFlight, maxDeadLetterInFlight atomic.Int64
+	updateMax := func(m *atomic.Int64, n int64) {
+		for cur := m.Load(); n > cur && !m.CompareAndSwap(cur, n); cur = m.Load() {
+		}
+	}
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deadLetter := strings.Contains(string(body), deadLetterIndex)
+		updateMax(&maxInFlight, inFlight.Add(1))
+		if deadLetter {
+			updateMax(&maxDeadLetterInFlight, deadLetterInFlight.Add(1))
+		}
+		// Hold the request so that the other clients try to send theirs.
+		time.Sleep(50 * time.Millisecond)
+		if deadLetter {
+			deadLetterInFlight.Add(-1)
+		}
+		inFlight.Add(-1)
+		_, _ = io.WriteString(w, `{"items":[{"create":{"status":201}}]}`)
+	}))
+	defer esMock.Close()
+
+	deadLetterLimiter := newBulkLimiter(limit, nil)
+	newClient := func() *Client {
+		client, err := NewClient(
+			clientSettings{
+				observer:          outputs.NewNilObserver(),
+				connection:        eslegclient.ConnectionSettings{URL: esMock.URL},
+				indexSelector:     testIndexSelector{},
+				deadLetterIndex:   deadLetterIndex,
+				deadLetterLimiter: deadLetterLimiter,
+			},
+			nil,
+			logptest.NewTestingLogger(t, ""),
+		)
+		require.NoError(t, err)
+		return client
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Publish batches to the dead letter index from more clients than the
+	// limit at once, along with regular batches that are not limited.
+	const workers = 6
+	batches := make([]*batchMock, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		client := newClient()
+		batches[i] = encodeBatch(client, &batchMock{
+			events: []publisher.Event{{Content: beat.Event{Fields: mapstr.M{"field": i}}}},
+		})
+		if i%3 != 0 {
+			batches[i].events[0].EncodedEvent.(*encodedEvent).setDeadLetter(deadLetterIndex, false, deadLetterFields{}, 400, "rejected")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = client.Publish(ctx, batches[i])
+		}()
+	}
+	wg.Wait()
+
+	for i := range workers {
+		require.NoError(t, errs[i], "publish %d should succeed", i)
+		assert.True(t, batches[i].ack, "batch %d should be acknowledged", i)
+	}
+	assert.Equal(t, int64(limit), maxDeadLetterInFlight.Load(), "no more dead letter bulk requests than the limit should be in flight")
+	assert.Greater(t, maxInFlight.Load(), int64(limit), "regular bulk requests should not be limited")
+
+	// When no dead letter slot frees up before the context is done, the
+	// batch is retried without being sent.
+	require.NoError(t, deadLetterLimiter.acquire(ctx))
+	client := newClient()
+	batch := encodeBatch(client, &batchMock{
+		events: []publisher.Event{{Content: beat.Event{Fields: mapstr.M{"field": "blocked"}}}},
+	})
+	batch.events[0].EncodedEvent.(*encodedEvent).setDeadLetter(deadLetterIndex, false, deadLetterFields{}, 400, "rejected")
+	blockedCtx, blockedCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer blockedCancel()
+	err := client.Publish(blockedCtx, batch)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, batch.retryEvents, 1, "the event should be retried")
+}
+
+func TestPublishCompressionExemptIndices(t *testing.T) {
+	var gzipped bool
+	var sent string
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gzipped = r.Header.Get("Content-Encoding") == "gzip"
+		var body io.Reader = r.Body
+		if gzipped {
+			body, _ = gzip.NewReader(body)
+		}
+		b, _ := io.ReadAll(body)
+		sent = string(b)
+		items := make([]string, strings.Count(sent, "\n")/2)
+		for i := range items {
+			items[i] = `{"create":{"status":201}}`
+		}
+		_, _ = io.WriteString(w, `{"items":[`+strings.Join(items, ",")+`]}`)
+	}))
+	defer esMock.Close()
+
+	expr, err := outil.FmtSelectorExpr(fmtstr.MustCompileEvent("%{[target]}"), "", outil.SelectorKeepCase)
+	require.NoError(t, err)
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewNilObserver(),
+			connection: eslegclient.ConnectionSettings{
+				URL:              esMock.URL,
+				CompressionLevel: 5,
+			},
+			indexSelector:     outil.MakeSelector(expr),
+			compressionExempt: []string{"blobs-*"},
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tests := map[string]struct {
+		indices     []string
+		wantGzipped bool
+	}{
+		"exempt index": {
+			indices:     []string{"blobs-images", "blobs-archives"},
+			wantGzipped: false,
+		},
+		"other index": {
+			indices:     []string{"logs-app"},
+			wantGzipped: true,
+		},
+		"mixed indices": {
+			indices:     []string{"blobs-images", "logs-app"},
+			wantGzipped: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var events []publisher.Event
+			for _, index := range tc.indices {
+				events = append(events, publisher.Event{Content: beat.Event{Fields: mapstr.M{"target": index}}})
+			}
+			batch := encodeBatch(client, &batchMock{events: events})
+
+			err := client.Publish(ctx, batch)
+			require.NoError(t, err)
+
+			assert.True(t, batch.ack, "batch should be acknowledged")
+			assert.Equal(t, tc.wantGzipped, gzipped, "unexpected request compression")
+			assert.Contains(t, sent, `"target":"`+tc.indices[0]+`"`, "the request body should hold the events")
+		})
+	}
+}
+
+func TestPublishCompressionThresholds(t *testing.T) {
+	var gzipped bool
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gzipped = r.Header.Get("Content-Encoding") == "gzip"
+		var body io.Reader = r.Body
+		if gzipped {
+			body, _ = gzip.NewReader(body)
+		}
+		b, _ := io.ReadAll(body)
+		items := make([]string, bytes.Count(b, []byte("\n"))/2)
+		for i := range items {
+			items[i] = `{"create":{"status":201}}`
+		}
+		_, _ = io.WriteString(w, `{"items":[`+strings.Join(items, ",")+`]}`)
+	}))
+	defer esMock.Close()
 
 	client, err := NewClient(
 		clientSettings{
 			observer: outputs.NewNilObserver(),
+			connection: eslegclient.ConnectionSettings{
+				URL:              esMock.URL,
+				CompressionLevel: 5,
+			},
+			indexSelector:        testIndexSelector{},
+			compressionMinEvents: 50,
+			compressionMinBytes:  8192,
 		},
 		nil,
-		logger,
+		logptest.NewTestingLogger(t, ""),
 	)
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
-	response := []byte(`{
-      "took": 0, "ingest_took": 0, "errors": true,
-      "items": [
-        {
-          "index": {
-            "_index": "filebeat-2016.08.10",
-            "_type": "log",
-            "_id": null,
-            "status": 500,
-            "error": {
-              "type": "exception",
-              "reason": "java.lang.IllegalArgumentException: java.lang.IllegalArgumentException: field [fail_on_purpose] not present as part of path [fail_on_purpose]",
-              "caused_by": {
-                "type": "illegal_argument_exception",
-                "reason": "java.lang.IllegalArgumentException: field [fail_on_purpose] not present as part of path [fail_on_purpose]",
-                "caused_by": {
-                  "type": "illegal_argument_exception",
-                  "reason": "field [fail_on_purpose] not present as part of path [fail_on_purpose]"
-                }
-              },
-              "header": {
-                "processor_type": "lowercase"
-              }
-            }
-          }
-        }
-      ]
-    }`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	event := publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 2}}}
-	events := encodeEvents(client, []publisher.Event{event})
+	tests := map[string]struct {
+		count       int
+		size        int
+		wantGzipped bool
+	}{
+		"few small events": {count: 49, size: 10, wantGzipped: false},
+		"many events":      {count: 500, size: 10, wantGzipped: true},
+		"few large events": {count: 2, size: 5000, wantGzipped: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			events := make([]publisher.Event, tc.count)
+			for i := range events {
+				events[i] = publisher.Event{Content: beat.Event{Fields: mapstr.M{"n": i, "message": strings.Repeat("x", tc.size)}}}
+			}
+			batch := encodeBatch(client, &batchMock{events: events})
 
-	res, _ := client.bulkCollectPublishFails(bulkResult{
-		events:   events,
-		status:   200,
-		response: response,
-	})
-	assert.Equal(t, 1, len(res))
-	assert.Equal(t, events, res)
+			err := client.Publish(ctx, batch)
+			require.NoError(t, err)
+
+			assert.True(t, batch.ack, "batch should be acknowledged")
+			assert.Equal(t, tc.wantGzipped, gzipped, "unexpected request compression")
+		})
+	}
 }
 
-func TestCollectPublishFailWithFailureStore(t *testing.T) {
-	logger := logptest.NewTestingLogger(t, "")
+func TestPublishDryRun(t *testing.T) {
+	var requests atomic.Int64
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer esMock.Close()
+
 	reg := monitoring.NewRegistry()
 	client, err := NewClient(
 		clientSettings{
-			observer: outputs.NewStats(reg, logp.NewNopLogger()),
+			observer:      outputs.NewStats(reg, logp.NewNopLogger()),
+			connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+			indexSelector: testIndexSelector{},
+			dryRun:        true,
 		},
 		nil,
-		logger,
+		logptest.NewTestingLogger(t, ""),
 	)
-	assert.NoError(t, err)
-
-	response := []byte(`{
-      "took": 42,
-      "errors": false,
-      "items": [
-        {
-          "create": {
-            "status": 201,
-            "failure_store": "used"
-          }
-        },
-        {
-          "create": {
-            "status": 201
-          }
-        }
-      ]
-    }`)
+	require.NoError(t, err)
 
-	event1 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 1}}})
-	event2 := encodeEvent(client, publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": 2}}})
-	events := []publisher.Event{event1, event2}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, client.Connect(ctx), "Connect should succeed without a cluster")
 
-	res, stats := client.bulkCollectPublishFails(bulkResult{
-		events:   events,
-		status:   200,
-		response: response,
+	batch := encodeBatch(client, &batchMock{
+		events: []publisher.Event{
+			{Content: beat.Event{Fields: mapstr.M{"field": 1}}},
+			{Content: beat.Event{Fields: mapstr.M{"field": 2}}},
+		},
 	})
-	assert.Equal(t, 0, len(res))
-	assert.Equal(t, bulkResultStats{acked: 2, failureStoreUsed: 1}, stats)
+	err = client.Publish(ctx, batch)
+	require.NoError(t, err)
 
-	stats.reportToObserver(client.observer)
-	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, true)
-	assert.EqualValues(t, 1, snapshot.Ints["events.failure_store"])
-	assert.EqualValues(t, 2, snapshot.Ints["events.acked"])
+	assert.Zero(t, requests.Load(), "no request should reach Elasticsearch in dry run mode")
+	assert.True(t, batch.ack, "batch should be acknowledged")
+	assertRegistryUint(t, reg, "events.would_send", 2, "the encoded events should be reported as would send")
+	assertRegistryUint(t, reg, "events.acked", 0, "the events should not be reported as acked")
+	assertRegistryUint(t, reg, "events.active", 0, "Active events should be zero when Publish returns")
 }
 
 func TestPublishResultForStats(t *testing.T) {
@@ -903,6 +3096,152 @@ func BenchmarkCollectPublishFailAll(b *testing.B) {
 	}
 }
 
+// BenchmarkCollectPublishFailLarge measures reading the bulk response of a
+// large batch. The response is read in place rather than decoded into a
+// slice of items, so reading it only allocates for the failed items, about
+// three allocations each, rather than for every item of the batch.
+func BenchmarkCollectPublishFailLarge(b *testing.B) {
+	const count = 1600
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewNilObserver(),
+		},
+		nil,
+		logp.NewNopLogger(),
+	)
+	assert.NoError(b, err)
+
+	var response strings.Builder
+	response.WriteString(`{"took": 30, "errors": true, "items": [`)
+	events := make([]publisher.Event, count)
+	for i := range events {
+		if i > 0 {
+			response.WriteString(",")
+		}
+		if i%100 == 1 {
+			response.WriteString(`{"create": {"_index": "test", "status": 429, "error": {"type": "es_rejected_execution_exception", "reason": "ups"}}}`)
+		} else {
+			response.WriteString(`{"create": {"_index": "test", "status": 201, "result": "created"}}`)
+		}
+		events[i] = publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": i}}}
+	}
+	response.WriteString("]}")
+	responseBytes := []byte(response.String())
+	encodeEvents(client, events)
+
+	// bulkCollectPublishFails reuses the slice of events for the events to
+	// retry, so each iteration is passed a fresh copy, made outside of the
+	// measurement.
+	batch := make([]publisher.Event, count)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		copy(batch, events)
+		b.StartTimer()
+		res, _ := client.bulkCollectPublishFails(bulkResult{
+			events:   batch,
+			status:   200,
+			response: responseBytes,
+		})
+		if len(res) != count/100 {
+			b.Fail()
+		}
+	}
+}
+
+// BenchmarkCollectPublishFailFastAck compares reading the items of a large
+// bulk response without errors with skipping them on the fast path, which
+// should be faster.
+func BenchmarkCollectPublishFailFastAck(b *testing.B) {
+	const count = 1600
+	var response strings.Builder
+	response.WriteString(`{"took": 30, "errors": false, "items": [`)
+	events := make([]publisher.Event, count)
+	for i := range events {
+		if i > 0 {
+			response.WriteString(",")
+		}
+		response.WriteString(`{"create": {"_index": "test", "_id": "abc", "_version": 1, "result": "created", "_shards": {"total": 2, "successful": 1, "failed": 0}, "_seq_no": 1, "_primary_term": 1, "status": 201}}`)
+		events[i] = publisher.Event{Content: beat.Event{Fields: mapstr.M{"field": i}}}
+	}
+	response.WriteString("]}")
+	responseBytes := []byte(response.String())
+
+	for _, fastAck := range []bool{false, true} {
+		b.Run(fmt.Sprintf("fast_ack=%t", fastAck), func(b *testing.B) {
+			client, err := NewClient(
+				clientSettings{
+					observer: outputs.NewNilObserver(),
+					fastAck:  fastAck,
+				},
+				nil,
+				logp.NewNopLogger(),
+			)
+			require.NoError(b, err)
+			encoded := encodeEvents(client, slices.Clone(events))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res, stats := client.bulkCollectPublishFails(bulkResult{
+					events:   slices.Clone(encoded),
+					status:   200,
+					response: responseBytes,
+				})
+				if len(res) != 0 || stats.acked != count {
+					b.Fail()
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBulkEncodeParallel compares encoding the bulk request of a large
+// batch serially and with several workers.
+func BenchmarkBulkEncodeParallel(b *testing.B) {
+	const count = 500
+	for _, workers := range []int{0, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			client, err := NewClient(
+				clientSettings{
+					observer:         outputs.NewNilObserver(),
+					indexSelector:    testIndexSelector{},
+					parallelEncoding: ParallelEncoding{Workers: workers},
+				},
+				nil,
+				logp.NewNopLogger(),
+			)
+			require.NoError(b, err)
+
+			events := make([]publisher.Event, count)
+			for i, event := range testutil.GenerateEvents(count, 20, 3) {
+				event.Meta = mapstr.M{e.FieldMetaID: strconv.Itoa(i), e.FieldMetaPipeline: "pipeline"}
+				events[i] = publisher.Event{Content: event}
+			}
+			encodeEvents(client, events)
+			version := *libversion.MustNew(version.GetDefaultVersion())
+			var buf bytes.Buffer
+			enc := eslegclient.NewJSONEncoder(&buf, false)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// bulkEncodePublishRequest reuses the slice of events, so
+				// pass a copy.
+				_, bulkItems := client.bulkEncodePublishRequest(version, slices.Clone(events))
+				enc.Reset()
+				for _, item := range bulkItems {
+					if err := enc.AddRaw(item); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkPublish(b *testing.B) {
 	tests := []struct {
 		Name   string
@@ -980,6 +3319,65 @@ func BenchmarkPublish(b *testing.B) {
 
 }
 
+func TestClientConnectClockSkew(t *testing.T) {
+	var nodeTime atomic.Int64
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, `{ "version": { "number": "8.17.0" } }`)
+		case "/_nodes/_local/stats/jvm":
+			assert.Equal(t, "nodes.*.timestamp", r.URL.Query().Get("filter_path"))
+			fmt.Fprintf(w, `{"nodes":{"node-1":{"timestamp":%d}}}`, nodeTime.Load())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer esMock.Close()
+
+	newClient := func(t *testing.T, settings ClockSkew) (*Client, *observer.ObservedLogs) {
+		logger, logs := logptest.NewTestingLoggerWithObserver(t, "")
+		client, err := NewClient(clientSettings{
+			observer:      outputs.NewNilObserver(),
+			connection:    eslegclient.ConnectionSettings{URL: esMock.URL},
+			indexSelector: testIndexSelector{},
+			clockSkew:     settings,
+		}, nil, logger)
+		require.NoError(t, err)
+		return client, logs
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	t.Run("within threshold", func(t *testing.T) {
+		nodeTime.Store(time.Now().Add(-10 * time.Second).UnixMilli())
+		client, logs := newClient(t, ClockSkew{MaxSkew: time.Minute})
+		require.NoError(t, client.Connect(ctx))
+		assert.Zero(t, logs.FilterMessageSnippet("Clock skew detected").Len())
+	})
+
+	t.Run("warn beyond threshold", func(t *testing.T) {
+		nodeTime.Store(time.Now().Add(-time.Hour).UnixMilli())
+		client, logs := newClient(t, ClockSkew{MaxSkew: time.Minute})
+		require.NoError(t, client.Connect(ctx))
+		assert.Equal(t, 1, logs.FilterMessageSnippet("Clock skew detected").Len())
+	})
+
+	t.Run("error beyond threshold", func(t *testing.T) {
+		nodeTime.Store(time.Now().Add(time.Hour).UnixMilli())
+		client, _ := newClient(t, ClockSkew{MaxSkew: time.Minute, Action: clockSkewError})
+		err := client.Connect(ctx)
+		require.ErrorContains(t, err, "clock skew check failed")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		nodeTime.Store(time.Now().Add(-time.Hour).UnixMilli())
+		client, logs := newClient(t, ClockSkew{Action: clockSkewError})
+		require.NoError(t, client.Connect(ctx))
+		assert.Zero(t, logs.FilterMessageSnippet("Clock skew detected").Len())
+	})
+}
+
 func TestClientWithHeaders(t *testing.T) {
 	requestCount := 0
 	// start a mock HTTP server
@@ -1043,35 +3441,123 @@ func TestBulkEncodeEvents(t *testing.T) {
 		config  mapstr.M
 		events  []mapstr.M
 	}{
-		"6.x": {
-			version: "6.8.0",
-			docType: "doc",
-			config:  mapstr.M{},
-			events:  []mapstr.M{{"message": "test"}},
+		"6.x": {
+			version: "6.8.0",
+			docType: "doc",
+			config:  mapstr.M{},
+			events:  []mapstr.M{{"message": "test"}},
+		},
+		"latest": {
+			version: version.GetDefaultVersion(),
+			docType: "",
+			config:  mapstr.M{},
+			events:  []mapstr.M{{"message": "test"}},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			logger := logptest.NewTestingLogger(t, "")
+			cfg := c.MustNewConfigFrom(test.config)
+			info := beat.Info{
+				IndexPrefix: "test",
+				Version:     test.version,
+				Logger:      logger,
+			}
+
+			im, err := idxmgmt.DefaultSupport(info, c.NewConfig())
+			require.NoError(t, err)
+
+			index, pipeline, err := buildSelectors(im, info, cfg)
+			require.NoError(t, err)
+
+			client, err := NewClient(
+				clientSettings{
+					observer:         outputs.NewNilObserver(),
+					indexSelector:    index,
+					pipelineSelector: pipeline,
+				},
+				nil,
+				logger,
+			)
+			assert.NoError(t, err)
+
+			events := make([]publisher.Event, len(test.events))
+			for i, fields := range test.events {
+				events[i] = publisher.Event{
+					Content: beat.Event{
+						Timestamp: time.Now(),
+						Fields:    fields,
+					},
+				}
+			}
+			encodeEvents(client, events)
+
+			encoded, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(test.version), events)
+			assert.Equal(t, len(events), len(encoded), "all events should have been encoded")
+			assert.Equal(t, 2*len(events), len(bulkItems), "incomplete bulk")
+
+			// check meta-data for each event
+			for i := 0; i < len(bulkItems); i += 2 {
+				var meta eslegclient.BulkMeta
+				switch v := bulkItems[i].(type) {
+				case eslegclient.BulkCreateAction:
+					meta = v.Create
+				case eslegclient.BulkIndexAction:
+					meta = v.Index
+				default:
+					panic("unknown type")
+				}
+
+				assert.NotEqual(t, "", meta.Index)
+				assert.Equal(t, test.docType, meta.DocType)
+			}
+
+			// TODO: customer per test case validation
+		})
+	}
+}
+
+func TestBulkEncodeRequireAlias(t *testing.T) {
+	cases := map[string]struct {
+		requireAlias bool
+		meta         mapstr.M
+		want         bool
+	}{
+		"disabled": {
+			meta: mapstr.M{},
+			want: false,
 		},
-		"latest": {
-			version: version.GetDefaultVersion(),
-			docType: "",
-			config:  mapstr.M{},
-			events:  []mapstr.M{{"message": "test"}},
+		"enabled": {
+			requireAlias: true,
+			meta:         mapstr.M{},
+			want:         true,
+		},
+		"enabled by event": {
+			meta: mapstr.M{e.FieldMetaRequireAlias: true},
+			want: true,
+		},
+		"disabled by event": {
+			requireAlias: true,
+			meta:         mapstr.M{e.FieldMetaRequireAlias: false},
+			want:         false,
 		},
 	}
 
 	for name, test := range cases {
-		test := test
 		t.Run(name, func(t *testing.T) {
 			logger := logptest.NewTestingLogger(t, "")
-			cfg := c.MustNewConfigFrom(test.config)
 			info := beat.Info{
 				IndexPrefix: "test",
-				Version:     test.version,
+				Version:     version.GetDefaultVersion(),
 				Logger:      logger,
 			}
 
 			im, err := idxmgmt.DefaultSupport(info, c.NewConfig())
 			require.NoError(t, err)
 
-			index, pipeline, err := buildSelectors(im, info, cfg)
+			index, pipeline, err := buildSelectors(im, info, c.NewConfig())
 			require.NoError(t, err)
 
 			client, err := NewClient(
@@ -1079,48 +3565,153 @@ func TestBulkEncodeEvents(t *testing.T) {
 					observer:         outputs.NewNilObserver(),
 					indexSelector:    index,
 					pipelineSelector: pipeline,
+					requireAlias:     test.requireAlias,
 				},
 				nil,
 				logger,
 			)
-			assert.NoError(t, err)
+			require.NoError(t, err)
 
-			events := make([]publisher.Event, len(test.events))
-			for i, fields := range test.events {
-				events[i] = publisher.Event{
+			var events []publisher.Event
+			for _, opType := range []e.OpType{e.OpTypeIndex, e.OpTypeCreate} {
+				meta := test.meta.Clone()
+				meta[e.FieldMetaOpType] = opType
+				events = append(events, publisher.Event{
 					Content: beat.Event{
 						Timestamp: time.Now(),
-						Fields:    fields,
+						Meta:      meta,
+						Fields:    mapstr.M{"message": "test"},
 					},
-				}
+				})
 			}
 			encodeEvents(client, events)
 
-			encoded, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(test.version), events)
-			assert.Equal(t, len(events), len(encoded), "all events should have been encoded")
-			assert.Equal(t, 2*len(events), len(bulkItems), "incomplete bulk")
+			encoded, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+			require.Equal(t, len(events), len(encoded), "all events should have been encoded")
+			require.Equal(t, 2*len(events), len(bulkItems), "incomplete bulk")
+			require.IsType(t, eslegclient.BulkIndexAction{}, bulkItems[0])
+			require.IsType(t, eslegclient.BulkCreateAction{}, bulkItems[2])
 
-			// check meta-data for each event
 			for i := 0; i < len(bulkItems); i += 2 {
-				var meta eslegclient.BulkMeta
-				switch v := bulkItems[i].(type) {
-				case eslegclient.BulkCreateAction:
-					meta = v.Create
-				case eslegclient.BulkIndexAction:
-					meta = v.Index
-				default:
-					panic("unknown type")
+				var buf bytes.Buffer
+				enc := eslegclient.NewJSONEncoder(&buf, false)
+				require.NoError(t, enc.AddRaw(bulkItems[i]))
+				if test.want {
+					assert.Contains(t, buf.String(), `"require_alias":true`, "action line should require an alias")
+				} else {
+					assert.NotContains(t, buf.String(), "require_alias", "action line should not require an alias")
 				}
-
-				assert.NotEqual(t, "", meta.Index)
-				assert.Equal(t, test.docType, meta.DocType)
 			}
-
-			// TODO: customer per test case validation
 		})
 	}
 }
 
+func TestBulkEncodePipelineMeta(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	info := beat.Info{
+		IndexPrefix: "test",
+		Version:     version.GetDefaultVersion(),
+		Logger:      logger,
+	}
+
+	im, err := idxmgmt.DefaultSupport(info, c.NewConfig())
+	require.NoError(t, err)
+
+	cfg := c.MustNewConfigFrom(mapstr.M{"pipeline": "default-pipeline"})
+	index, pipeline, err := buildSelectors(im, info, cfg)
+	require.NoError(t, err)
+
+	client, err := NewClient(
+		clientSettings{
+			observer:         outputs.NewNilObserver(),
+			indexSelector:    index,
+			pipelineSelector: pipeline,
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+
+	metas := []mapstr.M{
+		nil,
+		{e.FieldMetaPipeline: "Event-Pipeline"},
+		{e.FieldMetaPipeline: ""},
+		{e.FieldMetaID: "id"},
+	}
+	want := []string{"default-pipeline", "event-pipeline", "default-pipeline", "default-pipeline"}
+
+	events := make([]publisher.Event, len(metas))
+	for i, meta := range metas {
+		events[i] = publisher.Event{
+			Content: beat.Event{
+				Timestamp: time.Now(),
+				Meta:      meta,
+				Fields:    mapstr.M{"message": "test"},
+			},
+		}
+	}
+	encodeEvents(client, events)
+
+	encoded, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+	require.Equal(t, len(events), len(encoded), "all events should have been encoded")
+	require.Equal(t, 2*len(events), len(bulkItems), "incomplete bulk")
+
+	for i := range events {
+		var meta eslegclient.BulkMeta
+		switch v := bulkItems[2*i].(type) {
+		case eslegclient.BulkCreateAction:
+			meta = v.Create
+		case eslegclient.BulkIndexAction:
+			meta = v.Index
+		default:
+			t.Fatalf("unexpected action type %T", v)
+		}
+		assert.Equal(t, want[i], meta.Pipeline, "unexpected pipeline of event %d", i)
+	}
+}
+
+func TestBulkEncodeDynamicTemplates(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	client, err := NewClient(
+		clientSettings{
+			observer:      outputs.NewNilObserver(),
+			indexSelector: testIndexSelector{},
+		},
+		nil,
+		logger,
+	)
+	require.NoError(t, err)
+
+	events := encodeEvents(client, []publisher.Event{
+		{Content: beat.Event{Fields: mapstr.M{"message": "first"}}},
+		{Content: beat.Event{
+			Meta: mapstr.M{e.FieldMetaDynamicTemplates: mapstr.M{
+				"location": "geo_point",
+				"invalid":  1,
+			}},
+			Fields: mapstr.M{"message": "second", "location": "41.12,-71.34"},
+		}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "third"}}},
+	})
+
+	encoded, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+	require.Equal(t, len(events), len(encoded), "all events should have been encoded")
+	require.Equal(t, 2*len(events), len(bulkItems), "incomplete bulk")
+
+	for i := 0; i < len(bulkItems); i += 2 {
+		var buf bytes.Buffer
+		enc := eslegclient.NewJSONEncoder(&buf, false)
+		require.NoError(t, enc.AddRaw(bulkItems[i]))
+		if i == 2 {
+			assert.Contains(t, buf.String(), `"dynamic_templates":{"location":"geo_point"}`,
+				"action line of the event with dynamic templates should include them")
+		} else {
+			assert.NotContains(t, buf.String(), "dynamic_templates",
+				"action lines of other events should not include dynamic templates")
+		}
+	}
+}
+
 func TestBulkEncodeEventsWithOpType(t *testing.T) {
 	cases := []mapstr.M{
 		{"_id": "111", "op_type": e.OpTypeIndex, "message": "test 1", "bulkIndex": 0},
@@ -1129,6 +3720,11 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 		{"_id": "", "message": "test 3", "bulkIndex": 4},
 		{"_id": "114", "op_type": e.OpTypeDelete, "message": "test 4", "bulkIndex": 6},
 		{"_id": "115", "op_type": e.OpTypeIndex, "message": "test 5", "bulkIndex": 7},
+		{"_id": "116", "op_type": e.OpTypeIndex, "if_seq_no": 0, "if_primary_term": 2, "message": "test 7", "bulkIndex": 9},
+		{"_id": "117", "op_type": e.OpTypeIndex, "_version": 5, "_version_type": "external", "message": "test 8", "bulkIndex": 11},
+		{"_id": "118", "op_type": e.OpTypeIndex, "_version": 6, "message": "test 9", "bulkIndex": -1},                // version type missing
+		{"_id": "119", "op_type": e.OpTypeIndex, "_version_type": "external", "message": "test 10", "bulkIndex": -1}, // version missing
+		{"_id": "120", "_version": 7, "_version_type": "external", "message": "test 11", "bulkIndex": -1},            // create doesn't support external versions
 	}
 
 	cfg := c.MustNewConfigFrom(mapstr.M{})
@@ -1163,6 +3759,11 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 		if opType, exists := fields["op_type"]; exists {
 			meta[e.FieldMetaOpType] = opType
 		}
+		for _, key := range []string{e.FieldMetaIfSeqNo, e.FieldMetaIfPrimaryTerm, e.FieldMetaVersion, e.FieldMetaVersionType} {
+			if v, exists := fields[key]; exists {
+				meta[key] = v
+			}
+		}
 
 		events[i] = publisher.Event{
 			Content: beat.Event{
@@ -1176,8 +3777,8 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 	encodeEvents(client, events)
 
 	encoded, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
-	require.Equal(t, len(events)-1, len(encoded), "all events should have been encoded")
-	require.Equal(t, 9, len(bulkItems), "incomplete bulk")
+	require.Equal(t, len(events)-4, len(encoded), "all valid events should have been encoded")
+	require.Equal(t, 13, len(bulkItems), "incomplete bulk")
 
 	for i := 0; i < len(cases); i++ {
 		bulkEventIndex, _ := cases[i]["bulkIndex"].(int)
@@ -1197,8 +3798,302 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 		default:
 			require.FailNow(t, "unknown type")
 		}
+
+		// Concurrency control values are only emitted when set.
+		var buf bytes.Buffer
+		enc := eslegclient.NewJSONEncoder(&buf, false)
+		require.NoError(t, enc.AddRaw(bulkItems[bulkEventIndex]), caseMessage)
+		actionLine := buf.String()
+		if _, ok := cases[i][e.FieldMetaIfSeqNo]; ok {
+			require.Contains(t, actionLine, `"if_seq_no":0`, caseMessage)
+			require.Contains(t, actionLine, `"if_primary_term":2`, caseMessage)
+		} else {
+			require.NotContains(t, actionLine, "if_seq_no", caseMessage)
+			require.NotContains(t, actionLine, "if_primary_term", caseMessage)
+		}
+		if _, ok := cases[i][e.FieldMetaVersion]; ok {
+			require.Contains(t, actionLine, `"version":5`, caseMessage)
+			require.Contains(t, actionLine, `"version_type":"external"`, caseMessage)
+		} else {
+			require.NotContains(t, actionLine, "version", caseMessage)
+		}
+	}
+}
+
+func TestBulkEncodeIndexTransform(t *testing.T) {
+	// tenantSuffix appends the tenant of the event to its index.
+	tenantSuffix := func(event *beat.Event, index string) string {
+		tenant, _ := event.GetValue("tenant")
+		return fmt.Sprintf("%s-%v", index, tenant)
+	}
+	newEvents := func() []publisher.Event {
+		return []publisher.Event{
+			{Content: beat.Event{Fields: mapstr.M{"tenant": "a", "message": "first"}}},
+			{Content: beat.Event{Fields: mapstr.M{"tenant": "b", "message": "second"}}},
+		}
+	}
+	encode := func(settings encodingSettings, events []publisher.Event) []publisher.Event {
+		encoder := newEventEncoder(false, testIndexSelector{}, nil, settings)
+		for i := range events {
+			events[i], _ = encoder.EncodeEntry(events[i])
+		}
+		return events
+	}
+	indices := func(bulkItems []any) []string {
+		var got []string
+		for i := 0; i < len(bulkItems); i += 2 {
+			got = append(got, bulkItems[i].(eslegclient.BulkCreateAction).Create.Index)
+		}
+		return got
+	}
+
+	client, err := NewClient(
+		clientSettings{
+			observer:      outputs.NewNilObserver(),
+			indexSelector: testIndexSelector{},
+		},
+		nil,
+		logp.NewNopLogger(),
+	)
+	require.NoError(t, err)
+
+	t.Run("transforms the index of each event", func(t *testing.T) {
+		events := encode(encodingSettings{indexTransform: tenantSuffix}, newEvents())
+		_, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+		assert.Equal(t, []string{"test-a", "test-b"}, indices(bulkItems), "the bulk actions should target the transformed indices")
+	})
+
+	t.Run("unset transform keeps the selected index", func(t *testing.T) {
+		events := encode(encodingSettings{}, newEvents())
+		_, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+		assert.Equal(t, []string{"test", "test"}, indices(bulkItems), "the bulk actions should target the selected index")
+	})
+
+	t.Run("transformed index is validated", func(t *testing.T) {
+		settings := encodingSettings{
+			indexTransform:  tenantSuffix,
+			allowedIndices:  []string{"test-a"},
+			deadLetterIndex: "dead-letters",
+		}
+		events := encode(settings, newEvents())
+		_, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+		assert.Equal(t, []string{"test-a", "dead-letters"}, indices(bulkItems), "allowed_indices should apply to the transformed index")
+	})
+
+	t.Run("dead letter index is not transformed", func(t *testing.T) {
+		events := encode(encodingSettings{indexTransform: tenantSuffix}, newEvents())
+		events[1].EncodedEvent.(*encodedEvent).setDeadLetter("dead-letters", false, deadLetterFields{}, http.StatusBadRequest, "mapping error")
+		_, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+		assert.Equal(t, []string{"test-a", "dead-letters"}, indices(bulkItems), "only the event for the selected index should be transformed")
+	})
+}
+
+func TestBulkEncodeDropReasons(t *testing.T) {
+	expr, err := outil.FmtSelectorExpr(fmtstr.MustCompileEvent("logs-%{[service.name]}"), "", outil.SelectorKeepCase)
+	require.NoError(t, err)
+	indexSelector := outil.MakeSelector(expr)
+
+	var dropped []string
+	client, err := NewClient(
+		clientSettings{
+			observer:      outputs.NewNilObserver(),
+			indexSelector: indexSelector,
+			onDrop: func(_ publisher.Event, reason string) {
+				dropped = append(dropped, reason)
+			},
+		},
+		nil,
+		logp.NewNopLogger(),
+	)
+	require.NoError(t, err)
+
+	encoder := newEventEncoder(false, indexSelector, nil, encodingSettings{
+		allowedIndices: []string{"logs-web"},
+		emptyIndex:     EmptyIndex{Policy: emptyIndexDrop},
+		eventLimits:    EventLimits{MaxDepth: 2},
+	})
+	events := []publisher.Event{
+		{Content: beat.Event{Fields: mapstr.M{"service": mapstr.M{"name": "web"}}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "unrouted"}}},
+		{Content: beat.Event{Fields: mapstr.M{"service": mapstr.M{"name": "db"}}}},
+		{Content: beat.Event{Fields: mapstr.M{"service": mapstr.M{"name": "web"}, "a": mapstr.M{"b": mapstr.M{"c": 1}}}}},
+	}
+	for i := range events {
+		events[i], _ = encoder.EncodeEntry(events[i])
+	}
+
+	sent, _ := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+	assert.Len(t, sent, 1, "only the valid event should be sent")
+	assert.Equal(t, []string{dropReasonEmptyIndex, dropReasonIndexNotAllowed, dropReasonTooComplex}, dropped)
+}
+
+func TestBulkEncodeAuditIndex(t *testing.T) {
+	client, err := NewClient(
+		clientSettings{
+			observer:        outputs.NewNilObserver(),
+			indexSelector:   testIndexSelector{},
+			deadLetterIndex: "dead-letters",
+			auditIndex:      "audit",
+		},
+		nil,
+		logp.NewNopLogger(),
+	)
+	require.NoError(t, err)
+
+	events := encodeEvents(client, []publisher.Event{
+		{Content: beat.Event{Meta: mapstr.M{e.FieldMetaID: "1"}, Fields: mapstr.M{"message": "first"}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "second"}}},
+		{Content: beat.Event{Meta: mapstr.M{e.FieldMetaID: "3", e.FieldMetaOpType: e.OpTypeDelete}, Fields: mapstr.M{"message": "deleted"}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "dead letter"}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "already audited"}}},
+	})
+	events[3].EncodedEvent.(*encodedEvent).setDeadLetter("dead-letters", false, deadLetterFields{}, http.StatusBadRequest, "mapping error")
+	events[4].EncodedEvent.(*encodedEvent).audited = true
+
+	encoded, bulkItems := client.bulkEncodePublishRequest(*libversion.MustNew(version.GetDefaultVersion()), events)
+	require.Len(t, encoded, 5)
+	require.Len(t, bulkItems, 13, "the first two events should have a primary and an audit item")
+
+	// Each event's own item is followed by the item creating its copy.
+	for _, i := range []int{0, 4} {
+		primary, ok := bulkItems[i].(eslegclient.BulkCreateAction)
+		require.True(t, ok, "item %d should be a create action", i)
+		assert.Equal(t, "test", primary.Create.Index)
+
+		audit, ok := bulkItems[i+2].(eslegclient.BulkCreateAction)
+		require.True(t, ok, "item %d should be a create action", i+2)
+		assert.Equal(t, eslegclient.BulkMeta{Index: "audit"}, audit.Create, "the audit copy should have its own ID")
+		assert.Equal(t, bulkItems[i+1], bulkItems[i+3], "the audit copy should have the event's source")
+	}
+	assert.Equal(t, "1", bulkItems[0].(eslegclient.BulkCreateAction).Create.ID)
+	assert.IsType(t, eslegclient.BulkDeleteAction{}, bulkItems[8])
+	assert.Equal(t, "dead-letters", bulkItems[9].(eslegclient.BulkCreateAction).Create.Index)
+	assert.Equal(t, "test", bulkItems[11].(eslegclient.BulkCreateAction).Create.Index)
+
+	for i, audit := range []bool{true, true, false, false, false} {
+		assert.Equal(t, audit, encoded[i].EncodedEvent.(*encodedEvent).audit, "event %d", i)
+	}
+}
+
+func TestBulkItemSize(t *testing.T) {
+	client, err := NewClient(
+		clientSettings{
+			observer:        outputs.NewNilObserver(),
+			indexSelector:   testIndexSelector{},
+			deadLetterIndex: "dead-letters",
+			auditIndex:      "audit",
+		},
+		nil,
+		logp.NewNopLogger(),
+	)
+	require.NoError(t, err)
+
+	events := encodeEvents(client, []publisher.Event{
+		{Content: beat.Event{Meta: mapstr.M{e.FieldMetaID: "1"}, Fields: mapstr.M{"message": "with id"}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "audited"}}},
+		{Content: beat.Event{Meta: mapstr.M{e.FieldMetaID: "3", e.FieldMetaOpType: e.OpTypeDelete}, Fields: mapstr.M{"message": "deleted"}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "dead letter"}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "already audited"}}},
+	})
+	events[3].EncodedEvent.(*encodedEvent).setDeadLetter("dead-letters", false, deadLetterFields{}, http.StatusBadRequest, "mapping error")
+	events[4].EncodedEvent.(*encodedEvent).audited = true
+
+	v := *libversion.MustNew(version.GetDefaultVersion())
+	for i, event := range events {
+		size := client.bulkItemSize(v, event.EncodedEvent.(*encodedEvent))
+
+		// The size must match the lines the event adds to the request,
+		// including those of its audit copy.
+		_, bulkItems := client.bulkEncodePublishRequest(v, []publisher.Event{event})
+		var buf bytes.Buffer
+		enc := eslegclient.NewJSONEncoder(&buf, false)
+		for _, item := range bulkItems {
+			require.NoError(t, enc.AddRaw(item))
+		}
+		assert.Equal(t, buf.Len(), size, "event %d", i)
+	}
+}
+
+func TestBulkEncodeParallel(t *testing.T) {
+	const count = 601
+	newClient := func(parallel ParallelEncoding, dropped *[]string) *Client {
+		client, err := NewClient(
+			clientSettings{
+				observer:         outputs.NewNilObserver(),
+				indexSelector:    testIndexSelector{},
+				maxEventAge:      time.Hour,
+				auditIndex:       "audit",
+				parallelEncoding: parallel,
+				onDrop: func(event publisher.Event, reason string) {
+					*dropped = append(*dropped, event.EncodedEvent.(*encodedEvent).id+" "+reason) //nolint:errcheck //safe to ignore type check
+				},
+			},
+			nil,
+			logp.NewNopLogger(),
+		)
+		require.NoError(t, err)
+		return client
+	}
+	// newEvents returns events with a mix of actions, some of which are
+	// dropped for having no ID or for being too old.
+	now := time.Now()
+	newEvents := func(client *Client) []publisher.Event {
+		events := make([]publisher.Event, count)
+		for i := range events {
+			meta := mapstr.M{e.FieldMetaID: strconv.Itoa(i)}
+			timestamp := now
+			switch i % 7 {
+			case 1:
+				meta[e.FieldMetaOpType] = e.OpTypeIndex
+			case 2:
+				meta[e.FieldMetaOpType] = e.OpTypeDelete
+			case 3:
+				timestamp = timestamp.Add(-2 * time.Hour)
+			}
+			if i%50 == 4 {
+				meta[e.FieldMetaOpType] = e.OpTypeDelete
+				meta[e.FieldMetaID] = ""
+			}
+			events[i] = publisher.Event{Content: beat.Event{Timestamp: timestamp, Meta: meta, Fields: mapstr.M{"message": i}}}
+		}
+		return encodeEvents(client, events)
+	}
+	// body encodes the bulk items as the bulk request body.
+	body := func(bulkItems []any) string {
+		var buf bytes.Buffer
+		enc := eslegclient.NewJSONEncoder(&buf, false)
+		for _, item := range bulkItems {
+			require.NoError(t, enc.AddRaw(item))
+		}
+		return buf.String()
 	}
+	version := *libversion.MustNew(version.GetDefaultVersion())
 
+	var serialDropped []string
+	serial := newClient(ParallelEncoding{}, &serialDropped)
+	serialEvents, serialItems := serial.bulkEncodePublishRequest(version, newEvents(serial))
+
+	for _, workers := range []int{2, 4, 7, count + 1} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			var dropped []string
+			client := newClient(ParallelEncoding{Workers: workers, MinEvents: count}, &dropped)
+			events, bulkItems := client.bulkEncodePublishRequest(version, newEvents(client))
+
+			require.Equal(t, len(serialEvents), len(events))
+			for i := range events {
+				assert.Equal(t, serialEvents[i].EncodedEvent.(*encodedEvent).id, events[i].EncodedEvent.(*encodedEvent).id, "event %d should keep its position", i) //nolint:errcheck //safe to ignore type check
+			}
+			assert.Equal(t, body(serialItems), body(bulkItems), "the request body should match the serial encoding")
+			assert.Equal(t, serialDropped, dropped, "dropped events should be reported in order")
+		})
+	}
+
+	t.Run("batches below min_events are encoded serially", func(t *testing.T) {
+		var dropped []string
+		client := newClient(ParallelEncoding{Workers: 4, MinEvents: count + 1}, &dropped)
+		_, bulkItems := client.bulkEncodePublishRequest(version, newEvents(client))
+		assert.IsType(t, eslegclient.BulkCreateAction{}, bulkItems[0], "actions should be left for the request body to encode")
+	})
 }
 
 func TestClientWithAPIKey(t *testing.T) {
@@ -1234,7 +4129,7 @@ func TestClientWithAPIKey(t *testing.T) {
 func TestBulkRequestHasFilterPath(t *testing.T) {
 	logger := logptest.NewTestingLogger(t, "")
 
-	makePublishTestClient := func(t *testing.T, url string, configParams map[string]string) *Client {
+	makePublishTestClient := func(t *testing.T, url string, configParams map[string]string, filterPath BulkFilterPath) *Client {
 		client, err := NewClient(
 			clientSettings{
 				observer: outputs.NewNilObserver(),
@@ -1243,6 +4138,7 @@ func TestBulkRequestHasFilterPath(t *testing.T) {
 					Parameters: configParams,
 				},
 				indexSelector: testIndexSelector{},
+				filterPath:    filterPath,
 			},
 			nil,
 			logger,
@@ -1273,7 +4169,7 @@ func TestBulkRequestHasFilterPath(t *testing.T) {
 			}
 		}))
 		defer esMock.Close()
-		client := makePublishTestClient(t, esMock.URL, nil)
+		client := makePublishTestClient(t, esMock.URL, nil, BulkFilterPath{})
 
 		batch := encodeBatch(client, &batchMock{events: []publisher.Event{event1}})
 		result := client.doBulkRequest(ctx, batch)
@@ -1301,7 +4197,7 @@ func TestBulkRequestHasFilterPath(t *testing.T) {
 			}
 		}))
 		defer esMock.Close()
-		client := makePublishTestClient(t, esMock.URL, configParams)
+		client := makePublishTestClient(t, esMock.URL, configParams, BulkFilterPath{})
 
 		batch := encodeBatch(client, &batchMock{events: []publisher.Event{event1}})
 		result := client.doBulkRequest(ctx, batch)
@@ -1309,6 +4205,57 @@ func TestBulkRequestHasFilterPath(t *testing.T) {
 		require.Equal(t, len(reqParams), 2, "Bulk request should include configured parameter and standard filter path")
 		require.Equal(t, filterPathValue, reqParams.Get(filterPathKey), "Bulk request should include standard filter path")
 	})
+	t.Run("Single event with custom filter path entries", func(t *testing.T) {
+		var reqParams url.Values
+		esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqParams = r.URL.Query()
+			_, _ = w.Write([]byte(`{"items":[{"index":{"_id":"abc","result":"created","status":201}}]}`))
+		}))
+		defer esMock.Close()
+		client := makePublishTestClient(t, esMock.URL, nil, BulkFilterPath{Entries: []string{"items.*._id", "items.*.result"}})
+
+		batch := encodeBatch(client, &batchMock{events: []publisher.Event{event1}})
+		err := client.Publish(ctx, batch)
+		require.NoError(t, err)
+		assert.True(t, batch.ack, "the response with the custom fields should be read")
+		require.Equal(t, filterPathValue+",items.*._id,items.*.result", reqParams.Get(filterPathKey), "Bulk request should append the custom entries to the standard filter path")
+	})
+}
+
+func TestBulkParams(t *testing.T) {
+	tests := map[string]struct {
+		filterPath BulkFilterPath
+		want       string
+	}{
+		"standard": {
+			want: "errors,items.*.error,items.*.status,items.*.failure_store",
+		},
+		"append": {
+			filterPath: BulkFilterPath{Entries: []string{"items.*._id", "took"}},
+			want:       "errors,items.*.error,items.*.status,items.*.failure_store,items.*._id,took",
+		},
+		"append duplicate": {
+			filterPath: BulkFilterPath{Mode: filterPathAppend, Entries: []string{"items.*.status", "items.*.result"}},
+			want:       "errors,items.*.error,items.*.status,items.*.failure_store,items.*.result",
+		},
+		"replace": {
+			filterPath: BulkFilterPath{Mode: filterPathReplace, Entries: []string{"items.*._id"}},
+			want:       "items.*.error,items.*.status,items.*.failure_store,items.*._id",
+		},
+		"replace without entries": {
+			filterPath: BulkFilterPath{Mode: filterPathReplace},
+			want:       "items.*.error,items.*.status,items.*.failure_store",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			params := bulkParams(tc.filterPath)
+			assert.Equal(t, map[string]string{"filter_path": tc.want}, params)
+			for _, required := range requiredFilterPath {
+				assert.Contains(t, strings.Split(params["filter_path"], ","), required, "the required entries should never be dropped")
+			}
+		})
+	}
 }
 
 func TestSetDeadLetter(t *testing.T) {
@@ -1318,7 +4265,7 @@ func TestSetDeadLetter(t *testing.T) {
 	}
 	errType := 123
 	errStr := "test error string"
-	e.setDeadLetter(dead_letter_index, errType, errStr)
+	e.setDeadLetter(dead_letter_index, false, deadLetterFields{}, errType, errStr)
 
 	assert.True(t, e.deadLetter, "setDeadLetter should set the event's deadLetter flag")
 	assert.Equal(t, dead_letter_index, e.index, "setDeadLetter should overwrite the event's original index")
@@ -1332,3 +4279,68 @@ func TestSetDeadLetter(t *testing.T) {
 	assert.Equal(t, errType, errFields.ErrType, "encoded error.type should match value in setDeadLetter")
 	assert.Equal(t, errStr, errFields.ErrMessage, "encoded error.message should match value in setDeadLetter")
 }
+
+func TestSetDeadLetterErrorFields(t *testing.T) {
+	e := &encodedEvent{
+		index: "original_index",
+		id:    "doc-1",
+	}
+	fields := deadLetterFields{
+		errorType:     "dead_letter.status",
+		errorMessage:  "dead_letter.reason",
+		originalIndex: "dead_letter.index",
+		documentID:    "dead_letter.id",
+	}
+	e.setDeadLetter("dead_index", false, fields, 400, "test error string")
+
+	var doc map[string]any
+	err := json.Unmarshal(e.encoding, &doc)
+	require.NoError(t, err, "json decoding of encoded event should succeed")
+	assert.EqualValues(t, 400, doc["dead_letter.status"], "the error type should be in the configured field")
+	assert.Equal(t, "test error string", doc["dead_letter.reason"], "the error message should be in the configured field")
+	assert.Equal(t, "original_index", doc["dead_letter.index"], "the original index should be in the configured field")
+	assert.Equal(t, "doc-1", doc["dead_letter.id"], "the document ID should be in the configured field")
+	assert.NotContains(t, doc, "error.type", "the default error type field should not be set")
+	assert.NotContains(t, doc, "error.message", "the default error message field should not be set")
+	assert.NotContains(t, doc, "error.original_index", "the default original index field should not be set")
+	assert.NotContains(t, doc, "error.document_id", "the default document ID field should not be set")
+}
+
+func TestSetPipelineDeadLetterErrorFields(t *testing.T) {
+	e := &encodedEvent{
+		index: "original_index",
+	}
+	fields := deadLetterFields{processorType: "dead_letter.processor", pipeline: "dead_letter.pipeline"}
+	e.setPipelineDeadLetter("dead_index", false, fields, 400, "test error string", pipelineFailure{processorType: "grok", pipeline: "logs"})
+
+	var doc map[string]any
+	err := json.Unmarshal(e.encoding, &doc)
+	require.NoError(t, err, "json decoding of encoded event should succeed")
+	assert.Equal(t, "grok", doc["dead_letter.processor"], "the processor type should be in the configured field")
+	assert.Equal(t, "logs", doc["dead_letter.pipeline"], "the pipeline should be in the configured field")
+	assert.NotContains(t, doc, "error.processor_type", "the default processor type field should not be set")
+	assert.NotContains(t, doc, "error.pipeline", "the default pipeline field should not be set")
+}
+
+func TestCloneConnectionSettings(t *testing.T) {
+	client, err := NewClient(
+		clientSettings{
+			observer: outputs.NewNilObserver(),
+			connection: eslegclient.ConnectionSettings{
+				URL: "http://localhost:9200",
+				DNSRoundRobin: eslegclient.DNSRoundRobinSettings{
+					Enabled:         true,
+					RefreshInterval: time.Minute,
+				},
+				ExistsCacheTTL: 5 * time.Minute,
+			},
+		},
+		nil,
+		logptest.NewTestingLogger(t, ""),
+	)
+	require.NoError(t, err)
+
+	clone := client.Clone()
+	assert.Equal(t, client.conn.DNSRoundRobin, clone.conn.DNSRoundRobin)
+	assert.Equal(t, client.conn.ExistsCacheTTL, clone.conn.ExistsCacheTTL)
+}
@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/esleg/eslegclient"
+)
+
+const (
+	clockSkewWarn  = "warn"
+	clockSkewError = "error"
+)
+
+// clockSkewParams selects the timestamps of the node stats response.
+var clockSkewParams = map[string]string{"filter_path": "nodes.*.timestamp"}
+
+// checkClockSkew compares the local time to the time of the Elasticsearch
+// node the client is connected to. If they differ by more than the
+// configured maximum, it logs a warning or, if the action is error, returns
+// an error failing the connection. Failing to read the node time is only
+// logged, so that the check never prevents connecting to clusters where it
+// isn't allowed.
+func (client *Client) checkClockSkew() error {
+	settings := client.clockSkew
+	if settings.MaxSkew <= 0 {
+		return nil
+	}
+
+	skew, err := clockSkew(&client.conn)
+	if err != nil {
+		client.log.Warnf("Failed to check the clock skew with Elasticsearch: %v", err)
+		return nil
+	}
+	if skew.Abs() <= settings.MaxSkew {
+		return nil
+	}
+
+	msg := fmt.Sprintf("the local clock differs from the Elasticsearch clock by %v, more than the maximum of %v; date math index names and time based routing may select the wrong targets", skew, settings.MaxSkew)
+	if settings.Action == clockSkewError {
+		return fmt.Errorf("clock skew check failed: %s", msg)
+	}
+	client.log.Warnf("Clock skew detected: %s", msg)
+	return nil
+}
+
+// clockSkew returns the difference between the local time and the time of
+// the node serving the request. The local time is taken halfway through the
+// request to offset the request latency.
+func clockSkew(conn *eslegclient.Connection) (time.Duration, error) {
+	begin := time.Now()
+	status, body, err := conn.Request(http.MethodGet, "/_nodes/_local/stats/jvm", "", clockSkewParams, nil)
+	if err != nil {
+		return 0, err
+	}
+	local := begin.Add(time.Since(begin) / 2)
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d reading the node stats", status)
+	}
+
+	var stats struct {
+		Nodes map[string]struct {
+			Timestamp int64 `json:"timestamp"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return 0, fmt.Errorf("failed to parse the node stats: %w", err)
+	}
+	for _, node := range stats.Nodes {
+		return local.Sub(time.UnixMilli(node.Timestamp)), nil
+	}
+	return 0, fmt.Errorf("no node timestamp in the node stats")
+}
@@ -19,44 +19,248 @@ package elasticsearch
 
 import (
 	"fmt"
+	"path"
 	"time"
 
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
 	"github.com/elastic/beats/v7/libbeat/common/transport/kerberos"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
 )
 
 type ElasticsearchConfig struct {
-	Protocol           string            `config:"protocol"`
-	Path               string            `config:"path"`
-	Params             map[string]string `config:"parameters"`
-	Headers            map[string]string `config:"headers"`
-	Username           string            `config:"username"`
-	Password           string            `config:"password"`
-	APIKey             string            `config:"api_key"`
-	LoadBalance        bool              `config:"loadbalance"`
-	CompressionLevel   int               `config:"compression_level" validate:"min=0, max=9"`
-	EscapeHTML         bool              `config:"escape_html"`
-	Kerberos           *kerberos.Config  `config:"kerberos"`
-	BulkMaxSize        int               `config:"bulk_max_size"`
-	MaxRetries         int               `config:"max_retries"`
-	Backoff            Backoff           `config:"backoff"`
-	NonIndexablePolicy *config.Namespace `config:"non_indexable_policy"`
-	AllowOlderVersion  bool              `config:"allow_older_versions"`
-	Queue              config.Namespace  `config:"queue"`
+	Protocol              string            `config:"protocol"`
+	Path                  string            `config:"path"`
+	Params                map[string]string `config:"parameters"`
+	Headers               map[string]string `config:"headers"`
+	Username              string            `config:"username"`
+	Password              string            `config:"password"`
+	APIKey                string            `config:"api_key"`
+	LoadBalance           bool              `config:"loadbalance"`
+	CompressionLevel      int               `config:"compression_level" validate:"min=0, max=9"`
+	CompressionMode       string            `config:"compression_mode"`
+	CompressionTuning     CompressionTuning `config:"compression_tuning"`
+	CompressionExempt     []string          `config:"compression_exempt_indices"`
+	CompressionMinEvents  int               `config:"compression_min_events" validate:"min=0"`
+	CompressionMinBytes   cfgtype.ByteSize  `config:"compression_min_bytes"`
+	EscapeHTML            bool              `config:"escape_html"`
+	Kerberos              *kerberos.Config  `config:"kerberos"`
+	BulkMaxSize           int               `config:"bulk_max_size"`
+	MaxBulkBytes          cfgtype.ByteSize  `config:"max_bulk_bytes"`
+	MaxRetries            int               `config:"max_retries"`
+	MaxEventRetries       int               `config:"max_event_retries" validate:"min=0"`
+	ItemRetryRounds       int               `config:"item_retry_rounds" validate:"min=0"`
+	MaxEmptyRetries       int               `config:"max_empty_response_retries" validate:"min=0"`
+	FastAck               bool              `config:"fast_ack"`
+	MaxEventAge           time.Duration     `config:"max_event_age" validate:"min=0"`
+	MaxConcurrentBulk     int               `config:"max_concurrent_bulk" validate:"min=0"`
+	MaxDeadLetterBulk     int               `config:"max_concurrent_dead_letter_bulk" validate:"min=0"`
+	Backoff               Backoff           `config:"backoff"`
+	NonIndexablePolicy    *config.Namespace `config:"non_indexable_policy"`
+	AllowOlderVersion     bool              `config:"allow_older_versions"`
+	Queue                 config.Namespace  `config:"queue"`
+	DottedKeys            string            `config:"dotted_keys"`
+	MissingTimestamp      string            `config:"missing_timestamp"`
+	IndexField            string            `config:"index_field"`
+	AllowedIndices        []string          `config:"allowed_indices"`
+	DNSRoundRobin         DNSRoundRobin     `config:"dns_round_robin"`
+	EmptyIndex            EmptyIndex        `config:"empty_index"`
+	EventLimits           EventLimits       `config:"event_limits"`
+	PartialResponse       string            `config:"partial_response"`
+	ErrorLogDedup         ErrorLogDedup     `config:"error_log_dedup"`
+	JoinArrays            JoinArrays        `config:"join_arrays"`
+	TruncateFields        TruncateFields    `config:"truncate_fields"`
+	CircuitBreaker        CircuitBreaker    `config:"circuit_breaker"`
+	RetryBudget           RetryBudget       `config:"pipeline_retry_budget"`
+	BulkFilterPath        BulkFilterPath    `config:"bulk_filter_path"`
+	ItemStatusActions     ItemStatusActions `config:"item_status_actions"`
+	DryRun                bool              `config:"dry_run"`
+	ExistsCacheTTL        time.Duration     `config:"exists_cache_ttl" validate:"min=0"`
+	VersionConflictAction string            `config:"version_conflict_action"`
+	PerIndexMetrics       bool              `config:"per_index_metrics"`
+	RequireAlias          bool              `config:"require_alias"`
+	ClockSkew             ClockSkew         `config:"clock_skew"`
+	DropSummary           DropSummary       `config:"drop_summary"`
+	AuditIndex            string            `config:"audit_index"`
+	ParallelEncoding      ParallelEncoding  `config:"parallel_encoding"`
+	SameIDEvents          string            `config:"same_id_events"`
 
 	Transport httpcommon.HTTPTransportSettings `config:",inline"`
 }
 
 type Backoff struct {
-	Init time.Duration
-	Max  time.Duration
+	Init   time.Duration
+	Max    time.Duration
+	Jitter time.Duration `config:"jitter" validate:"min=0"`
+}
+
+// DNSRoundRobin configures distributing requests across all the addresses
+// a host name resolves to.
+type DNSRoundRobin struct {
+	Enabled         bool          `config:"enabled"`
+	RefreshInterval time.Duration `config:"refresh_interval" validate:"positive"`
+}
+
+// EmptyIndex configures the handling of events for which the index
+// selection yields an empty index name.
+type EmptyIndex struct {
+	Policy string `config:"policy"`
+	Index  string `config:"index"`
+}
+
+// JoinArrays configures encoding array fields as strings holding the
+// array elements separated by a delimiter, instead of as JSON arrays.
+type JoinArrays struct {
+	// Fields lists the fields whose arrays are joined.
+	Fields []string `config:"fields"`
+
+	// All specifies whether all arrays are joined, regardless of Fields.
+	All bool `config:"all"`
+
+	// Delimiter separates the joined elements.
+	Delimiter string `config:"delimiter"`
+}
+
+// ItemStatusActions overrides the action applied to events whose bulk
+// item failed with the listed statuses. Statuses that are not listed keep
+// the default action.
+type ItemStatusActions struct {
+	Retry      []int `config:"retry"`
+	Drop       []int `config:"drop"`
+	DeadLetter []int `config:"dead_letter"`
+}
+
+// TruncateFields configures truncating long string values when events are
+// encoded, so that a single oversized field doesn't make the document too
+// large to be ingested.
+type TruncateFields struct {
+	// MaxLength is the maximum length in bytes of string values. Zero
+	// disables truncation.
+	MaxLength int `config:"max_length" validate:"min=0"`
+
+	// Marker is appended to truncated values.
+	Marker string `config:"marker"`
+}
+
+// CompressionTuning configures the adaptive compression mode, in which the
+// compression level starts at compression_level and is adjusted to the
+// level saving the most bytes per unit of time spent compressing.
+type CompressionTuning struct {
+	// MinLevel and MaxLevel bound the compression levels that are used.
+	MinLevel int `config:"min_level"`
+	MaxLevel int `config:"max_level"`
+
+	// Interval is the period over which each compression level is
+	// measured before the level is changed.
+	Interval time.Duration `config:"interval" validate:"positive"`
+}
+
+// ErrorLogDedup configures the deduplication of the logs of identical
+// ingestion errors across indices.
+type ErrorLogDedup struct {
+	// Window is the time during which repeated errors are counted instead
+	// of being logged. Zero disables the deduplication.
+	Window time.Duration `config:"window" validate:"min=0"`
+}
+
+// CircuitBreaker configures pausing the publishing of batches after
+// Elasticsearch repeatedly rejected them with 429 Too Many Requests.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive batches entirely rejected
+	// with 429 after which the breaker opens. Zero disables the breaker.
+	Threshold int `config:"threshold" validate:"min=0"`
+
+	// Cooldown is the time during which no batch is sent once the breaker
+	// is open.
+	Cooldown time.Duration `config:"cooldown" validate:"positive"`
+}
+
+// ParallelEncoding configures encoding the bulk requests of large batches
+// with several workers.
+type ParallelEncoding struct {
+	// Workers is the number of event ranges of a batch encoded
+	// concurrently. Batches are encoded serially if it is 0 or 1.
+	Workers int `config:"workers" validate:"min=0"`
+
+	// MinEvents is the number of events below which batches are encoded
+	// serially, as the workers don't pay off for small batches.
+	MinEvents int `config:"min_events" validate:"min=0"`
+}
+
+// ClockSkew configures comparing the local time to the Elasticsearch time
+// when connecting, as date math index names and time based routing select
+// the wrong targets when the clocks differ.
+type ClockSkew struct {
+	// MaxSkew is the difference between the clocks above which the action
+	// is taken. Zero disables the check.
+	MaxSkew time.Duration `config:"max_skew" validate:"min=0"`
+
+	// Action is warn to log the skew, or error to also fail the
+	// connection.
+	Action string `config:"action"`
+}
+
+// RetryBudget configures the number of event retries allowed for each
+// ingest pipeline since an event of the pipeline was last ingested. Once a
+// pipeline's budget is used up, its failed events are sent to the dead
+// letter index, or dropped if there is none, instead of being retried.
+type RetryBudget struct {
+	// Default is the budget of the pipelines not listed in Pipelines. Zero
+	// disables the budget.
+	Default int `config:"default" validate:"min=0"`
+
+	// Pipelines sets the budget of specific pipelines.
+	Pipelines map[string]int `config:"pipelines"`
+}
+
+// BulkFilterPath configures the filter_path of Bulk API requests, which
+// selects the fields of the response returned by Elasticsearch.
+type BulkFilterPath struct {
+	// Entries are the filter_path entries added to the standard ones, for
+	// example items.*._id.
+	Entries []string `config:"entries"`
+
+	// Mode is append to add Entries to the standard entries, or replace to
+	// only keep the entries required to read the response.
+	Mode string `config:"mode"`
+}
+
+// EventLimits bounds the complexity of the events that are encoded, to
+// protect throughput from pathological events. Zero values disable the
+// corresponding limit.
+type EventLimits struct {
+	// MaxDepth is the maximum nesting depth of objects and arrays.
+	MaxDepth int `config:"max_depth" validate:"min=0"`
+
+	// MaxFields is the maximum total number of object fields and array
+	// elements.
+	MaxFields int `config:"max_fields" validate:"min=0"`
 }
 
 const (
 	defaultBulkSize = 1600
 )
 
+const (
+	statusActionRetry      = "retry"
+	statusActionDrop       = "drop"
+	statusActionDeadLetter = "dead_letter"
+
+	// statusActionDuplicate counts the event as a duplicate of an already
+	// indexed document. It can't be set in item_status_actions.
+	statusActionDuplicate = "duplicate"
+)
+
+const (
+	versionConflictDuplicate = "duplicate"
+	versionConflictFail      = "fail"
+)
+
+const (
+	compressionModeFixed    = "fixed"
+	compressionModeAdaptive = "adaptive"
+)
+
 var (
 	defaultConfig = ElasticsearchConfig{
 		Protocol:         "",
@@ -74,8 +278,32 @@ var (
 			Init: 1 * time.Second,
 			Max:  60 * time.Second,
 		},
-		BulkMaxSize: defaultBulkSize,
-		Transport:   ESDefaultTransportSettings(),
+		BulkMaxSize:           defaultBulkSize,
+		PartialResponse:       partialResponseRetry,
+		SameIDEvents:          sameIDOrdered,
+		VersionConflictAction: versionConflictDuplicate,
+		CompressionMode:       compressionModeFixed,
+		CompressionTuning: CompressionTuning{
+			MinLevel: 1,
+			MaxLevel: 9,
+			Interval: time.Minute,
+		},
+		DNSRoundRobin: DNSRoundRobin{
+			RefreshInterval: time.Minute,
+		},
+		JoinArrays: JoinArrays{
+			Delimiter: ",",
+		},
+		TruncateFields: TruncateFields{
+			Marker: "...",
+		},
+		CircuitBreaker: CircuitBreaker{
+			Cooldown: 30 * time.Second,
+		},
+		ParallelEncoding: ParallelEncoding{
+			MinEvents: 500,
+		},
+		Transport: ESDefaultTransportSettings(),
 	}
 )
 
@@ -96,5 +324,135 @@ func (c *ElasticsearchConfig) Validate() error {
 		return fmt.Errorf("cannot set both api_key and username/password")
 	}
 
+	switch c.DottedKeys {
+	case "", dottedKeysNone, dottedKeysFlatten, dottedKeysExpand:
+	default:
+		return fmt.Errorf("invalid dotted_keys value %q: must be one of %s, %s or %s",
+			c.DottedKeys, dottedKeysNone, dottedKeysFlatten, dottedKeysExpand)
+	}
+
+	switch c.EmptyIndex.Policy {
+	case "", emptyIndexDrop, emptyIndexDeadLetter:
+	case emptyIndexDefault:
+		if c.EmptyIndex.Index == "" {
+			return fmt.Errorf("empty_index.index must be set when empty_index.policy is %s", emptyIndexDefault)
+		}
+	default:
+		return fmt.Errorf("invalid empty_index.policy value %q: must be one of %s, %s or %s",
+			c.EmptyIndex.Policy, emptyIndexDefault, emptyIndexDrop, emptyIndexDeadLetter)
+	}
+
+	switch c.MissingTimestamp {
+	case "", missingTimestampKeep, missingTimestampIngestTime, missingTimestampDrop:
+	default:
+		return fmt.Errorf("invalid missing_timestamp value %q: must be one of %s, %s or %s",
+			c.MissingTimestamp, missingTimestampKeep, missingTimestampIngestTime, missingTimestampDrop)
+	}
+
+	switch c.PartialResponse {
+	case "", partialResponseRetry, partialResponseDeadLetter:
+	default:
+		return fmt.Errorf("invalid partial_response value %q: must be %s or %s",
+			c.PartialResponse, partialResponseRetry, partialResponseDeadLetter)
+	}
+
+	switch c.SameIDEvents {
+	case "", sameIDOrdered, sameIDCollapse:
+	default:
+		return fmt.Errorf("invalid same_id_events value %q: must be %s or %s",
+			c.SameIDEvents, sameIDOrdered, sameIDCollapse)
+	}
+
+	switch c.VersionConflictAction {
+	case "", versionConflictDuplicate, versionConflictFail:
+	default:
+		return fmt.Errorf("invalid version_conflict_action value %q: must be %s or %s",
+			c.VersionConflictAction, versionConflictDuplicate, versionConflictFail)
+	}
+
+	switch c.CompressionMode {
+	case "", compressionModeFixed:
+	case compressionModeAdaptive:
+		if c.CompressionLevel == 0 {
+			return fmt.Errorf("compression_mode %s requires compression to be enabled with compression_level", compressionModeAdaptive)
+		}
+		tuning := c.CompressionTuning
+		if tuning.MinLevel < 1 || tuning.MaxLevel > 9 || tuning.MinLevel > tuning.MaxLevel {
+			return fmt.Errorf("invalid compression_tuning levels [%d, %d]: must be a range within [1, 9]", tuning.MinLevel, tuning.MaxLevel)
+		}
+	default:
+		return fmt.Errorf("invalid compression_mode value %q: must be %s or %s",
+			c.CompressionMode, compressionModeFixed, compressionModeAdaptive)
+	}
+
+	switch c.ClockSkew.Action {
+	case "", clockSkewWarn, clockSkewError:
+	default:
+		return fmt.Errorf("invalid clock_skew.action value %q: must be %s or %s",
+			c.ClockSkew.Action, clockSkewWarn, clockSkewError)
+	}
+
+	switch c.BulkFilterPath.Mode {
+	case "", filterPathAppend, filterPathReplace:
+	default:
+		return fmt.Errorf("invalid bulk_filter_path.mode value %q: must be %s or %s",
+			c.BulkFilterPath.Mode, filterPathAppend, filterPathReplace)
+	}
+
+	for pipeline, budget := range c.RetryBudget.Pipelines {
+		if budget < 0 {
+			return fmt.Errorf("pipeline_retry_budget.pipelines.%s must not be negative", pipeline)
+		}
+	}
+
+	seen := make(map[int]bool)
+	for action, statuses := range c.ItemStatusActions.byAction() {
+		for _, status := range statuses {
+			if status < 300 || status > 599 {
+				return fmt.Errorf("invalid item_status_actions.%s status %d: must be an HTTP error status between 300 and 599", action, status)
+			}
+			if seen[status] {
+				return fmt.Errorf("item_status_actions status %d is listed for more than one action", status)
+			}
+			seen[status] = true
+		}
+	}
+
+	for _, pattern := range c.AllowedIndices {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid allowed_indices pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, pattern := range c.CompressionExempt {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid compression_exempt_indices pattern %q: %w", pattern, err)
+		}
+	}
+
 	return nil
 }
+
+// byAction returns the listed statuses keyed by action.
+func (a ItemStatusActions) byAction() map[string][]int {
+	return map[string][]int{
+		statusActionRetry:      a.Retry,
+		statusActionDrop:       a.Drop,
+		statusActionDeadLetter: a.DeadLetter,
+	}
+}
+
+// statusActions returns the configured item status actions keyed by
+// status.
+func (c *ElasticsearchConfig) statusActions() map[int]string {
+	actions := make(map[int]string)
+	for action, statuses := range c.ItemStatusActions.byAction() {
+		for _, status := range statuses {
+			actions[status] = action
+		}
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+	return actions
+}
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	conf "github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -56,7 +57,56 @@ non_indexable_policy.dead_letter_index:
 	if err != nil {
 		t.Fatalf("Can't read non-indexable policy: %v", err.Error())
 	}
-	assert.Equal(t, "my-dead-letter-index", index, "index should match config")
+	assert.Equal(t, "my-dead-letter-index", index.Index, "index should match config")
+	assert.False(t, index.IsDataStream, "index should not be a data stream by default")
+}
+
+func TestDeadLetterErrorFieldsPolicyConfig(t *testing.T) {
+	config := `
+non_indexable_policy.dead_letter_index:
+    index: "my-dead-letter-index"
+    error_type_field: "dead_letter.status"
+    error_message_field: "dead_letter.reason"
+    original_index_field: "dead_letter.index"
+    document_id_field: "dead_letter.id"
+    processor_type_field: "dead_letter.processor"
+    pipeline_field: "dead_letter.pipeline"
+`
+	c := conf.MustNewConfigFrom(config)
+	elasticsearchOutputConfig, err := readConfig(c)
+	if err != nil {
+		t.Fatalf("Can't create test configuration from valid input")
+	}
+	index, err := deadLetterIndexForPolicy(elasticsearchOutputConfig.NonIndexablePolicy, logp.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Can't read non-indexable policy: %v", err.Error())
+	}
+	fields := index.fields()
+	assert.Equal(t, "dead_letter.status", fields.errorTypeField(), "error type field should match config")
+	assert.Equal(t, "dead_letter.reason", fields.errorMessageField(), "error message field should match config")
+	assert.Equal(t, "dead_letter.index", fields.originalIndexField(), "original index field should match config")
+	assert.Equal(t, "dead_letter.id", fields.documentIDField(), "document ID field should match config")
+	assert.Equal(t, "dead_letter.processor", fields.processorTypeField(), "processor type field should match config")
+	assert.Equal(t, "dead_letter.pipeline", fields.pipelineField(), "pipeline field should match config")
+}
+
+func TestDeadLetterDataStreamPolicyConfig(t *testing.T) {
+	config := `
+non_indexable_policy.dead_letter_index:
+    index: "logs-dead-letter-default"
+    is_data_stream: true
+`
+	c := conf.MustNewConfigFrom(config)
+	elasticsearchOutputConfig, err := readConfig(c)
+	if err != nil {
+		t.Fatalf("Can't create test configuration from valid input")
+	}
+	index, err := deadLetterIndexForPolicy(elasticsearchOutputConfig.NonIndexablePolicy, logp.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Can't read non-indexable policy: %v", err.Error())
+	}
+	assert.Equal(t, "logs-dead-letter-default", index.Index, "index should match config")
+	assert.True(t, index.IsDataStream, "index should be a data stream")
 }
 
 func TestInvalidNonIndexablePolicyConfig(t *testing.T) {
@@ -108,6 +158,12 @@ func TestCompressionIsOnByDefault(t *testing.T) {
 	assert.Equal(t, 1, elasticsearchOutputConfig.CompressionLevel, "Default compression level should be 1")
 }
 
+func TestEmptyResponsesAreRetriedIndefinitelyByDefault(t *testing.T) {
+	cfg, err := readConfig(conf.MustNewConfigFrom(""))
+	require.NoError(t, err)
+	assert.Zero(t, cfg.MaxEmptyRetries, "events should not be dropped after empty responses unless configured")
+}
+
 func TestExplicitCompressionLevelOverridesDefault(t *testing.T) {
 	config := `
 compression_level: 0
@@ -120,6 +176,191 @@ compression_level: 0
 	assert.Equal(t, 0, elasticsearchOutputConfig.CompressionLevel, "Explicit compression level should override defaults")
 }
 
+func TestDottedKeysConfig(t *testing.T) {
+	for _, mode := range []string{"", dottedKeysNone, dottedKeysFlatten, dottedKeysExpand} {
+		c := conf.MustNewConfigFrom(map[string]any{"dotted_keys": mode})
+		_, err := readConfig(c)
+		assert.NoError(t, err, "dotted_keys %q should be valid", mode)
+	}
+
+	c := conf.MustNewConfigFrom(`
+dotted_keys: juggle
+`)
+	_, err := readConfig(c)
+	assert.Error(t, err, "an unknown dotted_keys mode should be rejected")
+}
+
+func TestAllowedIndicesConfig(t *testing.T) {
+	c := conf.MustNewConfigFrom(map[string]any{"allowed_indices": []string{"logs-*", "metrics-?-*"}})
+	cfg, err := readConfig(c)
+	require.NoError(t, err, "valid allowed_indices patterns should be accepted")
+	assert.Equal(t, []string{"logs-*", "metrics-?-*"}, cfg.AllowedIndices)
+
+	c = conf.MustNewConfigFrom(map[string]any{"allowed_indices": []string{"logs-[*"}})
+	_, err = readConfig(c)
+	assert.Error(t, err, "a malformed allowed_indices pattern should be rejected")
+}
+
+func TestCompressionExemptIndicesConfig(t *testing.T) {
+	c := conf.MustNewConfigFrom(map[string]any{"compression_exempt_indices": []string{"blobs-*"}})
+	cfg, err := readConfig(c)
+	require.NoError(t, err, "valid compression_exempt_indices patterns should be accepted")
+	assert.Equal(t, []string{"blobs-*"}, cfg.CompressionExempt)
+
+	c = conf.MustNewConfigFrom(map[string]any{"compression_exempt_indices": []string{"blobs-[*"}})
+	_, err = readConfig(c)
+	assert.Error(t, err, "a malformed compression_exempt_indices pattern should be rejected")
+}
+
+func TestItemStatusActionsConfig(t *testing.T) {
+	c := conf.MustNewConfigFrom(map[string]any{"item_status_actions": map[string]any{
+		"retry": []int{403},
+		"drop":  []int{503, 504},
+	}})
+	cfg, err := readConfig(c)
+	require.NoError(t, err, "valid item_status_actions should be accepted")
+	assert.Equal(t, map[int]string{403: statusActionRetry, 503: statusActionDrop, 504: statusActionDrop}, cfg.statusActions())
+
+	for name, actions := range map[string]map[string]any{
+		"success status":     {"drop": []int{200}},
+		"out of range code":  {"retry": []int{600}},
+		"conflicting action": {"retry": []int{403}, "dead_letter": []int{403}},
+	} {
+		c = conf.MustNewConfigFrom(map[string]any{"item_status_actions": actions})
+		_, err = readConfig(c)
+		assert.Error(t, err, "%s should be rejected", name)
+	}
+
+	cfg, err = readConfig(conf.NewConfig())
+	require.NoError(t, err)
+	assert.Nil(t, cfg.statusActions(), "no status action should be configured by default")
+}
+
+func TestEmptyIndexConfig(t *testing.T) {
+	tests := map[string]struct {
+		cfg     map[string]any
+		wantErr bool
+	}{
+		"unset":                      {cfg: map[string]any{}},
+		"drop":                       {cfg: map[string]any{"empty_index.policy": "drop"}},
+		"dead letter":                {cfg: map[string]any{"empty_index.policy": "dead_letter"}},
+		"default index":              {cfg: map[string]any{"empty_index.policy": "default_index", "empty_index.index": "logs-unrouted"}},
+		"default index without name": {cfg: map[string]any{"empty_index.policy": "default_index"}, wantErr: true},
+		"unknown policy":             {cfg: map[string]any{"empty_index.policy": "ignore"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(conf.MustNewConfigFrom(tc.cfg))
+			if tc.wantErr {
+				assert.Error(t, err, "the empty_index configuration should be rejected")
+			} else {
+				assert.NoError(t, err, "the empty_index configuration should be accepted")
+			}
+		})
+	}
+}
+
+func TestPartialResponseConfig(t *testing.T) {
+	tests := map[string]struct {
+		cfg     map[string]any
+		wantErr bool
+	}{
+		"unset":          {cfg: map[string]any{}},
+		"retry":          {cfg: map[string]any{"partial_response": "retry"}},
+		"dead letter":    {cfg: map[string]any{"partial_response": "dead_letter"}},
+		"unknown policy": {cfg: map[string]any{"partial_response": "drop"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(conf.MustNewConfigFrom(tc.cfg))
+			if tc.wantErr {
+				assert.Error(t, err, "the partial_response configuration should be rejected")
+			} else {
+				assert.NoError(t, err, "the partial_response configuration should be accepted")
+			}
+		})
+	}
+}
+
+func TestVersionConflictActionConfig(t *testing.T) {
+	tests := map[string]struct {
+		cfg     map[string]any
+		wantErr bool
+	}{
+		"unset":          {cfg: map[string]any{}},
+		"duplicate":      {cfg: map[string]any{"version_conflict_action": "duplicate"}},
+		"fail":           {cfg: map[string]any{"version_conflict_action": "fail"}},
+		"unknown action": {cfg: map[string]any{"version_conflict_action": "retry"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(conf.MustNewConfigFrom(tc.cfg))
+			if tc.wantErr {
+				assert.Error(t, err, "the version_conflict_action configuration should be rejected")
+			} else {
+				assert.NoError(t, err, "the version_conflict_action configuration should be accepted")
+			}
+		})
+	}
+}
+
+func TestClockSkewConfig(t *testing.T) {
+	tests := map[string]struct {
+		cfg     map[string]any
+		wantErr bool
+	}{
+		"unset":          {cfg: map[string]any{}},
+		"warn":           {cfg: map[string]any{"clock_skew.max_skew": "30s", "clock_skew.action": "warn"}},
+		"error":          {cfg: map[string]any{"clock_skew.max_skew": "30s", "clock_skew.action": "error"}},
+		"unknown action": {cfg: map[string]any{"clock_skew.action": "ignore"}, wantErr: true},
+		"negative skew":  {cfg: map[string]any{"clock_skew.max_skew": "-1s"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(conf.MustNewConfigFrom(tc.cfg))
+			if tc.wantErr {
+				assert.Error(t, err, "the clock_skew configuration should be rejected")
+			} else {
+				assert.NoError(t, err, "the clock_skew configuration should be accepted")
+			}
+		})
+	}
+}
+
+func TestCompressionModeConfig(t *testing.T) {
+	tests := map[string]struct {
+		cfg     map[string]any
+		wantErr bool
+	}{
+		"unset":    {cfg: map[string]any{}},
+		"fixed":    {cfg: map[string]any{"compression_mode": "fixed"}},
+		"adaptive": {cfg: map[string]any{"compression_mode": "adaptive", "compression_tuning.min_level": 1, "compression_tuning.max_level": 5}},
+		"adaptive without compression": {
+			cfg:     map[string]any{"compression_mode": "adaptive", "compression_level": 0},
+			wantErr: true,
+		},
+		"inverted levels": {
+			cfg:     map[string]any{"compression_mode": "adaptive", "compression_tuning.min_level": 6, "compression_tuning.max_level": 3},
+			wantErr: true,
+		},
+		"level out of range": {
+			cfg:     map[string]any{"compression_mode": "adaptive", "compression_tuning.max_level": 10},
+			wantErr: true,
+		},
+		"unknown mode": {cfg: map[string]any{"compression_mode": "auto"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(conf.MustNewConfigFrom(tc.cfg))
+			if tc.wantErr {
+				assert.Error(t, err, "the compression configuration should be rejected")
+			} else {
+				assert.NoError(t, err, "the compression configuration should be accepted")
+			}
+		})
+	}
+}
+
 func readConfig(cfg *conf.C) (*ElasticsearchConfig, error) {
 	c := defaultConfig
 	if err := cfg.Unpack(&c); err != nil {
@@ -30,27 +30,115 @@ const (
 	dead_letter_index = "dead_letter_index"
 )
 
-func deadLetterIndexForConfig(config *config.C) (string, error) {
-	var indexConfig struct {
-		Index string
+// deadLetterConfig is the configuration of the dead_letter_index policy.
+type deadLetterConfig struct {
+	Index string `config:"index"`
+
+	// IsDataStream indicates that Index is a data stream, which only
+	// accepts create actions.
+	IsDataStream bool `config:"is_data_stream"`
+
+	// ErrorTypeField and ErrorMessageField are the fields of dead letter
+	// documents holding the status and the message of the error.
+	ErrorTypeField    string `config:"error_type_field"`
+	ErrorMessageField string `config:"error_message_field"`
+
+	// OriginalIndexField and DocumentIDField are the fields of dead letter
+	// documents holding the index and the ID of the original event.
+	OriginalIndexField string `config:"original_index_field"`
+	DocumentIDField    string `config:"document_id_field"`
+
+	// ProcessorTypeField and PipelineField are the fields of dead letter
+	// documents holding the processor type and the ingest pipeline that
+	// failed.
+	ProcessorTypeField string `config:"processor_type_field"`
+	PipelineField      string `config:"pipeline_field"`
+}
+
+// fields returns the dead letter document fields configured by c.
+func (c deadLetterConfig) fields() deadLetterFields {
+	return deadLetterFields{
+		errorType:     c.ErrorTypeField,
+		errorMessage:  c.ErrorMessageField,
+		originalIndex: c.OriginalIndexField,
+		documentID:    c.DocumentIDField,
+		processorType: c.ProcessorTypeField,
+		pipeline:      c.PipelineField,
+	}
+}
+
+// deadLetterFields holds the names of the fields of dead letter documents
+// that describe the error. Fields that are empty get their default name.
+type deadLetterFields struct {
+	errorType     string
+	errorMessage  string
+	originalIndex string
+	documentID    string
+	processorType string
+	pipeline      string
+}
+
+func (f deadLetterFields) errorTypeField() string {
+	if f.errorType == "" {
+		return "error.type"
+	}
+	return f.errorType
+}
+
+func (f deadLetterFields) errorMessageField() string {
+	if f.errorMessage == "" {
+		return "error.message"
 	}
+	return f.errorMessage
+}
+
+func (f deadLetterFields) originalIndexField() string {
+	if f.originalIndex == "" {
+		return "error.original_index"
+	}
+	return f.originalIndex
+}
+
+func (f deadLetterFields) documentIDField() string {
+	if f.documentID == "" {
+		return "error.document_id"
+	}
+	return f.documentID
+}
+
+func (f deadLetterFields) processorTypeField() string {
+	if f.processorType == "" {
+		return "error.processor_type"
+	}
+	return f.processorType
+}
+
+func (f deadLetterFields) pipelineField() string {
+	if f.pipeline == "" {
+		return "error.pipeline"
+	}
+	return f.pipeline
+}
+
+func deadLetterIndexForConfig(config *config.C) (deadLetterConfig, error) {
+	var indexConfig deadLetterConfig
 	err := config.Unpack(&indexConfig)
 	if err != nil {
-		return "", err
+		return deadLetterConfig{}, err
 	}
 	if indexConfig.Index == "" {
-		return "", fmt.Errorf("%s policy requires an `index` to be specified", dead_letter_index)
+		return deadLetterConfig{}, fmt.Errorf("%s policy requires an `index` to be specified", dead_letter_index)
 	}
-	return indexConfig.Index, nil
+	return indexConfig, nil
 }
 
-func deadLetterIndexForPolicy(configNamespace *config.Namespace, logger *logp.Logger) (string, error) {
+func deadLetterIndexForPolicy(configNamespace *config.Namespace, logger *logp.Logger) (deadLetterConfig, error) {
 	if configNamespace == nil || configNamespace.Name() == drop {
-		return "", nil
+		return deadLetterConfig{}, nil
 	}
 	if configNamespace.Name() == dead_letter_index {
 		logger.Warn(cfgwarn.Beta("The non_indexable_policy dead_letter_index is beta."))
 		return deadLetterIndexForConfig(configNamespace.Config())
 	}
-	return "", fmt.Errorf("no such policy type: %s", configNamespace.Name())
+	return deadLetterConfig{}, fmt.Errorf("no such policy type: %s", configNamespace.Name())
 }
@@ -0,0 +1,109 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat/events"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// DropSummary configures indexing a summary document for each batch the
+// output drops, so that drops can be audited in Elasticsearch.
+type DropSummary struct {
+	Enabled bool `config:"enabled"`
+
+	// Index is the index or data stream the summaries are written to.
+	Index string `config:"index"`
+}
+
+// Validate checks that an index is set if summaries are enabled.
+func (s DropSummary) Validate() error {
+	if s.Enabled && s.Index == "" {
+		return fmt.Errorf("drop_summary.index is required when drop_summary is enabled")
+	}
+	return nil
+}
+
+// publishDropSummary indexes a document summarizing the dropped events in
+// the drop summary index, if enabled. The summary holds the indices the
+// events targeted, their number, the reason they were dropped and the
+// error that caused it. It is sent through the regular bulk publishing
+// path, and failing to index it is only logged.
+func (client *Client) publishDropSummary(ctx context.Context, dropped []publisher.Event, reason string, cause error) {
+	if !client.dropSummary.Enabled || len(dropped) == 0 {
+		return
+	}
+
+	var indices []string
+	for _, event := range dropped {
+		index := event.EncodedEvent.(*encodedEvent).index //nolint:errcheck //safe to ignore type check
+		if !slices.Contains(indices, index) {
+			indices = append(indices, index)
+		}
+	}
+	slices.Sort(indices)
+
+	now := time.Now().UTC()
+	fields := mapstr.M{
+		"@timestamp":       now,
+		"message":          fmt.Sprintf("dropped %d events: %s", len(dropped), reason),
+		"event.kind":       "event",
+		"event.action":     "batch-dropped",
+		"drop.reason":      reason,
+		"drop.event_count": len(dropped),
+		"drop.indices":     indices,
+	}
+	if cause != nil {
+		fields["drop.error"] = cause.Error()
+	}
+	summary := publisher.Event{EncodedEvent: &encodedEvent{
+		timestamp: now,
+		// Create works for both indices and data streams.
+		opType:   events.OpTypeCreate,
+		index:    client.dropSummary.Index,
+		encoding: []byte(fields.String()),
+		// The summary isn't an event, so it isn't copied to the audit
+		// index.
+		audited: true,
+	}}
+
+	result := client.sendBulkRequest(ctx, []publisher.Event{summary})
+	if result.connErr != nil {
+		client.log.Errorf("Failed to index the summary of %d dropped events in %q: %v", len(dropped), client.dropSummary.Index, result.connErr)
+		return
+	}
+	reader := newJSONReader(result.response)
+	if err := bulkReadToItems(reader); err != nil {
+		client.log.Errorf("Failed to read the response to the summary of %d dropped events: %v", len(dropped), err)
+		return
+	}
+	status, msg, _, _, err := bulkReadItemStatus(client.log, reader)
+	if err != nil {
+		client.log.Errorf("Failed to read the response to the summary of %d dropped events: %v", len(dropped), err)
+		return
+	}
+	if status >= 300 {
+		client.log.Errorf("Failed to index the summary of %d dropped events in %q (status=%v): %s", len(dropped), client.dropSummary.Index, status, msg)
+	}
+}
@@ -18,6 +18,8 @@
 package elasticsearch
 
 import (
+	"fmt"
+
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/common"
 	"github.com/elastic/beats/v7/libbeat/esleg/eslegclient"
@@ -67,11 +69,37 @@ func makeES(
 		return outputs.Fail(err)
 	}
 
-	deadLetterIndex, err := deadLetterIndexForPolicy(esConfig.NonIndexablePolicy, log)
+	deadLetter, err := deadLetterIndexForPolicy(esConfig.NonIndexablePolicy, log)
 	if err != nil {
 		log.Errorf("error in non_indexable_policy: %v", err)
 		return outputs.Fail(err)
 	}
+	deadLetterIndex := deadLetter.Index
+
+	if esConfig.EmptyIndex.Policy == emptyIndexDeadLetter && deadLetterIndex == "" {
+		err := fmt.Errorf("empty_index.policy %s requires a dead letter index in non_indexable_policy", emptyIndexDeadLetter)
+		log.Error(err)
+		return outputs.Fail(err)
+	}
+
+	if esConfig.PartialResponse == partialResponseDeadLetter && deadLetterIndex == "" {
+		err := fmt.Errorf("partial_response %s requires a dead letter index in non_indexable_policy", partialResponseDeadLetter)
+		log.Error(err)
+		return outputs.Fail(err)
+	}
+
+	if esConfig.DryRun {
+		log.Warn("The Elasticsearch output runs in dry run mode: events are encoded and acknowledged, but not sent to Elasticsearch.")
+	}
+
+	statusActions := esConfig.statusActions()
+	for _, action := range statusActions {
+		if action == statusActionDeadLetter && deadLetterIndex == "" {
+			err := fmt.Errorf("item_status_actions %s requires a dead letter index in non_indexable_policy", statusActionDeadLetter)
+			log.Error(err)
+			return outputs.Fail(err)
+		}
+	}
 
 	hosts, err := outputs.ReadHostList(cfg)
 	if err != nil {
@@ -88,8 +116,41 @@ func makeES(
 		params = nil
 	}
 
+	// The observer reports the metrics specific to this output only if it
+	// implements Observer.
+	esObserver := asObserver(observer)
+
 	encoderFactory := newEventEncoderFactory(
-		esConfig.EscapeHTML, indexSelector, pipelineSelector)
+		esConfig.EscapeHTML, indexSelector, pipelineSelector,
+		encodingSettings{
+			dottedKeys:       esConfig.DottedKeys,
+			observer:         esObserver,
+			allowedIndices:   esConfig.AllowedIndices,
+			deadLetterIndex:  deadLetterIndex,
+			deadLetterDS:     deadLetter.IsDataStream,
+			deadLetterFields: deadLetter.fields(),
+			emptyIndex:       esConfig.EmptyIndex,
+			eventLimits:      esConfig.EventLimits,
+			joinArrays:       esConfig.JoinArrays,
+			truncateFields:   esConfig.TruncateFields,
+			missingTimestamp: esConfig.MissingTimestamp,
+			indexTransform:   registeredIndexTransform(),
+			indexField:       esConfig.IndexField,
+			logger:           log,
+		})
+
+	// The limit on bulk requests in flight applies to the output as a
+	// whole, so all clients share the same limiter.
+	limiter := newBulkLimiter(esConfig.MaxConcurrentBulk, esObserver)
+	deadLetterLimiter := newBulkLimiter(esConfig.MaxDeadLetterBulk, nil)
+
+	// The circuit breaker tracks the saturation of the cluster, so all
+	// clients share it as well.
+	breaker := newCircuitBreaker(esConfig.CircuitBreaker, esObserver, log)
+
+	// The retry budget of a pipeline applies to the output, not to each
+	// of its clients.
+	budget := newRetryBudget(esConfig.RetryBudget)
 
 	clients := make([]outputs.NetworkClient, len(hosts))
 	for i, host := range hosts {
@@ -116,11 +177,55 @@ func makeES(
 				Transport:        esConfig.Transport,
 				IdleConnTimeout:  esConfig.Transport.IdleConnTimeout,
 				UserAgent:        beatInfo.UserAgent,
+				CompressionTuning: eslegclient.CompressionTuningSettings{
+					Enabled:  esConfig.CompressionMode == compressionModeAdaptive,
+					MinLevel: esConfig.CompressionTuning.MinLevel,
+					MaxLevel: esConfig.CompressionTuning.MaxLevel,
+					Interval: esConfig.CompressionTuning.Interval,
+				},
+				DNSRoundRobin: eslegclient.DNSRoundRobinSettings{
+					Enabled:         esConfig.DNSRoundRobin.Enabled,
+					RefreshInterval: esConfig.DNSRoundRobin.RefreshInterval,
+				},
+				ExistsCacheTTL: esConfig.ExistsCacheTTL,
 			},
 			indexSelector:    indexSelector,
 			pipelineSelector: pipelineSelector,
 			observer:         observer,
 			deadLetterIndex:  deadLetterIndex,
+			deadLetterDS:     deadLetter.IsDataStream,
+			deadLetterFields: deadLetter.fields(),
+			maxBulkBytes:     int(esConfig.MaxBulkBytes),
+			maxEventRetries:  esConfig.MaxEventRetries,
+			itemRetryRounds:  esConfig.ItemRetryRounds,
+			itemRetryBackoff: esConfig.Backoff,
+
+			maxEmptyResponseRetries: esConfig.MaxEmptyRetries,
+			fastAck:                 esConfig.FastAck,
+			retryJitter:             esConfig.Backoff.Jitter,
+			maxEventAge:             esConfig.MaxEventAge,
+			retryBudget:             budget,
+			partialResponse:         esConfig.PartialResponse,
+			filterPath:              esConfig.BulkFilterPath,
+			versionConflictAction:   esConfig.VersionConflictAction,
+			perIndexMetrics:         esConfig.PerIndexMetrics,
+			requireAlias:            esConfig.RequireAlias,
+
+			compressionExempt:    esConfig.CompressionExempt,
+			compressionMinEvents: esConfig.CompressionMinEvents,
+			compressionMinBytes:  int(esConfig.CompressionMinBytes),
+			statusActions:        statusActions,
+			dryRun:               esConfig.DryRun,
+			errorLogDedupWindow:  esConfig.ErrorLogDedup.Window,
+			breaker:              breaker,
+			clockSkew:            esConfig.ClockSkew,
+			bulkLimiter:          limiter,
+			deadLetterLimiter:    deadLetterLimiter,
+			dropSummary:          esConfig.DropSummary,
+			auditIndex:           esConfig.AuditIndex,
+			parallelEncoding:     esConfig.ParallelEncoding,
+			sameIDEvents:         esConfig.SameIDEvents,
+			onDrop:               registeredDropCallback(),
 		}, &connectCallbackRegistry, log)
 		if err != nil {
 			return outputs.Fail(err)
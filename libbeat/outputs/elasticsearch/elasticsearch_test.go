@@ -18,16 +18,26 @@
 package elasticsearch
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/esleg/eslegclient"
+	"github.com/elastic/beats/v7/libbeat/idxmgmt"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/logp/logptest"
 	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 func TestConnectCallbacksManagement(t *testing.T) {
@@ -103,6 +113,21 @@ func TestPipelineSelection(t *testing.T) {
 			event: beat.Event{Meta: mapstr.M{"pipeline": "Test"}},
 			want:  "test",
 		},
+		"pipeline via event meta overrides configured pipeline": {
+			cfg:   map[string]any{"pipeline": "test"},
+			event: beat.Event{Meta: mapstr.M{"pipeline": "override"}},
+			want:  "override",
+		},
+		"configured pipeline if event meta has no pipeline": {
+			cfg:   map[string]any{"pipeline": "test"},
+			event: beat.Event{Meta: mapstr.M{"_id": "abc"}},
+			want:  "test",
+		},
+		"configured pipeline if event meta pipeline is empty": {
+			cfg:   map[string]any{"pipeline": "test"},
+			event: beat.Event{Meta: mapstr.M{"pipeline": ""}},
+			want:  "test",
+		},
 		"pipelines setting": {
 			cfg: map[string]any{
 				"pipelines": []map[string]any{{"pipeline": "test"}},
@@ -139,3 +164,127 @@ func TestPipelineSelection(t *testing.T) {
 		})
 	}
 }
+
+func TestExistsCacheTTL(t *testing.T) {
+	info := beat.Info{Beat: "libbeat", Logger: logptest.NewTestingLogger(t, "")}
+	im, err := idxmgmt.DefaultSupport(info, config.MustNewConfigFrom(map[string]any{"setup.ilm.enabled": false}))
+	require.NoError(t, err)
+
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{ "version": { "number": "8.17.0" } }`)
+	}))
+	t.Cleanup(esMock.Close)
+
+	var ttl time.Duration
+	key, err := RegisterConnectCallback(func(conn *eslegclient.Connection, _ *logp.Logger) error {
+		ttl = conn.ExistsCacheTTL
+		return nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { DeregisterConnectCallback(key) })
+
+	group, err := makeES(im, info, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+		"hosts":            []string{esMock.URL},
+		"exists_cache_ttl": "5m",
+	}))
+	require.NoError(t, err)
+	client, ok := group.Clients[0].(outputs.NetworkClient)
+	require.True(t, ok, "the output should create network clients")
+	require.NoError(t, client.Connect(context.Background()))
+	require.Equal(t, 5*time.Minute, ttl, "the output should provide its exists_cache_ttl to connect callbacks")
+
+	_, err = makeES(im, info, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+		"hosts":            []string{"localhost:9200"},
+		"exists_cache_ttl": "-1m",
+	}))
+	require.Error(t, err, "a negative exists_cache_ttl should be rejected")
+}
+
+func TestRegisterIndexTransform(t *testing.T) {
+	info := beat.Info{Beat: "libbeat", Logger: logptest.NewTestingLogger(t, "")}
+	im, err := idxmgmt.DefaultSupport(info, config.MustNewConfigFrom(map[string]any{"setup.ilm.enabled": false}))
+	require.NoError(t, err)
+
+	RegisterIndexTransform(func(_ *beat.Event, index string) string {
+		return index + "-shard0"
+	})
+	t.Cleanup(func() { RegisterIndexTransform(nil) })
+
+	group, err := makeES(im, info, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+		"hosts": []string{"localhost:9200"},
+		"index": "logs",
+	}))
+	require.NoError(t, err)
+
+	encoded, _ := group.EncoderFactory().EncodeEntry(publisher.Event{Content: beat.Event{
+		Timestamp: time.Now(),
+		Fields:    mapstr.M{"message": "hello"},
+	}})
+	enc, ok := encoded.EncodedEvent.(*encodedEvent)
+	require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+	require.NoError(t, enc.err)
+	assert.Equal(t, "logs-shard0", enc.index, "the output should apply the registered index transform")
+}
+
+func TestRegisterDropCallback(t *testing.T) {
+	info := beat.Info{Beat: "libbeat", Logger: logptest.NewTestingLogger(t, "")}
+	im, err := idxmgmt.DefaultSupport(info, config.MustNewConfigFrom(map[string]any{"setup.ilm.enabled": false}))
+	require.NoError(t, err)
+
+	esMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprintln(w, `{ "version": { "number": "8.17.0" } }`)
+			return
+		}
+		fmt.Fprintln(w, `{"items": [{"create": {"status": 400, "error": {"type": "mapper_parsing_exception"}}}]}`)
+	}))
+	t.Cleanup(esMock.Close)
+
+	var reasons []string
+	RegisterDropCallback(func(_ publisher.Event, reason string) {
+		reasons = append(reasons, reason)
+	})
+	t.Cleanup(func() { RegisterDropCallback(nil) })
+
+	group, err := makeES(im, info, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+		"hosts": []string{esMock.URL},
+		"index": "logs",
+	}))
+	require.NoError(t, err)
+	client, ok := group.Clients[0].(outputs.NetworkClient)
+	require.True(t, ok, "the output should create network clients")
+	require.NoError(t, client.Connect(context.Background()))
+
+	event, _ := group.EncoderFactory().EncodeEntry(publisher.Event{Content: beat.Event{
+		Timestamp: time.Now(),
+		Fields:    mapstr.M{"message": "hello"},
+	}})
+	batch := &batchMock{events: []publisher.Event{event}}
+	require.NoError(t, client.Publish(context.Background(), batch))
+	assert.Equal(t, []string{dropReasonNonIndexable}, reasons, "the output should call the registered drop callback")
+}
+
+func TestAsObserver(t *testing.T) {
+	stats := outputs.NewStats(monitoring.NewRegistry(), logptest.NewTestingLogger(t, ""))
+	assert.Same(t, stats, asObserver(stats), "an observer reporting the output metrics is used as is")
+
+	// An observer that only reports the metrics common to all outputs
+	// still receives them, and the output specific metrics are ignored.
+	observer := &countingObserver{Observer: outputs.NewNilObserver()}
+	esObserver := asObserver(observer)
+	esObserver.NewBatch(3)
+	esObserver.BulkInFlight(1)
+	esObserver.IndexEvents("logs", 3, 0, 0, 0)
+	assert.Equal(t, 3, observer.batched)
+
+	assert.NotNil(t, asObserver(nil))
+}
+
+type countingObserver struct {
+	outputs.Observer
+	batched int
+}
+
+func (o *countingObserver) NewBatch(n int) {
+	o.batched += n
+}
@@ -0,0 +1,170 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// errorLogDeduper limits the logging of ingestion errors that are reported
+// for many events at once, for example when a mapping problem affects all
+// indices. Only the first occurrence of an error is logged within the
+// deduplication window; further occurrences are counted and reported in a
+// single summary when the window ends.
+type errorLogDeduper struct {
+	window time.Duration
+	log    *logp.Logger
+	now    func() time.Time
+
+	mu    sync.Mutex
+	seen  map[errorLogKey]*errorLogOccurrences
+	timer *time.Timer // Flushes the errors whose window ended.
+}
+
+// errorLogKey identifies an ingestion error regardless of the index it was
+// reported for.
+type errorLogKey struct {
+	errType string
+	reason  string
+}
+
+type errorLogOccurrences struct {
+	start   time.Time
+	count   int
+	indices map[string]struct{}
+}
+
+// newErrorLogDeduper returns a deduper for the given window, or nil if the
+// window is not positive. A nil deduper logs every error.
+func newErrorLogDeduper(window time.Duration, log *logp.Logger) *errorLogDeduper {
+	if window <= 0 {
+		return nil
+	}
+	return &errorLogDeduper{
+		window: window,
+		log:    log,
+		now:    time.Now,
+		seen:   make(map[errorLogKey]*errorLogOccurrences),
+	}
+}
+
+// allow records an occurrence of the error in itemMessage for index and
+// returns whether it should be logged.
+func (d *errorLogDeduper) allow(index string, itemMessage []byte) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	d.flush(now)
+
+	key := errorLogKeyFor(itemMessage)
+	if occurrences, ok := d.seen[key]; ok {
+		occurrences.count++
+		occurrences.indices[index] = struct{}{}
+		return false
+	}
+	d.seen[key] = &errorLogOccurrences{
+		start:   now,
+		indices: map[string]struct{}{index: {}},
+	}
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.window, d.flushExpired)
+	}
+	return true
+}
+
+// flushExpired reports the errors whose window ended, and schedules the
+// next flush if errors are still being deduplicated.
+func (d *errorLogDeduper) flushExpired() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timer = nil
+	now := d.now()
+	d.flush(now)
+	if len(d.seen) == 0 {
+		return
+	}
+	next := d.window
+	for _, occurrences := range d.seen {
+		next = min(next, occurrences.start.Add(d.window).Sub(now))
+	}
+	d.timer = time.AfterFunc(max(next, 0), d.flushExpired)
+}
+
+// flush reports and forgets the errors whose window ended before now.
+// d.mu must be held.
+func (d *errorLogDeduper) flush(now time.Time) {
+	for key, occurrences := range d.seen {
+		if now.Sub(occurrences.start) < d.window {
+			continue
+		}
+		d.report(key, occurrences, now)
+		delete(d.seen, key)
+	}
+}
+
+// close reports all the errors that are still being deduplicated.
+func (d *errorLogDeduper) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	now := d.now()
+	for key, occurrences := range d.seen {
+		d.report(key, occurrences, now)
+		delete(d.seen, key)
+	}
+}
+
+func (d *errorLogDeduper) report(key errorLogKey, occurrences *errorLogOccurrences, now time.Time) {
+	if occurrences.count == 0 {
+		return
+	}
+	indices := make([]string, 0, len(occurrences.indices))
+	for index := range occurrences.indices {
+		indices = append(indices, index)
+	}
+	slices.Sort(indices)
+	d.log.Warnf("Suppressed %d more occurrences of error (type=%s): %s in the last %v, affected indices: %v",
+		occurrences.count, key.errType, key.reason, now.Sub(occurrences.start).Round(time.Millisecond), indices)
+}
+
+// errorLogKeyFor returns the key for a bulk item error message. Messages that
+// aren't Elasticsearch error objects are keyed by their full content.
+func errorLogKeyFor(itemMessage []byte) errorLogKey {
+	var itemError struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(itemMessage, &itemError); err != nil || itemError.Type == "" {
+		return errorLogKey{reason: string(itemMessage)}
+	}
+	return errorLogKey{errType: itemError.Type, reason: itemError.Reason}
+}
@@ -19,16 +19,27 @@ package elasticsearch
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/beat/events"
+	"github.com/elastic/beats/v7/libbeat/common/jsontransform"
 	"github.com/elastic/beats/v7/libbeat/esleg/eslegclient"
 	"github.com/elastic/beats/v7/libbeat/outputs"
 	"github.com/elastic/beats/v7/libbeat/outputs/outil"
 	"github.com/elastic/beats/v7/libbeat/publisher"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue"
+	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 )
 
@@ -37,28 +48,155 @@ type eventEncoder struct {
 	enc              eslegclient.BodyEncoder
 	pipelineSelector *outil.Selector
 	indexSelector    outputs.IndexSelector
+	settings         encodingSettings
 }
 
+// encodingSettings holds the optional transformations applied to events
+// while they are encoded.
+type encodingSettings struct {
+	// dottedKeys determines how keys containing dots are encoded.
+	dottedKeys string
+
+	// observer, if set, is notified of the size of each encoded document.
+	observer Observer
+
+	// allowedIndices, if not empty, holds the index patterns that events
+	// may be written to. Events targeting any other index are sent to
+	// deadLetterIndex if it is set, and are dropped otherwise.
+	allowedIndices  []string
+	deadLetterIndex string
+
+	// deadLetterDS indicates that deadLetterIndex is a data stream, so
+	// events sent to it must use the create op_type.
+	deadLetterDS bool
+
+	// deadLetterFields holds the names of the error fields of documents
+	// sent to deadLetterIndex.
+	deadLetterFields deadLetterFields
+
+	// emptyIndex determines how events for which the index selection
+	// yields an empty index name are handled.
+	emptyIndex EmptyIndex
+
+	// eventLimits bounds the complexity of encoded events. Events
+	// exceeding it are sent to deadLetterIndex if it is set, and are
+	// dropped otherwise.
+	eventLimits EventLimits
+
+	// joinArrays determines which array fields are encoded as
+	// delimiter-joined strings.
+	joinArrays JoinArrays
+
+	// truncateFields determines which string values are truncated.
+	truncateFields TruncateFields
+
+	// missingTimestamp determines how events with a zero timestamp are
+	// handled.
+	missingTimestamp string
+
+	// indexTransform, if set, is applied to the index selected for each
+	// event, before the index is validated.
+	indexTransform IndexTransform
+
+	// indexField, if set, is the field events are given the name of the
+	// index they are written to, as resolved by the index selection and
+	// indexTransform.
+	indexField string
+
+	// logger is used to report transformation failures that do not
+	// prevent the event from being encoded.
+	logger *logp.Logger
+}
+
+// IndexTransform returns the index an event is written to, given the event
+// and the index resolved for it by the index selection. It is called when
+// events are encoded, so it must be safe for concurrent use. Events sent to
+// the dead letter index are not transformed.
+type IndexTransform func(event *beat.Event, index string) string
+
+const (
+	dottedKeysNone    = "none"
+	dottedKeysFlatten = "flatten"
+	dottedKeysExpand  = "expand"
+)
+
+const (
+	missingTimestampKeep       = "keep"
+	missingTimestampIngestTime = "ingest_time"
+	missingTimestampDrop       = "drop"
+)
+
+const (
+	emptyIndexDefault    = "default_index"
+	emptyIndexDrop       = "drop"
+	emptyIndexDeadLetter = "dead_letter"
+)
+
 type encodedEvent struct {
 	// If err is set, the event couldn't be encoded, and other fields should
 	// not be relied on.
 	err error
 
+	// dropReason is the reason the event is dropped if err is set, or empty
+	// if it couldn't be encoded.
+	dropReason string
+
 	// If deadLetter is true, this event produced an ingestion error on a
 	// previous attempt, and is now being retried as a bare event with all
 	// contents included as a raw string in the "message" field.
 	deadLetter bool
 
-	// timestamp is the timestamp from the source beat.Event. It's only used
+	// retries is the number of times the event has been returned for retry
+	// after failing to be ingested.
+	retries int
+
+	// emptyResponses is the number of bulk requests holding the event that
+	// got an empty response body.
+	emptyResponses int
+
+	// timestamp is the timestamp from the source beat.Event. It's used
 	// when reencoding for the dead letter index, so it isn't strictly needed
 	// but it avoids deserializing the encoded event to recover one field if
-	// there's an ingestion error.
+	// there's an ingestion error, and to drop events older than the client's
+	// maximum event age.
 	timestamp time.Time
 
 	// The meta fields from the original event (which aren't included in the
 	// encoding but may still need to be logged if there is an error).
 	meta mapstr.M
 
+	// originalIndex is the index the event targeted before it was sent to
+	// the dead letter index.
+	originalIndex string
+
+	// ifSeqNo and ifPrimaryTerm are set from the event metadata for
+	// optimistic concurrency control.
+	ifSeqNo       *int64
+	ifPrimaryTerm *int64
+
+	// version and versionType are set from the event metadata for
+	// documents versioned by the sender.
+	version     *int64
+	versionType string
+
+	// requireAlias overrides the requireAlias setting of the client if set.
+	requireAlias *bool
+
+	// dynamicTemplates maps field paths of the event to the dynamic
+	// templates used to map them.
+	dynamicTemplates map[string]string
+
+	// If audit is set, the bulk request the event is being sent in also
+	// creates a copy of it in the audit index, in the item that follows
+	// the event's own. audited is set once the copy was handled, so that
+	// it isn't created again when the event is retried.
+	audit   bool
+	audited bool
+
+	// indexed is set once the event was written to its target index, or
+	// found to be there already.
+	indexed bool
+
 	id       string
 	opType   events.OpType
 	pipeline string
@@ -70,15 +208,17 @@ func newEventEncoderFactory(
 	escapeHTML bool,
 	indexSelector outputs.IndexSelector,
 	pipelineSelector *outil.Selector,
+	settings encodingSettings,
 ) queue.EncoderFactory[publisher.Event] {
 	return func() queue.Encoder[publisher.Event] {
-		return newEventEncoder(escapeHTML, indexSelector, pipelineSelector)
+		return newEventEncoder(escapeHTML, indexSelector, pipelineSelector, settings)
 	}
 }
 
 func newEventEncoder(escapeHTML bool,
 	indexSelector outputs.IndexSelector,
 	pipelineSelector *outil.Selector,
+	settings encodingSettings,
 ) queue.Encoder[publisher.Event] {
 	buf := bytes.NewBuffer(nil)
 	enc := eslegclient.NewJSONEncoder(buf, escapeHTML)
@@ -87,6 +227,7 @@ func newEventEncoder(escapeHTML bool,
 		enc:              enc,
 		pipelineSelector: pipelineSelector,
 		indexSelector:    indexSelector,
+		settings:         settings,
 	}
 }
 
@@ -104,20 +245,112 @@ func (pe *eventEncoder) EncodeEntry(e publisher.Event) (publisher.Event, int) {
 // worse performance, so there's no reason to try optimizing around this
 // dependency.
 func (pe *eventEncoder) encodeRawEvent(e *beat.Event) *encodedEvent {
+	// The timestamp is set before the index is selected, as the index name
+	// may be formatted from it.
+	if e.Timestamp.IsZero() {
+		switch pe.settings.missingTimestamp {
+		case missingTimestampIngestTime:
+			e.Timestamp = time.Now().UTC()
+		case missingTimestampDrop:
+			return &encodedEvent{err: errors.New("event has no @timestamp, dropping event")}
+		}
+	}
 	opType := events.GetOpType(*e)
 	pipeline, err := getPipeline(e, pe.pipelineSelector)
 	if err != nil {
 		return &encodedEvent{err: fmt.Errorf("failed to select event pipeline: %w", err)}
 	}
-	var index string
+	var (
+		index string
+		// deadLetterStatus and deadLetterMsg are set if the event must be
+		// sent to the dead letter index.
+		deadLetterStatus int
+		deadLetterMsg    string
+	)
 	if pe.indexSelector != nil {
 		index, err = pe.indexSelector.Select(e)
 		if err != nil {
 			return &encodedEvent{err: fmt.Errorf("failed to select event index: %w", err)}
 		}
+		if pe.settings.indexTransform != nil {
+			index = pe.settings.indexTransform(e, index)
+		}
+		if index == "" {
+			if pe.settings.observer != nil {
+				pe.settings.observer.IndexEmpty(1)
+			}
+			switch pe.settings.emptyIndex.Policy {
+			case emptyIndexDefault:
+				index = pe.settings.emptyIndex.Index
+			case emptyIndexDrop:
+				return &encodedEvent{
+					err:        errors.New("no index selected for event, dropping event"),
+					dropReason: dropReasonEmptyIndex,
+				}
+			case emptyIndexDeadLetter:
+				deadLetterStatus = http.StatusBadRequest
+				deadLetterMsg = "no index selected for event"
+			}
+		}
+	}
+
+	if deadLetterMsg == "" && !pe.indexAllowed(index) {
+		if pe.settings.observer != nil {
+			pe.settings.observer.IndexNotAllowed(1)
+		}
+		if pe.settings.deadLetterIndex == "" {
+			return &encodedEvent{
+				err:        fmt.Errorf("event index %q is not in allowed_indices, dropping event", index),
+				dropReason: dropReasonIndexNotAllowed,
+			}
+		}
+		// Report the event as Elasticsearch would report a write to an
+		// index the output is not authorized for.
+		deadLetterStatus = http.StatusForbidden
+		deadLetterMsg = fmt.Sprintf("event index %q is not in allowed_indices", index)
+	}
+
+	if deadLetterMsg == "" {
+		if reason := pe.settings.eventLimits.exceededBy(e.Fields); reason != "" {
+			if pe.settings.observer != nil {
+				pe.settings.observer.EventTooComplex(1)
+			}
+			if pe.settings.deadLetterIndex == "" {
+				return &encodedEvent{
+					err:        fmt.Errorf("event %s, dropping event", reason),
+					dropReason: dropReasonTooComplex,
+				}
+			}
+			deadLetterStatus = http.StatusBadRequest
+			deadLetterMsg = "event " + reason
+		}
 	}
 
 	id, _ := events.GetMetaStringValue(*e, events.FieldMetaID)
+	ifSeqNo := getMetaInt64(e, events.FieldMetaIfSeqNo)
+	ifPrimaryTerm := getMetaInt64(e, events.FieldMetaIfPrimaryTerm)
+	docVersion := getMetaInt64(e, events.FieldMetaVersion)
+	versionType, _ := events.GetMetaStringValue(*e, events.FieldMetaVersionType)
+	dynamicTemplates := getDynamicTemplates(e)
+	var requireAlias *bool
+	if v, err := e.Meta.GetValue(events.FieldMetaRequireAlias); err == nil {
+		if b, ok := v.(bool); ok {
+			requireAlias = &b
+		}
+	}
+
+	if pe.settings.indexField != "" && deadLetterMsg == "" && index != "" {
+		// The fields may be shared with other holders of the event, so the
+		// index is set on a copy.
+		e.Fields = e.Fields.Clone()
+		if _, err := e.PutValue(pe.settings.indexField, index); err != nil {
+			pe.settings.log().Warnf("Failed to set the index of the event in field %q: %v", pe.settings.indexField, err)
+		}
+	}
+
+	pe.joinArrays(e)
+	pe.truncateFields(e)
+	pe.transformDottedKeys(e)
 
 	err = pe.enc.Marshal(e)
 	if err != nil {
@@ -126,27 +359,400 @@ func (pe *eventEncoder) encodeRawEvent(e *beat.Event) *encodedEvent {
 	bufBytes := pe.buf.Bytes()
 	bytes := make([]byte, len(bufBytes))
 	copy(bytes, bufBytes)
-	return &encodedEvent{
-		id:        id,
-		meta:      e.Meta,
-		timestamp: e.Timestamp,
-		opType:    opType,
-		pipeline:  pipeline,
-		index:     index,
-		encoding:  bytes,
+	if pe.settings.observer != nil {
+		pe.settings.observer.DocumentSize(len(bytes))
+	}
+	encoded := &encodedEvent{
+		id:               id,
+		ifSeqNo:          ifSeqNo,
+		ifPrimaryTerm:    ifPrimaryTerm,
+		version:          docVersion,
+		versionType:      versionType,
+		requireAlias:     requireAlias,
+		dynamicTemplates: dynamicTemplates,
+		meta:             e.Meta,
+		timestamp:        e.Timestamp,
+		opType:           opType,
+		pipeline:         pipeline,
+		index:            index,
+		encoding:         bytes,
+	}
+	if deadLetterMsg != "" {
+		pe.settings.log().Warnf("%s, sending event to dead letter index %q", deadLetterMsg, pe.settings.deadLetterIndex)
+		encoded.setDeadLetter(pe.settings.deadLetterIndex, pe.settings.deadLetterDS, pe.settings.deadLetterFields, deadLetterStatus, deadLetterMsg)
 	}
+	return encoded
+}
+
+// getMetaInt64 returns the integer value of the event metadata field key,
+// or nil if it is missing or not an integer.
+func getMetaInt64(e *beat.Event, key string) *int64 {
+	v, err := e.Meta.GetValue(key)
+	if err != nil {
+		return nil
+	}
+	var n int64
+	switch v := v.(type) {
+	case int:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint32:
+		n = int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil
+		}
+		n = int64(v)
+	case float64:
+		// Metadata decoded from JSON holds numbers as float64.
+		if v != math.Trunc(v) {
+			return nil
+		}
+		n = int64(v)
+	default:
+		return nil
+	}
+	return &n
+}
+
+// getDynamicTemplates returns the dynamic templates set in the event
+// metadata, or nil if there are none. Entries whose template name is not a
+// string are ignored.
+func getDynamicTemplates(e *beat.Event) map[string]string {
+	v, err := e.Meta.GetValue(events.FieldMetaDynamicTemplates)
+	if err != nil {
+		return nil
+	}
+	var templates map[string]string
+	add := func(field string, template any) {
+		if name, ok := template.(string); ok {
+			if templates == nil {
+				templates = map[string]string{}
+			}
+			templates[field] = name
+		}
+	}
+	switch v := v.(type) {
+	case map[string]string:
+		if len(v) > 0 {
+			templates = v
+		}
+	case mapstr.M:
+		for field, template := range v {
+			add(field, template)
+		}
+	case map[string]any:
+		for field, template := range v {
+			add(field, template)
+		}
+	}
+	return templates
+}
+
+// indexAllowed returns whether events may be written to index.
+func (pe *eventEncoder) indexAllowed(index string) bool {
+	if len(pe.settings.allowedIndices) == 0 {
+		return true
+	}
+	for _, pattern := range pe.settings.allowedIndices {
+		// Patterns are validated when the configuration is loaded.
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exceededBy returns a description of the limit exceeded by fields, or an
+// empty string if fields are within the limits. The walk stops as soon as a
+// limit is exceeded so that the check stays cheap for pathological events.
+func (l EventLimits) exceededBy(fields mapstr.M) string {
+	if l.MaxDepth <= 0 && l.MaxFields <= 0 {
+		return ""
+	}
+	w := limitWalker{limits: l}
+	w.walk(map[string]any(fields), 1)
+	return w.exceeded
+}
+
+type limitWalker struct {
+	limits   EventLimits
+	fields   int
+	exceeded string
+}
+
+// walk visits the fields of the container v at the given depth. It returns
+// false once a limit is exceeded.
+func (w *limitWalker) walk(v any, depth int) bool {
+	if w.limits.MaxDepth > 0 && depth > w.limits.MaxDepth {
+		w.exceeded = fmt.Sprintf("exceeds the maximum depth of %d", w.limits.MaxDepth)
+		return false
+	}
+	switch v := v.(type) {
+	case mapstr.M:
+		return w.walk(map[string]any(v), depth)
+	case map[string]any:
+		for _, f := range v {
+			if !w.visit(f, depth) {
+				return false
+			}
+		}
+	case []mapstr.M:
+		for _, f := range v {
+			if !w.visit(f, depth) {
+				return false
+			}
+		}
+	case []any:
+		for _, f := range v {
+			if !w.visit(f, depth) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// visit counts the field f of a container at depth, and walks f if it is
+// itself a container. It returns false once a limit is exceeded.
+func (w *limitWalker) visit(f any, depth int) bool {
+	w.fields++
+	if w.limits.MaxFields > 0 && w.fields > w.limits.MaxFields {
+		w.exceeded = fmt.Sprintf("exceeds the maximum of %d fields", w.limits.MaxFields)
+		return false
+	}
+	switch f.(type) {
+	case mapstr.M, map[string]any, []mapstr.M, []any:
+		return w.walk(f, depth+1)
+	}
+	return true
+}
+
+// transformDottedKeys rewrites the event fields according to the configured
+// dotted keys mode so that keys like "a.b.c" are encoded consistently,
+// either all as nested objects or all as dotted keys at the top level.
+// The original fields are not modified since they may be shared.
+func (pe *eventEncoder) transformDottedKeys(e *beat.Event) {
+	switch pe.settings.dottedKeys {
+	case dottedKeysFlatten:
+		e.Fields = e.Fields.Flatten()
+	case dottedKeysExpand:
+		e.Fields = e.Fields.Clone()
+		jsontransform.ExpandFields(pe.settings.log(), e, e.Fields, true)
+	}
+}
+
+// joinArrays replaces the configured array fields of e with strings holding
+// the array elements separated by the configured delimiter. Arrays that
+// contain objects or arrays are left unchanged.
+func (pe *eventEncoder) joinArrays(e *beat.Event) {
+	settings := pe.settings.joinArrays
+	if !settings.All && len(settings.Fields) == 0 {
+		return
+	}
+	e.Fields = e.Fields.Clone()
+	if settings.All {
+		joinAllArrays(e.Fields, settings.Delimiter)
+		return
+	}
+	for _, field := range settings.Fields {
+		value, err := e.Fields.GetValue(field)
+		if err != nil {
+			continue
+		}
+		if joined, ok := joinArray(value, settings.Delimiter); ok {
+			_, _ = e.Fields.Put(field, joined)
+		}
+	}
+}
+
+// truncateFields truncates the string values of e that are longer than the
+// configured maximum length, including values in nested objects and arrays,
+// and appends the configured marker to them.
+func (pe *eventEncoder) truncateFields(e *beat.Event) {
+	settings := pe.settings.truncateFields
+	if settings.MaxLength <= 0 {
+		return
+	}
+	if fields, ok := truncateMap(e.Fields, settings); ok {
+		e.Fields = fields
+	}
+}
+
+// truncateValue returns value with its long strings truncated, and whether
+// any string was truncated. Objects and arrays holding truncated strings
+// are copied, so that value itself is never modified.
+func truncateValue(value any, settings TruncateFields) (any, bool) {
+	switch value := value.(type) {
+	case string:
+		if len(value) <= settings.MaxLength {
+			return value, false
+		}
+		// Cut at a rune boundary so that the value stays valid UTF-8.
+		n := settings.MaxLength
+		for n > 0 && !utf8.RuneStart(value[n]) {
+			n--
+		}
+		return value[:n] + settings.Marker, true
+	case mapstr.M:
+		return truncateMap(value, settings)
+	case map[string]any:
+		return truncateMap(value, settings)
+	case []mapstr.M:
+		return truncateSlice(value, settings)
+	case []string:
+		return truncateSlice(value, settings)
+	case []any:
+		return truncateSlice(value, settings)
+	}
+	return value, false
+}
+
+func truncateMap[M ~map[string]any](m M, settings TruncateFields) (M, bool) {
+	var truncated M
+	for key, value := range m {
+		if t, ok := truncateValue(value, settings); ok {
+			if truncated == nil {
+				truncated = maps.Clone(m)
+			}
+			truncated[key] = t
+		}
+	}
+	return truncated, truncated != nil
+}
+
+func truncateSlice[S ~[]E, E any](s S, settings TruncateFields) (S, bool) {
+	var truncated S
+	for i, elem := range s {
+		if t, ok := truncateValue(elem, settings); ok {
+			if truncated == nil {
+				truncated = slices.Clone(s)
+			}
+			truncated[i] = t.(E) //nolint:errcheck //values are truncated to the same type
+		}
+	}
+	return truncated, truncated != nil
+}
+
+// joinAllArrays joins all the arrays in fields and its nested objects.
+func joinAllArrays(fields map[string]any, delimiter string) {
+	for key, value := range fields {
+		switch value := value.(type) {
+		case mapstr.M:
+			joinAllArrays(value, delimiter)
+		case map[string]any:
+			joinAllArrays(value, delimiter)
+		default:
+			if joined, ok := joinArray(value, delimiter); ok {
+				fields[key] = joined
+			}
+		}
+	}
+}
+
+// joinArray returns the elements of value separated by delimiter, if value
+// is an array of scalar values.
+func joinArray(value any, delimiter string) (string, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", false
+	}
+	if _, ok := value.([]byte); ok {
+		// Byte slices are encoded as base64 strings, not arrays.
+		return "", false
+	}
+	elems := make([]string, v.Len())
+	for i := range elems {
+		elem := v.Index(i)
+		for elem.Kind() == reflect.Interface || elem.Kind() == reflect.Pointer {
+			if elem.IsNil() {
+				break
+			}
+			elem = elem.Elem()
+		}
+		switch elem.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+			return "", false
+		case reflect.Interface, reflect.Pointer:
+			// A nil element is encoded as an empty string.
+			continue
+		}
+		elems[i] = fmt.Sprint(elem.Interface())
+	}
+	return strings.Join(elems, delimiter), true
+}
+
+// log returns the logger for reporting encoding problems.
+func (s encodingSettings) log() *logp.Logger {
+	if s.logger == nil {
+		return logp.NewNopLogger()
+	}
+	return s.logger
 }
 
 func (e *encodedEvent) setDeadLetter(
-	deadLetterIndex string, errType int, errMsg string,
+	deadLetterIndex string, dataStream bool, fields deadLetterFields, errType int, errMsg string,
+) {
+	e.setDeadLetterDocument(deadLetterIndex, dataStream, fields, errType, errMsg, nil)
+}
+
+// setPipelineDeadLetter is setDeadLetter for an event that failed in a
+// processor of an ingest pipeline. The dead letter document also holds the
+// type of the processor and the pipeline that failed, and the document
+// isn't sent through the pipeline, as it would fail again.
+func (e *encodedEvent) setPipelineDeadLetter(
+	deadLetterIndex string, dataStream bool, fields deadLetterFields, errType int, errMsg string, failure pipelineFailure,
 ) {
+	pipeline := failure.pipeline
+	if pipeline == "" {
+		pipeline = e.pipeline
+	}
+	e.setDeadLetterDocument(deadLetterIndex, dataStream, fields, errType, errMsg, mapstr.M{
+		fields.processorTypeField(): failure.processorType,
+		fields.pipelineField():      pipeline,
+	})
+	e.pipeline = ""
+}
+
+func (e *encodedEvent) setDeadLetterDocument(
+	deadLetterIndex string, dataStream bool, fields deadLetterFields, errType int, errMsg string, extra mapstr.M,
+) {
+	if !e.deadLetter {
+		e.originalIndex = e.index
+	}
 	e.deadLetter = true
 	e.index = deadLetterIndex
+	// The dead letter document is a new document, so the concurrency
+	// control and versioning of the original target don't apply to it.
+	e.ifSeqNo = nil
+	e.ifPrimaryTerm = nil
+	e.version = nil
+	e.versionType = ""
+	if dataStream {
+		// Data streams only accept create actions, whatever the op_type
+		// of the original event.
+		e.opType = events.OpTypeCreate
+	}
+	// Sending to the dead letter index is a new document, so it gets its
+	// own retries.
+	e.retries = 0
 	deadLetterReencoding := mapstr.M{
-		"@timestamp":    e.timestamp,
-		"message":       string(e.encoding),
-		"error.type":    errType,
-		"error.message": errMsg,
+		"@timestamp":                e.timestamp,
+		"message":                   string(e.encoding),
+		fields.errorTypeField():     errType,
+		fields.errorMessageField():  errMsg,
+		fields.originalIndexField(): e.originalIndex,
+	}
+	if e.id != "" {
+		// Keep the document ID in the payload so that the original
+		// document can be replayed from the dead letter index.
+		deadLetterReencoding[fields.documentIDField()] = e.id
+	}
+	for k, v := range extra {
+		deadLetterReencoding[k] = v
 	}
 	e.encoding = []byte(deadLetterReencoding.String())
 }
@@ -19,6 +19,7 @@ package elasticsearch
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,8 +28,13 @@ import (
 
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/beat/events"
+	"github.com/elastic/beats/v7/libbeat/common/fmtstr"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/outil"
 	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 type testIndexSelector struct{}
@@ -40,7 +46,7 @@ func (testIndexSelector) Select(event *beat.Event) (string, error) {
 func TestEncodeEntry(t *testing.T) {
 	indexSelector := testIndexSelector{}
 
-	encoder := newEventEncoder(true, indexSelector, nil)
+	encoder := newEventEncoder(true, indexSelector, nil, encodingSettings{})
 
 	metaFields := mapstr.M{
 		events.FieldMetaOpType:   "create",
@@ -103,6 +109,590 @@ func TestEncodeEntry(t *testing.T) {
 	assert.Contains(t, encBeatEvent.String(), `"pipeline":"TEST_PIPELINE"`, "String representation of encoded event should include the original event's meta fields")
 }
 
+func TestEncodeJoinArrays(t *testing.T) {
+	tests := map[string]struct {
+		settings JoinArrays
+		want     map[string]any
+	}{
+		"disabled": {
+			settings: JoinArrays{Delimiter: ","},
+			want: map[string]any{
+				"tags":    []any{"a", "b"},
+				"process": map[string]any{"args": []any{"ls", "-l"}, "pids": []any{1.0, 2.0}},
+				"hosts":   []any{map[string]any{"name": "h1"}},
+			},
+		},
+		"selected fields": {
+			settings: JoinArrays{Fields: []string{"tags", "process.args", "hosts", "missing"}, Delimiter: ","},
+			want: map[string]any{
+				"tags":    "a,b",
+				"process": map[string]any{"args": "ls,-l", "pids": []any{1.0, 2.0}},
+				"hosts":   []any{map[string]any{"name": "h1"}},
+			},
+		},
+		"all arrays": {
+			settings: JoinArrays{All: true, Delimiter: "|"},
+			want: map[string]any{
+				"tags":    "a|b",
+				"process": map[string]any{"args": "ls|-l", "pids": "1|2"},
+				"hosts":   []any{map[string]any{"name": "h1"}},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{joinArrays: tc.settings})
+			fields := mapstr.M{
+				"tags": []string{"a", "b"},
+				"process": mapstr.M{
+					"args": []any{"ls", "-l"},
+					"pids": []int{1, 2},
+				},
+				"hosts": []mapstr.M{{"name": "h1"}},
+			}
+			original := fields.Clone()
+			encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{Fields: fields}})
+			enc, ok := encoded.EncodedEvent.(*encodedEvent)
+			require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+			require.NoError(t, enc.err, "event should be encoded without error")
+
+			var got map[string]any
+			require.NoError(t, json.Unmarshal(enc.encoding, &got), "encoding should contain valid json")
+			delete(got, "@timestamp")
+			assert.Equal(t, tc.want, got, "only the selected arrays of scalars should be joined")
+			assert.Equal(t, original, fields, "original event fields should not be modified")
+		})
+	}
+}
+
+func TestEncodeTruncateFields(t *testing.T) {
+	long := strings.Repeat("x", 20)
+	tests := map[string]struct {
+		settings TruncateFields
+		want     map[string]any
+	}{
+		"disabled": {
+			settings: TruncateFields{Marker: "..."},
+			want: map[string]any{
+				"message": long,
+				"short":   "small",
+				"process": map[string]any{"args": []any{"ls", long}, "pid": 1.0},
+				"hosts":   []any{map[string]any{"name": long}},
+			},
+		},
+		"long values": {
+			settings: TruncateFields{MaxLength: 10, Marker: "..."},
+			want: map[string]any{
+				"message": "xxxxxxxxxx...",
+				"short":   "small",
+				"process": map[string]any{"args": []any{"ls", "xxxxxxxxxx..."}, "pid": 1.0},
+				"hosts":   []any{map[string]any{"name": "xxxxxxxxxx..."}},
+			},
+		},
+		"custom marker": {
+			settings: TruncateFields{MaxLength: 15, Marker: "[truncated]"},
+			want: map[string]any{
+				"message": "xxxxxxxxxxxxxxx[truncated]",
+				"short":   "small",
+				"process": map[string]any{"args": []any{"ls", "xxxxxxxxxxxxxxx[truncated]"}, "pid": 1.0},
+				"hosts":   []any{map[string]any{"name": "xxxxxxxxxxxxxxx[truncated]"}},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{truncateFields: tc.settings})
+			fields := mapstr.M{
+				"message": long,
+				"short":   "small",
+				"process": mapstr.M{
+					"args": []string{"ls", long},
+					"pid":  1,
+				},
+				"hosts": []any{mapstr.M{"name": long}},
+			}
+			original := mapstr.M{
+				"message": long,
+				"short":   "small",
+				"process": mapstr.M{
+					"args": []string{"ls", long},
+					"pid":  1,
+				},
+				"hosts": []any{mapstr.M{"name": long}},
+			}
+			encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{Fields: fields}})
+			enc, ok := encoded.EncodedEvent.(*encodedEvent)
+			require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+			require.NoError(t, enc.err, "event should be encoded without error")
+
+			var got map[string]any
+			require.NoError(t, json.Unmarshal(enc.encoding, &got), "encoding should contain valid json")
+			delete(got, "@timestamp")
+			assert.Equal(t, tc.want, got, "only the long string values should be truncated")
+			assert.Equal(t, original, fields, "original event fields should not be modified")
+		})
+	}
+}
+
+func TestTruncateValueKeepsValidUTF8(t *testing.T) {
+	// "é" is encoded in two bytes, so a cut after 3 bytes would split it.
+	got, ok := truncateValue("aéé", TruncateFields{MaxLength: 4, Marker: "..."})
+	require.True(t, ok, "a value longer than the maximum length should be truncated")
+	assert.Equal(t, "aé...", got, "the value should be cut at a rune boundary")
+}
+
+func TestEncodeDottedKeys(t *testing.T) {
+	tests := map[string]struct {
+		mode string
+		want map[string]any
+	}{
+		"none": {
+			mode: dottedKeysNone,
+			want: map[string]any{
+				"a.b.c": "dotted",
+				"a":     map[string]any{"d": "nested"},
+			},
+		},
+		"flatten": {
+			mode: dottedKeysFlatten,
+			want: map[string]any{
+				"a.b.c": "dotted",
+				"a.d":   "nested",
+			},
+		},
+		"expand": {
+			mode: dottedKeysExpand,
+			want: map[string]any{
+				"a": map[string]any{
+					"b": map[string]any{"c": "dotted"},
+					"d": "nested",
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{dottedKeys: tc.mode})
+			fields := mapstr.M{
+				"a.b.c": "dotted",
+				"a":     mapstr.M{"d": "nested"},
+			}
+			original := fields.Clone()
+			encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{Fields: fields}})
+			enc, ok := encoded.EncodedEvent.(*encodedEvent)
+			require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+			require.NoError(t, enc.err, "event should be encoded without error")
+
+			var got map[string]any
+			require.NoError(t, json.Unmarshal(enc.encoding, &got), "encoding should contain valid json")
+			delete(got, "@timestamp")
+			assert.Equal(t, tc.want, got, "dotted keys should be encoded according to the %s mode", tc.mode)
+			assert.Equal(t, original, fields, "original event fields should not be modified")
+		})
+	}
+
+	t.Run("expand conflict", func(t *testing.T) {
+		encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{dottedKeys: dottedKeysExpand})
+		fields := mapstr.M{
+			"a.b": "dotted",
+			"a":   "scalar",
+		}
+		encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{Fields: fields}})
+		enc, ok := encoded.EncodedEvent.(*encodedEvent)
+		require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+		require.NoError(t, enc.err, "a conflicting key should not prevent encoding")
+
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(enc.encoding, &got), "encoding should contain valid json")
+		errField, ok := got["error"].(map[string]any)
+		require.True(t, ok, "a conflicting key should be reported in the error field")
+		assert.Contains(t, errField["message"], "conflicting key", "the error message should describe the conflict")
+	})
+}
+
+func TestEncodeMissingTimestamp(t *testing.T) {
+	encode := func(t *testing.T, policy string, timestamp time.Time) *encodedEvent {
+		t.Helper()
+		encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{missingTimestamp: policy})
+		encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{Timestamp: timestamp, Fields: mapstr.M{"a": 1}}})
+		enc, ok := encoded.EncodedEvent.(*encodedEvent)
+		require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+		return enc
+	}
+	encodedTimestamp := func(t *testing.T, enc *encodedEvent) time.Time {
+		t.Helper()
+		require.NoError(t, enc.err, "event should be encoded without error")
+		var doc struct {
+			Timestamp time.Time `json:"@timestamp"`
+		}
+		require.NoError(t, json.Unmarshal(enc.encoding, &doc), "encoding should contain valid json")
+		return doc.Timestamp
+	}
+
+	for _, policy := range []string{"", missingTimestampKeep} {
+		t.Run("keep "+policy, func(t *testing.T) {
+			enc := encode(t, policy, time.Time{})
+			assert.True(t, encodedTimestamp(t, enc).IsZero(), "the zero timestamp should be sent as is")
+			assert.True(t, enc.timestamp.IsZero(), "encodedEvent.timestamp should be zero")
+		})
+	}
+
+	t.Run("ingest_time", func(t *testing.T) {
+		before := time.Now()
+		enc := encode(t, missingTimestampIngestTime, time.Time{})
+		after := time.Now()
+		got := encodedTimestamp(t, enc)
+		assert.False(t, got.Before(before.Truncate(time.Millisecond)) || got.After(after), "the ingest time should be sent, got %v", got)
+		assert.True(t, enc.timestamp.Truncate(time.Millisecond).Equal(got), "encodedEvent.timestamp should hold the ingest time")
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		enc := encode(t, missingTimestampDrop, time.Time{})
+		assert.ErrorContains(t, enc.err, "no @timestamp", "the event should fail to be encoded")
+	})
+
+	// Events that have a timestamp are sent as they are with every policy.
+	timestamp := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	for _, policy := range []string{missingTimestampKeep, missingTimestampIngestTime, missingTimestampDrop} {
+		t.Run("set "+policy, func(t *testing.T) {
+			assert.Equal(t, timestamp, encodedTimestamp(t, encode(t, policy, timestamp)))
+		})
+	}
+}
+
+func TestEncodeIndexField(t *testing.T) {
+	expr, err := outil.FmtSelectorExpr(fmtstr.MustCompileEvent("logs-%{[service.name]}-%{+yyyy.MM.dd}"), "", outil.SelectorKeepCase)
+	require.NoError(t, err)
+	indexSelector := outil.MakeSelector(expr)
+	timestamp := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	encode := func(t *testing.T, settings encodingSettings, fields mapstr.M) (*encodedEvent, mapstr.M) {
+		t.Helper()
+		encoder := newEventEncoder(false, indexSelector, nil, settings)
+		encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{Timestamp: timestamp, Fields: fields}})
+		enc, ok := encoded.EncodedEvent.(*encodedEvent)
+		require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+		require.NoError(t, enc.err, "event should be encoded without error")
+		var doc mapstr.M
+		require.NoError(t, json.Unmarshal(enc.encoding, &doc), "encoding should contain valid json")
+		return enc, doc
+	}
+
+	t.Run("resolved index", func(t *testing.T) {
+		enc, doc := encode(t, encodingSettings{indexField: "event.index"}, mapstr.M{"service": mapstr.M{"name": "api"}})
+		assert.Equal(t, "logs-api-2024.05.01", enc.index)
+		got, err := doc.GetValue("event.index")
+		require.NoError(t, err, "the index field should be set")
+		assert.Equal(t, "logs-api-2024.05.01", got, "the index field should hold the index the event is written to")
+	})
+
+	t.Run("transformed index", func(t *testing.T) {
+		settings := encodingSettings{
+			indexField: "event.index",
+			indexTransform: func(_ *beat.Event, index string) string {
+				return index + "-shard1"
+			},
+		}
+		enc, doc := encode(t, settings, mapstr.M{"service": mapstr.M{"name": "api"}})
+		assert.Equal(t, "logs-api-2024.05.01-shard1", enc.index)
+		got, err := doc.GetValue("event.index")
+		require.NoError(t, err, "the index field should be set")
+		assert.Equal(t, "logs-api-2024.05.01-shard1", got, "the index field should hold the transformed index")
+	})
+
+	t.Run("shared fields are not modified", func(t *testing.T) {
+		fields := mapstr.M{"service": mapstr.M{"name": "api"}}
+		_, doc := encode(t, encodingSettings{indexField: "event.index"}, fields)
+		assert.Contains(t, doc, "event", "the encoded event should hold the index field")
+		assert.Equal(t, mapstr.M{"service": mapstr.M{"name": "api"}}, fields, "the fields of the event should not be modified")
+	})
+
+	t.Run("default index", func(t *testing.T) {
+		settings := encodingSettings{
+			indexField: "event.index",
+			emptyIndex: EmptyIndex{Policy: emptyIndexDefault, Index: "logs-unrouted"},
+		}
+		_, doc := encode(t, settings, mapstr.M{})
+		got, err := doc.GetValue("event.index")
+		require.NoError(t, err, "the index field should be set")
+		assert.Equal(t, "logs-unrouted", got)
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		settings := encodingSettings{
+			indexField:      "event.index",
+			allowedIndices:  []string{"metrics-*"},
+			deadLetterIndex: "dead_letters",
+		}
+		enc, doc := encode(t, settings, mapstr.M{"service": mapstr.M{"name": "api"}})
+		assert.Equal(t, "dead_letters", enc.index)
+		assert.Contains(t, doc["message"], `"service"`, "the dead letter document should hold the original event")
+		assert.NotContains(t, doc["message"], `"event"`, "events sent to the dead letter index should not be stamped")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, doc := encode(t, encodingSettings{}, mapstr.M{"service": mapstr.M{"name": "api"}})
+		assert.NotContains(t, doc, "event", "no field should be added")
+	})
+}
+
+func TestEncodeDocumentSizeMetrics(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	observer := outputs.NewStats(reg, logp.NewNopLogger())
+	encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{observer: observer})
+
+	encode := func(messageLen int) uint64 {
+		event := publisher.Event{Content: beat.Event{
+			Fields: mapstr.M{"message": strings.Repeat("x", messageLen)},
+		}}
+		_, size := encoder.EncodeEntry(event)
+		return uint64(size)
+	}
+
+	var sum, largest uint64
+	for _, n := range []int{10, 1000, 100} {
+		size := encode(n)
+		sum += size
+		largest = max(largest, size)
+	}
+	assertRegistryUint(t, reg, "events.doc_size.avg", sum/3, "average document size should cover all encoded documents")
+	assertRegistryUint(t, reg, "events.doc_size.max", largest, "max document size should be the largest encoded document")
+
+	// Fill the window with small documents so that the earlier ones,
+	// including the largest, are evicted.
+	var small uint64
+	for range 1024 {
+		small = encode(1)
+	}
+	assertRegistryUint(t, reg, "events.doc_size.avg", small, "average document size should only cover the sliding window")
+	assertRegistryUint(t, reg, "events.doc_size.max", small, "max document size should only cover the sliding window")
+}
+
+func TestEncodeAllowedIndices(t *testing.T) {
+	tests := map[string]struct {
+		allowed         []string
+		deadLetterIndex string
+		wantErr         bool
+		wantDeadLetter  bool
+		wantNotAllowed  uint64
+	}{
+		"no allow list": {},
+		"allowed index": {
+			allowed: []string{"logs-*", "te*"},
+		},
+		"disallowed index is dropped": {
+			allowed:        []string{"logs-*"},
+			wantErr:        true,
+			wantNotAllowed: 1,
+		},
+		"disallowed index is dead lettered": {
+			allowed:         []string{"logs-*"},
+			deadLetterIndex: "dead_letters",
+			wantDeadLetter:  true,
+			wantNotAllowed:  1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := monitoring.NewRegistry()
+			encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{
+				observer:        outputs.NewStats(reg, logp.NewNopLogger()),
+				allowedIndices:  tc.allowed,
+				deadLetterIndex: tc.deadLetterIndex,
+			})
+			encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{
+				Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Fields:    mapstr.M{"message": "hello"},
+			}})
+			enc, ok := encoded.EncodedEvent.(*encodedEvent)
+			require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+
+			assertRegistryUint(t, reg, "events.not_allowed", tc.wantNotAllowed, "events.not_allowed should count disallowed events")
+			if tc.wantErr {
+				require.Error(t, enc.err, "an event targeting a disallowed index should fail to encode")
+				return
+			}
+			require.NoError(t, enc.err, "the event should be encoded")
+			assert.Equal(t, tc.wantDeadLetter, enc.deadLetter, "unexpected dead letter state")
+			if tc.wantDeadLetter {
+				assert.Equal(t, tc.deadLetterIndex, enc.index, "a disallowed event should target the dead letter index")
+				assert.Contains(t, string(enc.encoding), `not in allowed_indices`, "the dead letter document should describe the error")
+			} else {
+				assert.Equal(t, "test", enc.index, "an allowed event should keep its index")
+			}
+		})
+	}
+}
+
+func TestEncodeEmptyIndex(t *testing.T) {
+	expr, err := outil.FmtSelectorExpr(fmtstr.MustCompileEvent("logs-%{[service.name]}"), "", outil.SelectorKeepCase)
+	require.NoError(t, err)
+	indexSelector := outil.MakeSelector(expr)
+
+	tests := map[string]struct {
+		emptyIndex      EmptyIndex
+		allowed         []string
+		deadLetterIndex string
+		wantErr         bool
+		wantIndex       string
+		wantDeadLetter  bool
+		wantNotAllowed  uint64
+	}{
+		"no policy": {
+			wantIndex: "",
+		},
+		"default index": {
+			emptyIndex: EmptyIndex{Policy: emptyIndexDefault, Index: "logs-unrouted"},
+			wantIndex:  "logs-unrouted",
+		},
+		"default index not allowed": {
+			emptyIndex:     EmptyIndex{Policy: emptyIndexDefault, Index: "unrouted"},
+			allowed:        []string{"logs-*"},
+			wantErr:        true,
+			wantNotAllowed: 1,
+		},
+		"drop": {
+			emptyIndex: EmptyIndex{Policy: emptyIndexDrop},
+			wantErr:    true,
+		},
+		"dead letter": {
+			emptyIndex:      EmptyIndex{Policy: emptyIndexDeadLetter},
+			allowed:         []string{"logs-*"},
+			deadLetterIndex: "dead_letters",
+			wantIndex:       "dead_letters",
+			wantDeadLetter:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := monitoring.NewRegistry()
+			encoder := newEventEncoder(false, indexSelector, nil, encodingSettings{
+				observer:        outputs.NewStats(reg, logp.NewNopLogger()),
+				allowedIndices:  tc.allowed,
+				deadLetterIndex: tc.deadLetterIndex,
+				emptyIndex:      tc.emptyIndex,
+			})
+
+			// An event with the routing field is not affected by the policy.
+			encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{
+				Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Fields:    mapstr.M{"message": "hello", "service": mapstr.M{"name": "web"}},
+			}})
+			enc, ok := encoded.EncodedEvent.(*encodedEvent)
+			require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+			require.NoError(t, enc.err, "an event with the routing field should be encoded")
+			assert.Equal(t, "logs-web", enc.index, "an event with the routing field should keep its index")
+			assertRegistryUint(t, reg, "events.index_empty", 0, "events.index_empty should not count routed events")
+
+			encoded, _ = encoder.EncodeEntry(publisher.Event{Content: beat.Event{
+				Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Fields:    mapstr.M{"message": "hello"},
+			}})
+			enc, ok = encoded.EncodedEvent.(*encodedEvent)
+			require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+
+			assertRegistryUint(t, reg, "events.index_empty", 1, "events.index_empty should count events missing the routing field")
+			assertRegistryUint(t, reg, "events.not_allowed", tc.wantNotAllowed, "unexpected events.not_allowed count")
+			if tc.wantErr {
+				require.Error(t, enc.err, "the event should fail to encode")
+				return
+			}
+			require.NoError(t, enc.err, "the event should be encoded")
+			assert.Equal(t, tc.wantIndex, enc.index, "unexpected index for an event missing the routing field")
+			assert.Equal(t, tc.wantDeadLetter, enc.deadLetter, "unexpected dead letter state")
+			if tc.wantDeadLetter {
+				assert.Contains(t, string(enc.encoding), `no index selected for event`, "the dead letter document should describe the error")
+			}
+		})
+	}
+}
+
+func TestEncodeEventLimits(t *testing.T) {
+	deep := mapstr.M{"leaf": "value"}
+	for range 200 {
+		deep = mapstr.M{"nested": deep}
+	}
+	wide := make([]any, 1000)
+	for i := range wide {
+		wide[i] = i
+	}
+	normal := mapstr.M{
+		"message": "hello",
+		"host":    mapstr.M{"name": "example", "ip": []any{"10.0.0.1", "10.0.0.2"}},
+	}
+	limits := EventLimits{MaxDepth: 32, MaxFields: 100}
+
+	tests := map[string]struct {
+		fields          mapstr.M
+		deadLetterIndex string
+		wantErr         bool
+		wantDeadLetter  bool
+		wantTooComplex  uint64
+		wantReason      string
+	}{
+		"normal event": {
+			fields: normal,
+		},
+		"deep event is dropped": {
+			fields:         mapstr.M{"message": "hello", "deep": deep},
+			wantErr:        true,
+			wantTooComplex: 1,
+			wantReason:     "exceeds the maximum depth of 32",
+		},
+		"deep event is dead lettered": {
+			fields:          mapstr.M{"message": "hello", "deep": deep},
+			deadLetterIndex: "dead_letters",
+			wantDeadLetter:  true,
+			wantTooComplex:  1,
+			wantReason:      "exceeds the maximum depth of 32",
+		},
+		"wide event is dropped": {
+			fields:         mapstr.M{"message": "hello", "wide": wide},
+			wantErr:        true,
+			wantTooComplex: 1,
+			wantReason:     "exceeds the maximum of 100 fields",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := monitoring.NewRegistry()
+			encoder := newEventEncoder(false, testIndexSelector{}, nil, encodingSettings{
+				observer:        outputs.NewStats(reg, logp.NewNopLogger()),
+				deadLetterIndex: tc.deadLetterIndex,
+				eventLimits:     limits,
+			})
+			encoded, _ := encoder.EncodeEntry(publisher.Event{Content: beat.Event{
+				Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Fields:    tc.fields,
+			}})
+			enc, ok := encoded.EncodedEvent.(*encodedEvent)
+			require.True(t, ok, "EncodeEntry should set EncodedEvent to a *encodedEvent")
+
+			assertRegistryUint(t, reg, "events.too_complex", tc.wantTooComplex, "unexpected events.too_complex count")
+			if tc.wantErr {
+				require.Error(t, enc.err, "an event exceeding the limits should fail to encode")
+				assert.Contains(t, enc.err.Error(), tc.wantReason, "the error should describe the exceeded limit")
+				return
+			}
+			require.NoError(t, enc.err, "the event should be encoded")
+			assert.Equal(t, tc.wantDeadLetter, enc.deadLetter, "unexpected dead letter state")
+			if tc.wantDeadLetter {
+				assert.Equal(t, tc.deadLetterIndex, enc.index, "an event exceeding the limits should target the dead letter index")
+				assert.Contains(t, string(enc.encoding), tc.wantReason, "the dead letter document should describe the exceeded limit")
+			} else {
+				assert.Equal(t, "test", enc.index, "a normal event should keep its index")
+				assert.Contains(t, string(enc.encoding), `"message":"hello"`, "a normal event should be encoded unchanged")
+			}
+		})
+	}
+}
+
 // encodeBatch encodes a publisher.Batch so it can be provided to
 // Client.Publish and other helpers.
 // This modifies the batch in place, but also returns its input batch
@@ -123,6 +713,7 @@ func encodeEvents(client *Client, events []publisher.Event) []publisher.Event {
 		client.conn.EscapeHTML,
 		client.indexSelector,
 		client.pipelineSelector,
+		encodingSettings{},
 	)
 	for i := range events {
 		// Skip encoding if there's already encoded data present
@@ -140,6 +731,7 @@ func encodeEvent(client *Client, event publisher.Event) publisher.Event {
 		client.conn.EscapeHTML,
 		client.indexSelector,
 		client.pipelineSelector,
+		encodingSettings{},
 	)
 	encoded, _ := encoder.EncodeEntry(event)
 	return encoded
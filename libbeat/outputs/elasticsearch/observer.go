@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/outputs"
+)
+
+// Observer is an outputs.Observer that also reports the metrics specific to
+// the Elasticsearch output. The output reports these metrics if the
+// observer it is created with implements Observer, and ignores them
+// otherwise.
+type Observer interface {
+	outputs.Observer
+
+	CircuitOpen(bool)     // report whether the circuit breaker stopped publishing to the output
+	NoopEvents(int)       // report number of acked events that didn't change the target document
+	IndexNotAllowed(int)  // report number of events targeting an index that is not allowed
+	IndexEmpty(int)       // report number of events for which no index was selected
+	EventTooComplex(int)  // report number of events exceeding the configured complexity limits
+	ExpiredEvents(int)    // report number of events dropped for exceeding the configured maximum age
+	EventTooLarge(int)    // report number of events dropped for being too large to ingest on their own
+	WouldSendEvents(int)  // report number of events encoded but not sent in dry run mode
+	AuditEvents(int, int) // report number of audit copies of events created and failed
+	SupersededEvents(int) // report number of events not sent or retried because a later event writes the same document
+
+	BatchPreSplit() // report a batch was sent in multiple requests to stay under the request size limit
+
+	BulkLatency(time.Duration) // report the duration of a bulk request, from sending it to reading the response
+	BulkInFlight(int)          // report the number of bulk requests currently in flight
+	BulkSize(int, int)         // report the size in bytes of a bulk request body, before and after compression
+
+	BulkCompressionRatio(float64) // report the ratio of the compressed to the uncompressed size of a compressed bulk request body
+
+	DocumentSize(int) // report the size in bytes of an encoded document
+
+	IndexEvents(index string, acked, failed, dropped, tooMany int) // report the outcome of events targeting an index
+}
+
+// asObserver returns o as an Observer. If o doesn't implement Observer, the
+// metrics specific to the Elasticsearch output are ignored. A nil o ignores
+// all metrics.
+func asObserver(o outputs.Observer) Observer {
+	if o == nil {
+		o = outputs.NewNilObserver()
+	}
+	if observer, ok := o.(Observer); ok {
+		return observer
+	}
+	return outputObserver{o}
+}
+
+// outputObserver adapts an outputs.Observer that only reports the metrics
+// common to all outputs.
+type outputObserver struct {
+	outputs.Observer
+}
+
+func (outputObserver) CircuitOpen(bool)             {}
+func (outputObserver) NoopEvents(int)               {}
+func (outputObserver) IndexNotAllowed(int)          {}
+func (outputObserver) IndexEmpty(int)               {}
+func (outputObserver) EventTooComplex(int)          {}
+func (outputObserver) ExpiredEvents(int)            {}
+func (outputObserver) EventTooLarge(int)            {}
+func (outputObserver) WouldSendEvents(int)          {}
+func (outputObserver) AuditEvents(int, int)         {}
+func (outputObserver) SupersededEvents(int)         {}
+func (outputObserver) BatchPreSplit()               {}
+func (outputObserver) BulkLatency(time.Duration)    {}
+func (outputObserver) BulkInFlight(int)             {}
+func (outputObserver) BulkSize(int, int)            {}
+func (outputObserver) BulkCompressionRatio(float64) {}
+func (outputObserver) DocumentSize(int)             {}
+
+func (outputObserver) IndexEvents(string, int, int, int, int) {}
@@ -0,0 +1,105 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/esleg/eslegclient"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/version"
+)
+
+// enabled returns whether a batch of the given number of events is encoded
+// in parallel.
+func (p ParallelEncoding) enabled(events int) bool {
+	return p.Workers > 1 && events > 1 && events >= p.MinEvents
+}
+
+// bulkEncodePublishRequestParallel is bulkEncodePublishRequest for large
+// batches. The events are split into consecutive ranges that are encoded
+// concurrently, each by its own worker, and the results are put back
+// together in the order of the events. Workers also encode the bulk
+// actions, so that building the request body only has to copy them.
+func (client *Client) bulkEncodePublishRequestParallel(version version.V, data []publisher.Event) ([]publisher.Event, []any) {
+	workers := min(client.parallelEncoding.Workers, len(data))
+	size := (len(data) + workers - 1) / workers
+	ranges := make([][]any, (len(data)+size-1)/size)
+	dropReasons := make([]string, len(data))
+	ok := make([]bool, len(data))
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for r := range ranges {
+		start := r * size
+		end := min(start+size, len(data))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			enc := eslegclient.NewJSONEncoder(&buf, client.conn.EscapeHTML)
+			items := make([]any, 0, (end-start)*2)
+			// The encoded actions are appended to actions, which they are
+			// sliced from, to avoid allocating each of them.
+			var actions []byte
+			for i := start; i < end; i++ {
+				n := len(items)
+				items, dropReasons[i], ok[i] = client.appendBulkItems(items, version, now, data[i])
+				for j := n; j < len(items); j++ {
+					if _, raw := items[j].(eslegclient.RawEncoding); raw {
+						continue
+					}
+					enc.Reset()
+					// An action that fails to encode is left as is, for the
+					// error to be reported when the request body is built.
+					if err := enc.AddRaw(items[j]); err == nil {
+						n := len(actions)
+						actions = append(actions, buf.Bytes()...)
+						items[j] = eslegclient.RawEncoding{Encoding: actions[n:len(actions):len(actions)]}
+					}
+				}
+			}
+			ranges[r] = items
+		}()
+	}
+	wg.Wait()
+
+	okEvents := data[:0]
+	expired := 0
+	for i := range data {
+		if !ok[i] {
+			if dropReasons[i] == dropReasonExpired {
+				expired++
+			}
+			if dropReasons[i] != "" {
+				client.dropped(data[i], dropReasons[i])
+			}
+			continue
+		}
+		okEvents = append(okEvents, data[i])
+	}
+	client.observer.ExpiredEvents(expired)
+
+	bulkItems := make([]any, 0, len(data)*2)
+	for _, items := range ranges {
+		bulkItems = append(bulkItems, items...)
+	}
+	return okEvents, bulkItems
+}
@@ -0,0 +1,86 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"strings"
+	"sync"
+)
+
+// retryBudget limits the retries of the events of each ingest pipeline, so
+// that the events of a pipeline that keeps failing are given up on sooner
+// instead of competing with the retries of healthy pipelines. The budget of
+// a pipeline is the number of event retries allowed since an event of the
+// pipeline was last ingested.
+//
+// retryBudget is thread-safe, it is shared by all the clients of an output
+// and their clones, so that the budget applies to the output as a whole.
+type retryBudget struct {
+	defaultBudget int
+	budgets       map[string]int
+
+	mu   sync.Mutex
+	used map[string]int
+}
+
+// newRetryBudget returns a retry budget for the given settings, or nil if
+// no budget is set. A nil retryBudget never runs out.
+func newRetryBudget(settings RetryBudget) *retryBudget {
+	if settings.Default <= 0 && len(settings.Pipelines) == 0 {
+		return nil
+	}
+	// Pipeline names are matched in lower case, as they are selected.
+	budgets := make(map[string]int, len(settings.Pipelines))
+	for pipeline, budget := range settings.Pipelines {
+		budgets[strings.ToLower(pipeline)] = budget
+	}
+	return &retryBudget{
+		defaultBudget: settings.Default,
+		budgets:       budgets,
+		used:          make(map[string]int),
+	}
+}
+
+// ingested resets the budget of pipeline after one of its events has been
+// ingested.
+func (b *retryBudget) ingested(pipeline string) {
+	if b != nil {
+		b.mu.Lock()
+		delete(b.used, pipeline)
+		b.mu.Unlock()
+	}
+}
+
+// spend uses one retry from the budget of pipeline and returns whether the
+// budget allowed it. Events without a pipeline are not limited.
+func (b *retryBudget) spend(pipeline string) bool {
+	if b == nil || pipeline == "" {
+		return true
+	}
+	budget, ok := b.budgets[pipeline]
+	if !ok {
+		budget = b.defaultBudget
+	}
+	if budget <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used[pipeline]++
+	return b.used[pipeline] <= budget
+}
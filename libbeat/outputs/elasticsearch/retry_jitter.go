@@ -0,0 +1,76 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// retryJitter delays the retry of failed events by a random duration of up
+// to max, so that clients whose requests failed at the same time don't all
+// retry at the same time.
+//
+// retryJitter is not thread-safe, it is used by a single client.
+type retryJitter struct {
+	max  time.Duration
+	rand *rand.Rand
+
+	// sleep waits for d or until ctx is done.
+	sleep func(ctx context.Context, d time.Duration)
+}
+
+// newRetryJitter returns a jitter of up to max drawn from a generator
+// seeded with seed, or nil if max is not positive. A nil jitter never
+// delays retries.
+func newRetryJitter(max time.Duration, seed uint64) *retryJitter {
+	if max <= 0 {
+		return nil
+	}
+	return &retryJitter{
+		max:   max,
+		rand:  rand.New(rand.NewPCG(seed, seed)), //nolint:gosec //the jitter doesn't need a secure generator
+		sleep: sleepContext,
+	}
+}
+
+// delay returns a random duration between 0 and max.
+func (j *retryJitter) delay() time.Duration {
+	if j == nil {
+		return 0
+	}
+	return time.Duration(j.rand.Int64N(int64(j.max) + 1))
+}
+
+// wait blocks for a random duration between 0 and max, or until ctx is
+// done.
+func (j *retryJitter) wait(ctx context.Context) {
+	if d := j.delay(); d > 0 {
+		j.sleep(ctx, d)
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,172 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"github.com/elastic/beats/v7/libbeat/publisher"
+)
+
+const (
+	sameIDOrdered  = "ordered"
+	sameIDCollapse = "collapse"
+)
+
+// sameIDKey returns the key identifying the document an event writes: its
+// target index and document ID. It returns false for events without an ID
+// and for events sent to the dead letter index, which are new documents.
+func sameIDKey(event publisher.Event) (string, *encodedEvent, bool) {
+	encoded, ok := event.EncodedEvent.(*encodedEvent)
+	if !ok || encoded.err != nil || encoded.id == "" || encoded.deadLetter {
+		return "", nil, false
+	}
+	return encoded.index + "\x00" + encoded.id, encoded, true
+}
+
+// sameIDOrder holds the position in their batch of the events that write
+// the same document as another event of the batch. It is used to keep a
+// failed event from being retried once a later event writing the same
+// document was indexed, which would overwrite the newer document with the
+// older one.
+type sameIDOrder struct {
+	events map[*encodedEvent]sameIDPosition
+}
+
+type sameIDPosition struct {
+	key string
+	pos int
+}
+
+// newSameIDOrder returns the order of the events writing the same document
+// in events, or nil if every event writes a different document.
+func newSameIDOrder(events []publisher.Event) *sameIDOrder {
+	counts := make(map[string]int)
+	for _, event := range events {
+		if key, _, ok := sameIDKey(event); ok {
+			counts[key]++
+		}
+	}
+	var order *sameIDOrder
+	for i, event := range events {
+		key, encoded, ok := sameIDKey(event)
+		if !ok || counts[key] < 2 {
+			continue
+		}
+		if order == nil {
+			order = &sameIDOrder{events: make(map[*encodedEvent]sameIDPosition)}
+		}
+		order.events[encoded] = sameIDPosition{key: key, pos: i}
+	}
+	return order
+}
+
+// superseded removes from retry the events for which a later event of the
+// batch writing the same document was indexed, and returns the remaining
+// events and the number of events removed. Events keep their order. It is
+// safe to call on a nil order.
+func (o *sameIDOrder) superseded(retry []publisher.Event) ([]publisher.Event, int) {
+	if o == nil || len(retry) == 0 {
+		return retry, 0
+	}
+	// The position of the last indexed event writing each document.
+	last := make(map[string]int)
+	for encoded, p := range o.events {
+		if !encoded.indexed {
+			continue
+		}
+		if pos, ok := last[p.key]; !ok || p.pos > pos {
+			last[p.key] = p.pos
+		}
+	}
+	if len(last) == 0 {
+		return retry, 0
+	}
+	kept := retry[:0]
+	for _, event := range retry {
+		encoded := event.EncodedEvent.(*encodedEvent) //nolint:errcheck //safe to ignore type check
+		if p, ok := o.events[encoded]; ok && !encoded.deadLetter {
+			if pos, ok := last[p.key]; ok && pos > p.pos {
+				continue
+			}
+		}
+		kept = append(kept, event)
+	}
+	return kept, len(retry) - len(kept)
+}
+
+// collapseSameID returns the events with only the last of the events
+// writing the same document, and the number of events left out. events is
+// not modified.
+func collapseSameID(events []publisher.Event) ([]publisher.Event, int) {
+	last := make(map[string]int)
+	for i, event := range events {
+		if key, _, ok := sameIDKey(event); ok {
+			last[key] = i
+		}
+	}
+	n := 0
+	for i, event := range events {
+		if key, _, ok := sameIDKey(event); ok && last[key] != i {
+			n++
+		}
+	}
+	if n == 0 {
+		return events, 0
+	}
+	collapsed := make([]publisher.Event, 0, len(events)-n)
+	for i, event := range events {
+		if key, _, ok := sameIDKey(event); ok && last[key] != i {
+			continue
+		}
+		collapsed = append(collapsed, event)
+	}
+	return collapsed, n
+}
+
+// halves splits events into two consecutive chunks of about the same size.
+func halves(events []publisher.Event) [][]publisher.Event {
+	mid := len(events) / 2
+	return [][]publisher.Event{events[:mid:mid], events[mid:]}
+}
+
+// sameIDBatch applies the client's same_id_events mode to the events of a
+// batch. It returns the events to send and, in the ordered mode, the order
+// of the events writing the same document, if there are any.
+func (client *Client) sameIDBatch(events []publisher.Event) ([]publisher.Event, *sameIDOrder) {
+	if client.sameIDEvents == sameIDCollapse {
+		collapsed, n := collapseSameID(events)
+		if n > 0 {
+			// The events left out are acknowledged with the batch.
+			client.log.Debugf("Collapsed %d events writing the same document as a later event", n)
+			client.observer.AckedEvents(n)
+			client.observer.SupersededEvents(n)
+		}
+		return collapsed, nil
+	}
+	return events, newSameIDOrder(events)
+}
+
+// skipSuperseded removes from retry the events superseded by a later event
+// of the batch, see sameIDOrder.superseded, and reports them.
+func (client *Client) skipSuperseded(order *sameIDOrder, retry []publisher.Event) []publisher.Event {
+	retry, n := order.superseded(retry)
+	if n > 0 {
+		client.log.Debugf("Not retrying %d events superseded by a later indexed event with the same ID", n)
+		client.observer.SupersededEvents(n)
+	}
+	return retry
+}
@@ -18,6 +18,8 @@
 package outputs
 
 import (
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
@@ -64,14 +66,65 @@ type Stats struct {
 	// These events are also included in eventsFailed.
 	eventsTooMany *monitoring.Uint
 
+	// Whether the output's circuit breaker is open, so that batches are
+	// retried without being sent.
+	circuitOpen *monitoring.Bool
+
 	// Number of events sent to the Failure store
 	eventsFailureStore *monitoring.Uint
 
+	// Number of events acknowledged with a noop result, as they didn't
+	// change the target document. These events are also included in
+	// eventsACKed.
+	eventsNoop *monitoring.Uint
+
+	// Number of copies of events created in, and failed to be created in,
+	// the audit index. Audit copies don't affect the other event metrics.
+	eventsAuditAcked  *monitoring.Uint
+	eventsAuditFailed *monitoring.Uint
+
+	// Number of events that were not sent, or not retried, because a later
+	// event of their batch writes the same document. Events left out when
+	// collapsing a batch are also included in eventsACKed, and events not
+	// retried were included in eventsFailed when they failed.
+	eventsSuperseded *monitoring.Uint
+
+	// Number of events whose target index is not allowed by the output
+	// configuration. These events are also included in eventsDropped or
+	// eventsDeadLetter.
+	eventsNotAllowed *monitoring.Uint
+
+	// Number of events for which the index selection yielded an empty
+	// index name.
+	eventsIndexEmpty *monitoring.Uint
+
+	// Number of events exceeding the configured complexity limits. These
+	// events are also included in eventsDropped or eventsDeadLetter.
+	eventsTooComplex *monitoring.Uint
+
+	// Number of events older than the configured maximum age. These
+	// events are also included in eventsDropped.
+	eventsExpired *monitoring.Uint
+
+	// Number of events rejected by the output as too large on their own,
+	// so that splitting their batch can't help. These events are also
+	// included in eventsDropped.
+	eventsTooLarge *monitoring.Uint
+
+	// Number of events encoded but not sent as the output runs in dry run
+	// mode. These events are acknowledged without being counted in
+	// eventsACKed.
+	eventsWouldSend *monitoring.Uint
+
 	// Output batch stats
 
 	// Number of times a batch was split for being too large
 	batchesSplit *monitoring.Uint
 
+	// Number of batches sent in multiple requests to stay under the
+	// request size limit
+	batchesPreSplit *monitoring.Uint
+
 	//
 	// Output network connection stats
 	//
@@ -83,8 +136,40 @@ type Stats struct {
 
 	sendLatencyLifetimeMillis metrics.Sample // output latency in milliseconds for lifetime of connection
 	sendLatencyDeltaMillis    metrics.Sample // output latency in milliseconds, cleared each time "Visit" is used to report the metric
+
+	bulkLatencyMillis metrics.Sample   // bulk request latency in milliseconds, including failed requests
+	bulkInFlight      *monitoring.Uint // (gauge) bulk requests currently in flight
+
+	bulkUncompressedBytes metrics.Sample // bulk request body size in bytes, before compression
+	bulkCompressedBytes   metrics.Sample // bulk request body size in bytes, as sent
+
+	bulkCompressionRatio *monitoring.Float // (gauge) compressed to uncompressed body size ratio of the last compressed bulk request
+
+	// Encoded document size stats over the most recently encoded documents.
+	docSize    *sizeWindow
+	docSizeAvg *monitoring.Uint // (gauge) average encoded document size in bytes
+	docSizeMax *monitoring.Uint // (gauge) largest encoded document size in bytes
+
+	// Per index event stats, registered under indices in reg when an
+	// index is first reported. They are keyed by their registry name.
+	reg        *monitoring.Registry
+	indicesMu  sync.Mutex
+	indicesReg *monitoring.Registry
+	indices    map[string]*indexStats
+}
+
+// indexStats holds the event stats of an index.
+type indexStats struct {
+	acked   *monitoring.Uint
+	failed  *monitoring.Uint
+	dropped *monitoring.Uint
+	tooMany *monitoring.Uint
 }
 
+// docSizeWindowLen is the number of documents the document size
+// metrics are computed over.
+const docSizeWindowLen = 1024
+
 // NewStats creates a new Stats instance using a backing monitoring registry.
 // This function will create and register a number of metrics with the registry passed.
 // The registry must not be null.
@@ -99,9 +184,21 @@ func NewStats(reg *monitoring.Registry, logger *logp.Logger) *Stats {
 		eventsDuplicates:   monitoring.NewUint(reg, "events.duplicates"),
 		eventsActive:       monitoring.NewUint(reg, "events.active"),
 		eventsTooMany:      monitoring.NewUint(reg, "events.toomany"),
+		circuitOpen:        monitoring.NewBool(reg, "circuit_breaker.open"),
 		eventsFailureStore: monitoring.NewUint(reg, "events.failure_store"),
-
-		batchesSplit: monitoring.NewUint(reg, "batches.split"),
+		eventsNoop:         monitoring.NewUint(reg, "events.noop"),
+		eventsAuditAcked:   monitoring.NewUint(reg, "events.audit.acked"),
+		eventsAuditFailed:  monitoring.NewUint(reg, "events.audit.failed"),
+		eventsSuperseded:   monitoring.NewUint(reg, "events.superseded"),
+		eventsNotAllowed:   monitoring.NewUint(reg, "events.not_allowed"),
+		eventsIndexEmpty:   monitoring.NewUint(reg, "events.index_empty"),
+		eventsTooComplex:   monitoring.NewUint(reg, "events.too_complex"),
+		eventsExpired:      monitoring.NewUint(reg, "events.expired"),
+		eventsTooLarge:     monitoring.NewUint(reg, "events.too_large"),
+		eventsWouldSend:    monitoring.NewUint(reg, "events.would_send"),
+
+		batchesSplit:    monitoring.NewUint(reg, "batches.split"),
+		batchesPreSplit: monitoring.NewUint(reg, "batches.presplit"),
 
 		writeBytes:  monitoring.NewUint(reg, "write.bytes"),
 		writeErrors: monitoring.NewUint(reg, "write.errors"),
@@ -111,9 +208,26 @@ func NewStats(reg *monitoring.Registry, logger *logp.Logger) *Stats {
 
 		sendLatencyLifetimeMillis: metrics.NewUniformSample(1024),
 		sendLatencyDeltaMillis:    metrics.NewUniformSample(1024),
+
+		bulkLatencyMillis: metrics.NewUniformSample(1024),
+		bulkInFlight:      monitoring.NewUint(reg, "bulk_requests.in_flight"),
+
+		bulkUncompressedBytes: metrics.NewUniformSample(1024),
+		bulkCompressedBytes:   metrics.NewUniformSample(1024),
+
+		bulkCompressionRatio: monitoring.NewFloat(reg, "bulk_requests.compression_ratio"),
+
+		docSize:    newSizeWindow(docSizeWindowLen),
+		docSizeAvg: monitoring.NewUint(reg, "events.doc_size.avg"),
+		docSizeMax: monitoring.NewUint(reg, "events.doc_size.max"),
+
+		reg: reg,
 	}
 	_ = adapter.NewGoMetrics(reg, "write.latency", logger, adapter.Accept).Register("histogram", metrics.NewHistogram(obj.sendLatencyLifetimeMillis))
 	_ = adapter.NewGoMetrics(reg, "write.latency_delta", logger, adapter.Accept).Register("histogram", adapter.NewClearOnVisitHistogram(obj.sendLatencyDeltaMillis))
+	_ = adapter.NewGoMetrics(reg, "bulk_requests.latency", logger, adapter.Accept).Register("histogram", metrics.NewHistogram(obj.bulkLatencyMillis))
+	_ = adapter.NewGoMetrics(reg, "bulk_requests.bytes.uncompressed", logger, adapter.Accept).Register("histogram", metrics.NewHistogram(obj.bulkUncompressedBytes))
+	_ = adapter.NewGoMetrics(reg, "bulk_requests.bytes.compressed", logger, adapter.Accept).Register("histogram", metrics.NewHistogram(obj.bulkCompressedBytes))
 	return obj
 }
 
@@ -131,6 +245,13 @@ func (s *Stats) ReportLatency(time time.Duration) {
 	s.sendLatencyDeltaMillis.Update(time.Milliseconds())
 }
 
+// BulkLatency updates the bulk request latency histogram.
+func (s *Stats) BulkLatency(d time.Duration) {
+	if s != nil {
+		s.bulkLatencyMillis.Update(d.Milliseconds())
+	}
+}
+
 // AckedEvents updates active and acked event metrics.
 func (s *Stats) AckedEvents(n int) {
 	if s != nil {
@@ -180,6 +301,14 @@ func (s *Stats) BatchSplit() {
 	}
 }
 
+// BatchPreSplit updates the number of batches sent in multiple requests to
+// stay under the request size limit.
+func (s *Stats) BatchPreSplit() {
+	if s != nil {
+		s.batchesPreSplit.Inc()
+	}
+}
+
 // ErrTooMany updates the number of Too Many Requests responses reported by the output.
 func (s *Stats) ErrTooMany(n int) {
 	if s != nil {
@@ -187,6 +316,37 @@ func (s *Stats) ErrTooMany(n int) {
 	}
 }
 
+// CircuitOpen updates whether the output's circuit breaker is open.
+func (s *Stats) CircuitOpen(open bool) {
+	if s != nil {
+		s.circuitOpen.Set(open)
+	}
+}
+
+// BulkSize updates the bulk request body size histograms. For requests
+// that are not compressed, both sizes are the same.
+func (s *Stats) BulkSize(uncompressed, compressed int) {
+	if s != nil {
+		s.bulkUncompressedBytes.Update(int64(uncompressed))
+		s.bulkCompressedBytes.Update(int64(compressed))
+	}
+}
+
+// BulkCompressionRatio updates the compression ratio of bulk requests,
+// the compressed size of a request body divided by its uncompressed size.
+func (s *Stats) BulkCompressionRatio(ratio float64) {
+	if s != nil {
+		s.bulkCompressionRatio.Set(ratio)
+	}
+}
+
+// BulkInFlight updates the number of bulk requests in flight.
+func (s *Stats) BulkInFlight(n int) {
+	if s != nil {
+		s.bulkInFlight.Set(uint64(n)) //nolint:gosec //number of requests is never negative
+	}
+}
+
 // WriteError increases the write I/O error metrics.
 func (s *Stats) WriteError(err error) {
 	if s != nil {
@@ -220,3 +380,165 @@ func (s *Stats) FailureStoreEvents(n int) {
 		s.eventsFailureStore.Add(uint64(n)) //nolint:gosec //num events is never negative
 	}
 }
+
+// NoopEvents updates the number of events acknowledged with a noop result.
+func (s *Stats) NoopEvents(n int) {
+	if s != nil {
+		s.eventsNoop.Add(uint64(n)) //nolint:gosec //num events is never negative
+	}
+}
+
+// AuditEvents updates the number of audit copies of events that were
+// created and that failed to be created.
+func (s *Stats) AuditEvents(acked, failed int) {
+	if s != nil {
+		s.eventsAuditAcked.Add(uint64(acked))   //nolint:gosec //num events is never negative
+		s.eventsAuditFailed.Add(uint64(failed)) //nolint:gosec //num events is never negative
+	}
+}
+
+// SupersededEvents updates the number of events that were not sent or not
+// retried because a later event writes the same document.
+func (s *Stats) SupersededEvents(n int) {
+	if s != nil {
+		s.eventsSuperseded.Add(uint64(n)) //nolint:gosec //num events is never negative
+	}
+}
+
+// IndexNotAllowed updates the number of events whose target index is not
+// allowed by the output configuration.
+func (s *Stats) IndexNotAllowed(n int) {
+	if s != nil {
+		s.eventsNotAllowed.Add(uint64(n)) //nolint:gosec //num events is never negative
+	}
+}
+
+// IndexEmpty updates the number of events for which the index selection
+// yielded an empty index name.
+func (s *Stats) IndexEmpty(n int) {
+	if s != nil {
+		s.eventsIndexEmpty.Add(uint64(n)) //nolint:gosec //num events is never negative
+	}
+}
+
+// EventTooComplex updates the number of events exceeding the configured
+// complexity limits.
+func (s *Stats) EventTooComplex(n int) {
+	if s != nil {
+		s.eventsTooComplex.Add(uint64(n)) //nolint:gosec //num events is never negative
+	}
+}
+
+// ExpiredEvents updates the number of events older than the configured
+// maximum age.
+func (s *Stats) ExpiredEvents(n int) {
+	if s != nil {
+		s.eventsExpired.Add(uint64(n)) //nolint:gosec //num events is never negative
+	}
+}
+
+// WouldSendEvents updates active and would send event metrics.
+func (s *Stats) WouldSendEvents(n int) {
+	if s != nil {
+		s.eventsWouldSend.Add(uint64(n)) //nolint:gosec //num events is never negative
+		s.eventsActive.Sub(uint64(n))    //nolint:gosec //num events is never negative
+	}
+}
+
+// EventTooLarge updates the number of events too large to be ingested on
+// their own.
+func (s *Stats) EventTooLarge(n int) {
+	if s != nil {
+		s.eventsTooLarge.Add(uint64(n)) //nolint:gosec //num events is never negative
+	}
+}
+
+// DocumentSize updates the sliding window document size metrics with the
+// size of an encoded document.
+func (s *Stats) DocumentSize(n int) {
+	if s != nil {
+		avg, maxSize := s.docSize.add(n)
+		s.docSizeAvg.Set(avg)
+		s.docSizeMax.Set(maxSize)
+	}
+}
+
+// IndexEvents updates the event metrics of an index. The metrics are
+// registered under indices.<index> when the index is first reported. Dots
+// in the index name are replaced with underscores, as they separate the
+// levels of the registry, so indices whose names only differ by dots and
+// underscores share their metrics.
+func (s *Stats) IndexEvents(index string, acked, failed, dropped, tooMany int) {
+	if s == nil {
+		return
+	}
+	name := strings.ReplaceAll(index, ".", "_")
+	s.indicesMu.Lock()
+	stats, ok := s.indices[name]
+	if !ok {
+		if s.indices == nil {
+			s.indices = make(map[string]*indexStats)
+			s.indicesReg = s.reg.NewRegistry("indices")
+		}
+		reg := s.indicesReg.NewRegistry(name)
+		stats = &indexStats{
+			acked:   monitoring.NewUint(reg, "events.acked"),
+			failed:  monitoring.NewUint(reg, "events.failed"),
+			dropped: monitoring.NewUint(reg, "events.dropped"),
+			tooMany: monitoring.NewUint(reg, "events.toomany"),
+		}
+		s.indices[name] = stats
+	}
+	s.indicesMu.Unlock()
+
+	stats.acked.Add(uint64(acked))     //nolint:gosec //num events is never negative
+	stats.failed.Add(uint64(failed))   //nolint:gosec //num events is never negative
+	stats.dropped.Add(uint64(dropped)) //nolint:gosec //num events is never negative
+	stats.tooMany.Add(uint64(tooMany)) //nolint:gosec //num events is never negative
+}
+
+// sizeWindow tracks the average and maximum of the last n sizes added.
+type sizeWindow struct {
+	mu    sync.Mutex
+	sizes []uint64
+	next  int    // index of the next slot to write
+	count int    // number of valid entries in sizes
+	sum   uint64 // sum of valid entries in sizes
+	max   uint64 // largest valid entry in sizes
+}
+
+func newSizeWindow(n int) *sizeWindow {
+	return &sizeWindow{sizes: make([]uint64, n)}
+}
+
+// add records size in the window, evicting the oldest entry if the window
+// is full, and returns the average and maximum size in the window.
+func (w *sizeWindow) add(size int) (avg, maxSize uint64) {
+	v := uint64(size) //nolint:gosec // document sizes are never negative
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	evicted := w.sizes[w.next]
+	if w.count == len(w.sizes) {
+		w.sum -= evicted
+	} else {
+		w.count++
+		evicted = 0
+	}
+	w.sizes[w.next] = v
+	w.sum += v
+	w.next = (w.next + 1) % len(w.sizes)
+
+	switch {
+	case v >= w.max:
+		w.max = v
+	case evicted == w.max:
+		// The largest entry has left the window, so find the new one.
+		w.max = 0
+		for _, s := range w.sizes[:w.count] {
+			w.max = max(w.max, s)
+		}
+	}
+	return w.sum / uint64(w.count), w.max //nolint:gosec // count is never negative
+}
@@ -0,0 +1,47 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outputs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestIndexEventsSanitizedNameCollision(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	stats := NewStats(reg, logp.NewNopLogger())
+
+	// Both indices are registered as indices.logs_app, which must not
+	// panic and must report the events of both.
+	require.NotPanics(t, func() {
+		stats.IndexEvents("logs.app", 1, 0, 0, 0)
+		stats.IndexEvents("logs_app", 2, 1, 0, 0)
+	})
+
+	acked, ok := reg.Get("indices.logs_app.events.acked").(*monitoring.Uint)
+	require.True(t, ok, "acked events of logs_app should be registered")
+	assert.Equal(t, uint64(3), acked.Get())
+	failed, ok := reg.Get("indices.logs_app.events.failed").(*monitoring.Uint)
+	require.True(t, ok, "failed events of logs_app should be registered")
+	assert.Equal(t, uint64(1), failed.Get())
+}
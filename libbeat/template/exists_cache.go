@@ -0,0 +1,88 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package template
+
+import (
+	"sync"
+	"time"
+)
+
+// ExistsCache remembers for a limited time which templates and data streams
+// are known to exist, so that loading the template again, for example on
+// every reconnect of the output, doesn't check their existence each time.
+//
+// Only positive results are cached: a missing template or data stream is
+// created right after the check, so it is checked again on the next load.
+// A nil *ExistsCache is valid and caches nothing.
+type ExistsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	expires map[string]time.Time
+
+	now func() time.Time
+}
+
+// NewExistsCache creates a cache keeping existence results for ttl. A ttl of
+// 0 disables caching.
+func NewExistsCache(ttl time.Duration) *ExistsCache {
+	return &ExistsCache{ttl: ttl, expires: map[string]time.Time{}, now: time.Now}
+}
+
+// SetTTL updates how long existence results are kept. Cached results are
+// discarded if the ttl changes.
+func (c *ExistsCache) SetTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl != c.ttl {
+		c.ttl = ttl
+		clear(c.expires)
+	}
+}
+
+// exists reports whether the resource at path is known to exist.
+func (c *ExistsCache) exists(path string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.expires[path]
+	if !ok {
+		return false
+	}
+	if !c.now().Before(expires) {
+		delete(c.expires, path)
+		return false
+	}
+	return true
+}
+
+// markExists records that the resource at path exists.
+func (c *ExistsCache) markExists(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 {
+		c.expires[path] = c.now().Add(c.ttl)
+	}
+}
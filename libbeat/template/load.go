@@ -42,6 +42,7 @@ type ESLoader struct {
 	client          ESClient
 	lifecycleClient lifecycle.ClientHandler
 	builder         *templateBuilder
+	existsCache     *ExistsCache
 	log             *logp.Logger
 }
 
@@ -76,13 +77,15 @@ type templateBuilder struct {
 	beatPaths    *paths.Path
 }
 
-// NewESLoader creates a new template loader for ES
-func NewESLoader(client ESClient, lifecycleClient lifecycle.ClientHandler, logger *logp.Logger, beatPaths *paths.Path) (*ESLoader, error) {
+// NewESLoader creates a new template loader for ES. If existsCache is not
+// nil, it is used to skip checking the existence of templates and data
+// streams that were recently found to exist.
+func NewESLoader(client ESClient, lifecycleClient lifecycle.ClientHandler, existsCache *ExistsCache, logger *logp.Logger, beatPaths *paths.Path) (*ESLoader, error) {
 	if client == nil {
 		return nil, errors.New("can not load template without active Elasticsearch client")
 	}
 	return &ESLoader{
-		client: client, lifecycleClient: lifecycleClient,
+		client: client, lifecycleClient: lifecycleClient, existsCache: existsCache,
 		builder: newTemplateBuilder(client.IsServerless(), logger, beatPaths), log: logger.Named("template_loader"),
 	}, nil
 }
@@ -184,11 +187,16 @@ func (l *ESLoader) loadTemplate(templateName string, template map[string]any) er
 	if status > http.StatusMultipleChoices { // http status 300
 		return fmt.Errorf("couldn't load json. Status: %v", status)
 	}
+	l.existsCache.markExists(path)
 	return nil
 }
 
 func (l *ESLoader) checkExistsDatastream(name string) (bool, error) {
-	status, _, err := l.client.Request("GET", "/_data_stream/"+name, "", nil, nil)
+	path := "/_data_stream/" + name
+	if l.existsCache.exists(path) {
+		return true, nil
+	}
+	status, _, err := l.client.Request("GET", path, "", nil, nil)
 	if status == http.StatusNotFound {
 		return false, nil
 	}
@@ -196,6 +204,7 @@ func (l *ESLoader) checkExistsDatastream(name string) (bool, error) {
 		return false, err
 	}
 
+	l.existsCache.markExists(path)
 	return true, nil
 }
 
@@ -206,6 +215,7 @@ func (l *ESLoader) putDataStream(name string) error {
 	if err != nil {
 		return fmt.Errorf("could not put data stream: %w. Response body: %s", err, body)
 	}
+	l.existsCache.markExists(path)
 	return nil
 }
 
@@ -215,7 +225,11 @@ func (l *ESLoader) putDataStream(name string) error {
 // An error is returned if the loader failed to execute the request, or a
 // status code indicating some problems is encountered.
 func (l *ESLoader) checkExistsTemplate(name string) (bool, error) {
-	status, _, err := l.client.Request("HEAD", "/_index_template/"+name, "", nil, nil)
+	path := "/_index_template/" + name
+	if l.existsCache.exists(path) {
+		return true, nil
+	}
+	status, _, err := l.client.Request("HEAD", path, "", nil, nil)
 	if status == http.StatusNotFound {
 		return false, nil
 	}
@@ -223,6 +237,7 @@ func (l *ESLoader) checkExistsTemplate(name string) (bool, error) {
 		return false, err
 	}
 
+	l.existsCache.markExists(path)
 	return true, nil
 }
 
@@ -72,7 +72,7 @@ func newTestSetup(t *testing.T, cfg TemplateConfig) *testSetup {
 	}
 	handler := &mockClientHandler{serverless: false, mode: lifecycle.ILM}
 	logger := logptest.NewTestingLogger(t, "")
-	loader, err := NewESLoader(client, handler, logger, paths.New())
+	loader, err := NewESLoader(client, handler, nil, logger, paths.New())
 	require.NoError(t, err)
 	s := testSetup{t: t, client: client, loader: loader, config: cfg}
 	// don't care if the cleanup fails, since they might just return a 404
@@ -90,7 +90,7 @@ func newTestSetupWithESClient(t *testing.T, client ESClient, cfg TemplateConfig)
 	}
 	handler := &mockClientHandler{serverless: false, mode: lifecycle.ILM}
 	logger := logptest.NewTestingLogger(t, "")
-	loader, err := NewESLoader(client, handler, logger, paths.New())
+	loader, err := NewESLoader(client, handler, nil, logger, paths.New())
 	require.NoError(t, err)
 	return &testSetup{t: t, client: client, loader: loader, config: cfg}
 }
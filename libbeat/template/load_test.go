@@ -20,7 +20,9 @@ package template
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/elastic-agent-libs/logp/logptest"
@@ -259,6 +261,65 @@ func TestFileLoader_Load(t *testing.T) {
 	}
 }
 
+func TestESLoader_LoadExistsCache(t *testing.T) {
+	ver := "9.0.0"
+	info := beat.Info{Beat: "mock", Version: ver, IndexPrefix: "mock"}
+	tmplName := "mock-" + ver
+	logger := logptest.NewTestingLogger(t, "")
+
+	now := time.Now()
+	cache := NewExistsCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	client := newESClient(ver)
+	loader, err := NewESLoader(client, nil, cache, logger, paths.New())
+	require.NoError(t, err)
+
+	cfg := DefaultConfig(info)
+	checks := func() int {
+		return client.requests["HEAD /_index_template/"+tmplName]
+	}
+
+	require.NoError(t, loader.Load(cfg, info, nil, false))
+	assert.Equal(t, 1, checks(), "the template existence should be checked on the first load")
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, loader.Load(cfg, info, nil, false))
+	assert.Equal(t, 1, checks(), "the template existence should be cached within the ttl")
+
+	now = now.Add(time.Minute)
+	require.NoError(t, loader.Load(cfg, info, nil, false))
+	assert.Equal(t, 2, checks(), "the template existence should be checked again after the ttl")
+
+	cache.SetTTL(0)
+	require.NoError(t, loader.Load(cfg, info, nil, false))
+	require.NoError(t, loader.Load(cfg, info, nil, false))
+	assert.Equal(t, 4, checks(), "the template existence should be checked on every load with caching disabled")
+}
+
+// esClient is an ESClient for which all templates and data streams exist.
+type esClient struct {
+	ver      string
+	requests map[string]int
+}
+
+func newESClient(ver string) *esClient {
+	return &esClient{ver: ver, requests: map[string]int{}}
+}
+
+func (c *esClient) Request(method, path string, _ string, _ map[string]string, _ any) (int, []byte, error) {
+	c.requests[method+" "+path]++
+	return http.StatusOK, nil, nil
+}
+
+func (c *esClient) GetVersion() version.V {
+	return *version.MustNew(c.ver)
+}
+
+func (c *esClient) IsServerless() bool {
+	return false
+}
+
 type fileClient struct {
 	component, name, body, ver string
 }
@@ -287,6 +287,10 @@ func (bt *Metricbeat) Run(b *beat.Beat) error {
 			return err
 		}
 
+		if b.API != nil && moduleReloader.ReloadEnabled() {
+			b.API.AddReloader("modules", moduleReloader)
+		}
+
 		go moduleReloader.Run(factory)
 		wg.Go(func() {
 			<-bt.done
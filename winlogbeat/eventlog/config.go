@@ -64,6 +64,7 @@ type config struct {
 	NoMoreEvents         NoMoreEventsAction `config:"no_more_events"` // Action to take when no more events are available - wait or stop.
 	EventLanguage        uint32             `config:"language"`
 	IgnoreMissingChannel *bool              `config:"ignore_missing_channel"` // Ignore missing channels and continue reading.
+	ErrorCooldown        time.Duration      `config:"error_cooldown"`         // Wait before reopening after a repeated recoverable error.
 }
 
 // query contains parameters used to customize the event log data that is
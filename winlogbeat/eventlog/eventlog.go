@@ -60,6 +60,11 @@ type EventLog interface {
 
 	// IgnoreMissingChannel returns true if missing channels should be ignored.
 	IgnoreMissingChannel() bool
+
+	// ErrorCooldown returns how long to wait before reopening the event log
+	// after a recoverable error that has the same code as one that occurred
+	// less than this long ago. Zero disables the cooldown.
+	ErrorCooldown() time.Duration
 }
 
 // Record represents a single event from the log.
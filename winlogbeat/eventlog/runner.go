@@ -24,6 +24,8 @@ import (
 	"io"
 	"math"
 	"runtime"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/elastic/beats/v7/libbeat/management/status"
@@ -128,12 +130,17 @@ func Run(
 		return true
 	})
 
+	cooldown := newErrorCooldown(log, api.ErrorCooldown())
+
 	currentCheckpoint := evtCheckpoint
 runLoop:
 	for cancelCtx.Err() == nil {
 		openErr := api.Open(currentCheckpoint, metricsRegistry)
 		if openErr != nil {
 			if openErrHandler.backoff(cancelCtx, openErr) {
+				if !cooldown.wait(cancelCtx, openErr) {
+					break runLoop
+				}
 				continue runLoop
 			}
 			if cancelCtx.Err() != nil {
@@ -171,6 +178,9 @@ runLoop:
 				}
 
 				if readErrHandler.backoff(cancelCtx, readErr) {
+					if !cooldown.wait(cancelCtx, readErr) {
+						break runLoop
+					}
 					continue runLoop
 				}
 
@@ -180,6 +190,8 @@ runLoop:
 				return readErr
 			}
 
+			cooldown.reset()
+
 			if len(records) == 0 {
 				_ = timed.Wait(cancelCtx, time.Second)
 				continue
@@ -228,3 +240,59 @@ func (b *exponentialLimitedBackoff) backoff(ctx context.Context, err error) bool
 func (b *exponentialLimitedBackoff) reset() {
 	b.currentDelay = b.initialDelay
 }
+
+// errorCooldown delays reopening the event log when the same recoverable
+// error code occurs repeatedly in quick succession.
+type errorCooldown struct {
+	log    *logp.Logger
+	period time.Duration
+	seen   map[string]time.Time // time each error code last occurred
+
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+func newErrorCooldown(log *logp.Logger, period time.Duration) *errorCooldown {
+	return &errorCooldown{
+		log:    log,
+		period: period,
+		seen:   make(map[string]time.Time),
+		now:    time.Now,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			return timed.Wait(ctx, d)
+		},
+	}
+}
+
+// wait waits for the cooldown period if err has the same code as an error
+// that occurred less than a period ago. It returns false if ctx is done
+// before the period is over.
+func (c *errorCooldown) wait(ctx context.Context, err error) bool {
+	if c.period <= 0 {
+		return true
+	}
+	code := errorCode(err)
+	if last, ok := c.seen[code]; ok && c.now().Sub(last) < c.period {
+		c.log.Debugw("recoverable error repeated within cooldown, waiting before reopening", "error", err, "cooldown", c.period)
+		if c.sleep(ctx, c.period) != nil {
+			return false
+		}
+	}
+	c.seen[code] = c.now()
+	return true
+}
+
+// reset forgets the errors that occurred, after a successful read.
+func (c *errorCooldown) reset() {
+	clear(c.seen)
+}
+
+// errorCode returns the code identifying err: its Windows error code if it
+// has one, or its message otherwise.
+func errorCode(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return strconv.FormatUint(uint64(errno), 10)
+	}
+	return err.Error()
+}
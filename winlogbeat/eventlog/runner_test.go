@@ -20,8 +20,10 @@ package eventlog
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -169,6 +171,10 @@ func (l *replayingGapEventLog) IgnoreMissingChannel() bool {
 	return false
 }
 
+func (l *replayingGapEventLog) ErrorCooldown() time.Duration {
+	return 0
+}
+
 // TestRunClosesEventLogWithoutRacingRead verifies that when the runner's
 // context is cancelled while a Read is in progress, the event log is not
 // closed until that Read has returned. Closing the event log frees native
@@ -275,6 +281,76 @@ func (l *concurrencyProbeEventLog) Channel() string            { return "System"
 func (l *concurrencyProbeEventLog) IsFile() bool               { return false }
 func (l *concurrencyProbeEventLog) IgnoreMissingChannel() bool { return false }
 
+func (l *concurrencyProbeEventLog) ErrorCooldown() time.Duration { return 0 }
+
+func TestErrorCooldown(t *testing.T) {
+	const period = 10 * time.Second
+	errOther := errors.New("other error")
+
+	newCooldown := func() (*errorCooldown, *time.Time, *[]time.Duration) {
+		now := time.Unix(0, 0)
+		var waits []time.Duration
+		c := newErrorCooldown(logp.NewLogger("eventlog_runner_test"), period)
+		c.now = func() time.Time { return now }
+		c.sleep = func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			now = now.Add(d)
+			return nil
+		}
+		return c, &now, &waits
+	}
+
+	t.Run("waits for rapid repeats of the same code", func(t *testing.T) {
+		c, now, waits := newCooldown()
+
+		require.True(t, c.wait(context.Background(), syscall.Errno(1717)))
+		require.Empty(t, *waits, "the first occurrence of a code must not wait")
+
+		*now = now.Add(time.Second)
+		require.True(t, c.wait(context.Background(), fmt.Errorf("reading: %w", syscall.Errno(1717))))
+		require.Equal(t, []time.Duration{period}, *waits, "a repeat within the cooldown must wait")
+
+		*now = now.Add(time.Second)
+		require.True(t, c.wait(context.Background(), errOther))
+		require.Len(t, *waits, 1, "a different code must not wait")
+
+		*now = now.Add(period)
+		require.True(t, c.wait(context.Background(), syscall.Errno(1717)))
+		require.Len(t, *waits, 1, "a repeat after the cooldown must not wait")
+	})
+
+	t.Run("successful read resets the cooldown", func(t *testing.T) {
+		c, now, waits := newCooldown()
+
+		require.True(t, c.wait(context.Background(), syscall.Errno(1717)))
+		c.reset()
+		*now = now.Add(time.Second)
+		require.True(t, c.wait(context.Background(), syscall.Errno(1717)))
+		require.Empty(t, *waits, "a repeat after a successful read must not wait")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c, _, waits := newCooldown()
+		c.period = 0
+
+		require.True(t, c.wait(context.Background(), syscall.Errno(1717)))
+		require.True(t, c.wait(context.Background(), syscall.Errno(1717)))
+		require.Empty(t, *waits)
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		c, _, _ := newCooldown()
+		c.sleep = func(ctx context.Context, _ time.Duration) error {
+			return ctx.Err()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		require.True(t, c.wait(ctx, syscall.Errno(1717)))
+		require.False(t, c.wait(ctx, syscall.Errno(1717)))
+	})
+}
+
 type noOpPublisher struct{}
 
 func (noOpPublisher) Publish([]Record) error {
@@ -26,6 +26,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/sys/windows"
 
@@ -230,6 +231,11 @@ func (l *winEventLog) IgnoreMissingChannel() bool {
 	return !l.file && (l.config.IgnoreMissingChannel == nil || *l.config.IgnoreMissingChannel)
 }
 
+// ErrorCooldown returns the cooldown applied to repeated recoverable errors.
+func (l *winEventLog) ErrorCooldown() time.Duration {
+	return l.config.ErrorCooldown
+}
+
 func (l *winEventLog) Open(state checkpoint.EventLogState, metricsRegistry *monitoring.Registry) error {
 	l.lastRead = state
 	// we need to defer metrics initialization since when the event log
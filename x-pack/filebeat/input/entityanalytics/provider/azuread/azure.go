@@ -116,6 +116,7 @@ func (p *azure) Run(inputCtx v2.Context, store *kvstore.Store, client beat.Clien
 	p.fetcher.SetLogger(p.logger)
 
 	p.metrics = newMetrics(inputCtx.MetricsRegistry, inputCtx.Logger)
+	p.fetcher.SetMetrics(&p.metrics.sweeps)
 
 	lastSyncTime, _ := getLastSync(store)
 	syncWaitTime := time.Until(lastSyncTime.Add(p.conf.SyncInterval))
@@ -209,7 +210,7 @@ func (p *azure) runFullSync(inputCtx v2.Context, store *kvstore.Store, client be
 
 	wantUsers := p.conf.wantUsers()
 	wantDevices := p.conf.wantDevices()
-	if (len(state.users) != 0 && wantUsers) || (len(state.devices) != 0 && wantDevices) {
+	if (len(state.users) != 0 && wantUsers) || (len(state.devices) != 0 && wantDevices) || len(state.userParseErrors) != 0 {
 		tracker := kvstore.NewTxTracker(ctx)
 
 		start := time.Now()
@@ -221,6 +222,9 @@ func (p *azure) runFullSync(inputCtx v2.Context, store *kvstore.Store, client be
 				p.publishUser(u, state, inputCtx.ID, client, tracker)
 			}
 		}
+		for _, u := range state.userParseErrors {
+			p.publishUserParseError(u, inputCtx.ID, client, tracker)
+		}
 
 		if len(state.devices) != 0 && wantDevices {
 			p.logger.Debugw("publishing devices", "count", len(state.devices))
@@ -269,9 +273,13 @@ func (p *azure) runIncrementalUpdate(inputCtx v2.Context, store *kvstore.Store,
 		return err
 	}
 
-	if updatedUsers.Len() != 0 || updatedDevices.Len() != 0 {
+	if updatedUsers.Len() != 0 || updatedDevices.Len() != 0 || len(state.userParseErrors) != 0 {
 		tracker := kvstore.NewTxTracker(ctx)
 
+		for _, u := range state.userParseErrors {
+			p.publishUserParseError(u, inputCtx.ID, client, tracker)
+		}
+
 		if updatedUsers.Len() != 0 {
 			updatedUsers.ForEach(func(id uuid.UUID) {
 				u, ok := state.users[id]
@@ -366,6 +374,10 @@ func (p *azure) doFetch(ctx context.Context, state *stateStore, fullSync bool) (
 	state.groupsLink = groupLink
 
 	for _, v := range changedUsers {
+		if v.ParseError != "" {
+			state.userParseErrors = append(state.userParseErrors, v)
+			continue
+		}
 		updatedUsers.Add(v.ID)
 		state.storeUser(v)
 	}
@@ -377,6 +389,10 @@ func (p *azure) doFetch(ctx context.Context, state *stateStore, fullSync bool) (
 		state.storeGroup(v)
 	}
 
+	if p.metrics != nil {
+		p.metrics.deletedMembers.Add(countDeletedMembers(changedGroups))
+	}
+
 	// Populate group relationships tree.
 	for _, g := range changedGroups {
 		if g.Deleted {
@@ -528,6 +544,20 @@ func (p *azure) doFetch(ctx context.Context, state *stateStore, fullSync bool) (
 	return updatedUsers, updatedDevices, nil
 }
 
+// countDeletedMembers returns the number of members of groups that were
+// removed from their group.
+func countDeletedMembers(groups []*fetcher.Group) uint64 {
+	var n uint64
+	for _, g := range groups {
+		for _, m := range g.Members {
+			if m.Deleted {
+				n++
+			}
+		}
+	}
+	return n
+}
+
 // publishMarker will publish a write marker document using the given beat.Client.
 // If start is true, then it will be a start marker, otherwise an end marker.
 func (p *azure) publishMarker(ts, eventTime time.Time, inputID string, start bool, client beat.Client, tracker *kvstore.TxTracker) {
@@ -606,6 +636,28 @@ func (p *azure) publishUser(u *fetcher.User, state *stateStore, inputID string,
 	client.Publish(event)
 }
 
+// publishUserParseError will publish the document of a user that could not
+// be parsed using the given beat.Client.
+func (p *azure) publishUserParseError(u *fetcher.User, inputID string, client beat.Client, tracker *kvstore.TxTracker) {
+	userDoc := mapstr.M{}
+
+	_, _ = userDoc.Put("azure_ad", u.Fields)
+	_, _ = userDoc.Put("labels.identity_source", inputID)
+	_, _ = userDoc.Put("event.action", "user-parse-error")
+	_, _ = userDoc.Put("error.message", u.ParseError)
+
+	event := beat.Event{
+		Timestamp: time.Now(),
+		Fields:    userDoc,
+		Private:   tracker,
+	}
+	tracker.Add()
+
+	p.logger.Debugw("Publishing user with parse error", "error", u.ParseError)
+
+	client.Publish(event)
+}
+
 // publishDevice will publish a device document using the given beat.Client.
 func (p *azure) publishDevice(d *fetcher.Device, state *stateStore, inputID string, client beat.Client, tracker *kvstore.TxTracker) {
 	deviceDoc := mapstr.M{}
@@ -7,6 +7,7 @@ package azuread
 import (
 	"context"
 	"fmt"
+	"slices"
 	"testing"
 	"time"
 
@@ -14,8 +15,11 @@ import (
 
 	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/internal/collections"
 	mockauth "github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/azuread/authenticator/mock"
+	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/azuread/fetcher"
 	mockfetcher "github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/azuread/fetcher/mock"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 func TestAzure_DoFetch(t *testing.T) {
@@ -218,3 +222,100 @@ func TestAzure_DoFetch_NoMFAEnrichment(t *testing.T) {
 		require.Nil(t, u.MFA, "expected user %q to have no MFA details when enrich_with is not set", u.ID)
 	}
 }
+
+func TestAzure_DoFetch_DeletedMembers(t *testing.T) {
+	dbFilename := "TestAzure_DoFetch_DeletedMembers.db"
+	store := testSetupStore(t, dbFilename)
+	t.Cleanup(func() {
+		testCleanupStore(store, dbFilename)
+	})
+
+	// Remove some members of the groups returned by the mock fetcher.
+	var groups []*fetcher.Group
+	for _, g := range mockfetcher.GroupResponse {
+		group := *g
+		group.Members = append([]fetcher.Member(nil), g.Members...)
+		groups = append(groups, &group)
+	}
+	groups[0].Members[0].Deleted = true
+	groups[1].Members[1].Deleted = true
+	groups[1].Members[2].Deleted = true
+
+	a := azure{
+		conf:    conf{},
+		logger:  logp.L(),
+		auth:    mockauth.New(""),
+		fetcher: groupsFetcher{Fetcher: mockfetcher.New(), groups: groups},
+		metrics: newMetrics(monitoring.NewRegistry(), logp.NewNopLogger()),
+	}
+
+	ss, err := newStateStore(store)
+	require.NoError(t, err)
+	defer ss.close(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err = a.doFetch(ctx, ss, false)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), a.metrics.deletedMembers.Get(), "deleted members should be counted")
+
+	// Each sweep adds the deleted members it receives.
+	_, _, err = a.doFetch(ctx, ss, false)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), a.metrics.deletedMembers.Get(), "deleted members should be counted on each sweep")
+}
+
+func TestAzure_DoFetch_UserParseErrors(t *testing.T) {
+	dbFilename := "TestAzure_DoFetch_UserParseErrors.db"
+	store := testSetupStore(t, dbFilename)
+	t.Cleanup(func() {
+		testCleanupStore(store, dbFilename)
+	})
+
+	parseError := &fetcher.User{
+		Fields:     mapstr.M{"displayName": "No ID"},
+		ParseError: "user missing required id field",
+	}
+	users := append(slices.Clone(mockfetcher.UserResponse), parseError)
+
+	a := azure{
+		conf:    conf{},
+		logger:  logp.L(),
+		auth:    mockauth.New(""),
+		fetcher: usersFetcher{Fetcher: mockfetcher.New(), users: users},
+	}
+
+	ss, err := newStateStore(store)
+	require.NoError(t, err)
+	defer ss.close(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updatedUsers, _, err := a.doFetch(ctx, ss, false)
+	require.NoError(t, err)
+	require.Equal(t, []*fetcher.User{parseError}, ss.userParseErrors)
+	require.Len(t, ss.users, len(mockfetcher.UserResponse), "users with parse errors should not be stored")
+	require.Equal(t, len(mockfetcher.UserResponse), updatedUsers.Len())
+}
+
+// usersFetcher is a fetcher.Fetcher returning users instead of the
+// users of the embedded fetcher.
+type usersFetcher struct {
+	fetcher.Fetcher
+	users []*fetcher.User
+}
+
+func (f usersFetcher) Users(context.Context, string) ([]*fetcher.User, string, error) {
+	return f.users, mockfetcher.UserDeltaLinkResponse, nil
+}
+
+// groupsFetcher is a fetcher.Fetcher returning groups instead of the
+// groups of the embedded fetcher.
+type groupsFetcher struct {
+	fetcher.Fetcher
+	groups []*fetcher.Group
+}
+
+func (f groupsFetcher) Groups(context.Context, string) ([]*fetcher.Group, string, error) {
+	return f.groups, mockfetcher.GroupDeltaLinkResponse, nil
+}
@@ -49,4 +49,8 @@ type Fetcher interface {
 
 	// SetLogger sets the logger on the Fetcher.
 	SetLogger(logger *logp.Logger)
+
+	// SetMetrics sets the sweep counters on the Fetcher. If m is nil,
+	// sweeps are not counted.
+	SetMetrics(m *Metrics)
 }
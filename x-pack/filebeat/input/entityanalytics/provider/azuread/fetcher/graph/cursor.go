@@ -0,0 +1,129 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/azuread/fetcher"
+)
+
+// Checkpoint is the pagination progress of an interrupted fetch.
+type Checkpoint struct {
+	// Link is the next page to fetch.
+	Link string `json:"link"`
+
+	// Devices holds the devices fetched from the pages before Link. They
+	// are returned together with the devices of the remaining pages when
+	// the fetch is resumed.
+	Devices []*fetcher.Device `json:"devices,omitempty"`
+}
+
+// CursorStore persists pagination progress so that an interrupted fetch
+// can be resumed from the last fully processed page. Checkpoints are keyed
+// by the link the fetch started from.
+type CursorStore interface {
+	// Load returns the checkpoint for the fetch started from key, or a
+	// zero Checkpoint if there is none.
+	Load(key string) (Checkpoint, error)
+
+	// Save stores c as the checkpoint for the fetch started from key.
+	Save(key string, c Checkpoint) error
+
+	// Clear removes the checkpoint for the fetch started from key.
+	Clear(key string) error
+}
+
+// fileCursorStore is a CursorStore that holds checkpoints in a JSON file.
+type fileCursorStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newFileCursorStore(path string) *fileCursorStore {
+	return &fileCursorStore{path: path}
+}
+
+func (s *fileCursorStore) Load(key string) (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursors, err := s.read()
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	return cursors[key], nil
+}
+
+func (s *fileCursorStore) Save(key string, c Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursors, err := s.read()
+	if err != nil {
+		return err
+	}
+	cursors[key] = c
+	return s.write(cursors)
+}
+
+func (s *fileCursorStore) Clear(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursors, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := cursors[key]; !ok {
+		return nil
+	}
+	delete(cursors, key)
+	return s.write(cursors)
+}
+
+func (s *fileCursorStore) read() (map[string]Checkpoint, error) {
+	cursors := make(map[string]Checkpoint)
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cursors, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cursor store: %w", err)
+	}
+	if err = json.Unmarshal(b, &cursors); err != nil {
+		return nil, fmt.Errorf("unable to decode cursor store: %w", err)
+	}
+	return cursors, nil
+}
+
+// write replaces the store file so that a crash while writing does not
+// leave a partially written checkpoint.
+func (s *fileCursorStore) write(cursors map[string]Checkpoint) error {
+	b, err := json.Marshal(cursors)
+	if err != nil {
+		return fmt.Errorf("unable to encode cursor store: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0o750)
+	if err != nil {
+		return fmt.Errorf("unable to create cursor store directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, b, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to write cursor store: %w", err)
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return fmt.Errorf("unable to write cursor store: %w", err)
+	}
+	return nil
+}
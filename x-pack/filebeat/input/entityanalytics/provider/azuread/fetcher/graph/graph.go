@@ -8,6 +8,7 @@
 package graph
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -15,8 +16,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"go.elastic.co/ecszap"
@@ -40,6 +44,7 @@ const (
 
 	queryName           = "$select"
 	defaultGroupsQuery  = "displayName,members"
+	groupPropertyQuery  = "classification,visibility,groupTypes"
 	defaultUsersQuery   = "accountEnabled,userPrincipalName,mail,displayName,givenName,surname,jobTitle,officeLocation,mobilePhone,businessPhones"
 	defaultDevicesQuery = "accountEnabled,deviceId,displayName,operatingSystem,operatingSystemVersion,physicalIds,extensionAttributes,alternativeSecurityIds"
 	expandName          = "$expand"
@@ -48,6 +53,10 @@ const (
 	apiUserType   = "#microsoft.graph.user"
 	apiDeviceType = "#microsoft.graph.device"
 
+	// maxTimeoutRetries is the number of times a request that exceeded
+	// the configured request timeout is retried.
+	maxTimeoutRetries = 3
+
 	mfaDetailsPath     = "/reports/authenticationMethods/userRegistrationDetails"
 	signInActivityPath = "/users"
 )
@@ -122,10 +131,13 @@ type userAPI mapstr.M
 
 // groupAPI matches the format of group data from the API.
 type groupAPI struct {
-	ID           uuid.UUID   `json:"id"`
-	DisplayName  string      `json:"displayName"`
-	MembersDelta []memberAPI `json:"members@delta,omitempty"`
-	Removed      *removed    `json:"@removed,omitempty"`
+	ID             uuid.UUID   `json:"id"`
+	DisplayName    string      `json:"displayName"`
+	Classification string      `json:"classification,omitempty"`
+	Visibility     string      `json:"visibility,omitempty"`
+	GroupTypes     []string    `json:"groupTypes,omitempty"`
+	MembersDelta   []memberAPI `json:"members@delta,omitempty"`
+	Removed        *removed    `json:"@removed,omitempty"`
 }
 
 // deleted returns true if the group has been marked as deleted.
@@ -158,6 +170,31 @@ type graphConf struct {
 	APIEndpoint string    `config:"api_endpoint"`
 	Select      selection `config:"select"`
 	Expand      expansion `config:"expand"`
+	Rename      renaming  `config:"rename"`
+
+	// RemovedEntities specifies whether entities marked as @removed
+	// by the API are returned, "emit", or dropped, "suppress".
+	RemovedEntities string `config:"removed_entities"`
+
+	// MissingUserID specifies how users returned by the API without an
+	// id are handled. They are logged and dropped, "skip", returned with
+	// their parse error, "emit", or fail the fetch, "fail".
+	MissingUserID string `config:"missing_user_id"`
+
+	// CheckpointPages specifies whether device fetch progress is
+	// checkpointed after each page so that an interrupted fetch
+	// resumes from the last fully processed page.
+	CheckpointPages bool `config:"checkpoint_pages"`
+
+	// GroupProperties specifies whether the classification, visibility
+	// and group types of groups are fetched in addition to the selected
+	// group properties.
+	GroupProperties bool `config:"group_properties"`
+
+	// RequestTimeout is the time allowed for each request, including
+	// reading the response. Requests that time out are retried. Zero
+	// disables the timeout.
+	RequestTimeout time.Duration `config:"request_timeout" validate:"min=0"`
 
 	Transport httpcommon.HTTPTransportSettings `config:",inline"`
 
@@ -165,6 +202,31 @@ type graphConf struct {
 	Tracer *tracerConfig `config:"tracer"`
 }
 
+const (
+	removedEmit     = "emit"
+	removedSuppress = "suppress"
+)
+
+const (
+	missingIDSkip = "skip"
+	missingIDEmit = "emit"
+	missingIDFail = "fail"
+)
+
+func (c *graphConf) Validate() error {
+	switch c.RemovedEntities {
+	case "", removedEmit, removedSuppress:
+	default:
+		return fmt.Errorf("invalid removed_entities policy %q: must be %q or %q", c.RemovedEntities, removedEmit, removedSuppress)
+	}
+	switch c.MissingUserID {
+	case "", missingIDSkip, missingIDEmit, missingIDFail:
+	default:
+		return fmt.Errorf("invalid missing_user_id policy %q: must be %q, %q or %q", c.MissingUserID, missingIDSkip, missingIDEmit, missingIDFail)
+	}
+	return nil
+}
+
 type tracerConfig struct {
 	Enabled           *bool `config:"enabled"`
 	lumberjack.Logger `config:",inline"`
@@ -205,6 +267,14 @@ type expansion struct {
 	DeviceExpansion map[string][]string `config:"devices"`
 }
 
+// renaming maps the names of the fields of users and devices returned by
+// the API to the names they are returned with. Groups have no free-form
+// fields to rename.
+type renaming struct {
+	UserFields   map[string]string `config:"users"`
+	DeviceFields map[string]string `config:"devices"`
+}
+
 // graph implements the fetcher.Fetcher interface.
 type graph struct {
 	conf   graphConf
@@ -212,6 +282,12 @@ type graph struct {
 	logger *logp.Logger
 	auth   authenticator.Authenticator
 
+	// cursors, if not nil, holds device pagination checkpoints.
+	cursors CursorStore
+
+	// metrics, if not nil, holds the sweep counters.
+	metrics *fetcher.Metrics
+
 	usersURL           string
 	groupsURL          string
 	devicesURL         string
@@ -220,17 +296,39 @@ type graph struct {
 	signInActivityURL  string
 }
 
+// suppress returns whether an entity should be dropped from the fetch
+// results due to the removed entities policy.
+func (f *graph) suppress(deleted bool) bool {
+	return deleted && f.conf.RemovedEntities == removedSuppress
+}
+
 // SetLogger sets the logger on this fetcher.
 func (f *graph) SetLogger(logger *logp.Logger) {
 	f.logger = logger
 }
 
+// SetMetrics sets the sweep counters on this fetcher. If m is nil, sweeps
+// are not counted.
+func (f *graph) SetMetrics(m *fetcher.Metrics) {
+	f.metrics = m
+}
+
+// SetCursorStore sets the store used to checkpoint device pagination
+// progress. If s is nil, progress is not checkpointed.
+func (f *graph) SetCursorStore(s CursorStore) {
+	f.cursors = s
+}
+
 // Groups retrieves group identity assets from Azure Active Directory using
 // Microsoft's Graph API. If a delta link is given, it will be used to resume
 // from the last query, and only changed groups will be returned. Otherwise,
 // a full list of known groups will be returned. In either case, a new delta link
 // will be returned as well.
 func (f *graph) Groups(ctx context.Context, deltaLink string) ([]*fetcher.Group, string, error) {
+	if f.metrics != nil {
+		f.metrics.Groups.Sweep(deltaLink)
+	}
+
 	fetchURL := f.groupsURL
 	if deltaLink != "" {
 		fetchURL = deltaLink
@@ -254,6 +352,10 @@ func (f *graph) Groups(ctx context.Context, deltaLink string) ([]*fetcher.Group,
 
 		for _, v := range response.Groups {
 			f.logger.Debugf("Got group %q from API", v.ID)
+			if f.suppress(v.deleted()) {
+				f.logger.Debugf("Suppressing removed group %q", v.ID)
+				continue
+			}
 			groups = append(groups, newGroupFromAPI(v))
 		}
 
@@ -277,6 +379,16 @@ func (f *graph) Groups(ctx context.Context, deltaLink string) ([]*fetcher.Group,
 // a full list of known users will be returned. In either case, a new delta link
 // will be returned as well.
 func (f *graph) Users(ctx context.Context, deltaLink string) ([]*fetcher.User, string, error) {
+	if f.metrics != nil {
+		f.metrics.Users.Sweep(deltaLink)
+	}
+	return f.users(ctx, deltaLink)
+}
+
+// users retrieves the users listed at the provided link, or at the base
+// users URL if the link is empty. Unlike Users, it does not count a sweep,
+// so it can be used to follow per-device owner links.
+func (f *graph) users(ctx context.Context, deltaLink string) ([]*fetcher.User, string, error) {
 	var users []*fetcher.User
 
 	fetchURL := f.usersURL
@@ -300,12 +412,28 @@ func (f *graph) Users(ctx context.Context, deltaLink string) ([]*fetcher.User, s
 		_ = body.Close()
 
 		for _, v := range response.Users {
-			user, err := newUserFromAPI(v)
+			user, err := newUserFromAPI(v, f.conf.Rename.UserFields)
+			if errors.Is(err, errMissingUserID) {
+				switch f.conf.MissingUserID {
+				case missingIDFail:
+					return nil, "", fmt.Errorf("unable to parse user from API: %w", err)
+				case missingIDEmit:
+					f.logger.Debugw("Emitting user with parse error from API", "error", err)
+					fields := mapstr.M(v)
+					renameFields(fields, f.conf.Rename.UserFields)
+					users = append(users, &fetcher.User{Fields: fields, ParseError: err.Error()})
+					continue
+				}
+			}
 			if err != nil {
 				f.logger.Errorw("Unable to parse user from API", "error", err)
 				continue
 			}
 			f.logger.Debugf("Got user %q from API", user.ID)
+			if f.suppress(user.Deleted) {
+				f.logger.Debugf("Suppressing removed user %q", user.ID)
+				continue
+			}
 			users = append(users, user)
 		}
 
@@ -328,13 +456,29 @@ func (f *graph) Users(ctx context.Context, deltaLink string) ([]*fetcher.User, s
 // from the last query, and only changed users will be returned. Otherwise,
 // a full list of known users will be returned. In either case, a new delta link
 // will be returned as well.
+//
+// If a cursor store is set, the link to the next page is checkpointed after
+// each page is processed, together with the devices fetched so far, and a
+// fetch starting from the same link resumes from the checkpoint. The
+// resumed fetch returns the checkpointed devices with those of the
+// remaining pages, so that the result is that of an uninterrupted fetch.
 func (f *graph) Devices(ctx context.Context, deltaLink string) ([]*fetcher.Device, string, error) {
+	if f.metrics != nil {
+		f.metrics.Devices.Sweep(deltaLink)
+	}
+
 	var devices []*fetcher.Device
 
 	fetchURL := f.devicesURL
 	if deltaLink != "" {
 		fetchURL = deltaLink
 	}
+	cursorKey := fetchURL
+	if c := f.loadCursor(cursorKey); c.Link != "" {
+		f.logger.Infow("Resuming device fetch from checkpoint", "link", c.Link, "devices", len(c.Devices))
+		fetchURL = c.Link
+		devices = c.Devices
+	}
 
 	for {
 		var response apiDeviceResponse
@@ -352,12 +496,16 @@ func (f *graph) Devices(ctx context.Context, deltaLink string) ([]*fetcher.Devic
 		_ = body.Close()
 
 		for _, v := range response.Devices {
-			device, err := newDeviceFromAPI(v)
+			device, err := newDeviceFromAPI(v, f.conf.Rename.DeviceFields)
 			if err != nil {
 				f.logger.Errorw("Unable to parse device from API", "error", err)
 				continue
 			}
 			f.logger.Debugf("Got device %q from API", device.ID)
+			if f.suppress(device.Deleted) {
+				f.logger.Debugf("Suppressing removed device %q", device.ID)
+				continue
+			}
 
 			f.addRegistered(ctx, device, "registeredOwners", &device.RegisteredOwners)
 			f.addRegistered(ctx, device, "registeredUsers", &device.RegisteredUsers)
@@ -366,6 +514,7 @@ func (f *graph) Devices(ctx context.Context, deltaLink string) ([]*fetcher.Devic
 		}
 
 		if response.DeltaLink != "" {
+			f.clearCursor(cursorKey)
 			return devices, response.DeltaLink, nil
 		}
 		if response.NextLink == fetchURL {
@@ -373,16 +522,49 @@ func (f *graph) Devices(ctx context.Context, deltaLink string) ([]*fetcher.Devic
 		}
 		if response.NextLink != "" {
 			fetchURL = response.NextLink
+			f.saveCursor(cursorKey, Checkpoint{Link: fetchURL, Devices: devices})
 		} else {
 			return devices, "", missingLinkError{"devices"}
 		}
 	}
 }
 
+// loadCursor returns the checkpoint for the fetch starting from key, if
+// any. Cursor store failures are logged and do not fail the fetch.
+func (f *graph) loadCursor(key string) Checkpoint {
+	if f.cursors == nil {
+		return Checkpoint{}
+	}
+	c, err := f.cursors.Load(key)
+	if err != nil {
+		f.logger.Errorw("Unable to load pagination checkpoint", "error", err)
+		return Checkpoint{}
+	}
+	return c
+}
+
+func (f *graph) saveCursor(key string, c Checkpoint) {
+	if f.cursors == nil {
+		return
+	}
+	if err := f.cursors.Save(key, c); err != nil {
+		f.logger.Errorw("Unable to save pagination checkpoint", "error", err)
+	}
+}
+
+func (f *graph) clearCursor(key string) {
+	if f.cursors == nil {
+		return
+	}
+	if err := f.cursors.Clear(key); err != nil {
+		f.logger.Errorw("Unable to clear pagination checkpoint", "error", err)
+	}
+}
+
 // addRegistered adds registered owner or user UUIDs to the provided device.
 func (f *graph) addRegistered(ctx context.Context, device *fetcher.Device, typ string, set *collections.UUIDSet) {
 	usersLink := fmt.Sprintf("%s/%s/%s", f.deviceOwnerUserURL, device.ID, typ) // ID here is the object ID.
-	users, _, err := f.Users(ctx, usersLink)
+	users, _, err := f.users(ctx, usersLink)
 	switch {
 	case err == nil, errors.Is(err, nextLinkLoopError{"users"}), errors.Is(err, missingLinkError{"users"}):
 	default:
@@ -512,8 +694,48 @@ func (f *graph) fetchSignInActivityPage(ctx context.Context, fetchURL string, re
 
 // doRequest is a convenience function for making HTTP requests to the Graph API.
 // It will automatically handle requesting a token using the authenticator attached
-// to this fetcher.
+// to this fetcher. If a request timeout is configured, requests that time out are
+// retried up to maxTimeoutRetries times.
 func (f *graph) doRequest(ctx context.Context, method, url string, body io.Reader) (io.ReadCloser, error) {
+	if f.conf.RequestTimeout <= 0 {
+		return f.sendRequest(ctx, method, url, body)
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := f.sendRequestWithTimeout(ctx, method, url, body)
+		var timeoutErr requestTimeoutError
+		if !errors.As(err, &timeoutErr) || attempt == maxTimeoutRetries || body != nil {
+			return res, err
+		}
+		f.logger.Warnw("Retrying request after timeout", "url", url, "timeout", f.conf.RequestTimeout, "attempt", attempt+1)
+	}
+}
+
+// sendRequestWithTimeout makes a request that must complete, including
+// reading the response body, within the configured request timeout. The
+// response body is returned from memory. A requestTimeoutError is returned
+// if the timeout expires.
+func (f *graph) sendRequestWithTimeout(ctx context.Context, method, url string, body io.Reader) (io.ReadCloser, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, f.conf.RequestTimeout)
+	defer cancel()
+
+	res, err := f.sendRequest(reqCtx, method, url, body)
+	if err == nil {
+		var data []byte
+		data, err = io.ReadAll(res)
+		_ = res.Close()
+		if err == nil {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+		err = fmt.Errorf("unable to read response: %w", err)
+	}
+	if ctx.Err() == nil && errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+		return nil, requestTimeoutError{url: url, timeout: f.conf.RequestTimeout}
+	}
+	return nil, err
+}
+
+// sendRequest makes a single request to the Graph API.
+func (f *graph) sendRequest(ctx context.Context, method, url string, body io.Reader) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create request: %w", err)
@@ -567,6 +789,10 @@ func New(ctx context.Context, id string, cfg *config.C, logger *logp.Logger, aut
 		auth:   auth,
 		client: client,
 	}
+	if c.CheckpointPages {
+		name := httplog.SanitizeFileName(id) + "-cursors.json"
+		f.cursors = newFileCursorStore(p.Resolve(paths.Data, filepath.Join(inputName, name)))
+	}
 	if f.conf.APIEndpoint == "" {
 		f.conf.APIEndpoint = defaultAPIEndpoint
 	}
@@ -575,7 +801,11 @@ func New(ctx context.Context, id string, cfg *config.C, logger *logp.Logger, aut
 	if err != nil {
 		return nil, fmt.Errorf("invalid groups URL endpoint: %w", err)
 	}
-	groupsURL.RawQuery, err = formatQuery(queryName, c.Select.GroupQuery, defaultGroupsQuery, c.Expand.GroupExpansion)
+	groupQuery := c.Select.GroupQuery
+	if c.GroupProperties {
+		groupQuery = withGroupProperties(groupQuery)
+	}
+	groupsURL.RawQuery, err = formatQuery(queryName, groupQuery, defaultGroupsQuery, c.Expand.GroupExpansion)
 	if err != nil {
 		return nil, fmt.Errorf("failed to format group query: %w", err)
 	}
@@ -681,8 +911,28 @@ func formatQuery(name string, query []string, dflt string, expand map[string][]s
 	return url.QueryUnescape(vals.Encode())
 }
 
-// newUserFromAPI translates an API-representation of a user to a fetcher.User.
-func newUserFromAPI(u userAPI) (*fetcher.User, error) {
+// withGroupProperties returns the group query with the properties in
+// groupPropertyQuery added, starting from the default query if query is
+// empty.
+func withGroupProperties(query []string) []string {
+	if len(query) == 0 {
+		query = strings.Split(defaultGroupsQuery, ",")
+	}
+	query = slices.Clone(query)
+	for _, p := range strings.Split(groupPropertyQuery, ",") {
+		if !slices.Contains(query, p) {
+			query = append(query, p)
+		}
+	}
+	return query
+}
+
+// errMissingUserID is returned by newUserFromAPI for users without an id.
+var errMissingUserID = errors.New("user missing required id field")
+
+// newUserFromAPI translates an API-representation of a user to a fetcher.User,
+// renaming its fields as set in renames.
+func newUserFromAPI(u userAPI, renames map[string]string) (*fetcher.User, error) {
 	var newUser fetcher.User
 	var err error
 
@@ -695,13 +945,14 @@ func newUserFromAPI(u userAPI) (*fetcher.User, error) {
 		}
 		delete(newUser.Fields, "id")
 	} else {
-		return nil, errors.New("user missing required id field")
+		return nil, errMissingUserID
 	}
 
 	if _, ok := newUser.Fields["@removed"]; ok {
 		newUser.Deleted = true
 		delete(newUser.Fields, "@removed")
 	}
+	renameFields(newUser.Fields, renames)
 
 	return &newUser, nil
 }
@@ -709,9 +960,12 @@ func newUserFromAPI(u userAPI) (*fetcher.User, error) {
 // newGroupFromAPI translates an API-representation of a group to a fetcher.Group.
 func newGroupFromAPI(g groupAPI) *fetcher.Group {
 	newGroup := fetcher.Group{
-		ID:      g.ID,
-		Name:    g.DisplayName,
-		Deleted: g.deleted(),
+		ID:             g.ID,
+		Name:           g.DisplayName,
+		Deleted:        g.deleted(),
+		Classification: g.Classification,
+		Visibility:     g.Visibility,
+		GroupTypes:     g.GroupTypes,
 	}
 	for _, v := range g.MembersDelta {
 		var typ fetcher.MemberType
@@ -735,8 +989,9 @@ func newGroupFromAPI(g groupAPI) *fetcher.Group {
 	return &newGroup
 }
 
-// newDeviceFromAPI translates an API-representation of a device to a fetcher.Device.
-func newDeviceFromAPI(d deviceAPI) (*fetcher.Device, error) {
+// newDeviceFromAPI translates an API-representation of a device to a
+// fetcher.Device, renaming its fields as set in renames.
+func newDeviceFromAPI(d deviceAPI, renames map[string]string) (*fetcher.Device, error) {
 	var newDevice fetcher.Device
 	var err error
 
@@ -756,10 +1011,33 @@ func newDeviceFromAPI(d deviceAPI) (*fetcher.Device, error) {
 		newDevice.Deleted = true
 		delete(newDevice.Fields, "@removed")
 	}
+	renameFields(newDevice.Fields, renames)
 
 	return &newDevice, nil
 }
 
+// renameFields moves the values of the fields named by the keys of renames
+// to the fields named by their values. Dotted names address nested fields.
+// Fields that are not set are ignored. All the values are read before any
+// is moved, so that renames don't depend on each other.
+func renameFields(fields mapstr.M, renames map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+	values := make(map[string]any, len(renames))
+	for from := range renames {
+		v, err := fields.GetValue(from)
+		if err != nil {
+			continue
+		}
+		values[from] = v
+		_ = fields.Delete(from)
+	}
+	for from, v := range values {
+		_, _ = fields.Put(renames[from], v)
+	}
+}
+
 type nextLinkLoopError struct {
 	endpoint string
 }
@@ -768,6 +1046,15 @@ func (e nextLinkLoopError) Error() string {
 	return fmt.Sprintf("error during fetch %s, encountered nextLink fetch infinite loop", e.endpoint)
 }
 
+type requestTimeoutError struct {
+	url     string
+	timeout time.Duration
+}
+
+func (e requestTimeoutError) Error() string {
+	return fmt.Sprintf("request to %s did not complete within %v", e.url, e.timeout)
+}
+
 type missingLinkError struct {
 	endpoint string
 }
@@ -7,6 +7,7 @@ package graph
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"path"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +28,8 @@ import (
 	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/azuread/fetcher"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-libs/paths"
 	"github.com/elastic/lumberjack"
 )
@@ -510,6 +514,266 @@ func TestGraph_Users(t *testing.T) {
 	require.Equal(t, wantDeltaLink, gotDeltaLink)
 }
 
+func TestGraph_SweepMetrics(t *testing.T) {
+	var testSrv testServer
+	testSrv.setup(t)
+	defer testSrv.srv.Close()
+
+	rawConf := graphConf{
+		APIEndpoint: "http://" + testSrv.addr,
+	}
+	c, err := config.NewConfigFrom(&rawConf)
+	require.NoError(t, err)
+	auth := mock.New(mock.DefaultTokenValue)
+
+	f, err := New(context.Background(), t.Name(), c, logp.L(), auth, &paths.Path{Logs: t.TempDir()})
+	require.NoError(t, err)
+
+	reg := monitoring.NewRegistry()
+	newSweep := func(name string) fetcher.SweepMetrics {
+		return fetcher.SweepMetrics{
+			Delta: monitoring.NewUint(reg, name+"_delta"),
+			Full:  monitoring.NewUint(reg, name+"_full"),
+		}
+	}
+	m := fetcher.Metrics{
+		Users:   newSweep("users"),
+		Groups:  newSweep("groups"),
+		Devices: newSweep("devices"),
+	}
+	f.SetMetrics(&m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, deltaLink, err := f.Users(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), m.Users.Full.Get(), "unexpected full sweeps after base URL sweep")
+	require.Equal(t, uint64(0), m.Users.Delta.Get(), "unexpected delta sweeps after base URL sweep")
+
+	_, _, err = f.Users(ctx, deltaLink)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), m.Users.Full.Get(), "unexpected full sweeps after delta sweep")
+	require.Equal(t, uint64(1), m.Users.Delta.Get(), "unexpected delta sweeps after delta sweep")
+
+	_, _, err = f.Groups(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), m.Groups.Full.Get(), "unexpected full group sweeps")
+	require.Equal(t, uint64(0), m.Groups.Delta.Get(), "unexpected delta group sweeps")
+
+	// Following device owner links must not count as user sweeps.
+	_, deltaLink, err = f.Devices(ctx, "")
+	require.NoError(t, err)
+	_, _, err = f.Devices(ctx, deltaLink)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), m.Devices.Full.Get(), "unexpected full device sweeps")
+	require.Equal(t, uint64(1), m.Devices.Delta.Get(), "unexpected delta device sweeps")
+	require.Equal(t, uint64(1), m.Users.Full.Get(), "device owner lookups counted as full user sweeps")
+	require.Equal(t, uint64(1), m.Users.Delta.Get(), "device owner lookups counted as delta user sweeps")
+}
+
+func TestGraph_RemovedEntities(t *testing.T) {
+	const (
+		activeID  = "5ebc6a0f-05b7-4f42-9c8a-682bbc75d0fc"
+		removedID = "d897d560-3d17-4dae-81b3-c898fe82bf84"
+	)
+	var addr string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/delta", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		data, err := json.Marshal(apiUserResponse{
+			DeltaLink: "http://" + addr + "/users/delta?$deltatoken=test",
+			Users: []userAPI{
+				{"id": activeID, "displayName": "User One"},
+				{"id": removedID, "@removed": map[string]any{"reason": "changed"}},
+			},
+		})
+		require.NoError(t, err)
+		_, _ = w.Write(data)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr = srv.Listener.Addr().String()
+
+	tests := []struct {
+		policy string
+		want   []*fetcher.User
+	}{
+		{
+			policy: "",
+			want: []*fetcher.User{
+				{ID: uuid.Must(uuid.FromString(activeID)), Fields: map[string]any{"displayName": "User One"}},
+				{ID: uuid.Must(uuid.FromString(removedID)), Fields: map[string]any{}, Deleted: true},
+			},
+		},
+		{
+			policy: removedEmit,
+			want: []*fetcher.User{
+				{ID: uuid.Must(uuid.FromString(activeID)), Fields: map[string]any{"displayName": "User One"}},
+				{ID: uuid.Must(uuid.FromString(removedID)), Fields: map[string]any{}, Deleted: true},
+			},
+		},
+		{
+			policy: removedSuppress,
+			want: []*fetcher.User{
+				{ID: uuid.Must(uuid.FromString(activeID)), Fields: map[string]any{"displayName": "User One"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			c, err := config.NewConfigFrom(&graphConf{
+				APIEndpoint:     "http://" + addr,
+				RemovedEntities: test.policy,
+			})
+			require.NoError(t, err)
+			f, err := New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), &paths.Path{Logs: t.TempDir()})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, _, err := f.Users(ctx, "")
+			require.NoError(t, err)
+			require.EqualValues(t, test.want, got)
+		})
+	}
+}
+
+func TestGraph_MissingUserID(t *testing.T) {
+	const userID = "5ebc6a0f-05b7-4f42-9c8a-682bbc75d0fc"
+	var addr string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/delta", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		data, err := json.Marshal(apiUserResponse{
+			DeltaLink: "http://" + addr + "/users/delta?$deltatoken=test",
+			Users: []userAPI{
+				{"id": userID, "displayName": "User One"},
+				{"displayName": "No ID"},
+			},
+		})
+		require.NoError(t, err)
+		_, _ = w.Write(data)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr = srv.Listener.Addr().String()
+
+	user := &fetcher.User{ID: uuid.Must(uuid.FromString(userID)), Fields: map[string]any{"displayName": "User One"}}
+	tests := []struct {
+		policy  string
+		want    []*fetcher.User
+		wantErr string
+	}{
+		{
+			policy: "",
+			want:   []*fetcher.User{user},
+		},
+		{
+			policy: missingIDSkip,
+			want:   []*fetcher.User{user},
+		},
+		{
+			policy: missingIDEmit,
+			want: []*fetcher.User{
+				user,
+				{Fields: map[string]any{"displayName": "No ID"}, ParseError: "user missing required id field"},
+			},
+		},
+		{
+			policy:  missingIDFail,
+			wantErr: "unable to parse user from API: user missing required id field",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			c, err := config.NewConfigFrom(&graphConf{
+				APIEndpoint:   "http://" + addr,
+				MissingUserID: test.policy,
+			})
+			require.NoError(t, err)
+			f, err := New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), &paths.Path{Logs: t.TempDir()})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, _, err := f.Users(ctx, "")
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.EqualValues(t, test.want, got)
+		})
+	}
+}
+
+func TestGraph_Rename(t *testing.T) {
+	const userID = "5ebc6a0f-05b7-4f42-9c8a-682bbc75d0fc"
+	var addr string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/delta", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		data, err := json.Marshal(apiUserResponse{
+			DeltaLink: "http://" + addr + "/users/delta?$deltatoken=test",
+			Users: []userAPI{
+				{"id": userID, "userPrincipalName": "user.one@example.com", "mail": "one@example.com", "displayName": "User One"},
+			},
+		})
+		require.NoError(t, err)
+		_, _ = w.Write(data)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr = srv.Listener.Addr().String()
+
+	c := config.MustNewConfigFrom(map[string]any{
+		"api_endpoint": "http://" + addr,
+		"rename.users": map[string]any{
+			"userPrincipalName": "user.name",
+			"mail":              "email",
+			"jobTitle":          "title",
+		},
+	})
+	f, err := New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), &paths.Path{Logs: t.TempDir()})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, _, err := f.Users(ctx, "")
+	require.NoError(t, err)
+	want := []*fetcher.User{{
+		ID: uuid.Must(uuid.FromString(userID)),
+		Fields: mapstr.M{
+			"user":        mapstr.M{"name": "user.one@example.com"},
+			"email":       "one@example.com",
+			"displayName": "User One",
+		},
+	}}
+	require.EqualValues(t, want, got)
+}
+
+func TestNewDeviceFromAPI_Rename(t *testing.T) {
+	const deviceID = "6a59ea83-02bd-468f-a40b-f2c3d1821983"
+	got, err := newDeviceFromAPI(deviceAPI{
+		"id":              deviceID,
+		"displayName":     "DESKTOP-LK3PESR",
+		"operatingSystem": "Windows",
+		"deviceId":        "eab73519-780d-4d43-be6d-a4a89af2a348",
+	}, map[string]string{
+		// Renames are independent of each other.
+		"displayName": "deviceId",
+		"deviceId":    "device.id",
+	})
+	require.NoError(t, err)
+	require.Equal(t, uuid.Must(uuid.FromString(deviceID)), got.ID)
+	require.Equal(t, mapstr.M{
+		"deviceId":        "DESKTOP-LK3PESR",
+		"device":          mapstr.M{"id": "eab73519-780d-4d43-be6d-a4a89af2a348"},
+		"operatingSystem": "Windows",
+	}, got.Fields)
+}
+
 func TestGraph_Devices(t *testing.T) {
 	var testSrv testServer
 	testSrv.setup(t)
@@ -629,6 +893,211 @@ func TestGraph_Devices(t *testing.T) {
 	}
 }
 
+func TestGraph_DevicesCheckpoint(t *testing.T) {
+	const (
+		firstID  = "6a59ea83-02bd-468f-a40b-f2c3d1821983"
+		secondID = "adbbe40a-0627-4328-89f1-88cac84dbc7f"
+	)
+	var (
+		addr      string
+		failPage2 = true
+		requests  []string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices/delta", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RawQuery)
+		var resp apiDeviceResponse
+		switch r.URL.Query().Get("$skiptoken") {
+		case "":
+			resp = apiDeviceResponse{
+				NextLink: "http://" + addr + "/devices/delta?$skiptoken=page2",
+				Devices:  []deviceAPI{{"id": firstID}},
+			}
+		case "page2":
+			if failPage2 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			resp = apiDeviceResponse{
+				DeltaLink: "http://" + addr + "/devices/delta?$deltatoken=test",
+				Devices:   []deviceAPI{{"id": secondID}},
+			}
+		}
+		w.Header().Add("Content-Type", "application/json")
+		data, err := json.Marshal(resp)
+		require.NoError(t, err)
+		_, _ = w.Write(data)
+	})
+	mux.HandleFunc("/devices/{id}/{type}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"value":[],"@odata.deltaLink":"unused"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr = srv.Listener.Addr().String()
+
+	c, err := config.NewConfigFrom(&graphConf{
+		APIEndpoint:     "http://" + addr,
+		CheckpointPages: true,
+	})
+	require.NoError(t, err)
+	p := &paths.Path{Data: t.TempDir(), Logs: t.TempDir()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f, err := New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), p)
+	require.NoError(t, err)
+	_, _, err = f.Devices(ctx, "")
+	require.Error(t, err, "expected the fetch of the second page to fail")
+
+	// Simulate a restart with a new fetcher using the same data path.
+	failPage2 = false
+	requests = nil
+	f, err = New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), p)
+	require.NoError(t, err)
+	got, deltaLink, err := f.Devices(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"$skiptoken=page2"}, requests, "expected the fetch to resume from the checkpointed link")
+	require.Len(t, got, 2, "expected the devices of the pages fetched before the restart to be returned")
+	require.Equal(t, uuid.Must(uuid.FromString(firstID)), got[0].ID)
+	require.Equal(t, uuid.Must(uuid.FromString(secondID)), got[1].ID)
+	require.Equal(t, "http://"+addr+"/devices/delta?$deltatoken=test", deltaLink)
+
+	// The checkpoint is cleared once the fetch completes.
+	requests = nil
+	_, _, err = f.Devices(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(requests), "expected a complete fetch to start from the first page")
+	require.NotContains(t, requests[0], "skiptoken", "expected a complete fetch to start from the first page")
+}
+
+func TestGraph_RequestTimeout(t *testing.T) {
+	const deviceID = "6a59ea83-02bd-468f-a40b-f2c3d1821983"
+	var (
+		addr     string
+		requests atomic.Int64
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices/delta", func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// Stall the first request past the request timeout.
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		data, err := json.Marshal(apiDeviceResponse{
+			DeltaLink: "http://" + addr + "/devices/delta?$deltatoken=test",
+			Devices:   []deviceAPI{{"id": deviceID}},
+		})
+		require.NoError(t, err)
+		_, _ = w.Write(data)
+	})
+	mux.HandleFunc("/devices/{id}/{type}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"value":[],"@odata.deltaLink":"unused"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr = srv.Listener.Addr().String()
+
+	c, err := config.NewConfigFrom(map[string]any{
+		"api_endpoint":    "http://" + addr,
+		"request_timeout": "100ms",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f, err := New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), nil)
+	require.NoError(t, err)
+	got, deltaLink, err := f.Devices(ctx, "")
+	require.NoError(t, err, "expected the timed out request to be retried")
+	require.Equal(t, int64(2), requests.Load(), "expected the page to be requested again after the timeout")
+	require.Len(t, got, 1)
+	require.Equal(t, uuid.Must(uuid.FromString(deviceID)), got[0].ID)
+	require.Equal(t, "http://"+addr+"/devices/delta?$deltatoken=test", deltaLink)
+}
+
+func TestGraph_RequestTimeoutExhausted(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c, err := config.NewConfigFrom(map[string]any{
+		"api_endpoint":    srv.URL,
+		"request_timeout": "50ms",
+	})
+	require.NoError(t, err)
+
+	f, err := New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), nil)
+	require.NoError(t, err)
+	_, _, err = f.Devices(context.Background(), "")
+	var timeoutErr requestTimeoutError
+	require.ErrorAs(t, err, &timeoutErr, "expected a request timeout error once retries are exhausted")
+	require.Equal(t, int64(maxTimeoutRetries+1), requests.Load(), "expected the request to be retried before failing")
+}
+
+func TestGraph_GroupProperties(t *testing.T) {
+	const groupID = "0b4fa0e2-b2a6-4e1a-a2a0-2b4d3f5e6a7b"
+	var (
+		addr   string
+		gotSel string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/groups/delta", func(w http.ResponseWriter, r *http.Request) {
+		gotSel = r.URL.Query().Get(queryName)
+		w.Header().Add("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{
+			"@odata.deltaLink": "http://%s/groups/delta?$deltatoken=test",
+			"value": [{
+				"id": %q,
+				"displayName": "governed",
+				"classification": "Confidential",
+				"visibility": "Private",
+				"groupTypes": ["Unified"]
+			}]
+		}`, addr, groupID)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr = srv.Listener.Addr().String()
+
+	c, err := config.NewConfigFrom(map[string]any{
+		"api_endpoint":     "http://" + addr,
+		"group_properties": true,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f, err := New(context.Background(), t.Name(), c, logp.L(), mock.New(mock.DefaultTokenValue), nil)
+	require.NoError(t, err)
+	got, _, err := f.Groups(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, "displayName,members,classification,visibility,groupTypes", gotSel, "expected the group properties to be selected")
+	require.Equal(t, []*fetcher.Group{{
+		ID:             uuid.Must(uuid.FromString(groupID)),
+		Name:           "governed",
+		Classification: "Confidential",
+		Visibility:     "Private",
+		GroupTypes:     []string{"Unified"},
+	}}, got)
+}
+
+func TestWithGroupProperties(t *testing.T) {
+	require.Equal(t, []string{"displayName", "members", "classification", "visibility", "groupTypes"}, withGroupProperties(nil))
+	require.Equal(t, []string{"displayName", "visibility", "members", "classification", "groupTypes"}, withGroupProperties([]string{"displayName", "visibility", "members"}))
+}
+
 func TestGraph_UserMFADetails(t *testing.T) {
 	var testSrv testServer
 	testSrv.setup(t)
@@ -833,6 +1302,32 @@ var validateConfigTests = []struct {
 			"tracer.filename": "/var/logs/path.log",
 		},
 	},
+	{
+		name: "removed_entities_suppress",
+		config: map[string]any{
+			"removed_entities": "suppress",
+		},
+	},
+	{
+		name: "invalid_removed_entities",
+		config: map[string]any{
+			"removed_entities": "hide",
+		},
+		wantErr: errors.New(`invalid removed_entities policy "hide": must be "emit" or "suppress" accessing config`),
+	},
+	{
+		name: "missing_user_id_fail",
+		config: map[string]any{
+			"missing_user_id": "fail",
+		},
+	},
+	{
+		name: "invalid_missing_user_id",
+		config: map[string]any{
+			"missing_user_id": "drop",
+		},
+		wantErr: errors.New(`invalid missing_user_id policy "drop": must be "skip", "emit" or "fail" accessing config`),
+	},
 }
 
 func TestConfigValidation(t *testing.T) {
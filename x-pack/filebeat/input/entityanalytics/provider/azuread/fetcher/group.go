@@ -26,6 +26,12 @@ type Group struct {
 	Name string `json:"name"`
 	// Indicates the group has been deleted.
 	Deleted bool `json:"deleted,omitempty"`
+	// The classification of the group, for example its sensitivity.
+	Classification string `json:"classification,omitempty"`
+	// The visibility of the group, for example Private or Public.
+	Visibility string `json:"visibility,omitempty"`
+	// The types of the group, for example Unified for Microsoft 365 groups.
+	GroupTypes []string `json:"group_types,omitempty"`
 	// A list of members for this group.
 	Members []Member `json:"-"`
 }
@@ -0,0 +1,34 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fetcher
+
+import "github.com/elastic/elastic-agent-libs/monitoring"
+
+// Metrics holds the sweep counters for each resource type retrieved by a
+// Fetcher.
+type Metrics struct {
+	Users   SweepMetrics
+	Groups  SweepMetrics
+	Devices SweepMetrics
+}
+
+// SweepMetrics counts the sweeps of a single resource type.
+type SweepMetrics struct {
+	Delta *monitoring.Uint // The number of sweeps resumed from a delta link.
+	Full  *monitoring.Uint // The number of sweeps starting from the base URL.
+}
+
+// Sweep records a sweep that was started with the provided delta link.
+// An empty delta link is counted as a full sweep. It is safe to call on a
+// zero SweepMetrics.
+func (m SweepMetrics) Sweep(deltaLink string) {
+	c := m.Full
+	if deltaLink != "" {
+		c = m.Delta
+	}
+	if c != nil {
+		c.Inc()
+	}
+}
@@ -217,6 +217,9 @@ func (f *mock) UserSignInActivity(ctx context.Context) (map[uuid.UUID]*fetcher.S
 // SetLogger is not used for this implementation.
 func (f *mock) SetLogger(logger *logp.Logger) {}
 
+// SetMetrics is not used for this implementation.
+func (f *mock) SetMetrics(m *fetcher.Metrics) {}
+
 // New creates a new instance of a mock fetcher.
 func New() fetcher.Fetcher {
 	return &mock{}
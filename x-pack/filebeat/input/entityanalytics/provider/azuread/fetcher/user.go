@@ -39,6 +39,10 @@ type User struct {
 	// is not persisted; it is populated during each sync/update cycle when the
 	// "sign_in_activity" enrich_with option is set.
 	SignInActivity *SignInActivityDetails `json:"-"`
+	// ParseError holds the reason the user returned by the API could not
+	// be parsed. Users with a parse error have no ID and are published
+	// as is, without being stored.
+	ParseError string `json:"-"`
 }
 
 // MFARegistrationDetails contains MFA registration information for a user
@@ -7,6 +7,7 @@ package azuread
 import (
 	"github.com/rcrowley/go-metrics"
 
+	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/azuread/fetcher"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-libs/monitoring/adapter"
@@ -20,6 +21,8 @@ type inputMetrics struct {
 	updateTotal          *monitoring.Uint // The total number of incremental updates.
 	updateError          *monitoring.Uint // The number of incremental updates that failed due to an error.
 	updateProcessingTime metrics.Sample   // Histogram of the elapsed incremental update times in nanoseconds (time of API contact to items sent to output).
+	deletedMembers       *monitoring.Uint // The total number of deleted group members received from the API.
+	sweeps               fetcher.Metrics  // The number of delta and full sweeps for each resource type.
 }
 
 // newMetrics creates a new instance for gathering metrics.
@@ -31,6 +34,12 @@ func newMetrics(reg *monitoring.Registry, logger *logp.Logger) *inputMetrics {
 		updateTotal:          monitoring.NewUint(reg, "update_total"),
 		updateError:          monitoring.NewUint(reg, "update_error"),
 		updateProcessingTime: metrics.NewUniformSample(1024),
+		deletedMembers:       monitoring.NewUint(reg, "deleted_members_total"),
+		sweeps: fetcher.Metrics{
+			Users:   newSweepMetrics(reg, "users"),
+			Groups:  newSweepMetrics(reg, "groups"),
+			Devices: newSweepMetrics(reg, "devices"),
+		},
 	}
 
 	adapter.NewGoMetrics(reg, "sync_processing_time", logger, adapter.Accept).Register("histogram", metrics.NewHistogram(out.syncProcessingTime))     //nolint:errcheck // A unique namespace is used so name collisions are impossible.
@@ -38,3 +47,12 @@ func newMetrics(reg *monitoring.Registry, logger *logp.Logger) *inputMetrics {
 
 	return &out
 }
+
+// newSweepMetrics creates the delta and full sweep counters for the named
+// resource type.
+func newSweepMetrics(reg *monitoring.Registry, resource string) fetcher.SweepMetrics {
+	return fetcher.SweepMetrics{
+		Delta: monitoring.NewUint(reg, resource+"_delta_sweeps_total"),
+		Full:  monitoring.NewUint(reg, resource+"_full_sweeps_total"),
+	}
+}
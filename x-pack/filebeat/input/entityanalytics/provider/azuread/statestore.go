@@ -46,6 +46,10 @@ type stateStore struct {
 	devices       map[uuid.UUID]*fetcher.Device
 	groups        map[uuid.UUID]*fetcher.Group
 	relationships collections.UUIDTree
+
+	// userParseErrors holds the users of the current fetch that could
+	// not be parsed. They are published but never stored.
+	userParseErrors []*fetcher.User
 }
 
 // newStateStore creates a new instance of stateStore. It will open a new write
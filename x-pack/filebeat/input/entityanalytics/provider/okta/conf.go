@@ -12,10 +12,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
+	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/okta/internal/okta"
 	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
 	"github.com/elastic/lumberjack"
 )
@@ -39,6 +42,11 @@ func defaultConfig() conf {
 				WaitMin:     &waitMin,
 				WaitMax:     &waitMax,
 			},
+			RateLimitRetries: okta.DefaultRateLimitRetries,
+			ConnectionRetry: connRetryConfig{
+				WaitMin: time.Second,
+				WaitMax: 30 * time.Second,
+			},
 			RedirectForwardHeaders: false,
 			RedirectMaxRedirects:   10,
 			Transport:              transport,
@@ -80,6 +88,24 @@ type conf struct {
 	// If it zero or negative, the API default is used.
 	BatchSize int `config:"batch_size"`
 
+	// PrefetchPages specifies whether the next page of users
+	// is requested while the current page is being processed.
+	PrefetchPages bool `config:"prefetch_pages"`
+
+	// DeviceUserWorkers is the number of devices whose
+	// users are requested concurrently. All requests share
+	// the API rate limits. If it is zero, devices are
+	// queried one at a time.
+	DeviceUserWorkers int `config:"device_user_workers" validate:"min=0"`
+
+	// PageErrors specifies how a failure to get a page during
+	// enumeration is handled. If it is "fail" or empty, the
+	// enumeration fails. If it is "partial", the users or devices
+	// of the pages already fetched are kept and the enumeration
+	// returns a partial result error. The state cursor is not
+	// advanced, so the next run resumes from the previous one.
+	PageErrors string `config:"page_errors"`
+
 	// LimitWindow is the time between Okta
 	// API limit resets.
 	LimitWindow time.Duration `config:"limit_window"`
@@ -88,6 +114,58 @@ type conf struct {
 	// overriding the guidance in API responses.
 	LimitFixed *int `config:"limit_fixed"`
 
+	// LimitInitial is the number of requests to allow in each
+	// LimitWindow for an endpoint until the API has reported the
+	// endpoint's limit, keyed by endpoint path.
+	LimitInitial map[string]int `config:"limit_initial"`
+
+	// LimitPersist specifies whether the most recently observed
+	// API rate limit state is persisted so that it is respected
+	// after a restart.
+	LimitPersist bool `config:"limit_persist"`
+
+	// FullSyncEmit specifies which entities are published by a
+	// full synchronization. If it is "all" or empty, all entities
+	// are published. If it is "changed", only the entities that
+	// were discovered, or whose content changed since they were
+	// last published, are published, along with the entities that
+	// are no longer returned by the API, which are published as
+	// deleted.
+	FullSyncEmit string `config:"full_sync_emit"`
+
+	// RateLimitEvent specifies whether an event holding the
+	// most recently observed API rate limit state of each
+	// endpoint is published after each full synchronization
+	// and incremental update.
+	RateLimitEvent bool `config:"rate_limit_event"`
+
+	// SyncSummary specifies whether a summary event is
+	// published at the end of each full synchronization.
+	SyncSummary bool `config:"sync_summary"`
+
+	// ECSDeviceFields specifies whether the hardware attributes of
+	// device profiles are also published under ECS host and device
+	// fields.
+	ECSDeviceFields bool `config:"ecs_device_fields"`
+
+	// UserStateMapping maps Okta user status values to the
+	// normalized state published in user.state. Its entries
+	// override the default mapping, and are matched without
+	// regard to case. Mapping a status to an empty string
+	// omits the state for users with that status.
+	UserStateMapping map[string]string `config:"user_state_mapping"`
+
+	// KeepLinks specifies the entities whose HAL _links
+	// navigation is retained. It may include "users",
+	// "devices" and "device_users". If it is not set, the
+	// links of all entities are retained.
+	KeepLinks []string `config:"keep_links"`
+
+	// OmitRecoveryQuestion specifies whether the recovery
+	// question marker is removed from the credentials of
+	// users and device users.
+	OmitRecoveryQuestion bool `config:"omit_recovery_question"`
+
 	// Request is the configuration for establishing
 	// HTTP requests to the API.
 	Request *requestConfig `config:"request"`
@@ -197,11 +275,22 @@ func (t *tracerConfig) enabled() bool {
 }
 
 type requestConfig struct {
-	Retry                  retryConfig `config:"retry"`
-	RedirectForwardHeaders bool        `config:"redirect.forward_headers"`
-	RedirectHeadersBanList []string    `config:"redirect.headers_ban_list"`
-	RedirectMaxRedirects   int         `config:"redirect.max_redirects"`
-	KeepAlive              keepAlive   `config:"keep_alive"`
+	Retry                  retryConfig      `config:"retry"`
+	RateLimitRetries       int              `config:"rate_limit_retries" validate:"min=0"`
+	ConnectionRetry        connRetryConfig  `config:"connection_retry"`
+	MaxResponseSize        cfgtype.ByteSize `config:"max_response_size"`
+	RedirectForwardHeaders bool             `config:"redirect.forward_headers"`
+	RedirectHeadersBanList []string         `config:"redirect.headers_ban_list"`
+	RedirectMaxRedirects   int              `config:"redirect.max_redirects"`
+	KeepAlive              keepAlive        `config:"keep_alive"`
+
+	// UserAgent is the User-Agent header of the requests. If it is empty,
+	// the user agent of the Beat is used.
+	UserAgent string `config:"user_agent"`
+	// Headers are added to the requests, unless the request already sets
+	// them, so they can't replace the Accept, Content-Type or Authorization
+	// headers.
+	Headers map[string]string `config:"headers"`
 
 	Transport httpcommon.HTTPTransportSettings `config:",inline"`
 }
@@ -245,6 +334,42 @@ func (c retryConfig) getWaitMax() time.Duration {
 	return *c.WaitMax
 }
 
+// requestOptions returns the options of the requests made to the Okta API.
+func (c *requestConfig) requestOptions() okta.RequestOptions {
+	if c == nil {
+		return okta.RequestOptions{}
+	}
+	return okta.RequestOptions{
+		RateLimitRetries: c.RateLimitRetries,
+
+		ConnRetries: c.ConnectionRetry.MaxRetries,
+		ConnWaitMin: c.ConnectionRetry.WaitMin,
+		ConnWaitMax: c.ConnectionRetry.WaitMax,
+
+		MaxResponseSize: int64(c.MaxResponseSize),
+	}
+}
+
+// connRetryConfig configures the retry of requests that failed with a
+// transient connection error. It is independent of retryConfig, which is
+// only used for API token authentication, and of the retry of rate
+// limited requests.
+type connRetryConfig struct {
+	MaxRetries int           `config:"max_retries" validate:"min=0"`
+	WaitMin    time.Duration `config:"wait_min"`
+	WaitMax    time.Duration `config:"wait_max"`
+}
+
+func (c connRetryConfig) Validate() error {
+	switch {
+	case c.WaitMin <= 0:
+		return errors.New("wait_min must be greater than zero")
+	case c.WaitMax < c.WaitMin:
+		return errors.New("wait_max must not be less than wait_min")
+	}
+	return nil
+}
+
 type keepAlive struct {
 	Disable             *bool         `config:"disable"`
 	MaxIdleConns        int           `config:"max_idle_connections"`
@@ -299,6 +424,32 @@ func (c *conf) Validate() error {
 		return errors.New("dataset must be 'all', 'users', 'devices' or empty")
 	}
 
+	switch c.FullSyncEmit {
+	case "", "all", "changed":
+	default:
+		return errors.New("full_sync_emit must be 'all', 'changed' or empty")
+	}
+
+	switch c.PageErrors {
+	case "", "fail", "partial":
+	default:
+		return errors.New("page_errors must be 'fail', 'partial' or empty")
+	}
+
+	for _, k := range c.KeepLinks {
+		switch k {
+		case "users", "devices", "device_users":
+		default:
+			return fmt.Errorf("keep_links must only include 'users', 'devices' or 'device_users': %q", k)
+		}
+	}
+
+	for endpoint, n := range c.LimitInitial {
+		if n <= 0 {
+			return fmt.Errorf("limit_initial for %s must be positive", endpoint)
+		}
+	}
+
 	// Validate authentication configuration
 	if c.OAuth2 != nil && c.OAuth2.isEnabled() {
 		err := c.OAuth2.Validate()
@@ -342,6 +493,12 @@ func (c *conf) wantDevices() bool {
 	}
 }
 
+// keepLinks returns whether the HAL _links navigation of the entity kind
+// is retained.
+func (c *conf) keepLinks(kind string) bool {
+	return c.KeepLinks == nil || slices.Contains(c.KeepLinks, kind)
+}
+
 // populateJSONFromFile reads a JSON file and populates the destination.
 func populateJSONFromFile(file string, dst *common.JSONBlob) error {
 	_, err := os.Stat(file)
@@ -60,6 +60,39 @@ var validateTests = []struct {
 		},
 		wantErr: errSyncBeforeUpdate,
 	},
+	{
+		name: "invalid_limit_initial",
+		cfg: func() conf {
+			cfg := defaultConfig()
+			cfg.OktaDomain = "test.okta.com"
+			cfg.OktaToken = "test-token"
+			cfg.LimitInitial = map[string]int{"/api/v1/users": 0}
+			return cfg
+		}(),
+		wantErr: errors.New("limit_initial for /api/v1/users must be positive"),
+	},
+	{
+		name: "invalid_page_errors",
+		cfg: func() conf {
+			cfg := defaultConfig()
+			cfg.OktaDomain = "test.okta.com"
+			cfg.OktaToken = "test-token"
+			cfg.PageErrors = "ignore"
+			return cfg
+		}(),
+		wantErr: errors.New("page_errors must be 'fail', 'partial' or empty"),
+	},
+	{
+		name: "invalid_keep_links",
+		cfg: func() conf {
+			cfg := defaultConfig()
+			cfg.OktaDomain = "test.okta.com"
+			cfg.OktaToken = "test-token"
+			cfg.KeepLinks = []string{"devices", "groups"}
+			return cfg
+		}(),
+		wantErr: errors.New(`keep_links must only include 'users', 'devices' or 'device_users': "groups"`),
+	},
 	{
 		name: "tracer_disabled",
 		cfg: func() conf {
@@ -183,3 +216,35 @@ func TestKeepAliveSetting(t *testing.T) {
 		})
 	}
 }
+
+func TestRateLimitRetriesSetting(t *testing.T) {
+	for _, test := range []struct {
+		retries int
+		wantErr bool
+	}{
+		{retries: 3},
+		// Zero is accepted, and the requests use the default number of
+		// retries.
+		{retries: 0},
+		{retries: -1, wantErr: true},
+	} {
+		t.Run(fmt.Sprint(test.retries), func(t *testing.T) {
+			cfg := config.MustNewConfigFrom(map[string]interface{}{
+				"request.rate_limit_retries": test.retries,
+			})
+			conf := defaultConfig()
+			conf.OktaDomain = "test.domain"
+			conf.OktaToken = "test_token"
+			err := cfg.Unpack(&conf)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error return from Unpack: got: %v want error: %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := conf.Request.requestOptions().RateLimitRetries; got != test.retries {
+				t.Errorf("unexpected rate limit retries: got: %d want: %d", got, test.retries)
+			}
+		})
+	}
+}
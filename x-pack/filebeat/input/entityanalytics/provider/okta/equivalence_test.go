@@ -150,7 +150,7 @@ func TestEquivalence_FullSync(t *testing.T) {
 	// GetUserDetails directly against the same mock.
 	log2 := logptest.NewTestingLogger(t, "equiv")
 	lim := legacyokta.NewRateLimiter(time.Minute, nil)
-	legacyUsers, _, err := legacyokta.GetUserDetails(t.Context(), srv.Client(), srv.Listener.Addr().String(), "test-token", "", nil, legacyokta.OmitCredentials|legacyokta.OmitCredentialsLinks|legacyokta.OmitTransitioningToStatus, lim, log2)
+	legacyUsers, _, err := legacyokta.GetUserDetails(t.Context(), srv.Client(), srv.Listener.Addr().String(), "test-token", "", nil, legacyokta.OmitCredentials|legacyokta.OmitCredentialsLinks|legacyokta.OmitTransitioningToStatus, legacyokta.RequestOptions{}, lim, log2)
 	if err != nil {
 		t.Fatalf("legacy GetUserDetails: %v", err)
 	}
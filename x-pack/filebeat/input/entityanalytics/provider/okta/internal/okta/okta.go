@@ -12,11 +12,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/sync/errgroup"
+
 	"github.com/elastic/elastic-agent-libs/logp"
 )
 
@@ -101,6 +106,23 @@ type Role struct {
 	Permissions    []Permission `json:"permissions,omitempty"`
 }
 
+// AppLink is an Okta application link, describing an application a user
+// is assigned to.
+//
+// See https://developer.okta.com/docs/api/openapi/okta-management/management/tag/User/#tag/User/operation/listAppLinks.
+type AppLink struct {
+	ID               string `json:"id"`
+	Label            string `json:"label"`
+	LinkURL          string `json:"linkUrl"`
+	LogoURL          string `json:"logoUrl"`
+	AppName          string `json:"appName"`
+	AppInstanceID    string `json:"appInstanceId"`
+	AppAssignmentID  string `json:"appAssignmentId"`
+	CredentialsSetup bool   `json:"credentialsSetup"`
+	Hidden           bool   `json:"hidden"`
+	SortOrder        int    `json:"sortOrder"`
+}
+
 // Permission is an Okta role permission.
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tags/roleecustompermission.
@@ -145,6 +167,58 @@ type DeviceDisplayName struct {
 // See https://datatracker.ietf.org/doc/html/draft-kelly-json-hal-06 for details.
 type HAL map[string]any
 
+// RequestOptions holds the settings applied to each request to the Okta API.
+// The zero value only retries requests that were rate limited.
+type RequestOptions struct {
+	// RateLimitRetries is the maximum number of times a request that was
+	// rate limited is retried. If it is zero, DefaultRateLimitRetries is used.
+	RateLimitRetries int
+	// ConnRetries is the maximum number of times a request that failed
+	// with a transient connection error, such as a connection reset or a
+	// DNS failure, is retried. These retries are counted separately from
+	// the retries of rate limited requests.
+	ConnRetries int
+	// ConnWaitMin is the wait before the first retry of a request that
+	// failed with a connection error. The wait doubles for each retry,
+	// up to ConnWaitMax.
+	ConnWaitMin time.Duration
+	ConnWaitMax time.Duration
+	// MaxResponseSize is the maximum size in bytes of a response body.
+	// Reading a larger body stops at the limit and the request fails
+	// with ErrResponseTooLarge. Zero means no limit.
+	MaxResponseSize int64
+}
+
+// DefaultRateLimitRetries is the maximum number of times a request that was
+// rate limited is retried if RequestOptions.RateLimitRetries is not set.
+const DefaultRateLimitRetries = 5
+
+// rateLimitRetries returns the maximum number of retries of a request that
+// was rate limited.
+func (o RequestOptions) rateLimitRetries() int {
+	if o.RateLimitRetries <= 0 {
+		return DefaultRateLimitRetries
+	}
+	return o.RateLimitRetries
+}
+
+// ErrResponseTooLarge is returned when a response body exceeds the
+// configured maximum response size.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// connWait returns the wait before retry n of a request that failed with a
+// connection error, with retries counted from zero.
+func (o RequestOptions) connWait(n int) time.Duration {
+	wait := o.ConnWaitMin
+	for ; n > 0 && (o.ConnWaitMax <= 0 || wait < o.ConnWaitMax); n-- {
+		wait *= 2
+	}
+	if o.ConnWaitMax > 0 {
+		wait = min(wait, o.ConnWaitMax)
+	}
+	return wait
+}
+
 // Response is a set of omit options specifying a part of the response to omit.
 //
 // See https://developer.okta.com/docs/reference/api/users/#content-type-header-fields-2 for details.
@@ -196,6 +270,8 @@ func (o Response) String() string {
 // with the query syntax described at https://developer.okta.com/docs/reference/core-okta-api/#filter.
 // Parts of the response may be omitted using the omit parameter.
 //
+// Requests failing with a transient connection error are retried as configured in opts.
+//
 // The provided rate limiter must allow at least request and will be updated with the
 // response's X-Rate-Limit headers. Details for rate limits are available at
 // https://help.okta.com/en-us/Content/Topics/Security/API-rate-limits.htm
@@ -203,7 +279,7 @@ func (o Response) String() string {
 // https://${yourOktaDomain}/reports/rate-limit.
 //
 // See https://developer.okta.com/docs/reference/api/users/#list-users for details.
-func GetUserDetails(ctx context.Context, cli *http.Client, host, key, user string, query url.Values, omit Response, lim *RateLimiter, log *logp.Logger) ([]User, http.Header, error) {
+func GetUserDetails(ctx context.Context, cli *http.Client, host, key, user string, query url.Values, omit Response, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]User, http.Header, error) {
 	var endpoint, path string
 	if user == "" {
 		endpoint = "/api/v1/users"
@@ -219,7 +295,7 @@ func GetUserDetails(ctx context.Context, cli *http.Client, host, key, user strin
 		Path:     path,
 		RawQuery: query.Encode(),
 	}
-	return getDetails[User](ctx, cli, u, endpoint, key, user == "", omit, lim, log)
+	return getDetails[User](ctx, cli, u, endpoint, key, user == "", omit, opts, lim, log)
 }
 
 // GetUserFactors returns Okta user factors using the users API endpoint. host is the
@@ -228,7 +304,7 @@ func GetUserDetails(ctx context.Context, cli *http.Client, host, key, user strin
 // See GetUserDetails for details of the query and rate limit parameters.
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tag/UserFactor/#tag/UserFactor/operation/listFactors.
-func GetUserFactors(ctx context.Context, cli *http.Client, host, key, user string, lim *RateLimiter, log *logp.Logger) ([]Factor, http.Header, error) {
+func GetUserFactors(ctx context.Context, cli *http.Client, host, key, user string, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]Factor, http.Header, error) {
 	if user == "" {
 		return nil, nil, errors.New("no user specified")
 	}
@@ -241,7 +317,7 @@ func GetUserFactors(ctx context.Context, cli *http.Client, host, key, user strin
 		Host:   host,
 		Path:   path,
 	}
-	return getDetails[Factor](ctx, cli, u, endpoint, key, true, OmitNone, lim, log)
+	return getDetails[Factor](ctx, cli, u, endpoint, key, true, OmitNone, opts, lim, log)
 }
 
 // GetUserRoles returns Okta user roles using the users API endpoint. host is the
@@ -250,7 +326,7 @@ func GetUserFactors(ctx context.Context, cli *http.Client, host, key, user strin
 // See GetUserDetails for details of the query and rate limit parameters.
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tag/RoleAssignmentBGroup/#tag/RoleAssignmentBGroup/operation/listGroupAssignedRoles.
-func GetUserRoles(ctx context.Context, cli *http.Client, host, key, user string, lim *RateLimiter, log *logp.Logger) ([]Role, http.Header, error) {
+func GetUserRoles(ctx context.Context, cli *http.Client, host, key, user string, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]Role, http.Header, error) {
 	if user == "" {
 		return nil, nil, errors.New("no user specified")
 	}
@@ -263,7 +339,30 @@ func GetUserRoles(ctx context.Context, cli *http.Client, host, key, user string,
 		Host:   host,
 		Path:   path,
 	}
-	return getDetails[Role](ctx, cli, u, endpoint, key, true, OmitNone, lim, log)
+	return getDetails[Role](ctx, cli, u, endpoint, key, true, OmitNone, opts, lim, log)
+}
+
+// GetUserAppLinks returns the Okta application links of a user using the users API
+// endpoint. host is the Okta user domain and key is the API token to use for the query.
+// user must not be empty.
+//
+// See GetUserDetails for details of the query and rate limit parameters.
+//
+// See https://developer.okta.com/docs/api/openapi/okta-management/management/tag/User/#tag/User/operation/listAppLinks.
+func GetUserAppLinks(ctx context.Context, cli *http.Client, host, key, user string, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]AppLink, http.Header, error) {
+	if user == "" {
+		return nil, nil, errors.New("no user specified")
+	}
+
+	const endpoint = "/api/v1/users/{user}/appLinks"
+	path := strings.Replace(endpoint, "{user}", user, 1)
+
+	u := &url.URL{
+		Scheme: "https",
+		Host:   host,
+		Path:   path,
+	}
+	return getDetails[AppLink](ctx, cli, u, endpoint, key, true, OmitNone, opts, lim, log)
 }
 
 // GetUserGroupDetails returns Okta group details using the users API endpoint. host is the
@@ -272,7 +371,7 @@ func GetUserRoles(ctx context.Context, cli *http.Client, host, key, user string,
 // See GetUserDetails for details of the query and rate limit parameters.
 //
 // See https://developer.okta.com/docs/reference/api/users/#request-parameters-8 (no anchor exists on the page for this endpoint) for details.
-func GetUserGroupDetails(ctx context.Context, cli *http.Client, host, key, user string, lim *RateLimiter, log *logp.Logger) ([]Group, http.Header, error) {
+func GetUserGroupDetails(ctx context.Context, cli *http.Client, host, key, user string, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]Group, http.Header, error) {
 	if user == "" {
 		return nil, nil, errors.New("no user specified")
 	}
@@ -285,7 +384,7 @@ func GetUserGroupDetails(ctx context.Context, cli *http.Client, host, key, user
 		Host:   host,
 		Path:   path,
 	}
-	return getDetails[Group](ctx, cli, u, endpoint, key, true, OmitNone, lim, log)
+	return getDetails[Group](ctx, cli, u, endpoint, key, true, OmitNone, opts, lim, log)
 }
 
 // GetUserDevices returns Okta device details for devices enrolled by the provided user
@@ -295,7 +394,7 @@ func GetUserGroupDetails(ctx context.Context, cli *http.Client, host, key, user
 // See GetUserDetails for details of the query and rate limit parameters.
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tags/userresources/other/listuserdevices for details.
-func GetUserDevices(ctx context.Context, cli *http.Client, host, key, user string, lim *RateLimiter, log *logp.Logger) ([]Device, http.Header, error) {
+func GetUserDevices(ctx context.Context, cli *http.Client, host, key, user string, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]Device, http.Header, error) {
 	if user == "" {
 		return nil, nil, errors.New("no user specified")
 	}
@@ -308,7 +407,7 @@ func GetUserDevices(ctx context.Context, cli *http.Client, host, key, user strin
 		Host:   host,
 		Path:   path,
 	}
-	return getDetails[Device](ctx, cli, u, endpoint, key, true, OmitNone, lim, log)
+	return getDetails[Device](ctx, cli, u, endpoint, key, true, OmitNone, opts, lim, log)
 }
 
 // GetGroupRoles returns Okta group roles using the groups API endpoint. host is the
@@ -317,7 +416,7 @@ func GetUserDevices(ctx context.Context, cli *http.Client, host, key, user strin
 // See GetUserDetails for details of the query and rate limit parameters.
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tag/RoleAssignmentBGroup/#tag/RoleAssignmentBGroup/operation/listGroupAssignedRoles.
-func GetGroupRoles(ctx context.Context, cli *http.Client, host, key, group string, lim *RateLimiter, log *logp.Logger) ([]Role, http.Header, error) {
+func GetGroupRoles(ctx context.Context, cli *http.Client, host, key, group string, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]Role, http.Header, error) {
 	if group == "" {
 		return nil, nil, errors.New("no group specified")
 	}
@@ -330,7 +429,7 @@ func GetGroupRoles(ctx context.Context, cli *http.Client, host, key, group strin
 		Host:   host,
 		Path:   path,
 	}
-	return getDetails[Role](ctx, cli, u, endpoint, key, true, OmitNone, lim, log)
+	return getDetails[Role](ctx, cli, u, endpoint, key, true, OmitNone, opts, lim, log)
 }
 
 // GetRolePermissions returns the permissions for an Okta role using the IAM roles API endpoint.
@@ -339,7 +438,7 @@ func GetGroupRoles(ctx context.Context, cli *http.Client, host, key, group strin
 // This call requires the okta.roles.read OAuth2 scope and only applies to custom roles (type CUSTOM).
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tags/roleecustompermission.
-func GetRolePermissions(ctx context.Context, cli *http.Client, host, key, roleID string, lim *RateLimiter, log *logp.Logger) ([]Permission, http.Header, error) {
+func GetRolePermissions(ctx context.Context, cli *http.Client, host, key, roleID string, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]Permission, http.Header, error) {
 	if roleID == "" {
 		return nil, nil, errors.New("no role ID specified")
 	}
@@ -355,7 +454,7 @@ func GetRolePermissions(ctx context.Context, cli *http.Client, host, key, roleID
 	// The permissions endpoint returns {"permissions":[...]} not a plain JSON array,
 	// so we use permissionsWrapper with all=false to let getDetails unmarshal it as a
 	// single object, then unwrap the slice.
-	result, h, err := getDetails[permissionsWrapper](ctx, cli, u, endpoint, key, false, OmitNone, lim, log)
+	result, h, err := getDetails[permissionsWrapper](ctx, cli, u, endpoint, key, false, OmitNone, opts, lim, log)
 	if err != nil || len(result) == 0 {
 		return nil, h, err
 	}
@@ -369,7 +468,7 @@ func GetRolePermissions(ctx context.Context, cli *http.Client, host, key, roleID
 // See GetUserDetails for details of the query and rate limit parameters.
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tag/Device/#tag/Device/operation/listDevices for details.
-func GetDeviceDetails(ctx context.Context, cli *http.Client, host, key, device string, query url.Values, lim *RateLimiter, log *logp.Logger) ([]Device, http.Header, error) {
+func GetDeviceDetails(ctx context.Context, cli *http.Client, host, key, device string, query url.Values, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]Device, http.Header, error) {
 	var endpoint string
 	var path string
 	if device == "" {
@@ -386,7 +485,7 @@ func GetDeviceDetails(ctx context.Context, cli *http.Client, host, key, device s
 		Path:     path,
 		RawQuery: query.Encode(),
 	}
-	return getDetails[Device](ctx, cli, u, endpoint, key, device == "", OmitNone, lim, log)
+	return getDetails[Device](ctx, cli, u, endpoint, key, device == "", OmitNone, opts, lim, log)
 }
 
 // GetDeviceUsers returns Okta user details for users associated with the provided device identifier
@@ -396,7 +495,7 @@ func GetDeviceDetails(ctx context.Context, cli *http.Client, host, key, device s
 // See GetUserDetails for details of the query and rate limit parameters.
 //
 // See https://developer.okta.com/docs/api/openapi/okta-management/management/tag/Device/#tag/Device/operation/listDeviceUsers for details.
-func GetDeviceUsers(ctx context.Context, cli *http.Client, host, key, device string, query url.Values, omit Response, lim *RateLimiter, log *logp.Logger) ([]User, http.Header, error) {
+func GetDeviceUsers(ctx context.Context, cli *http.Client, host, key, device string, query url.Values, omit Response, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]User, http.Header, error) {
 	if device == "" {
 		// No user associated with a null device. Not an error.
 		return nil, nil, nil
@@ -411,7 +510,7 @@ func GetDeviceUsers(ctx context.Context, cli *http.Client, host, key, device str
 		Path:     path,
 		RawQuery: query.Encode(),
 	}
-	du, h, err := getDetails[devUser](ctx, cli, u, endpoint, key, true, omit, lim, log)
+	du, h, err := getDetails[devUser](ctx, cli, u, endpoint, key, true, omit, opts, lim, log)
 	if err != nil {
 		return nil, h, err
 	}
@@ -422,6 +521,51 @@ func GetDeviceUsers(ctx context.Context, cli *http.Client, host, key, device str
 	return users, h, nil
 }
 
+// GetDevicesUsers returns Okta user details for the users associated with each of
+// the provided device identifiers, keyed by device identifier. All pages of users are
+// fetched for each device. Up to workers devices are queried concurrently, and all
+// requests share lim, so that concurrency does not exceed the API rate limits. If
+// workers is less than one, devices are queried one at a time. query must not be
+// mutated while the call is in progress.
+//
+// See GetDeviceUsers for details of the query parameters.
+func GetDevicesUsers(ctx context.Context, cli *http.Client, host, key string, devices []string, query url.Values, omit Response, workers int, opts RequestOptions, lim *RateLimiter, log *logp.Logger) (map[string][]User, error) {
+	var (
+		mu    sync.Mutex
+		users = make(map[string][]User, len(devices))
+	)
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(workers, 1))
+	for _, device := range devices {
+		g.Go(func() error {
+			var deviceUsers []User
+			q := query
+			for {
+				batch, h, err := GetDeviceUsers(ctx, cli, host, key, device, q, omit, opts, lim, log)
+				if err != nil {
+					return err
+				}
+				deviceUsers = append(deviceUsers, batch...)
+				q, err = Next(h)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+			}
+			mu.Lock()
+			users[device] = deviceUsers
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // SupervisedUser holds the subset of Okta user fields used for the supervises enrichment.
 type SupervisedUser struct {
 	ID    string `json:"id"`
@@ -434,7 +578,7 @@ type SupervisedUser struct {
 
 // entity is an Okta entity analytics entity.
 type entity interface {
-	User | Group | Role | Factor | Device | devUser | permissionsWrapper
+	User | Group | Role | Factor | Device | AppLink | devUser | permissionsWrapper
 }
 
 // permissionsWrapper is used to deserialise the /api/v1/iam/roles/{roleId}/permissions
@@ -452,10 +596,11 @@ type devUser struct {
 // for the specific user are returned, otherwise a list of all users is returned.
 //
 // See GetUserDetails for details of the query and rate limit parameters.
-func getDetails[E entity](ctx context.Context, cli *http.Client, u *url.URL, endpoint string, key string, all bool, omit Response, lim *RateLimiter, log *logp.Logger) ([]E, http.Header, error) {
+func getDetails[E entity](ctx context.Context, cli *http.Client, u *url.URL, endpoint string, key string, all bool, omit Response, opts RequestOptions, lim *RateLimiter, log *logp.Logger) ([]E, http.Header, error) {
 	url := u.String()
 	retryCount := 0
-	const maxRetries = 5
+	connRetryCount := 0
+	maxRetries := opts.rateLimitRetries()
 
 	for {
 		if retryCount > maxRetries {
@@ -489,23 +634,41 @@ func getDetails[E entity](ctx context.Context, cli *http.Client, u *url.URL, end
 		}
 		resp, err := cli.Do(req)
 		if err != nil {
-			return nil, nil, err
+			if connRetryCount >= opts.ConnRetries || ctx.Err() != nil || !isTransient(err) {
+				return nil, nil, err
+			}
+			wait := opts.connWait(connRetryCount)
+			connRetryCount++
+			log.Warnw("retrying after connection error", "error", err, "retry", connRetryCount, "max", opts.ConnRetries, "wait", wait)
+			if err = sleep(ctx, wait); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
-		defer resp.Body.Close()
 		err = lim.Update(endpoint, resp.Header, log)
 		if err != nil {
 			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
 			return nil, nil, err
 		}
 
 		if resp.StatusCode == http.StatusTooManyRequests {
-			log.Warnw("received 429 Too Many Requests")
+			resp.Body.Close()
 			retryCount++
+			wait := rateLimitWait(resp.Header, time.Now())
+			log.Warnw("received 429 Too Many Requests", "wait", wait)
+			if retryCount <= maxRetries {
+				// Don't spend the retry before the limit resets.
+				if err = sleep(ctx, wait); err != nil {
+					return nil, nil, err
+				}
+			}
 			continue
 		}
 
 		var body bytes.Buffer
-		n, err := io.Copy(&body, resp.Body)
+		n, err := readBody(&body, resp.Body, opts.MaxResponseSize)
+		resp.Body.Close()
 		if err != nil {
 			return nil, nil, err
 		}
@@ -536,6 +699,76 @@ func getDetails[E entity](ctx context.Context, cli *http.Client, u *url.URL, end
 	}
 }
 
+// readBody copies r to dst, reading at most limit bytes if limit is
+// positive. It returns ErrResponseTooLarge if r holds more than limit
+// bytes, leaving the remainder of r unread.
+func readBody(dst *bytes.Buffer, r io.Reader, limit int64) (int64, error) {
+	if limit <= 0 {
+		return io.Copy(dst, r)
+	}
+	n, err := io.Copy(dst, io.LimitReader(r, limit+1))
+	if err != nil {
+		return n, err
+	}
+	if n > limit {
+		return n, fmt.Errorf("%w: exceeds %d bytes", ErrResponseTooLarge, limit)
+	}
+	return n, nil
+}
+
+// isTransient returns whether err is a connection error that may not
+// happen again if the request is retried.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// *url.Error is a net.Error itself, so check the error it wraps.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// rateLimitWait returns the time to wait before retrying a request whose
+// response, with headers h, was rate limited. It is the longest of the waits
+// until the time in the X-Rate-Limit-Reset header and given by the Retry-After
+// header, which may hold either a number of seconds or a date. It is zero if
+// neither header is valid, and at most maxWait.
+func rateLimitWait(h http.Header, now time.Time) time.Duration {
+	var wait time.Duration
+	if v := h.Get("X-Rate-Limit-Reset"); v != "" {
+		if rst, err := strconv.ParseInt(v, 10, 64); err == nil {
+			wait = time.Unix(rst, 0).Sub(now)
+		}
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			wait = max(wait, time.Duration(min(secs, int64(maxWait/time.Second)))*time.Second)
+		} else if t, err := http.ParseTime(v); err == nil {
+			wait = max(wait, t.Sub(now))
+		}
+	}
+	return min(max(wait, 0), maxWait)
+}
+
+// sleep waits for d or until ctx is done, in which case it returns the
+// context's error.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 // recoverError returns an error based on the returned Okta API error. Error
 // detection here depends on Okta errors being a JSON object while we are
 // requesting a JSON array.
@@ -12,11 +12,15 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -65,7 +69,7 @@ func Test(t *testing.T) {
 			t.Run("me", func(t *testing.T) {
 				query := make(url.Values)
 				query.Set("limit", "200")
-				users, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, "me", query, omit, limiter, logger)
+				users, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, "me", query, omit, RequestOptions{}, limiter, logger)
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
@@ -94,7 +98,7 @@ func Test(t *testing.T) {
 			t.Run("my_groups", func(t *testing.T) {
 				query := make(url.Values)
 				query.Set("limit", "200")
-				groups, _, err := GetUserGroupDetails(context.Background(), http.DefaultClient, host, key, me.ID, limiter, logger)
+				groups, _, err := GetUserGroupDetails(context.Background(), http.DefaultClient, host, key, me.ID, RequestOptions{}, limiter, logger)
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
@@ -119,7 +123,7 @@ func Test(t *testing.T) {
 			t.Run("my_roles", func(t *testing.T) {
 				query := make(url.Values)
 				query.Set("limit", "200")
-				roles, _, err := GetUserRoles(context.Background(), http.DefaultClient, host, key, me.ID, limiter, logger)
+				roles, _, err := GetUserRoles(context.Background(), http.DefaultClient, host, key, me.ID, RequestOptions{}, limiter, logger)
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
@@ -144,7 +148,7 @@ func Test(t *testing.T) {
 			t.Run("my_factors", func(t *testing.T) {
 				query := make(url.Values)
 				query.Set("limit", "200")
-				factors, _, err := GetUserFactors(context.Background(), http.DefaultClient, host, key, me.ID, limiter, logger)
+				factors, _, err := GetUserFactors(context.Background(), http.DefaultClient, host, key, me.ID, RequestOptions{}, limiter, logger)
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
@@ -175,7 +179,7 @@ func Test(t *testing.T) {
 
 				query := make(url.Values)
 				query.Set("limit", "200")
-				users, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, login, query, omit, limiter, logger)
+				users, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, login, query, omit, RequestOptions{}, limiter, logger)
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
@@ -190,7 +194,7 @@ func Test(t *testing.T) {
 			t.Run("all", func(t *testing.T) {
 				query := make(url.Values)
 				query.Set("limit", "200")
-				users, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, "", query, omit, limiter, logger)
+				users, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, "", query, omit, RequestOptions{}, limiter, logger)
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
@@ -218,7 +222,7 @@ func Test(t *testing.T) {
 				query := make(url.Values)
 				query.Set("limit", "200")
 				query.Add("search", `not (status pr)`) // This cannot ever be true.
-				_, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, "", query, omit, limiter, logger)
+				_, _, err := GetUserDetails(context.Background(), http.DefaultClient, host, key, "", query, omit, RequestOptions{}, limiter, logger)
 				oktaErr := &Error{}
 				if !errors.As(err, &oktaErr) {
 					// Don't test the value of the error since it was
@@ -234,7 +238,7 @@ func Test(t *testing.T) {
 	t.Run("device", func(t *testing.T) {
 		query := make(url.Values)
 		query.Set("limit", "200")
-		devices, _, err := GetDeviceDetails(context.Background(), http.DefaultClient, host, key, "", query, limiter, logger)
+		devices, _, err := GetDeviceDetails(context.Background(), http.DefaultClient, host, key, "", query, RequestOptions{}, limiter, logger)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -246,7 +250,7 @@ func Test(t *testing.T) {
 			t.Logf("devices: %s", b)
 		}
 		for _, d := range devices {
-			users, _, err := GetDeviceUsers(context.Background(), http.DefaultClient, host, key, d.ID, query, OmitCredentials, limiter, logger)
+			users, _, err := GetDeviceUsers(context.Background(), http.DefaultClient, host, key, d.ID, query, OmitCredentials, RequestOptions{}, limiter, logger)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -271,7 +275,7 @@ var localTests = []struct {
 		name: "users",
 		msg:  `[{"id":"userid","status":"STATUS","created":"2023-05-14T13:37:20.000Z","activated":null,"statusChanged":"2023-05-15T01:50:30.000Z","lastLogin":"2023-05-15T01:59:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","passwordChanged":"2023-05-15T01:50:32.000Z","recovery_question":{"question":"Who's a major player in the cowboy scene?","answer":"Annie Oakley"},"type":{"id":"typeid"},"profile":{"firstName":"name","lastName":"surname","mobilePhone":null,"secondEmail":null,"login":"name.surname@example.com","email":"name.surname@example.com"},"credentials":{"password":{"value":"secret"},"emails":[{"value":"name.surname@example.com","status":"VERIFIED","type":"PRIMARY"}],"provider":{"type":"OKTA","name":"OKTA"}},"_links":{"self":{"href":"https://localhost/api/v1/users/userid"}}}]`,
 		fn: func(ctx context.Context, cli *http.Client, host, key, user string, query url.Values, lim *RateLimiter, log *logp.Logger) (any, http.Header, error) {
-			return GetUserDetails(context.Background(), cli, host, key, user, query, OmitNone, lim, log)
+			return GetUserDetails(context.Background(), cli, host, key, user, query, OmitNone, RequestOptions{}, lim, log)
 		},
 		mkWant: mkWant[User],
 	},
@@ -280,7 +284,7 @@ var localTests = []struct {
 		name: "devices",
 		msg:  `[{"id":"devid","status":"CREATED","created":"2019-10-02T18:03:07.000Z","lastUpdated":"2019-10-02T18:03:07.000Z","profile":{"displayName":"Example Device name 1","platform":"WINDOWS","serialNumber":"XXDDRFCFRGF3M8MD6D","sid":"S-1-11-111","registered":true,"secureHardwarePresent":false,"diskEncryptionType":"ALL_INTERNAL_VOLUMES"},"resourceType":"UDDevice","resourceDisplayName":{"value":"Example Device name 1","sensitive":false},"resourceAlternateId":null,"resourceId":"guo4a5u7YAHhjXrMK0g4","_links":{"activate":{"href":"https://{yourOktaDomain}/api/v1/devices/guo4a5u7YAHhjXrMK0g4/lifecycle/activate","hints":{"allow":["POST"]}},"self":{"href":"https://{yourOktaDomain}/api/v1/devices/guo4a5u7YAHhjXrMK0g4","hints":{"allow":["GET","PATCH","PUT"]}},"users":{"href":"https://{yourOktaDomain}/api/v1/devices/guo4a5u7YAHhjXrMK0g4/users","hints":{"allow":["GET"]}}}},{"id":"guo4a5u7YAHhjXrMK0g5","status":"ACTIVE","created":"2023-06-21T23:24:02.000Z","lastUpdated":"2023-06-21T23:24:02.000Z","profile":{"displayName":"Example Device name 2","platform":"ANDROID","manufacturer":"Google","model":"Pixel 6","osVersion":"13:2023-05-05","registered":true,"secureHardwarePresent":true,"diskEncryptionType":"USER"},"resourceType":"UDDevice","resourceDisplayName":{"value":"Example Device name 2","sensitive":false},"resourceAlternateId":null,"resourceId":"guo4a5u7YAHhjXrMK0g5","_links":{"activate":{"href":"https://{yourOktaDomain}/api/v1/devices/guo4a5u7YAHhjXrMK0g5/lifecycle/activate","hints":{"allow":["POST"]}},"self":{"href":"https://{yourOktaDomain}/api/v1/devices/guo4a5u7YAHhjXrMK0g5","hints":{"allow":["GET","PATCH","PUT"]}},"users":{"href":"https://{yourOktaDomain}/api/v1/devices/guo4a5u7YAHhjXrMK0g5/users","hints":{"allow":["GET"]}}}}]`,
 		fn: func(ctx context.Context, cli *http.Client, host, key, device string, query url.Values, lim *RateLimiter, log *logp.Logger) (any, http.Header, error) {
-			return GetDeviceDetails(context.Background(), cli, host, key, device, query, lim, log)
+			return GetDeviceDetails(context.Background(), cli, host, key, device, query, RequestOptions{}, lim, log)
 		},
 		mkWant: mkWant[Device],
 	},
@@ -290,10 +294,20 @@ var localTests = []struct {
 		msg:  `[{"created":"2023-08-07T21:48:27.000Z","managementStatus":"NOT_MANAGED","user":{"id":"userid","status":"STATUS","created":"2023-05-14T13:37:20.000Z","activated":null,"statusChanged":"2023-05-15T01:50:30.000Z","lastLogin":"2023-05-15T01:59:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","passwordChanged":"2023-05-15T01:50:32.000Z","type":{"id":"typeid"},"profile":{"firstName":"name","lastName":"surname","mobilePhone":null,"secondEmail":null,"login":"name.surname@example.com","email":"name.surname@example.com"},"credentials":{"password":{"value":"secret"},"recovery_question":{"question":"Who's a major player in the cowboy scene?","answer":"Annie Oakley"},"emails":[{"value":"name.surname@example.com","status":"VERIFIED","type":"PRIMARY"}],"provider":{"type":"OKTA","name":"OKTA"}},"_links":{"self":{"href":"https://localhost/api/v1/users/userid"}}}}]`,
 		id:   "devid",
 		fn: func(ctx context.Context, cli *http.Client, host, key, device string, query url.Values, lim *RateLimiter, log *logp.Logger) (any, http.Header, error) {
-			return GetDeviceUsers(context.Background(), cli, host, key, device, query, OmitNone, lim, log)
+			return GetDeviceUsers(context.Background(), cli, host, key, device, query, OmitNone, RequestOptions{}, lim, log)
 		},
 		mkWant: mkWant[devUser],
 	},
+	{
+		// Test case from https://developer.okta.com/docs/api/openapi/okta-management/management/tag/User/#tag/User/operation/listAppLinks
+		name: "users_appLinks",
+		msg:  `[{"id":"00ub0oNGTSWTBKOLGLNR","label":"Google Apps Mail","linkUrl":"https://{yourOktaDomain}/home/google/0oa3omz2i9XRNSRIHBZO/50","logoUrl":"https://{yourOktaDomain}/img/logos/google-mail.png","appName":"google","appInstanceId":"0oa3omz2i9XRNSRIHBZO","appAssignmentId":"0ua3omz7weMMMQJERBKY","credentialsSetup":false,"hidden":false,"sortOrder":0}]`,
+		id:   "userid",
+		fn: func(ctx context.Context, cli *http.Client, host, key, user string, query url.Values, lim *RateLimiter, log *logp.Logger) (any, http.Header, error) {
+			return GetUserAppLinks(context.Background(), cli, host, key, user, RequestOptions{}, lim, log)
+		},
+		mkWant: mkWant[AppLink],
+	},
 }
 
 func mkWant[E entity](data string) (any, error) {
@@ -333,10 +347,10 @@ func TestLocal(t *testing.T) {
 				if err != nil {
 					t.Errorf("unexpected error parsing request URI: %v", err)
 				}
-				name, _, ok := strings.Cut(test.name, "_")
+				name, sub, ok := strings.Cut(test.name, "_")
 				endpoint := "/api/v1/" + name
 				if ok {
-					endpoint += "/" + test.id + "/users"
+					endpoint += "/" + test.id + "/" + sub
 				}
 				if u.Path != endpoint {
 					t.Errorf("unexpected API endpoint: got:%s want:%s", u.Path, endpoint)
@@ -405,6 +419,107 @@ func TestLocal(t *testing.T) {
 	}
 }
 
+func TestGetDevicesUsers(t *testing.T) {
+	logp.TestingSetup()
+	logger := logp.L()
+
+	const (
+		workers = 3
+		pages   = 2
+	)
+	devices := []string{"dev1", "dev2", "dev3", "dev4", "dev5", "dev6", "dev7", "dev8"}
+
+	// newServer returns a server for the device users endpoint that holds
+	// each request for delay and records the maximum number of requests
+	// it handled concurrently.
+	newServer := func(t *testing.T, delay time.Duration, maxInFlight *atomic.Int64) (*httptest.Server, string) {
+		var inFlight atomic.Int64
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(delay)
+
+			device, ok := strings.CutPrefix(r.URL.Path, "/api/v1/devices/")
+			device, ok2 := strings.CutSuffix(device, "/users")
+			if !ok || !ok2 {
+				t.Errorf("unexpected API endpoint: %s", r.URL.Path)
+			}
+			page := r.URL.Query().Get("after")
+			if page == "" {
+				page = "1"
+				w.Header().Add("link", fmt.Sprintf(`<https://localhost/api/v1/devices/%s/users?after=2>; rel="next"`, device))
+			}
+			fmt.Fprintf(w, `[{"user":{"id":"%s-user%s"}}]`, device, page)
+		}))
+		t.Cleanup(ts.Close)
+		u, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatalf("failed to parse server URL: %v", err)
+		}
+		return ts, u.Host
+	}
+
+	t.Run("concurrency", func(t *testing.T) {
+		// Hold requests so that concurrent requests overlap, and allow
+		// enough requests that the rate limiter does not serialize them.
+		var maxInFlight atomic.Int64
+		ts, host := newServer(t, 50*time.Millisecond, &maxInFlight)
+		limit := 1000
+		limiter := NewRateLimiter(time.Second, &limit)
+
+		got, err := GetDevicesUsers(context.Background(), ts.Client(), host, "token", devices, url.Values{}, OmitNone, workers, RequestOptions{}, limiter, logger)
+		if err != nil {
+			t.Fatalf("unexpected error from GetDevicesUsers: %v", err)
+		}
+
+		want := make(map[string][]User)
+		for _, d := range devices {
+			for p := 1; p <= pages; p++ {
+				want[d] = append(want[d], User{ID: fmt.Sprintf("%s-user%d", d, p)})
+			}
+		}
+		if !cmp.Equal(want, got, cmpopts.EquateEmpty()) {
+			t.Errorf("unexpected result:\n- want\n+ got\n%s", cmp.Diff(want, got, cmpopts.EquateEmpty()))
+		}
+
+		if n := maxInFlight.Load(); n > workers {
+			t.Errorf("too many concurrent requests: got:%d want at most:%d", n, workers)
+		} else if n < 2 {
+			t.Errorf("requests were not concurrent: got:%d concurrent requests", n)
+		}
+	})
+
+	t.Run("rate_limit", func(t *testing.T) {
+		// Respond immediately, so that the time taken is determined
+		// by the rate limiter shared by the workers.
+		var maxInFlight atomic.Int64
+		ts, host := newServer(t, 0, &maxInFlight)
+		limit := 50
+		limiter := NewRateLimiter(time.Second, &limit)
+
+		start := time.Now()
+		_, err := GetDevicesUsers(context.Background(), ts.Client(), host, "token", devices, url.Values{}, OmitNone, workers, RequestOptions{}, limiter, logger)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("unexpected error from GetDevicesUsers: %v", err)
+		}
+
+		calls := len(devices) * pages
+		if minElapsed := time.Duration(calls-1) * time.Second / time.Duration(limit); elapsed < minElapsed {
+			t.Errorf("requests were not rate limited: %d requests took %v, want at least %v", calls, elapsed, minElapsed)
+		}
+		if _, ok := limiter.byEndpoint["/api/v1/devices/{device}/users"]; !ok || len(limiter.byEndpoint) != 1 {
+			t.Errorf("unexpected endpoints tracked by rate limiter: %v", limiter.byEndpoint)
+		}
+	})
+}
+
 var nextTests = []struct {
 	header  http.Header
 	want    string
@@ -513,7 +628,7 @@ func TestRateLimitRetries(t *testing.T) {
 		// retry until there's a non-429 response
 		query := make(url.Values)
 		query.Set("limit", "200")
-		got, _, err := GetUserDetails(context.Background(), ts.Client(), host, key, "", query, OmitNone, limiter, logger)
+		got, _, err := GetUserDetails(context.Background(), ts.Client(), host, key, "", query, OmitNone, RequestOptions{}, limiter, logger)
 		if err != nil {
 			t.Fatalf("unexpected error from Get_Details: %v", err)
 		}
@@ -524,7 +639,7 @@ func TestRateLimitRetries(t *testing.T) {
 		// stop trying after the maximum retries
 		query = make(url.Values)
 		query.Set("limit", "200")
-		_, _, err = GetUserDetails(context.Background(), ts.Client(), host, key, "", query, OmitNone, limiter, logger)
+		_, _, err = GetUserDetails(context.Background(), ts.Client(), host, key, "", query, OmitNone, RequestOptions{}, limiter, logger)
 		expectedErrMsg := "maximum retries (5) finished without success"
 		if err == nil {
 			t.Errorf("expected the error '%s', but got no error", expectedErrMsg)
@@ -533,4 +648,328 @@ func TestRateLimitRetries(t *testing.T) {
 		}
 
 	})
+
+	t.Run("configured_retries", func(t *testing.T) {
+		var requests atomic.Int32
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.Header().Add("x-rate-limit-limit", "1000000")
+			w.Header().Add("x-rate-limit-remaining", "0")
+			w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Unix()))
+			http.Error(w, "[]", http.StatusTooManyRequests)
+		}))
+		defer ts.Close()
+		u, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatalf("failed to parse server URL: %v", err)
+		}
+
+		fixedLimit := 1000000
+		limiter := NewRateLimiter(time.Minute, &fixedLimit)
+		opts := RequestOptions{RateLimitRetries: 2}
+		_, _, err = GetUserDetails(context.Background(), ts.Client(), u.Host, "token", "", nil, OmitNone, opts, limiter, logger)
+		expectedErrMsg := "maximum retries (2) finished without success"
+		if err == nil {
+			t.Errorf("expected the error '%s', but got no error", expectedErrMsg)
+		} else if err.Error() != expectedErrMsg {
+			t.Errorf("expected error message '%s', but got '%s'", expectedErrMsg, err.Error())
+		}
+		if got, want := requests.Load(), int32(1+opts.RateLimitRetries); got != want {
+			t.Errorf("unexpected number of requests: got:%d want:%d", got, want)
+		}
+	})
+}
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{name: "none", want: 0},
+		{name: "reset", header: map[string]string{"X-Rate-Limit-Reset": fmt.Sprint(now.Add(10 * time.Second).Unix())}, want: 10 * time.Second},
+		{name: "past_reset", header: map[string]string{"X-Rate-Limit-Reset": fmt.Sprint(now.Add(-10 * time.Second).Unix())}, want: 0},
+		{name: "retry_after_seconds", header: map[string]string{"Retry-After": "20"}, want: 20 * time.Second},
+		{name: "retry_after_date", header: map[string]string{"Retry-After": now.Add(30 * time.Second).Format(http.TimeFormat)}, want: 30 * time.Second},
+		{name: "longest", header: map[string]string{"X-Rate-Limit-Reset": fmt.Sprint(now.Add(10 * time.Second).Unix()), "Retry-After": "5"}, want: 10 * time.Second},
+		{name: "invalid", header: map[string]string{"X-Rate-Limit-Reset": "soon", "Retry-After": "later"}, want: 0},
+		{name: "capped", header: map[string]string{"Retry-After": "86400"}, want: maxWait},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := make(http.Header)
+			for k, v := range test.header {
+				h.Set(k, v)
+			}
+			if got := rateLimitWait(h, now); got != test.want {
+				t.Errorf("unexpected wait: got:%v want:%v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitRetryWait(t *testing.T) {
+	logp.TestingSetup()
+	logger := logp.L()
+
+	const msg = `[{"id":"userid","status":"STATUS","profile":{"login":"name.surname@example.com"}}]`
+
+	var (
+		mu       sync.Mutex
+		reset    time.Time
+		requests []time.Time
+	)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, time.Now())
+		w.Header().Add("x-rate-limit-limit", "1000000")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(reset.Unix()))
+		if len(requests) == 1 {
+			w.Header().Add("x-rate-limit-remaining", "0")
+			http.Error(w, "[]", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Add("x-rate-limit-remaining", "49")
+		fmt.Fprintln(w, msg)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("failed to parse server URL: %v", err)
+	}
+
+	// The rate limiter ignores the headers with a fixed limit, so only
+	// the retry waits for the reset.
+	fixedLimit := 1000000
+
+	t.Run("wait_for_reset", func(t *testing.T) {
+		mu.Lock()
+		requests = nil
+		reset = time.Now().Add(2 * time.Second).Truncate(time.Second)
+		mu.Unlock()
+		lim := NewRateLimiter(time.Minute, &fixedLimit)
+
+		_, _, err := GetUserDetails(context.Background(), ts.Client(), u.Host, "token", "", nil, OmitNone, RequestOptions{}, lim, logger)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(requests) != 2 {
+			t.Fatalf("unexpected number of requests: got:%d want:2", len(requests))
+		}
+		if requests[1].Before(reset) {
+			t.Errorf("retried before the rate limit reset: retry:%v reset:%v", requests[1], reset)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		mu.Lock()
+		requests = nil
+		reset = time.Now().Add(time.Hour)
+		mu.Unlock()
+		lim := NewRateLimiter(time.Minute, &fixedLimit)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, _, err := GetUserDetails(ctx, ts.Client(), u.Host, "token", "", nil, OmitNone, RequestOptions{}, lim, logger)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: got:%v want:%v", err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("wait was not cancelled: waited %v", elapsed)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(requests) != 1 {
+			t.Errorf("unexpected number of requests: got:%d want:1", len(requests))
+		}
+	})
+}
+
+func TestConnectionRetries(t *testing.T) {
+	logp.TestingSetup()
+	logger := logp.L()
+
+	const msg = `[{"id":"userid","status":"STATUS","profile":{"login":"name.surname@example.com"}}]`
+	want, err := mkWant[User](msg)
+	if err != nil {
+		t.Fatalf("failed to unmarshal entity data: %v", err)
+	}
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("x-rate-limit-limit", "1000000")
+		w.Header().Add("x-rate-limit-remaining", "49")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Unix()))
+		fmt.Fprintln(w, msg)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("failed to parse server URL: %v", err)
+	}
+
+	opts := RequestOptions{
+		ConnRetries: 3,
+		ConnWaitMin: time.Millisecond,
+		ConnWaitMax: 2 * time.Millisecond,
+	}
+	for _, test := range []struct {
+		name     string
+		failures int
+		opts     RequestOptions
+		wantErr  bool
+	}{
+		{name: "no_failure", failures: 0, opts: opts},
+		{name: "transient_failures", failures: 3, opts: opts},
+		{name: "too_many_failures", failures: 4, opts: opts, wantErr: true},
+		{name: "no_retries", failures: 1, opts: RequestOptions{}, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			transport := &flakyTransport{next: ts.Client().Transport, failures: test.failures}
+			cli := &http.Client{Transport: transport}
+			// Don't let the rate limiter delay retries before the limits
+			// are known.
+			fixedLimit := 1000000
+			lim := NewRateLimiter(time.Minute, &fixedLimit)
+
+			got, _, err := GetUserDetails(context.Background(), cli, u.Host, "token", "", nil, OmitNone, test.opts, lim, logger)
+			if test.wantErr {
+				if !errors.Is(err, syscall.ECONNRESET) {
+					t.Errorf("unexpected error: got:%v want:%v", err, syscall.ECONNRESET)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !cmp.Equal(want, got) {
+					t.Errorf("unexpected result:\n- want\n+ got\n%s", cmp.Diff(want, got))
+				}
+			}
+			wantRequests := min(test.failures, test.opts.ConnRetries) + 1
+			if transport.requests != wantRequests {
+				t.Errorf("unexpected number of requests: got:%d want:%d", transport.requests, wantRequests)
+			}
+		})
+	}
+}
+
+// flakyTransport fails the first failures requests with a connection reset
+// error before sending requests with next.
+type flakyTransport struct {
+	next     http.RoundTripper
+	failures int
+	requests int
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	if t.requests <= t.failures {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	return t.next.RoundTrip(req)
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	logp.TestingSetup()
+	logger := logp.L()
+
+	const msg = `[{"id":"userid","status":"STATUS","profile":{"login":"name.surname@example.com"}}]`
+	want, err := mkWant[User](msg)
+	if err != nil {
+		t.Fatalf("failed to unmarshal entity data: %v", err)
+	}
+	// The oversized response is a valid list of users padded to 1MiB.
+	huge := `[{"id":"userid","status":"` + strings.Repeat("A", 1<<20) + `"}]`
+
+	for _, test := range []struct {
+		name      string
+		oversized int
+		opts      RequestOptions
+		wantErr   bool
+		wantReqs  int
+	}{
+		{name: "no_limit", oversized: 1, opts: RequestOptions{}, wantReqs: 1},
+		{name: "within_limit", oversized: 0, opts: RequestOptions{MaxResponseSize: 1024}, wantReqs: 1},
+		{name: "exceeded", oversized: 1, opts: RequestOptions{MaxResponseSize: 1024}, wantErr: true, wantReqs: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Add("x-rate-limit-limit", "1000000")
+				w.Header().Add("x-rate-limit-remaining", "49")
+				w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Unix()))
+				if requests <= test.oversized {
+					fmt.Fprintln(w, huge)
+					return
+				}
+				fmt.Fprintln(w, msg)
+			}))
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatalf("failed to parse server URL: %v", err)
+			}
+
+			transport := &countingTransport{next: ts.Client().Transport}
+			cli := &http.Client{Transport: transport}
+			fixedLimit := 1000000
+			lim := NewRateLimiter(time.Minute, &fixedLimit)
+
+			got, _, err := GetUserDetails(context.Background(), cli, u.Host, "token", "", nil, OmitNone, test.opts, lim, logger)
+			if test.wantErr {
+				if !errors.Is(err, ErrResponseTooLarge) {
+					t.Errorf("unexpected error: got:%v want:%v", err, ErrResponseTooLarge)
+				}
+				if limit := (test.opts.MaxResponseSize + 1) * int64(test.wantReqs); transport.read > limit {
+					t.Errorf("unexpected number of bytes read: got:%d want at most:%d", transport.read, limit)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				want := want
+				if test.oversized >= test.wantReqs {
+					// The oversized response was accepted.
+					want = []User{{ID: "userid", Status: strings.Repeat("A", 1<<20)}}
+				}
+				if !cmp.Equal(want, got) {
+					t.Errorf("unexpected result:\n- want\n+ got\n%s", cmp.Diff(want, got))
+				}
+			}
+			if requests != test.wantReqs {
+				t.Errorf("unexpected number of requests: got:%d want:%d", requests, test.wantReqs)
+			}
+		})
+	}
+}
+
+// countingTransport counts the bytes read from the bodies of the responses
+// received with next.
+type countingTransport struct {
+	next http.RoundTripper
+	read int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, n: &t.read}
+	return resp, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	*b.n += int64(n)
+	return n, err
 }
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -21,10 +22,30 @@ import (
 // Each API endpoint has its own rate limit, which can be dynamically updated
 // using response headers. If a fixed limit is set, it takes precedence over any
 // information from response headers.
+//
+// A RateLimiter is safe for concurrent use.
 type RateLimiter struct {
 	window     time.Duration
 	fixedLimit *int
+
+	mu         sync.Mutex
 	byEndpoint map[string]endpointRateLimiter
+
+	// initialLimits holds the number of requests to allow in each
+	// window for endpoints that have not yet reported a limit.
+	initialLimits map[string]int
+
+	// observed holds the most recent rate limit state reported by the
+	// API for each endpoint so that it can be persisted across restarts.
+	observed map[string]EndpointLimit
+}
+
+// EndpointLimit is the rate limit state most recently reported by the API
+// for a single endpoint.
+type EndpointLimit struct {
+	Limit     float64   `json:"limit"`
+	Remaining float64   `json:"remaining"`
+	Reset     time.Time `json:"reset"`
 }
 
 // endpointRateLimiter represents rate limiting information for a single API endpoint.
@@ -49,26 +70,29 @@ const maxWait = 30 * time.Minute
 //   - A pointer to a new RateLimiter instance.
 func NewRateLimiter(window time.Duration, fixedLimit *int) *RateLimiter {
 	endpoints := make(map[string]endpointRateLimiter)
-	r := RateLimiter{
+	return &RateLimiter{
 		window:     window,
 		fixedLimit: fixedLimit,
 		byEndpoint: endpoints,
+		observed:   make(map[string]EndpointLimit),
 	}
-	r.fixedLimit = fixedLimit
-	return &r
 }
 
 var immediatelyReady = make(chan struct{})
 
 func init() { close(immediatelyReady) }
 
-func (r RateLimiter) endpoint(path string) endpointRateLimiter {
+// endpoint returns the rate limiter for path, creating it if needed.
+// r.mu must be held.
+func (r *RateLimiter) endpoint(path string) endpointRateLimiter {
 	if existing, ok := r.byEndpoint[path]; ok {
 		return existing
 	}
 	limit := rate.Limit(1)
 	if r.fixedLimit != nil {
 		limit = rate.Limit(float64(*r.fixedLimit) / r.window.Seconds())
+	} else if n, ok := r.initialLimits[path]; ok {
+		limit = rate.Limit(float64(n) / r.window.Seconds())
 	}
 	limiter := rate.NewLimiter(limit, 1) // Allow a single fetch operation to obtain limits from the API
 	newEndpointRateLimiter := endpointRateLimiter{
@@ -79,8 +103,21 @@ func (r RateLimiter) endpoint(path string) endpointRateLimiter {
 	return newEndpointRateLimiter
 }
 
-func (r RateLimiter) Wait(ctx context.Context, endpoint string, url *url.URL, log *logp.Logger) (err error) {
+// SetInitialLimits sets the number of requests to allow in each window for
+// the given endpoints until the API reports their limits. Endpoints without
+// an initial limit are allowed one request per second. Initial limits only
+// apply to endpoints that have not been used yet, and are ignored if a fixed
+// limit is set.
+func (r *RateLimiter) SetInitialLimits(limits map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.initialLimits = limits
+}
+
+func (r *RateLimiter) Wait(ctx context.Context, endpoint string, url *url.URL, log *logp.Logger) (err error) {
+	r.mu.Lock()
 	e := r.endpoint(endpoint)
+	r.mu.Unlock()
 	log.Debugw("rate limit", "limit", e.limiter.Limit(), "burst", e.limiter.Burst(), "url", url.String())
 	ctxWithDeadline, cancel := context.WithDeadline(ctx, time.Now().Add(maxWait))
 	defer cancel()
@@ -95,11 +132,10 @@ func (r RateLimiter) Wait(ctx context.Context, endpoint string, url *url.URL, lo
 // Update implements the Okta rate limit policy translation.
 //
 // See https://developer.okta.com/docs/reference/rl-best-practices/ for details.
-func (r RateLimiter) Update(endpoint string, h http.Header, log *logp.Logger) error {
+func (r *RateLimiter) Update(endpoint string, h http.Header, log *logp.Logger) error {
 	if r.fixedLimit != nil {
 		return nil
 	}
-	e := r.endpoint(endpoint)
 	limit := h.Get("X-Rate-Limit-Limit")
 	remaining := h.Get("X-Rate-Limit-Remaining")
 	reset := h.Get("X-Rate-Limit-Reset")
@@ -121,6 +157,56 @@ func (r RateLimiter) Update(endpoint string, h http.Header, log *logp.Logger) er
 		return err
 	}
 	resetTime := time.Unix(rst, 0)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.endpoint(endpoint)
+	r.observed[endpoint] = EndpointLimit{Limit: lim, Remaining: rem, Reset: resetTime}
+	r.apply(endpoint, e, lim, rem, resetTime, log)
+	return nil
+}
+
+// State returns the most recently observed rate limit state for each
+// endpoint whose reset time has not yet passed. The returned map may be
+// persisted and passed to Restore to resume respecting the rate limit
+// windows after a restart.
+func (r *RateLimiter) State() map[string]EndpointLimit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	state := make(map[string]EndpointLimit, len(r.observed))
+	for endpoint, l := range r.observed {
+		if l.Reset.After(now) {
+			state[endpoint] = l
+		}
+	}
+	return state
+}
+
+// Restore applies previously observed rate limit state, as returned by
+// State, to the rate limiter. Entries whose reset time has passed are
+// ignored since the API will provide fresh guidance on the next request.
+// If a fixed limit is set, Restore is a no-op.
+func (r *RateLimiter) Restore(state map[string]EndpointLimit, log *logp.Logger) {
+	if r.fixedLimit != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for endpoint, l := range state {
+		if !l.Reset.After(now) {
+			continue
+		}
+		log.Debugw("rate limit restore", "endpoint", endpoint, "limit", l.Limit, "remaining", l.Remaining, "reset_time", l.Reset.UTC())
+		r.observed[endpoint] = l
+		r.apply(endpoint, r.endpoint(endpoint), l.Limit, l.Remaining, l.Reset, log)
+	}
+}
+
+// apply sets the rate limit for the endpoint e based on the limit, the
+// number of remaining requests and the time at which the limit resets.
+// r.mu must be held.
+func (r *RateLimiter) apply(endpoint string, e endpointRateLimiter, lim, rem float64, resetTime time.Time, log *logp.Logger) {
 	per := time.Until(resetTime).Seconds()
 
 	// Be conservative here; the docs don't exactly specify burst rates.
@@ -164,10 +250,9 @@ func (r RateLimiter) Update(endpoint string, h http.Header, log *logp.Logger) er
 			log.Debugw("rate limit reset", "reset_time", resetTimeUTC, "reset_rate", next, "reset_burst", burst)
 		})
 
-		return nil
+		return
 	}
 	e.limiter.SetLimit(rateLimit)
 	e.limiter.SetBurst(burst)
 	log.Debugw("rate limit adjust", "set_rate", rateLimit, "set_burst", burst)
-	return nil
 }
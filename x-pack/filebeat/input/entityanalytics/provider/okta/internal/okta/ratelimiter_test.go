@@ -6,6 +6,7 @@ package okta
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -170,6 +171,48 @@ func TestRateLimiter(t *testing.T) {
 		}
 	})
 
+	t.Run("Initial limits apply per endpoint before response information", func(t *testing.T) {
+		const window = time.Minute
+		r := NewRateLimiter(window, nil)
+		r.SetInitialLimits(map[string]int{"/api/v1/users": 600})
+
+		e := r.endpoint("/api/v1/users")
+		if e.limiter.Limit() != 600/60 {
+			t.Errorf("unexpected initial rate (for 600 reqs / 60 secs): %f", e.limiter.Limit())
+		}
+		e = r.endpoint("/api/v1/devices")
+		if e.limiter.Limit() != 1 {
+			t.Errorf("unexpected initial rate for endpoint without an initial limit: %f", e.limiter.Limit())
+		}
+
+		// update to 15 requests remaining, reset in 30s
+		headers := http.Header{
+			"X-Rate-Limit-Limit":     []string{"600"},
+			"X-Rate-Limit-Remaining": []string{"15"},
+			"X-Rate-Limit-Reset":     []string{strconv.FormatInt(time.Now().Unix()+30, 10)},
+		}
+		err := r.Update("/api/v1/users", headers, logp.L())
+		if err != nil {
+			t.Errorf("unexpected error from Update(): %v", err)
+		}
+		e = r.endpoint("/api/v1/users")
+		if e.limiter.Limit() >= 600/60 {
+			t.Errorf("initial rate not replaced by response information: %f", e.limiter.Limit())
+		}
+	})
+
+	t.Run("A fixed limit overrides initial limits", func(t *testing.T) {
+		const window = time.Minute
+		var fixedLimit int = 120
+		r := NewRateLimiter(window, &fixedLimit)
+		r.SetInitialLimits(map[string]int{"/api/v1/users": 600})
+
+		e := r.endpoint("/api/v1/users")
+		if e.limiter.Limit() != 120/60 {
+			t.Errorf("unexpected rate (for fixed 120 reqs / 60 secs): %f", e.limiter.Limit())
+		}
+	})
+
 	t.Run("A concurrent rate limit should not set a new rate of zero", func(t *testing.T) {
 		const window = time.Minute
 		r := NewRateLimiter(window, nil)
@@ -217,4 +260,72 @@ func TestRateLimiter(t *testing.T) {
 			t.Errorf("expected rate %f, but got %f, after exceeding the concurrent rate limit", expectedNewLimit, newLimit)
 		}
 	})
+
+	t.Run("Restored state honors the saved reset time", func(t *testing.T) {
+		const window = time.Minute
+		r := NewRateLimiter(window, nil)
+		const endpoint = "/foo"
+
+		// update to none remaining, reset well in the future
+		reset := time.Now().Add(time.Hour).Unix()
+		headers := http.Header{
+			"X-Rate-Limit-Limit":     []string{"60"},
+			"X-Rate-Limit-Remaining": []string{"0"},
+			"X-Rate-Limit-Reset":     []string{strconv.FormatInt(reset, 10)},
+		}
+		err := r.Update(endpoint, headers, logp.L())
+		if err != nil {
+			t.Errorf("unexpected error from Update(): %v", err)
+		}
+
+		// Round-trip the state through JSON as it would be when persisted.
+		b, err := json.Marshal(r.State())
+		if err != nil {
+			t.Fatalf("unexpected error marshaling state: %v", err)
+		}
+		var state map[string]EndpointLimit
+		err = json.Unmarshal(b, &state)
+		if err != nil {
+			t.Fatalf("unexpected error unmarshaling state: %v", err)
+		}
+		if got := state[endpoint].Reset.Unix(); got != reset {
+			t.Errorf("unexpected persisted reset time: got:%d want:%d", got, reset)
+		}
+
+		restored := NewRateLimiter(window, nil)
+		restored.Restore(state, logp.L())
+		e := restored.endpoint(endpoint)
+
+		select {
+		case <-e.ready:
+			t.Errorf("restored limiter is ready before the saved reset time")
+		default:
+		}
+		if e.limiter.Allow() {
+			t.Errorf("restored limiter allowed a request when none are remaining")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		u, _ := url.Parse(endpoint)
+		err = restored.Wait(ctx, endpoint, u, logp.L())
+		if err == nil {
+			t.Errorf("expected restored limiter to block until the saved reset time")
+		}
+	})
+
+	t.Run("Expired state is not restored", func(t *testing.T) {
+		const window = time.Minute
+		state := map[string]EndpointLimit{
+			"/foo": {Limit: 60, Remaining: 0, Reset: time.Now().Add(-time.Minute)},
+		}
+		r := NewRateLimiter(window, nil)
+		r.Restore(state, logp.L())
+		if !r.endpoint("/foo").limiter.Allow() {
+			t.Errorf("doesn't allow an initial request after restoring expired state")
+		}
+		if len(r.State()) != 0 {
+			t.Errorf("unexpected state after restoring expired state: %v", r.State())
+		}
+	})
 }
@@ -20,6 +20,8 @@ type inputMetrics struct {
 	updateTotal          *monitoring.Uint // The total number of incremental updates.
 	updateError          *monitoring.Uint // The number of incremental updates that failed due to an error.
 	updateProcessingTime metrics.Sample   // Histogram of the elapsed incremental update times in nanoseconds (time of API contact to items sent to output).
+	apiRequests          *monitoring.Uint // The total number of API requests sent, including retries.
+	apiRetries           *monitoring.Uint // The number of API requests that were retries of a failed request.
 }
 
 // newMetrics creates a new instance for gathering metrics.
@@ -31,6 +33,8 @@ func newMetrics(reg *monitoring.Registry, logger *logp.Logger) *inputMetrics {
 		updateTotal:          monitoring.NewUint(reg, "update_total"),
 		updateError:          monitoring.NewUint(reg, "update_error"),
 		updateProcessingTime: metrics.NewUniformSample(1024),
+		apiRequests:          monitoring.NewUint(reg, "api_requests_total"),
+		apiRetries:           monitoring.NewUint(reg, "api_retries_total"),
 	}
 
 	adapter.NewGoMetrics(reg, "sync_processing_time", logger, adapter.Accept).Register("histogram", metrics.NewHistogram(out.syncProcessingTime))     //nolint:errcheck // A unique namespace is used so name collisions are impossible.
@@ -61,7 +61,7 @@ func (o *oAuth2Config) fetchOktaOauthClient(ctx context.Context, client *http.Cl
 		},
 	}
 
-	var tokenSource oauth2.TokenSource
+	var tokenSource invalidatingTokenSource
 	var err error
 
 	// Determine authentication method based on provided credentials
@@ -126,12 +126,69 @@ func (o *oAuth2Config) fetchOktaOauthClient(ctx context.Context, client *http.Cl
 		return nil, errors.New("no authentication credentials provided")
 	}
 
-	// Inject the configured HTTP client into the context so that
-	// oauth2.NewClient uses its transport (which carries TLS settings)
-	// as the base transport for API requests, rather than falling back
-	// to http.DefaultTransport.
-	ctxWithClient := context.WithValue(ctx, oauth2.HTTPClient, client)
-	return oauth2.NewClient(ctxWithClient, tokenSource), nil
+	// Use the transport of the configured HTTP client (which carries TLS
+	// settings) as the base transport for API requests, rather than
+	// falling back to http.DefaultTransport.
+	return &http.Client{
+		Transport: &unauthorizedRetryTransport{
+			base:   &oauth2.Transport{Base: client.Transport, Source: tokenSource},
+			source: tokenSource,
+		},
+	}, nil
+}
+
+// invalidatingTokenSource is an oauth2.TokenSource caching its token until
+// it is invalidated or expires.
+type invalidatingTokenSource interface {
+	oauth2.TokenSource
+	invalidate()
+}
+
+// unauthorizedRetryTransport retries a request once with a new token when
+// the API rejects the current token, for example because it was revoked
+// before its expiry.
+type unauthorizedRetryTransport struct {
+	base   http.RoundTripper
+	source invalidatingTokenSource
+}
+
+func (t *unauthorizedRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// A request body can only be sent again if it can be recreated.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	t.source.invalidate()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// invalidate discards the cached token, so that the next call to Token
+// obtains a new one.
+func (cs *clientSecretTokenSource) invalidate() {
+	cs.mu.Lock()
+	cs.token = nil
+	cs.mu.Unlock()
+}
+
+// invalidate discards the cached token, so that the next call to Token
+// obtains a new one.
+func (ts *oktaTokenSource) invalidate() {
+	ts.mu.Lock()
+	ts.token = nil
+	ts.mu.Unlock()
 }
 
 // Token implements oauth2.TokenSource for client secret authentication.
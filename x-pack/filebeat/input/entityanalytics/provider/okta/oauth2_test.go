@@ -8,6 +8,7 @@ package okta
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -493,3 +494,75 @@ func TestFetchOktaOauthClient_JWKJSONRoundTrip(t *testing.T) {
 		t.Errorf("GET /resource status = %d; want %d", resp.StatusCode, http.StatusOK)
 	}
 }
+
+func TestFetchOktaOauthClient_RefreshOnUnauthorized(t *testing.T) {
+	var (
+		tokens int
+		auths  []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokens++
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"access_token": fmt.Sprintf("token-%d", tokens),
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+			})
+		case "/resource":
+			auth := r.Header.Get("Authorization")
+			auths = append(auths, auth)
+			// Only the second token is accepted.
+			if auth != "Bearer token-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &oAuth2Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-secret",
+		Scopes:       []string{"okta.users.read"},
+		TokenURL:     srv.URL + "/token",
+	}
+	client, err := cfg.fetchOktaOauthClient(context.Background(), srv.Client())
+	if err != nil {
+		t.Fatalf("fetchOktaOauthClient() error: %v", err)
+	}
+
+	get := func() int {
+		resp, err := client.Get(srv.URL + "/resource") //nolint:noctx // No need for a context here.
+		if err != nil {
+			t.Fatalf("GET /resource error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The first token is rejected, so it is refreshed and the request is
+	// retried with the second token.
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, auths)
+	assert.Equal(t, 2, tokens)
+
+	// The refreshed token is reused.
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, 2, tokens)
+
+	// A request rejected with a new token is not retried again.
+	auths = nil
+	cfg.ClientSecret = "other-secret"
+	tokens = 2
+	client, err = cfg.fetchOktaOauthClient(context.Background(), srv.Client())
+	if err != nil {
+		t.Fatalf("fetchOktaOauthClient() error: %v", err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, get())
+	assert.Equal(t, []string{"Bearer token-3", "Bearer token-4"}, auths)
+}
@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"slices"
@@ -34,6 +35,7 @@ import (
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-libs/paths"
 	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
 	"github.com/elastic/go-concert/ctxtool"
@@ -114,6 +116,14 @@ func (p *oktaInput) Run(inputCtx v2.Context, store *kvstore.Store, client beat.C
 
 	// Allow a single fetch operation to obtain limits from the API.
 	p.lim = okta.NewRateLimiter(p.cfg.LimitWindow, p.cfg.LimitFixed)
+	p.lim.SetInitialLimits(p.cfg.LimitInitial)
+	if p.cfg.LimitPersist {
+		limits, err := getRateLimits(store)
+		if err != nil && !errIsItemNotFound(err) {
+			p.logger.Warnw("failed to get persisted rate limits", "error", err)
+		}
+		p.lim.Restore(limits, p.logger)
+	}
 
 	if p.cfg.Tracer != nil {
 		resolved, err := httplog.ResolveTraceFilename(inputCtx.Agent.Paths, Name, inputCtx.IDWithoutName, p.cfg.Tracer.Filename)
@@ -123,8 +133,12 @@ func (p *oktaInput) Run(inputCtx v2.Context, store *kvstore.Store, client beat.C
 		p.cfg.Tracer.Filename = resolved
 	}
 
+	if p.cfg.Request.UserAgent == "" {
+		p.cfg.Request.UserAgent = inputCtx.Agent.UserAgent
+	}
+
 	var err error
-	p.client, err = newClient(ctxtool.FromCanceller(inputCtx.Cancelation), p.cfg, p.logger)
+	p.client, err = newClient(ctxtool.FromCanceller(inputCtx.Cancelation), p.cfg, p.metrics, p.logger)
 	if err != nil {
 		return err
 	}
@@ -142,7 +156,9 @@ func (p *oktaInput) Run(inputCtx v2.Context, store *kvstore.Store, client beat.C
 			return nil
 		case <-syncTimer.C:
 			start := time.Now()
-			if err := p.runFullSync(inputCtx, store, client); err != nil {
+			summary := p.newSyncSummary(start)
+			err := p.runFullSync(inputCtx, store, client, summary)
+			if err != nil {
 				msg := "Error running full sync"
 				p.logger.Errorw(msg, "error", err)
 				inputCtx.UpdateStatus(status.Degraded, fmt.Sprintf("%s: %v", msg, err))
@@ -152,6 +168,13 @@ func (p *oktaInput) Run(inputCtx v2.Context, store *kvstore.Store, client beat.C
 			}
 			p.metrics.syncTotal.Inc()
 			p.metrics.syncProcessingTime.Update(time.Since(start).Nanoseconds())
+			p.persistRateLimits(store)
+			if p.cfg.SyncSummary {
+				p.publishSyncSummary(summary, err, inputCtx.ID, client)
+			}
+			if p.cfg.RateLimitEvent {
+				p.publishRateLimits(inputCtx.ID, client)
+			}
 
 			syncTimer.Reset(p.cfg.SyncInterval)
 			p.logger.Debugf("Next sync expected at: %v", time.Now().Add(p.cfg.SyncInterval))
@@ -176,19 +199,39 @@ func (p *oktaInput) Run(inputCtx v2.Context, store *kvstore.Store, client beat.C
 			}
 			p.metrics.updateTotal.Inc()
 			p.metrics.updateProcessingTime.Update(time.Since(start).Nanoseconds())
+			p.persistRateLimits(store)
+			if p.cfg.RateLimitEvent {
+				p.publishRateLimits(inputCtx.ID, client)
+			}
 			updateTimer.Reset(p.cfg.UpdateInterval)
 			p.logger.Debugf("Next update expected at: %v", time.Now().Add(p.cfg.UpdateInterval))
 		}
 	}
 }
 
-func newClient(ctx context.Context, cfg conf, log *logp.Logger) (*http.Client, error) {
+// persistRateLimits stores the current API rate limit state if limit
+// persistence is configured. The state is stored independently of the
+// sync state so that it is kept even when a sync or update fails, which
+// is when it is most likely to be needed.
+func (p *oktaInput) persistRateLimits(store *kvstore.Store) {
+	if !p.cfg.LimitPersist {
+		return
+	}
+	err := setRateLimits(store, p.lim.State())
+	if err != nil {
+		p.logger.Warnw("failed to persist rate limits", "error", err)
+	}
+}
+
+func newClient(ctx context.Context, cfg conf, metrics *inputMetrics, log *logp.Logger) (*http.Client, error) {
 	c, err := cfg.Request.Transport.Client(clientOptions(cfg.Request.KeepAlive.settings(), log)...)
 	if err != nil {
 		return nil, err
 	}
 
 	c = requestTrace(ctx, c, cfg, log)
+	c.Transport = countingRoundTripper{next: c.Transport, count: metrics.apiRequests}
+	c.Transport = headerRoundTripper{next: c.Transport, userAgent: cfg.Request.UserAgent, headers: cfg.Request.Headers}
 
 	c.CheckRedirect = checkRedirect(cfg.Request, log)
 
@@ -210,10 +253,51 @@ func newClient(ctx context.Context, cfg conf, log *logp.Logger) (*http.Client, e
 		RetryMax:     cfg.Request.Retry.getMaxAttempts(),
 		CheckRetry:   retryablehttp.DefaultRetryPolicy,
 		Backoff:      retryablehttp.DefaultBackoff,
+		RequestLogHook: func(_ retryablehttp.Logger, _ *http.Request, attempt int) {
+			if attempt > 0 {
+				metrics.apiRetries.Inc()
+			}
+		},
 	}
 	return client.StandardClient(), nil
 }
 
+// countingRoundTripper counts the requests sent by the wrapped round tripper.
+type countingRoundTripper struct {
+	next  http.RoundTripper
+	count *monitoring.Uint
+}
+
+func (rt countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.count.Inc()
+	return rt.next.RoundTrip(req)
+}
+
+// headerRoundTripper adds the configured User-Agent and headers to the
+// requests sent by the wrapped round tripper. This includes the requests
+// obtaining OAuth2 tokens.
+type headerRoundTripper struct {
+	next      http.RoundTripper
+	userAgent string
+	headers   map[string]string
+}
+
+func (rt headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.userAgent == "" && len(rt.headers) == 0 {
+		return rt.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if rt.userAgent != "" {
+		req.Header.Set("User-Agent", rt.userAgent)
+	}
+	for k, v := range rt.headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	return rt.next.RoundTrip(req)
+}
+
 // requestTrace decorates cli with an httplog.LoggingRoundTripper if cfg.Tracer
 // is non-nil.
 func requestTrace(ctx context.Context, cli *http.Client, cfg conf, log *logp.Logger) *http.Client {
@@ -297,8 +381,10 @@ func (l *retryLog) Warn(msg string, kv ...interface{})  { l.log.Warnw(msg, kv...
 // runFullSync performs a full synchronization. It will fetch user and group
 // identities from Azure Active Directory, enrich users with group memberships,
 // and publishes all known users (regardless if they have been modified) to the
-// given beat.Client.
-func (p *oktaInput) runFullSync(inputCtx v2.Context, store *kvstore.Store, client beat.Client) error {
+// given beat.Client. If full_sync_emit is "changed", only the users whose
+// content changed since they were last published are published, along with
+// the users that no longer exist.
+func (p *oktaInput) runFullSync(inputCtx v2.Context, store *kvstore.Store, client beat.Client, summary *syncSummary) error {
 	p.logger.Debugf("Running full sync...")
 
 	p.logger.Debugf("Opening new transaction...")
@@ -314,8 +400,10 @@ func (p *oktaInput) runFullSync(inputCtx v2.Context, store *kvstore.Store, clien
 		}
 	}()
 
+	var partial error
 	wantUsers := p.cfg.wantUsers()
 	wantDevices := p.cfg.wantDevices()
+	changedOnly := p.cfg.FullSyncEmit == "changed"
 	if wantUsers || wantDevices {
 		ctx := ctxtool.FromCanceller(inputCtx.Cancelation)
 		p.logger.Debugf("Starting fetch...")
@@ -326,24 +414,59 @@ func (p *oktaInput) runFullSync(inputCtx v2.Context, store *kvstore.Store, clien
 		p.publishMarker(start, start, inputCtx.ID, true, client, tracker)
 
 		if wantUsers {
+			seen := make(map[string]bool)
 			err = p.doFetchUsers(ctx, state, true, func(u *User) {
-				p.publishUser(u, state, inputCtx.ID, client, tracker)
+				summary.addUser(u)
+				seen[u.ID] = true
+				if u.updateHash() || !changedOnly {
+					p.publishUser(u, state, inputCtx.ID, client, tracker)
+				}
 			})
-			if err != nil {
+			if err != nil && !isPartial(err) {
 				return err
 			}
+			partial = err
+			// Users missing from a partial result may still exist.
+			if changedOnly && err == nil {
+				deleted, err := state.deleteUsers(seen)
+				if err != nil {
+					return err
+				}
+				for _, u := range deleted {
+					p.publishUser(u, state, inputCtx.ID, client, tracker)
+				}
+			}
 		}
 		if wantDevices {
+			seen := make(map[string]bool)
 			err = p.doFetchDevices(ctx, state, true, func(d *Device) {
-				p.publishDevice(d, state, inputCtx.ID, client, tracker)
+				summary.devices++
+				seen[d.ID] = true
+				if d.updateHash() || !changedOnly {
+					p.publishDevice(d, state, inputCtx.ID, client, tracker)
+				}
 			})
-			if err != nil {
+			if err != nil && !isPartial(err) {
 				return err
 			}
+			partial = errors.Join(partial, err)
+			if changedOnly && err == nil {
+				deleted, err := state.deleteDevices(seen)
+				if err != nil {
+					return err
+				}
+				for _, d := range deleted {
+					p.publishDevice(d, state, inputCtx.ID, client, tracker)
+				}
+			}
 		}
 
-		end := time.Now()
-		p.publishMarker(end, end, inputCtx.ID, false, client, tracker)
+		// The end marker signals that all entities have been
+		// published, so it is not sent for a partial result.
+		if partial == nil {
+			end := time.Now()
+			p.publishMarker(end, end, inputCtx.ID, false, client, tracker)
+		}
 
 		tracker.Wait()
 
@@ -352,13 +475,17 @@ func (p *oktaInput) runFullSync(inputCtx v2.Context, store *kvstore.Store, clien
 		}
 	}
 
-	state.lastSync = time.Now()
+	// Keep the entities of a partial result, but leave the last
+	// sync time so that the full sync is not considered complete.
+	if partial == nil {
+		state.lastSync = time.Now()
+	}
 	err = state.close(true)
 	if err != nil {
 		return fmt.Errorf("unable to commit state: %w", err)
 	}
 
-	return nil
+	return partial
 }
 
 // runIncrementalUpdate will run an incremental update. The process is similar
@@ -381,23 +508,28 @@ func (p *oktaInput) runIncrementalUpdate(inputCtx v2.Context, store *kvstore.Sto
 	ctx := ctxtool.FromCanceller(inputCtx.Cancelation)
 	tracker := kvstore.NewTxTracker(ctx)
 
+	var partial error
 	if p.cfg.wantUsers() {
 		p.logger.Debugf("Fetching changed users...")
 		err = p.doFetchUsers(ctx, state, false, func(u *User) {
+			u.updateHash()
 			p.publishUser(u, state, inputCtx.ID, client, tracker)
 		})
-		if err != nil {
+		if err != nil && !isPartial(err) {
 			return err
 		}
+		partial = err
 	}
 	if p.cfg.wantDevices() {
 		p.logger.Debugf("Fetching changed devices...")
 		err = p.doFetchDevices(ctx, state, false, func(d *Device) {
+			d.updateHash()
 			p.publishDevice(d, state, inputCtx.ID, client, tracker)
 		})
-		if err != nil {
+		if err != nil && !isPartial(err) {
 			return err
 		}
+		partial = errors.Join(partial, err)
 	}
 
 	tracker.Wait()
@@ -405,14 +537,123 @@ func (p *oktaInput) runIncrementalUpdate(inputCtx v2.Context, store *kvstore.Sto
 		return ctx.Err()
 	}
 
-	state.lastUpdate = time.Now()
+	if partial == nil {
+		state.lastUpdate = time.Now()
+	}
 	if err = state.close(true); err != nil {
 		return fmt.Errorf("unable to commit state: %w", err)
 	}
 
+	return partial
+}
+
+// partialError is returned by an enumeration that failed to get a page
+// after earlier pages had been fetched and published, when the page_errors
+// option is "partial".
+type partialError struct {
+	pages int // The number of pages fetched before the failure.
+	err   error
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("partial result after %d pages: %v", e.pages, e.err)
+}
+
+func (e *partialError) Unwrap() error { return e.err }
+
+// isPartial returns whether err is a partial result error.
+func isPartial(err error) bool {
+	var pe *partialError
+	return errors.As(err, &pe)
+}
+
+// pageError returns the error for a failure to get a page after pages
+// pages have been fetched. If the page_errors option is "partial" and
+// pages were fetched, err is returned as a partial result error.
+func (p *oktaInput) pageError(pages int, err error) error {
+	if p.cfg.PageErrors != "partial" || pages == 0 {
+		return err
+	}
+	return &partialError{pages: pages, err: err}
+}
+
+// userPages calls fn with each page of users returned by the API for query,
+// following pagination links until the last page or the first error. If
+// the prefetch_pages option is set, the next page is requested while fn
+// is processing the current one.
+func (p *oktaInput) userPages(ctx context.Context, query url.Values, omit okta.Response, fn func([]okta.User)) error {
+	if !p.cfg.PrefetchPages {
+		for {
+			batch, next, err := p.userPage(ctx, query, omit)
+			fn(batch)
+			if err != nil || next == nil {
+				return err
+			}
+			query = next
+		}
+	}
+
+	type page struct {
+		users []okta.User
+		err   error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	pages := make(chan page)
+	defer func() {
+		// Stop any outstanding prefetch and wait for it to complete.
+		cancel()
+		for range pages {
+		}
+	}()
+	go func() {
+		defer close(pages)
+		for {
+			batch, next, err := p.userPage(ctx, query, omit)
+			select {
+			case pages <- page{users: batch, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil || next == nil {
+				return
+			}
+			query = next
+		}
+	}()
+	for pg := range pages {
+		fn(pg.users)
+		if pg.err != nil {
+			return pg.err
+		}
+	}
 	return nil
 }
 
+// omitRecoveryQuestion removes the recovery question from the credentials
+// of users.
+func omitRecoveryQuestion(users []okta.User) {
+	for i := range users {
+		if users[i].Credentials != nil {
+			users[i].Credentials.RecoveryQuestion = nil
+		}
+	}
+}
+
+// userPage returns a single page of users and the query for the following
+// page. If there are no more pages, next is nil.
+func (p *oktaInput) userPage(ctx context.Context, query url.Values, omit okta.Response) (batch []okta.User, next url.Values, err error) {
+	batch, h, err := okta.GetUserDetails(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), "", query, omit, p.cfg.Request.requestOptions(), p.lim, p.logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.logger.Debugf("received batch of %d users from API", len(batch))
+	next, err = okta.Next(h)
+	if err == io.EOF {
+		return batch, nil, nil
+	}
+	return batch, next, err
+}
+
 // doFetchUsers handles fetching user identities from Okta. If fullSync is true, then
 // any existing deltaLink will be ignored, forcing a full synchronization from Okta.
 // Returns a set of modified users by ID.
@@ -474,16 +715,23 @@ func (p *oktaInput) doFetchUsers(ctx context.Context, state *stateStore, fullSyn
 
 	var (
 		n           int
+		pages       int
 		lastUpdated time.Time
 	)
-	for {
-		batch, h, err := okta.GetUserDetails(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), "", query, omit, p.lim, p.logger)
-		if err != nil {
-			p.logger.Debugf("received %d users from API", n)
-			return err
+	err = p.userPages(ctx, query, omit, func(batch []okta.User) {
+		if batch == nil {
+			// The page could not be fetched.
+			return
+		}
+		pages++
+		if !p.cfg.keepLinks("users") {
+			for i := range batch {
+				batch[i].Links = nil
+			}
+		}
+		if p.cfg.OmitRecoveryQuestion {
+			omitRecoveryQuestion(batch)
 		}
-		p.logger.Debugf("received batch of %d users from API", len(batch))
-
 		if fullSync {
 			for _, u := range batch {
 				doPublish(p.addUserMetadata(ctx, u, state, permsCache))
@@ -501,16 +749,13 @@ func (p *oktaInput) doFetchUsers(ctx context.Context, state *stateStore, fullSyn
 				}
 			}
 		}
-
-		next, err := okta.Next(h)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			p.logger.Debugf("received %d users from API", n)
+	})
+	if err != nil {
+		p.logger.Debugf("received %d users from API", n)
+		err = p.pageError(pages, err)
+		if !isPartial(err) {
 			return err
 		}
-		query = next
 	}
 
 	if wantSupervises {
@@ -546,6 +791,12 @@ func (p *oktaInput) doFetchUsers(ctx context.Context, state *stateStore, fullSyn
 		}
 	}
 
+	if err != nil {
+		// Keep the previous query so that the next update
+		// resumes from where this one started.
+		return err
+	}
+
 	// Prepare query for next update. This is any record that was updated
 	// at or after the last updated record we saw this round. Use this rather
 	// than time.Now() since we may have received stale records. Use ge
@@ -571,7 +822,7 @@ func (p *oktaInput) addUserMetadata(ctx context.Context, u okta.User, state *sta
 		return su
 	}
 	if slices.Contains(p.cfg.EnrichWith, "groups") {
-		groups, _, err := okta.GetUserGroupDetails(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.lim, p.logger)
+		groups, _, err := okta.GetUserGroupDetails(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.cfg.Request.requestOptions(), p.lim, p.logger)
 		if err != nil {
 			p.logger.Warnf("failed to get user group membership for %s: %v", u.ID, err)
 		} else {
@@ -579,7 +830,7 @@ func (p *oktaInput) addUserMetadata(ctx context.Context, u okta.User, state *sta
 		}
 	}
 	if slices.Contains(p.cfg.EnrichWith, "factors") {
-		factors, _, err := okta.GetUserFactors(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.lim, p.logger)
+		factors, _, err := okta.GetUserFactors(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.cfg.Request.requestOptions(), p.lim, p.logger)
 		if err != nil {
 			p.logger.Warnf("failed to get user factors for %s: %v", u.ID, err)
 		} else {
@@ -587,7 +838,7 @@ func (p *oktaInput) addUserMetadata(ctx context.Context, u okta.User, state *sta
 		}
 	}
 	if slices.Contains(p.cfg.EnrichWith, "roles") || slices.Contains(p.cfg.EnrichWith, "perms") {
-		roles, _, err := okta.GetUserRoles(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.lim, p.logger)
+		roles, _, err := okta.GetUserRoles(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.cfg.Request.requestOptions(), p.lim, p.logger)
 		if err != nil {
 			p.logger.Warnf("failed to get user roles for %s: %v", u.ID, err)
 		} else {
@@ -603,7 +854,7 @@ func (p *oktaInput) addUserMetadata(ctx context.Context, u okta.User, state *sta
 					// run and reuse them to avoid O(users * custom_roles) API calls.
 					perms, cached := permsCache[role.RoleID]
 					if !cached {
-						perms, _, err = okta.GetRolePermissions(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), role.RoleID, p.lim, p.logger)
+						perms, _, err = okta.GetRolePermissions(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), role.RoleID, p.cfg.Request.requestOptions(), p.lim, p.logger)
 						if err != nil {
 							p.logger.Warnf("failed to get permissions for role %s: %v", role.RoleID, err)
 							continue
@@ -617,7 +868,7 @@ func (p *oktaInput) addUserMetadata(ctx context.Context, u okta.User, state *sta
 		}
 	}
 	if slices.Contains(p.cfg.EnrichWith, "devices") {
-		devices, _, err := okta.GetUserDevices(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.lim, p.logger)
+		devices, _, err := okta.GetUserDevices(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), u.ID, p.cfg.Request.requestOptions(), p.lim, p.logger)
 		if err != nil {
 			p.logger.Warnf("failed to get enrolled devices for user %s: %v", u.ID, err)
 		} else {
@@ -716,51 +967,52 @@ func (p *oktaInput) doFetchDevices(ctx context.Context, state *stateStore, fullS
 
 	var (
 		n           int
+		pages       int
 		lastUpdated time.Time
 	)
-	for {
-		batch, h, err := okta.GetDeviceDetails(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), "", deviceQuery, p.lim, p.logger)
+	for ; ; pages++ {
+		batch, h, err := okta.GetDeviceDetails(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), "", deviceQuery, p.cfg.Request.requestOptions(), p.lim, p.logger)
 		if err != nil {
 			p.logger.Debugf("received %d devices from API", n)
-			return err
+			return p.pageError(pages, err)
 		}
 		p.logger.Debugf("received batch of %d devices from API", len(batch))
 
-		for i, d := range batch {
-			userQuery := cloneURLValues(userQueryInit)
-			for {
-				// TODO: Consider softening the response to errors here. If we fail to get users
-				// from a device, do we want to fail completely? There are arguments in both
-				// directions. We _could_ keep a multierror and return that in the end, which
-				// would guarantee progression, but may result in holes in the data. What we are
-				// doing at the moment (both here and in doFetchUsers) guarantees no holes, but
-				// at the cost of potentially not making progress.
-
-				const omit = okta.OmitCredentials | okta.OmitCredentialsLinks | okta.OmitTransitioningToStatus
-
-				users, h, err := okta.GetDeviceUsers(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), d.ID, userQuery, omit, p.lim, p.logger)
-				if err != nil {
-					p.logger.Debugf("received %d device users from API", len(users))
-					return err
-				}
-				p.logger.Debugf("received batch of %d device users from API", len(users))
+		// If we fail to get the users of a device, the page of devices is not
+		// published. By default this fails the enumeration, which guarantees no
+		// holes in the data at the cost of potentially not making progress. With
+		// the "partial" page_errors policy, the devices of the preceding pages are
+		// kept and a partial result error is returned.
 
-				// Users are not stored in the state as they are in doFetchUsers. We expect
-				// them to already have been discovered/stored from that call and are stored
-				// associated with the device undecorated with discovery state. Or, if the
-				// the dataset is set to "devices", then we have been asked not to care about
-				// this detail.
-				batch[i].Users = append(batch[i].Users, users...)
+		const omit = okta.OmitCredentials | okta.OmitCredentialsLinks | okta.OmitTransitioningToStatus
 
-				next, err := okta.Next(h)
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-					p.logger.Debugf("received %d devices from API", n)
-					return err
+		ids := make([]string, len(batch))
+		for i, d := range batch {
+			ids[i] = d.ID
+		}
+		users, err := okta.GetDevicesUsers(ctx, p.client, p.cfg.OktaDomain, p.getAuthToken(), ids, userQueryInit, omit, p.cfg.DeviceUserWorkers, p.cfg.Request.requestOptions(), p.lim, p.logger)
+		if err != nil {
+			p.logger.Debugf("received %d devices from API", n)
+			return p.pageError(pages, err)
+		}
+		for i, d := range batch {
+			// Users are not stored in the state as they are in doFetchUsers. We expect
+			// them to already have been discovered/stored from that call and are stored
+			// associated with the device undecorated with discovery state. Or, if the
+			// the dataset is set to "devices", then we have been asked not to care about
+			// this detail.
+			p.logger.Debugf("received %d device users from API", len(users[d.ID]))
+			batch[i].Users = append(batch[i].Users, users[d.ID]...)
+			if !p.cfg.keepLinks("devices") {
+				batch[i].Links = nil
+			}
+			if !p.cfg.keepLinks("device_users") {
+				for j := range batch[i].Users {
+					batch[i].Users[j].Links = nil
 				}
-				userQuery = next
+			}
+			if p.cfg.OmitRecoveryQuestion {
+				omitRecoveryQuestion(batch[i].Users)
 			}
 		}
 
@@ -788,7 +1040,8 @@ func (p *oktaInput) doFetchDevices(ctx context.Context, state *stateStore, fullS
 				break
 			}
 			p.logger.Debugf("received %d devices from API", n)
-			return err
+			// The current page has been published.
+			return p.pageError(pages+1, err)
 		}
 		deviceQuery = next
 	}
@@ -847,6 +1100,97 @@ func (p *oktaInput) publishMarker(ts, eventTime time.Time, inputID string, start
 	client.Publish(event)
 }
 
+// syncSummary holds the outcome of a full synchronization for reporting in
+// the sync summary event.
+type syncSummary struct {
+	start   time.Time
+	users   int
+	devices int
+	groups  map[string]struct{}
+
+	// Metric values at the start of the synchronization, used to
+	// calculate the number of API requests made during it.
+	apiRequests uint64
+	apiRetries  uint64
+}
+
+func (p *oktaInput) newSyncSummary(start time.Time) *syncSummary {
+	return &syncSummary{
+		start:       start,
+		groups:      make(map[string]struct{}),
+		apiRequests: p.metrics.apiRequests.Get(),
+		apiRetries:  p.metrics.apiRetries.Get(),
+	}
+}
+
+func (s *syncSummary) addUser(u *User) {
+	s.users++
+	for _, g := range u.Groups {
+		s.groups[g.ID] = struct{}{}
+	}
+}
+
+// publishSyncSummary will publish a summary of the full synchronization
+// described by s, which ended with the error err, using the given beat.Client.
+func (p *oktaInput) publishSyncSummary(s *syncSummary, err error, inputID string, client beat.Client) {
+	end := time.Now()
+	fields := mapstr.M{}
+	_, _ = fields.Put("labels.identity_source", inputID)
+	_, _ = fields.Put("event.action", "sync-summary")
+	_, _ = fields.Put("event.start", s.start)
+	_, _ = fields.Put("event.end", end)
+	_, _ = fields.Put("event.duration", end.Sub(s.start).Nanoseconds())
+	if err != nil {
+		_, _ = fields.Put("event.outcome", "failure")
+		_, _ = fields.Put("error.message", err.Error())
+	} else {
+		_, _ = fields.Put("event.outcome", "success")
+	}
+	_, _ = fields.Put("okta.sync.users", s.users)
+	_, _ = fields.Put("okta.sync.devices", s.devices)
+	_, _ = fields.Put("okta.sync.groups", len(s.groups))
+	_, _ = fields.Put("okta.sync.api_requests", p.metrics.apiRequests.Get()-s.apiRequests)
+	_, _ = fields.Put("okta.sync.api_retries", p.metrics.apiRetries.Get()-s.apiRetries)
+
+	p.logger.Debug("Publishing sync summary")
+
+	client.Publish(beat.Event{
+		Timestamp: end,
+		Fields:    fields,
+	})
+}
+
+// publishRateLimits will publish the most recently observed API rate limit
+// state of each endpoint using the given beat.Client. Nothing is published
+// if no endpoint reported a current rate limit.
+func (p *oktaInput) publishRateLimits(inputID string, client beat.Client) {
+	state := p.lim.State()
+	if len(state) == 0 {
+		return
+	}
+	limits := make([]mapstr.M, 0, len(state))
+	for _, endpoint := range slices.Sorted(maps.Keys(state)) {
+		l := state[endpoint]
+		limits = append(limits, mapstr.M{
+			"endpoint":  endpoint,
+			"limit":     l.Limit,
+			"remaining": l.Remaining,
+			"reset":     l.Reset,
+		})
+	}
+	fields := mapstr.M{}
+	_, _ = fields.Put("labels.identity_source", inputID)
+	_, _ = fields.Put("event.action", "rate-limits")
+	_, _ = fields.Put("okta.rate_limits", limits)
+
+	p.logger.Debug("Publishing rate limits")
+
+	client.Publish(beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	})
+}
+
 // publishUser will publish a user document using the given beat.Client.
 func (p *oktaInput) publishUser(u *User, state *stateStore, inputID string, client beat.Client, tracker *kvstore.TxTracker) {
 	userDoc := mapstr.M{}
@@ -854,6 +1198,9 @@ func (p *oktaInput) publishUser(u *User, state *stateStore, inputID string, clie
 	_, _ = userDoc.Put("okta", u.User)
 	_, _ = userDoc.Put("labels.identity_source", inputID)
 	_, _ = userDoc.Put("user.id", u.ID)
+	if state := p.cfg.userState(u.Status); state != "" {
+		_, _ = userDoc.Put("user.state", state)
+	}
 	_, _ = userDoc.Put("groups", u.Groups)
 	_, _ = userDoc.Put("roles", u.Roles)
 	_, _ = userDoc.Put("factors", u.Factors)
@@ -888,6 +1235,9 @@ func (p *oktaInput) publishDevice(d *Device, state *stateStore, inputID string,
 	_, _ = devDoc.Put("okta", d.Device)
 	_, _ = devDoc.Put("labels.identity_source", inputID)
 	_, _ = devDoc.Put("device.id", d.ID)
+	if p.cfg.ECSDeviceFields {
+		putECSDeviceFields(devDoc, d.Profile)
+	}
 
 	switch d.State {
 	case Deleted:
@@ -910,6 +1260,60 @@ func (p *oktaInput) publishDevice(d *Device, state *stateStore, inputID string,
 	client.Publish(event)
 }
 
+// ecsDeviceFields maps the hardware attributes of Okta device profiles
+// to the ECS fields they are published under.
+var ecsDeviceFields = []struct {
+	profile, ecs string
+}{
+	{profile: "serialNumber", ecs: "device.serial_number"},
+	{profile: "manufacturer", ecs: "device.manufacturer"},
+	{profile: "model", ecs: "device.model.identifier"},
+	{profile: "platform", ecs: "host.os.platform"},
+	{profile: "osVersion", ecs: "host.os.version"},
+}
+
+// putECSDeviceFields adds the hardware attributes of the device profile to
+// doc under their ECS fields. The raw profile is left in place under okta.
+// Okta reports platforms in upper case, while ECS uses lower case values.
+func putECSDeviceFields(doc mapstr.M, profile map[string]any) {
+	for _, f := range ecsDeviceFields {
+		v, ok := profile[f.profile].(string)
+		if !ok || v == "" {
+			continue
+		}
+		if f.ecs == "host.os.platform" {
+			v = strings.ToLower(v)
+		}
+		_, _ = doc.Put(f.ecs, v)
+	}
+}
+
+// defaultUserStates maps the Okta user status values to normalized user
+// states. See https://developer.okta.com/docs/reference/api/users/#user-status
+// for the meaning of the Okta status values.
+var defaultUserStates = map[string]string{
+	"STAGED":           "pending",
+	"PROVISIONED":      "pending",
+	"ACTIVE":           "active",
+	"RECOVERY":         "active",
+	"PASSWORD_EXPIRED": "active",
+	"LOCKED_OUT":       "locked",
+	"SUSPENDED":        "suspended",
+	"DEPROVISIONED":    "deactivated",
+}
+
+// userState returns the normalized state of users with the Okta status,
+// or an empty string if the status is not mapped.
+func (c *conf) userState(status string) string {
+	status = strings.ToUpper(status)
+	for k, v := range c.UserStateMapping {
+		if strings.ToUpper(k) == status {
+			return v
+		}
+	}
+	return defaultUserStates[status]
+}
+
 // getAuthToken returns the appropriate authentication token for API calls.
 // For OAuth2 authentication, it returns an empty string since the OAuth2 client
 // handles authentication automatically. For API token authentication, it returns
@@ -7,19 +7,27 @@ package okta
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"path"
+	"reflect"
 	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	v2 "github.com/elastic/beats/v7/filebeat/input/v2"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/internal/kvstore"
 	"github.com/elastic/beats/v7/x-pack/filebeat/input/entityanalytics/provider/okta/internal/okta"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/lumberjack"
 )
 
@@ -493,6 +501,836 @@ func TestOktaDoFetchSupervisesEnrichment(t *testing.T) {
 	}
 }
 
+func TestOktaDoFetchPrefetchPages(t *testing.T) {
+	logp.TestingSetup()
+
+	const (
+		window = time.Minute
+		key    = "token"
+		pages  = 4
+		user   = `{"id":"user-%d-%d","status":"ACTIVE","created":"2023-05-14T13:37:20.000Z","activated":"2023-05-14T13:37:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","type":{},"profile":{"email":"user@example.com","login":"user@example.com"}}`
+	)
+
+	fetchUsers := func(t *testing.T, prefetch bool) []string {
+		dbFilename := fmt.Sprintf("TestOktaDoFetchPrefetchPages_%t.db", prefetch)
+		store := testSetupStore(t, dbFilename)
+		t.Cleanup(func() { testCleanupStore(store, dbFilename) })
+
+		// requested[i] is closed when page i has been requested.
+		requested := make([]chan struct{}, pages)
+		for i := range requested {
+			requested[i] = make(chan struct{})
+		}
+
+		setHeaders := func(w http.ResponseWriter) {
+			w.Header().Add("x-rate-limit-limit", "1000")
+			w.Header().Add("x-rate-limit-remaining", "999")
+			w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/api/v1/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			setHeaders(w)
+			page := 0
+			if after := r.URL.Query().Get("after"); after != "" {
+				fmt.Sscan(after, &page)
+			}
+			close(requested[page])
+			if page+1 < pages {
+				w.Header().Add("link", fmt.Sprintf(`<https://localhost/api/v1/users?after=%d>; rel="next"`, page+1))
+			}
+			fmt.Fprintf(w, "["+user+","+user+"]", page, 0, page, 1)
+		}))
+		mux.Handle("/api/v1/users/{userid}/groups", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			setHeaders(w)
+			fmt.Fprintln(w, "[]")
+		}))
+		ts := httptest.NewTLSServer(mux)
+		t.Cleanup(ts.Close)
+
+		u, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatalf("unexpected error parsing server URL: %v", err)
+		}
+
+		a := oktaInput{
+			cfg: conf{
+				OktaDomain:    u.Host,
+				OktaToken:     key,
+				Dataset:       "users",
+				EnrichWith:    []string{"groups"},
+				PrefetchPages: prefetch,
+			},
+			client: ts.Client(),
+			lim:    okta.NewRateLimiter(window, nil),
+			logger: logp.L(),
+		}
+
+		ss, err := newStateStore(store)
+		if err != nil {
+			t.Fatalf("unexpected error making state store: %v", err)
+		}
+		defer ss.close(false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		var got []string
+		err = a.doFetchUsers(ctx, ss, true, func(u *User) {
+			got = append(got, u.ID)
+			if !prefetch {
+				return
+			}
+			var page, idx int
+			fmt.Sscanf(u.ID, "user-%d-%d", &page, &idx)
+			if idx != 0 || page+1 == pages {
+				return
+			}
+			// The next page must be requested while this page is
+			// still being processed.
+			select {
+			case <-requested[page+1]:
+			case <-time.After(10 * time.Second):
+				t.Errorf("page %d was not prefetched while processing page %d", page+1, page)
+			}
+		})
+		if err != nil {
+			t.Fatalf("unexpected error from doFetchUsers: %v", err)
+		}
+		return got
+	}
+
+	serial := fetchUsers(t, false)
+	if len(serial) != 2*pages {
+		t.Fatalf("unexpected number of users from serial enumeration: got %d, want %d", len(serial), 2*pages)
+	}
+	prefetched := fetchUsers(t, true)
+	if !slices.Equal(prefetched, serial) {
+		t.Errorf("unexpected prefetched enumeration:\ngot: %v\nwant:%v", prefetched, serial)
+	}
+}
+
+func TestOktaDoFetchPartialPages(t *testing.T) {
+	logp.TestingSetup()
+
+	const (
+		window = time.Minute
+		key    = "token"
+		pages  = 3
+		failed = 1
+		user   = `{"id":"user-%d","status":"ACTIVE","created":"2023-05-14T13:37:20.000Z","activated":"2023-05-14T13:37:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","type":{},"profile":{"email":"user@example.com","login":"user@example.com"}}`
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("x-rate-limit-limit", "1000")
+		w.Header().Add("x-rate-limit-remaining", "999")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+		page := 0
+		if after := r.URL.Query().Get("after"); after != "" {
+			fmt.Sscan(after, &page)
+		}
+		if page == failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if page+1 < pages {
+			w.Header().Add("link", fmt.Sprintf(`<https://localhost/api/v1/users?after=%d>; rel="next"`, page+1))
+		}
+		fmt.Fprintf(w, "["+user+"]", page)
+	}))
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error parsing server URL: %v", err)
+	}
+
+	for _, policy := range []string{"fail", "partial"} {
+		t.Run(policy, func(t *testing.T) {
+			dbFilename := fmt.Sprintf("TestOktaDoFetchPartialPages_%s.db", policy)
+			store := testSetupStore(t, dbFilename)
+			t.Cleanup(func() { testCleanupStore(store, dbFilename) })
+
+			a := oktaInput{
+				cfg: conf{
+					OktaDomain: u.Host,
+					OktaToken:  key,
+					Dataset:    "users",
+					EnrichWith: []string{"none"},
+					PageErrors: policy,
+				},
+				client: ts.Client(),
+				lim:    okta.NewRateLimiter(window, nil),
+				logger: logp.L(),
+			}
+
+			ss, err := newStateStore(store)
+			if err != nil {
+				t.Fatalf("unexpected error making state store: %v", err)
+			}
+			defer ss.close(false)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			var got []string
+			err = a.doFetchUsers(ctx, ss, true, func(u *User) {
+				got = append(got, u.ID)
+			})
+			if err == nil {
+				t.Fatal("expected error from doFetchUsers")
+			}
+			if isPartial(err) != (policy == "partial") {
+				t.Errorf("unexpected error type for %s policy: %v", policy, err)
+			}
+			if want := []string{"user-0"}; !slices.Equal(got, want) {
+				t.Errorf("unexpected published users: got:%v want:%v", got, want)
+			}
+			if _, ok := ss.users["user-0"]; policy == "partial" && !ok {
+				t.Error("users of the fetched pages were not stored")
+			}
+			if ss.nextUsers != "" {
+				t.Errorf("unexpected next users query after failed page: %q", ss.nextUsers)
+			}
+		})
+	}
+}
+
+func TestOktaSyncSummary(t *testing.T) {
+	logp.TestingSetup()
+
+	const (
+		window     = time.Minute
+		key        = "token"
+		dbFilename = "TestOktaSyncSummary.db"
+		user       = `{"id":"%s","status":"ACTIVE","created":"2023-05-14T13:37:20.000Z","activated":"2023-05-14T13:37:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","type":{},"profile":{"email":"user@example.com","login":"user@example.com"}}`
+		group      = `{"id":"%s","profile":{"name":"%[1]s"}}`
+	)
+	store := testSetupStore(t, dbFilename)
+	t.Cleanup(func() { testCleanupStore(store, dbFilename) })
+
+	groups := map[string][]string{
+		"user1": {"group1", "group2"},
+		"user2": {"group2"},
+	}
+	setHeaders := func(w http.ResponseWriter) {
+		w.Header().Add("x-rate-limit-limit", "1000")
+		w.Header().Add("x-rate-limit-remaining", "999")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		fmt.Fprintf(w, "["+user+","+user+"]", "user1", "user2")
+	}))
+	mux.Handle("/api/v1/users/{userid}/groups", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		var objs []string
+		for _, g := range groups[r.PathValue("userid")] {
+			objs = append(objs, fmt.Sprintf(group, g))
+		}
+		fmt.Fprint(w, "["+strings.Join(objs, ",")+"]")
+	}))
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error parsing server URL: %v", err)
+	}
+
+	metrics := newMetrics(monitoring.NewRegistry(), logp.L())
+	cli := ts.Client()
+	cli.Transport = countingRoundTripper{next: cli.Transport, count: metrics.apiRequests}
+	a := oktaInput{
+		cfg: conf{
+			OktaDomain:  u.Host,
+			OktaToken:   key,
+			Dataset:     "users",
+			EnrichWith:  []string{"groups"},
+			SyncSummary: true,
+		},
+		client:  cli,
+		lim:     okta.NewRateLimiter(window, nil),
+		metrics: metrics,
+		logger:  logp.L(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	inputCtx := v2.Context{ID: "test-okta", Cancelation: ctx}
+
+	var client publishRecorder
+	summary := a.newSyncSummary(time.Now())
+	err = a.runFullSync(inputCtx, store, &client, summary)
+	if err != nil {
+		t.Fatalf("unexpected error from runFullSync: %v", err)
+	}
+	a.publishSyncSummary(summary, err, inputCtx.ID, &client)
+
+	if len(client.events) == 0 {
+		t.Fatal("no events published")
+	}
+	got := client.events[len(client.events)-1].Fields
+	want := map[string]any{
+		"labels.identity_source": "test-okta",
+		"event.action":           "sync-summary",
+		"event.outcome":          "success",
+		"okta.sync.users":        2,
+		"okta.sync.devices":      0,
+		"okta.sync.groups":       2,
+		"okta.sync.api_requests": uint64(3), // One user page and one group page per user.
+		"okta.sync.api_retries":  uint64(0),
+	}
+	for k, v := range want {
+		gotV, err := got.GetValue(k)
+		if err != nil {
+			t.Errorf("missing summary field %s: %v", k, err)
+			continue
+		}
+		if gotV != v {
+			t.Errorf("unexpected value for summary field %s: got %v (%[2]T), want %v (%[3]T)", k, gotV, v)
+		}
+	}
+	if ok, _ := got.HasKey("error.message"); ok {
+		t.Errorf("unexpected error.message in successful sync summary: %v", got)
+	}
+
+	a.publishSyncSummary(a.newSyncSummary(time.Now()), errors.New("sync failed"), inputCtx.ID, &client)
+	got = client.events[len(client.events)-1].Fields
+	if outcome, _ := got.GetValue("event.outcome"); outcome != "failure" {
+		t.Errorf("unexpected outcome for failed sync: got %v, want failure", outcome)
+	}
+	if msg, _ := got.GetValue("error.message"); msg != "sync failed" {
+		t.Errorf("unexpected error.message for failed sync: got %v, want sync failed", msg)
+	}
+}
+
+func TestOktaFullSyncEmitChanged(t *testing.T) {
+	logp.TestingSetup()
+
+	const (
+		window     = time.Minute
+		key        = "token"
+		dbFilename = "TestOktaFullSyncEmitChanged.db"
+		user       = `{"id":"%s","status":"ACTIVE","created":"2023-05-14T13:37:20.000Z","activated":"2023-05-14T13:37:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","type":{},"profile":{"email":"%[1]s@example.com","login":"%[1]s@example.com","title":"%s"}}`
+	)
+	store := testSetupStore(t, dbFilename)
+	t.Cleanup(func() { testCleanupStore(store, dbFilename) })
+
+	// users holds the title of each user returned by the API.
+	var users map[string]string
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("x-rate-limit-limit", "1000")
+		w.Header().Add("x-rate-limit-remaining", "999")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+		var objs []string
+		for _, id := range slices.Sorted(maps.Keys(users)) {
+			objs = append(objs, fmt.Sprintf(user, id, users[id]))
+		}
+		fmt.Fprint(w, "["+strings.Join(objs, ",")+"]")
+	}))
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error parsing server URL: %v", err)
+	}
+
+	a := oktaInput{
+		cfg: conf{
+			OktaDomain:   u.Host,
+			OktaToken:    key,
+			Dataset:      "users",
+			FullSyncEmit: "changed",
+		},
+		client:  ts.Client(),
+		lim:     okta.NewRateLimiter(window, nil),
+		metrics: newMetrics(monitoring.NewRegistry(), logp.L()),
+		logger:  logp.L(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	inputCtx := v2.Context{ID: "test-okta", Cancelation: ctx}
+
+	// sync runs a full sync and returns the action of each published user.
+	sync := func() map[string]string {
+		t.Helper()
+		var client publishRecorder
+		err := a.runFullSync(inputCtx, store, &client, a.newSyncSummary(time.Now()))
+		if err != nil {
+			t.Fatalf("unexpected error from runFullSync: %v", err)
+		}
+		got := make(map[string]string)
+		for _, e := range client.events {
+			id, err := e.Fields.GetValue("user.id")
+			if err != nil {
+				continue // A write marker.
+			}
+			action, _ := e.Fields.GetValue("event.action")
+			got[id.(string)], _ = action.(string)
+		}
+		return got
+	}
+
+	users = map[string]string{"user1": "engineer", "user2": "engineer", "user3": "engineer"}
+	got := sync()
+	want := map[string]string{
+		"user1": "user-discovered",
+		"user2": "user-discovered",
+		"user3": "user-discovered",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected users published by first sync: got %v, want %v", got, want)
+	}
+
+	users = map[string]string{"user1": "engineer", "user2": "manager", "user4": "engineer"}
+	got = sync()
+	want = map[string]string{
+		"user2": "user-modified",
+		"user3": "user-deleted",
+		"user4": "user-discovered",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected users published by second sync: got %v, want %v", got, want)
+	}
+
+	// Nothing changed, so nothing is published.
+	got = sync()
+	if len(got) != 0 {
+		t.Errorf("unexpected users published by unchanged sync: %v", got)
+	}
+}
+
+func TestOktaRateLimitEvent(t *testing.T) {
+	logp.TestingSetup()
+
+	const (
+		window     = time.Minute
+		key        = "token"
+		dbFilename = "TestOktaRateLimitEvent.db"
+		user       = `{"id":"user1","status":"ACTIVE","created":"2023-05-14T13:37:20.000Z","activated":"2023-05-14T13:37:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","type":{},"profile":{"email":"user@example.com","login":"user@example.com"}}`
+	)
+	store := testSetupStore(t, dbFilename)
+	t.Cleanup(func() { testCleanupStore(store, dbFilename) })
+
+	reset := time.Now().Add(time.Minute).Truncate(time.Second)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("x-rate-limit-limit", "600")
+		w.Header().Add("x-rate-limit-remaining", "598")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(reset.Unix()))
+		fmt.Fprint(w, "["+user+"]")
+	}))
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error parsing server URL: %v", err)
+	}
+
+	a := oktaInput{
+		cfg: conf{
+			OktaDomain:     u.Host,
+			OktaToken:      key,
+			Dataset:        "users",
+			RateLimitEvent: true,
+		},
+		client:  ts.Client(),
+		lim:     okta.NewRateLimiter(window, nil),
+		metrics: newMetrics(monitoring.NewRegistry(), logp.L()),
+		logger:  logp.L(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	inputCtx := v2.Context{ID: "test-okta", Cancelation: ctx}
+
+	var client publishRecorder
+	a.publishRateLimits(inputCtx.ID, &client)
+	if len(client.events) != 0 {
+		t.Fatalf("unexpected rate limit event before any request: %v", client.events[0].Fields)
+	}
+
+	err = a.runFullSync(inputCtx, store, &client, a.newSyncSummary(time.Now()))
+	if err != nil {
+		t.Fatalf("unexpected error from runFullSync: %v", err)
+	}
+	a.publishRateLimits(inputCtx.ID, &client)
+
+	got := client.events[len(client.events)-1].Fields
+	if action, _ := got.GetValue("event.action"); action != "rate-limits" {
+		t.Fatalf("unexpected event action: got %v, want rate-limits", action)
+	}
+	if source, _ := got.GetValue("labels.identity_source"); source != "test-okta" {
+		t.Errorf("unexpected identity source: got %v, want test-okta", source)
+	}
+	limits, _ := got.GetValue("okta.rate_limits")
+	want := []mapstr.M{{
+		"endpoint":  "/api/v1/users",
+		"limit":     600.0,
+		"remaining": 598.0,
+		"reset":     time.Unix(reset.Unix(), 0),
+	}}
+	if !reflect.DeepEqual(limits, want) {
+		t.Errorf("unexpected rate limits:\ngot:  %v\nwant: %v", limits, want)
+	}
+}
+
+func TestOktaECSDeviceFields(t *testing.T) {
+	const device = `{"id":"guo4a5uyerdpvAiJT0h7","status":"ACTIVE","created":"2022-05-14T13:37:20.000Z","lastUpdated":"2022-05-14T13:37:20.000Z","profile":{"displayName":"DESKTOP-XXXX","platform":"WINDOWS","manufacturer":"LENOVO","model":"20BH002DUS","osVersion":"10.0.19043","serialNumber":"1XXXX0X0X","registered":true,"secureHardwarePresent":false,"diskEncryptionType":"ALL_INTERNAL_VOLUMES"},"resourceType":"UDDevice","resourceDisplayName":{"value":"DESKTOP-XXXX","sensitive":false},"resourceAlternateId":null,"resourceId":"guo4a5uyerdpvAiJT0h7"}`
+
+	var d Device
+	err := json.Unmarshal([]byte(device), &d.Device)
+	if err != nil {
+		t.Fatalf("unexpected error unmarshaling device: %v", err)
+	}
+	d.State = Discovered
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			a := oktaInput{
+				cfg:    conf{ECSDeviceFields: enabled},
+				logger: logp.NewNopLogger(),
+			}
+			var client publishRecorder
+			a.publishDevice(&d, nil, "test-okta", &client, kvstore.NewTxTracker(context.Background()))
+			if len(client.events) != 1 {
+				t.Fatalf("unexpected number of published events: got %d, want 1", len(client.events))
+			}
+			got := client.events[0].Fields
+
+			want := map[string]any{
+				"device.serial_number":    "1XXXX0X0X",
+				"device.manufacturer":     "LENOVO",
+				"device.model.identifier": "20BH002DUS",
+				"host.os.platform":        "windows",
+				"host.os.version":         "10.0.19043",
+			}
+			for k, v := range want {
+				gotV, err := got.GetValue(k)
+				if !enabled {
+					if err == nil {
+						t.Errorf("unexpected field %s with ECS device fields disabled: %v", k, gotV)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("missing ECS field %s: %v", k, err)
+					continue
+				}
+				if gotV != v {
+					t.Errorf("unexpected value for ECS field %s: got %v, want %v", k, gotV, v)
+				}
+			}
+
+			raw, ok := got["okta"].(okta.Device)
+			if !ok {
+				t.Fatalf("unexpected type for okta field: %T", got["okta"])
+			}
+			if !reflect.DeepEqual(raw.Profile, d.Profile) {
+				t.Errorf("unexpected raw profile: got %v, want %v", raw.Profile, d.Profile)
+			}
+		})
+	}
+}
+
+func TestOktaKeepLinks(t *testing.T) {
+	logp.TestingSetup()
+
+	const (
+		window = time.Minute
+		key    = "token"
+		user   = `{"id":"userid","status":"ACTIVE","created":"2023-05-14T13:37:20.000Z","activated":"2023-05-14T13:37:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","type":{},"profile":{"login":"user@example.com"},"_links":{"self":{"href":"https://localhost/api/v1/users/userid"}}}`
+		device = `{"id":"deviceid","status":"ACTIVE","created":"2019-10-02T18:03:07.000Z","lastUpdated":"2019-10-02T18:03:07.000Z","profile":{"displayName":"Example Device name 1"},"resourceType":"UDDevice","resourceDisplayName":{"value":"Example Device name 1","sensitive":false},"resourceId":"deviceid","_links":{"users":{"href":"https://localhost/api/v1/devices/deviceid/users","hints":{"allow":["GET"]}}}}`
+	)
+
+	setHeaders := func(w http.ResponseWriter) {
+		w.Header().Add("x-rate-limit-limit", "1000")
+		w.Header().Add("x-rate-limit-remaining", "999")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		fmt.Fprint(w, "["+user+"]")
+	}))
+	mux.Handle("/api/v1/devices", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		fmt.Fprint(w, "["+device+"]")
+	}))
+	mux.Handle("/api/v1/devices/{deviceid}/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		fmt.Fprint(w, `[{"user":`+user+`}]`)
+	}))
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error parsing server URL: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		keepLinks       []string
+		wantUserLinks   bool
+		wantDeviceLinks bool
+		wantDevUserLink bool
+	}{
+		{name: "default", keepLinks: nil, wantUserLinks: true, wantDeviceLinks: true, wantDevUserLink: true},
+		{name: "devices", keepLinks: []string{"devices"}, wantUserLinks: false, wantDeviceLinks: true, wantDevUserLink: false},
+		{name: "none", keepLinks: []string{}, wantUserLinks: false, wantDeviceLinks: false, wantDevUserLink: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbFilename := fmt.Sprintf("TestOktaKeepLinks_%s.db", test.name)
+			store := testSetupStore(t, dbFilename)
+			t.Cleanup(func() { testCleanupStore(store, dbFilename) })
+
+			a := oktaInput{
+				cfg: conf{
+					OktaDomain: u.Host,
+					OktaToken:  key,
+					EnrichWith: []string{"none"},
+					KeepLinks:  test.keepLinks,
+				},
+				client: ts.Client(),
+				lim:    okta.NewRateLimiter(window, nil),
+				logger: logp.L(),
+			}
+
+			ss, err := newStateStore(store)
+			if err != nil {
+				t.Fatalf("unexpected error making state store: %v", err)
+			}
+			defer ss.close(false)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			var users []*User
+			err = a.doFetchUsers(ctx, ss, true, func(u *User) {
+				users = append(users, u)
+			})
+			if err != nil {
+				t.Fatalf("unexpected error fetching users: %v", err)
+			}
+			var devices []*Device
+			err = a.doFetchDevices(ctx, ss, true, func(d *Device) {
+				devices = append(devices, d)
+			})
+			if err != nil {
+				t.Fatalf("unexpected error fetching devices: %v", err)
+			}
+			if len(users) != 1 || len(devices) != 1 || len(devices[0].Users) != 1 {
+				t.Fatalf("unexpected number of entities: users=%d devices=%d", len(users), len(devices))
+			}
+
+			if got := users[0].Links != nil; got != test.wantUserLinks {
+				t.Errorf("unexpected user links: got:%v want retained:%t", users[0].Links, test.wantUserLinks)
+			}
+			if got := devices[0].Links != nil; got != test.wantDeviceLinks {
+				t.Errorf("unexpected device links: got:%v want retained:%t", devices[0].Links, test.wantDeviceLinks)
+			}
+			if got := devices[0].Users[0].Links != nil; got != test.wantDevUserLink {
+				t.Errorf("unexpected device user links: got:%v want retained:%t", devices[0].Users[0].Links, test.wantDevUserLink)
+			}
+		})
+	}
+}
+
+func TestOktaOmitRecoveryQuestion(t *testing.T) {
+	logp.TestingSetup()
+
+	const (
+		window = time.Minute
+		key    = "token"
+		user   = `{"id":"userid","status":"ACTIVE","created":"2023-05-14T13:37:20.000Z","activated":"2023-05-14T13:37:20.000Z","lastUpdated":"2023-05-15T01:50:32.000Z","type":{},"profile":{"login":"user@example.com"},"credentials":{"password":{"value":"secret"},"recovery_question":{"question":"Who's a major player in the cowboy scene?","answer":"Annie Oakley"},"provider":{"type":"OKTA","name":"OKTA"}}}`
+		device = `{"id":"deviceid","status":"ACTIVE","created":"2019-10-02T18:03:07.000Z","lastUpdated":"2019-10-02T18:03:07.000Z","profile":{"displayName":"Example Device name 1"},"resourceType":"UDDevice","resourceDisplayName":{"value":"Example Device name 1","sensitive":false},"resourceId":"deviceid"}`
+	)
+
+	setHeaders := func(w http.ResponseWriter) {
+		w.Header().Add("x-rate-limit-limit", "1000")
+		w.Header().Add("x-rate-limit-remaining", "999")
+		w.Header().Add("x-rate-limit-reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		fmt.Fprint(w, "["+user+"]")
+	}))
+	mux.Handle("/api/v1/devices", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		fmt.Fprint(w, "["+device+"]")
+	}))
+	mux.Handle("/api/v1/devices/{deviceid}/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		fmt.Fprint(w, `[{"user":`+user+`}]`)
+	}))
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error parsing server URL: %v", err)
+	}
+
+	for _, omit := range []bool{false, true} {
+		t.Run(fmt.Sprintf("omit_%t", omit), func(t *testing.T) {
+			dbFilename := fmt.Sprintf("TestOktaOmitRecoveryQuestion_%t.db", omit)
+			store := testSetupStore(t, dbFilename)
+			t.Cleanup(func() { testCleanupStore(store, dbFilename) })
+
+			a := oktaInput{
+				cfg: conf{
+					OktaDomain:           u.Host,
+					OktaToken:            key,
+					EnrichWith:           []string{"none"},
+					OmitRecoveryQuestion: omit,
+				},
+				client: ts.Client(),
+				lim:    okta.NewRateLimiter(window, nil),
+				logger: logp.L(),
+			}
+
+			ss, err := newStateStore(store)
+			if err != nil {
+				t.Fatalf("unexpected error making state store: %v", err)
+			}
+			defer ss.close(false)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			var users []*User
+			err = a.doFetchUsers(ctx, ss, true, func(u *User) {
+				users = append(users, u)
+			})
+			if err != nil {
+				t.Fatalf("unexpected error fetching users: %v", err)
+			}
+			var devices []*Device
+			err = a.doFetchDevices(ctx, ss, true, func(d *Device) {
+				devices = append(devices, d)
+			})
+			if err != nil {
+				t.Fatalf("unexpected error fetching devices: %v", err)
+			}
+			if len(users) != 1 || len(devices) != 1 || len(devices[0].Users) != 1 {
+				t.Fatalf("unexpected number of entities: users=%d devices=%d", len(users), len(devices))
+			}
+
+			for name, creds := range map[string]*okta.Credentials{
+				"user":        users[0].Credentials,
+				"device user": devices[0].Users[0].Credentials,
+			} {
+				if creds == nil {
+					t.Fatalf("unexpected nil %s credentials", name)
+				}
+				if got := creds.RecoveryQuestion != nil; got == omit {
+					t.Errorf("unexpected %s recovery question: got:%v want retained:%t", name, creds.RecoveryQuestion, !omit)
+				}
+				if creds.Password == nil || creds.Provider.Type != "OKTA" {
+					t.Errorf("unexpected %s credentials: got:%+v", name, creds)
+				}
+			}
+		})
+	}
+}
+
+func TestOktaUserStates(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		mapping map[string]string
+		want    map[string]string // Okta status to user.state, empty if omitted.
+	}{
+		{
+			name: "default",
+			want: map[string]string{
+				"ACTIVE":           "active",
+				"PASSWORD_EXPIRED": "active",
+				"STAGED":           "pending",
+				"LOCKED_OUT":       "locked",
+				"SUSPENDED":        "suspended",
+				"DEPROVISIONED":    "deactivated",
+				"UNKNOWN":          "",
+			},
+		},
+		{
+			name: "custom",
+			mapping: map[string]string{
+				"suspended":     "disabled",
+				"DEPROVISIONED": "disabled",
+				"LOCKED_OUT":    "",
+				"UNKNOWN":       "other",
+			},
+			want: map[string]string{
+				"ACTIVE":        "active",
+				"SUSPENDED":     "disabled",
+				"DEPROVISIONED": "disabled",
+				"LOCKED_OUT":    "",
+				"UNKNOWN":       "other",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a := oktaInput{
+				cfg:    conf{UserStateMapping: test.mapping},
+				logger: logp.NewNopLogger(),
+			}
+			for status, want := range test.want {
+				u := User{User: okta.User{ID: "userid", Status: status}, State: Discovered}
+				var client publishRecorder
+				a.publishUser(&u, nil, "test-okta", &client, kvstore.NewTxTracker(context.Background()))
+				if len(client.events) != 1 {
+					t.Fatalf("unexpected number of published events: got %d, want 1", len(client.events))
+				}
+				got, err := client.events[0].Fields.GetValue("user.state")
+				if want == "" {
+					if err == nil {
+						t.Errorf("unexpected user.state for status %s: %v", status, got)
+					}
+					continue
+				}
+				if got != want {
+					t.Errorf("unexpected user.state for status %s: got %v, want %s", status, got, want)
+				}
+				raw, ok := client.events[0].Fields["okta"].(okta.User)
+				if !ok || raw.Status != status {
+					t.Errorf("unexpected raw status for status %s: got %v", status, client.events[0].Fields["okta"])
+				}
+			}
+		})
+	}
+}
+
+// publishRecorder is a beat.Client that records published events and
+// acknowledges their transaction trackers.
+type publishRecorder struct {
+	events []beat.Event
+}
+
+func (c *publishRecorder) Publish(e beat.Event) {
+	c.events = append(c.events, e)
+	if t, ok := e.Private.(*kvstore.TxTracker); ok {
+		t.Ack()
+	}
+}
+
+func (c *publishRecorder) PublishAll(events []beat.Event) {
+	for _, e := range events {
+		c.Publish(e)
+	}
+}
+
+func (c *publishRecorder) Close() error { return nil }
+
 func TestAssignSupervises(t *testing.T) {
 	logp.TestingSetup()
 
@@ -685,3 +1523,49 @@ func TestStoreUserExistingPointer(t *testing.T) {
 		t.Errorf("Supervises not persisted: got %+v", reloaded.Supervises)
 	}
 }
+
+func TestOktaRequestHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "[]")
+	}))
+	defer srv.Close()
+
+	cfg := defaultConfig()
+	cfg.Request.UserAgent = "beats-test/1.0"
+	cfg.Request.Headers = map[string]string{
+		"X-Telemetry":   "entity-analytics",
+		"Authorization": "ignored",
+	}
+	log := logp.L()
+	cli, err := newClient(context.Background(), cfg, newMetrics(monitoring.NewRegistry(), log), log)
+	if err != nil {
+		t.Fatalf("unexpected error creating client: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "SSWS token")
+	resp, err := cli.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error sending request: %v", err)
+	}
+	resp.Body.Close()
+
+	if ua := got.Get("User-Agent"); ua != cfg.Request.UserAgent {
+		t.Errorf("unexpected User-Agent: got:%q want:%q", ua, cfg.Request.UserAgent)
+	}
+	if v := got.Get("X-Telemetry"); v != "entity-analytics" {
+		t.Errorf("unexpected X-Telemetry header: got:%q want:%q", v, "entity-analytics")
+	}
+	if v := got.Get("Authorization"); v != "SSWS token" {
+		t.Errorf("configured header replaced request header: got:%q want:%q", v, "SSWS token")
+	}
+	if v := req.Header.Get("User-Agent"); v != "" {
+		t.Errorf("original request was modified: User-Agent:%q", v)
+	}
+}
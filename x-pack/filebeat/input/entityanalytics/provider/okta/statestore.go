@@ -5,6 +5,8 @@
 package okta
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,6 +25,7 @@ var (
 	lastUpdateKey  = []byte("last_update")
 	usersLinkKey   = []byte("users_link")
 	devicesLinkKey = []byte("devices_link")
+	rateLimitsKey  = []byte("rate_limits")
 )
 
 //go:generate stringer -type State
@@ -43,11 +46,56 @@ type User struct {
 	Devices    []okta.Device         `json:"devices"`
 	Supervises []okta.SupervisedUser `json:"supervises"`
 	State      State                 `json:"state"`
+
+	// Hash is the content hash of the user when it was last
+	// published.
+	Hash string `json:"hash,omitempty"`
+}
+
+// updateHash sets the content hash of the user, and returns whether it
+// differs from the hash the user was last published with.
+func (u *User) updateHash() bool {
+	h := contentHash(struct {
+		User       okta.User
+		Groups     []okta.Group
+		Roles      []okta.Role
+		Factors    []okta.Factor
+		Devices    []okta.Device
+		Supervises []okta.SupervisedUser
+	}{u.User, u.Groups, u.Roles, u.Factors, u.Devices, u.Supervises})
+	changed := h != u.Hash
+	u.Hash = h
+	return changed
 }
 
 type Device struct {
 	okta.Device `json:"properties"`
 	State       State `json:"state"`
+
+	// Hash is the content hash of the device when it was last
+	// published.
+	Hash string `json:"hash,omitempty"`
+}
+
+// updateHash sets the content hash of the device, and returns whether it
+// differs from the hash the device was last published with.
+func (d *Device) updateHash() bool {
+	h := contentHash(d.Device)
+	changed := h != d.Hash
+	d.Hash = h
+	return changed
+}
+
+// contentHash returns the hex encoded SHA-256 hash of the JSON encoding
+// of v. Map keys are sorted by encoding/json, so equal values have equal
+// hashes. An empty string is returned if v can't be encoded.
+func contentHash(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
 }
 
 // stateStore wraps a kvstore.Transaction and provides convenience methods for
@@ -136,11 +184,12 @@ func newStateStore(store *kvstore.Store) (*stateStore, error) {
 
 // storeUser stores a user. If the user does not exist in the store, then the
 // user will be marked as discovered. Otherwise, the user will be marked
-// as modified.
+// as modified, and keeps the content hash it was last published with.
 func (s *stateStore) storeUser(u okta.User) *User {
 	su := User{User: u}
 	if existing, ok := s.users[u.ID]; ok {
 		su.State = Modified
+		su.Hash = existing.Hash
 		*existing = su
 		return existing
 	}
@@ -150,20 +199,59 @@ func (s *stateStore) storeUser(u okta.User) *User {
 }
 
 // storeDevice stores a device. If the device does not exist in the store, then the
-// device will be marked as discovered. Otherwise, the user will be marked
-// as modified.
+// device will be marked as discovered. Otherwise, the device will be marked
+// as modified, and keeps the content hash it was last published with.
 func (s *stateStore) storeDevice(d okta.Device) *Device {
 	du := Device{Device: d}
 	if existing, ok := s.devices[d.ID]; ok {
 		du.State = Modified
+		du.Hash = existing.Hash
 		*existing = du
-	} else {
-		du.State = Discovered
-		s.devices[d.ID] = &du
+		return existing
 	}
+	du.State = Discovered
+	s.devices[d.ID] = &du
 	return &du
 }
 
+// deleteUsers removes the users whose IDs are not in keep from the store,
+// and returns them marked as deleted.
+func (s *stateStore) deleteUsers(keep map[string]bool) ([]*User, error) {
+	var deleted []*User
+	for id, u := range s.users {
+		if keep[id] {
+			continue
+		}
+		err := s.tx.Delete(usersBucket, []byte(id))
+		if err != nil {
+			return deleted, fmt.Errorf("unable to delete user %q from state: %w", id, err)
+		}
+		delete(s.users, id)
+		u.State = Deleted
+		deleted = append(deleted, u)
+	}
+	return deleted, nil
+}
+
+// deleteDevices removes the devices whose IDs are not in keep from the
+// store, and returns them marked as deleted.
+func (s *stateStore) deleteDevices(keep map[string]bool) ([]*Device, error) {
+	var deleted []*Device
+	for id, d := range s.devices {
+		if keep[id] {
+			continue
+		}
+		err := s.tx.Delete(devicesBucket, []byte(id))
+		if err != nil {
+			return deleted, fmt.Errorf("unable to delete device %q from state: %w", id, err)
+		}
+		delete(s.devices, id)
+		d.State = Deleted
+		deleted = append(deleted, d)
+	}
+	return deleted, nil
+}
+
 // close will close out the stateStore. If commit is true, the staged values on the
 // stateStore will be set in the kvstore database, and the transaction will be
 // committed. Otherwise, all changes will be discarded and the transaction will
@@ -249,6 +337,24 @@ func getLastUpdate(store *kvstore.Store) (time.Time, error) {
 	return t, err
 }
 
+// getRateLimits retrieves the persisted API rate limit state from the kvstore
+// database. If the value doesn't exist, a nil map is returned.
+func getRateLimits(store *kvstore.Store) (map[string]okta.EndpointLimit, error) {
+	var limits map[string]okta.EndpointLimit
+	err := store.RunTransaction(false, func(tx *kvstore.Transaction) error {
+		return tx.Get(stateBucket, rateLimitsKey, &limits)
+	})
+
+	return limits, err
+}
+
+// setRateLimits stores the API rate limit state in the kvstore database.
+func setRateLimits(store *kvstore.Store, limits map[string]okta.EndpointLimit) error {
+	return store.RunTransaction(true, func(tx *kvstore.Transaction) error {
+		return tx.Set(stateBucket, rateLimitsKey, limits)
+	})
+}
+
 // errIsItemNotFound returns true if the error represents an item not found
 // error (bucket not found or key not found).
 func errIsItemNotFound(err error) bool {
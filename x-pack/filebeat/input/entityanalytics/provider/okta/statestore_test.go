@@ -252,6 +252,35 @@ func TestStateStore(t *testing.T) {
 			t.Errorf("unexpected result from getLastUpdate: got:%v want:%v", got, lastUpdate)
 		}
 	})
+
+	t.Run("rate_limits", func(t *testing.T) {
+		dbFilename := "TestRateLimits.db"
+		store := testSetupStore(t, dbFilename)
+		t.Cleanup(func() {
+			testCleanupStore(store, dbFilename)
+		})
+
+		_, err := getRateLimits(store)
+		if !errIsItemNotFound(err) {
+			t.Errorf("unexpected error from getRateLimits on empty store: %v", err)
+		}
+
+		want := map[string]okta.EndpointLimit{
+			"/api/v1/users": {Limit: 600, Remaining: 0, Reset: time.Now().Add(time.Minute).Truncate(time.Second)},
+		}
+		err = setRateLimits(store, want)
+		if err != nil {
+			t.Fatalf("unexpected error from setRateLimits: %v", err)
+		}
+
+		got, err := getRateLimits(store)
+		if err != nil {
+			t.Errorf("unexpected error from getRateLimits: %v", err)
+		}
+		if !cmp.Equal(want, got) {
+			t.Errorf("unexpected result:\n- want\n+ got\n%s", cmp.Diff(want, got))
+		}
+	})
 }
 
 func TestErrIsItemFound(t *testing.T) {
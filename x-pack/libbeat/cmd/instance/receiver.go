@@ -83,10 +83,12 @@ func NewBeatReceiver(ctx context.Context, b *instance.Beat, creator beat.Creator
 		retryer := backoff.NewRetryer(50, 100*time.Millisecond, 1*time.Second)
 		err := retryer.Retry(ctx, func() error {
 			var err error
-			b.API, err = api.NewWithDefaultRoutes(
+			b.API, err = api.NewWithDefaultRoutesAndInfo(
 				b.Info.Logger.Named("metrics.http"),
 				b.Config.HTTP,
-				b.Monitoring)
+				b.Monitoring,
+				b.Info.Beat,
+				b.Info.Version)
 			if err != nil {
 				return fmt.Errorf("could not start the HTTP server for the API: %w", err)
 			}
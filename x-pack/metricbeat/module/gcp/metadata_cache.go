@@ -15,6 +15,7 @@ import (
 	"google.golang.org/api/sqladmin/v1"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 // Cache represents a generic cache with metadata and mutex
@@ -24,6 +25,13 @@ type Cache[T any] struct {
 	refreshInterval time.Duration
 	lock            sync.Mutex
 	logger          *logp.Logger
+	metrics         *refreshMetrics
+}
+
+// refreshMetrics reports the outcome of cache refresh attempts.
+type refreshMetrics struct {
+	fetchErrors    *monitoring.Uint   // number of failed refresh attempts
+	lastFetchError *monitoring.String // error from the last refresh attempt, empty on success
 }
 
 func (c *Cache[T]) isExpired() bool {
@@ -55,6 +63,7 @@ func (c *Cache[T]) EnsureFresh(refreshFunc func() (map[string]T, error)) error {
 
 	c.logger.Debug("cache expired, refreshing data...")
 	newData, err := refreshFunc()
+	c.reportRefresh(err)
 	if err != nil {
 		return fmt.Errorf("failed to refresh cache data; calling refreshFunc failed: %w", err)
 	}
@@ -68,6 +77,36 @@ func (c *Cache[T]) EnsureFresh(refreshFunc func() (map[string]T, error)) error {
 	return nil
 }
 
+// SetMetricsRegistry makes the cache report the outcome of each refresh
+// attempt to reg as the fetch_errors counter and the last_fetch_error string.
+// If reg is nil, refreshes are not reported.
+func (c *Cache[T]) SetMetricsRegistry(reg *monitoring.Registry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if reg == nil {
+		c.metrics = nil
+		return
+	}
+	c.metrics = &refreshMetrics{
+		fetchErrors:    monitoring.NewUint(reg, "fetch_errors"),
+		lastFetchError: monitoring.NewString(reg, "last_fetch_error"),
+	}
+}
+
+// reportRefresh records the result of a refresh attempt. c.lock must be held.
+func (c *Cache[T]) reportRefresh(err error) {
+	if c.metrics == nil {
+		return
+	}
+	if err != nil {
+		c.metrics.fetchErrors.Inc()
+		c.metrics.lastFetchError.Set(err.Error())
+		return
+	}
+	c.metrics.lastFetchError.Set("")
+}
+
 // NewCache creates a new cache instance with the given refresh interval
 func NewCache[T any](logger *logp.Logger, refreshInterval time.Duration) *Cache[T] {
 	return &Cache[T]{
@@ -17,6 +17,7 @@ import (
 	"google.golang.org/api/sqladmin/v1"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 func TestNewCache(t *testing.T) {
@@ -109,6 +110,39 @@ func TestCache_EnsureFresh_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "refresh failed")
 }
 
+func TestCache_EnsureFresh_Metrics(t *testing.T) {
+	logger := logp.NewLogger("test")
+	cache := NewCache[string](logger, 0)
+	reg := monitoring.NewRegistry()
+	cache.SetMetricsRegistry(reg)
+
+	fetchErrors := func() uint64 {
+		return reg.Get("fetch_errors").(*monitoring.Uint).Get()
+	}
+	lastFetchError := func() string {
+		return reg.Get("last_fetch_error").(*monitoring.String).Get()
+	}
+
+	failing := func() (map[string]string, error) {
+		return nil, errors.New("list instances failed")
+	}
+	succeeding := func() (map[string]string, error) {
+		return map[string]string{"key": "value"}, nil
+	}
+
+	require.Error(t, cache.EnsureFresh(failing))
+	assert.Equal(t, uint64(1), fetchErrors())
+	assert.Equal(t, "list instances failed", lastFetchError())
+
+	require.Error(t, cache.EnsureFresh(failing))
+	assert.Equal(t, uint64(2), fetchErrors())
+	assert.Equal(t, "list instances failed", lastFetchError())
+
+	require.NoError(t, cache.EnsureFresh(succeeding))
+	assert.Equal(t, uint64(2), fetchErrors())
+	assert.Empty(t, lastFetchError())
+}
+
 func TestCache_EnsureFresh_UpdatesData(t *testing.T) {
 	logger := logp.NewLogger("test")
 	cache := NewCache[string](logger, 100*time.Millisecond)
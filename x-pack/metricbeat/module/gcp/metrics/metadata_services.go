@@ -22,6 +22,7 @@ func NewMetadataServiceForConfig(
 	c config,
 	serviceName string,
 	cacheRegistry *gcp.CacheRegistry,
+	redisMetrics *redis.Metrics,
 	logger *logp.Logger,
 ) (gcp.MetadataService, error) {
 	switch serviceName {
@@ -30,7 +31,7 @@ func NewMetadataServiceForConfig(
 	case gcp.ServiceCloudSQL:
 		return cloudsql.NewMetadataService(ctx, c.ProjectID, c.Zone, c.Region, c.Regions, c.organizationID, c.organizationName, c.projectName, cacheRegistry, logger, c.opt...)
 	case gcp.ServiceRedis:
-		return redis.NewMetadataService(ctx, c.ProjectID, c.Zone, c.Region, c.Regions, c.organizationID, c.organizationName, c.projectName, cacheRegistry, logger, c.opt...)
+		return redis.NewMetadataService(ctx, c.ProjectID, c.Zone, c.Region, c.Regions, c.organizationID, c.organizationName, c.projectName, c.NormalizeRedisMachineType, c.EnrichRedisMetadata, cacheRegistry, redisMetrics, logger, c.opt...)
 	case gcp.ServiceDataproc:
 		return dataproc.NewMetadataService(ctx, c.ProjectID, c.Regions, c.organizationID, c.organizationName, c.projectName, c.CollectDataprocUserLabels, cacheRegistry, logger, c.opt...)
 	default:
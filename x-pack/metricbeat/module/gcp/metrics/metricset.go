@@ -22,6 +22,7 @@ import (
 
 	"github.com/elastic/beats/v7/metricbeat/mb"
 	"github.com/elastic/beats/v7/x-pack/metricbeat/module/gcp"
+	"github.com/elastic/beats/v7/x-pack/metricbeat/module/gcp/metrics/redis"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 )
 
@@ -49,6 +50,7 @@ type MetricSet struct {
 	requester             *metricsRequester
 	MetricsConfig         []metricsConfig `config:"metrics" validate:"nonzero,required"`
 	metadataCacheRegistry *gcp.CacheRegistry
+	redisMetrics          *redis.Metrics
 }
 
 // metricsConfig holds a configuration specific for metrics metricset.
@@ -110,6 +112,8 @@ type config struct {
 	CredentialsJSON            string        `config:"credentials_json"`
 	Endpoint                   string        `config:"endpoint"`
 	CollectDataprocUserLabels  bool          `config:"collect_dataproc_user_labels"`
+	NormalizeRedisMachineType  bool          `config:"normalize_redis_machine_type"`
+	EnrichRedisMetadata        bool          `config:"enrich_redis_metadata"`
 	MetadataCache              bool          `config:"metadata_cache"`
 	MetadataCacheRefreshPeriod time.Duration `config:"metadata_cache_refresh_period"`
 
@@ -123,7 +127,10 @@ type config struct {
 // New creates a new instance of the MetricSet. New is responsible for unpacking
 // any MetricSet specific configuration options if there are any.
 func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
-	m := &MetricSet{BaseMetricSet: base}
+	m := &MetricSet{
+		BaseMetricSet: base,
+		config:        config{EnrichRedisMetadata: true},
+	}
 
 	if err := base.Module().UnpackConfig(&m.config); err != nil {
 		return nil, err
@@ -199,6 +206,11 @@ func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
 	}
 
 	m.metadataCacheRegistry = gcp.NewCacheRegistry(m.Logger(), metadataCacheRefreshPeriod)
+	if reg := base.Metrics(); reg != nil {
+		redisReg := reg.GetOrCreateRegistry("metadata.redis")
+		m.metadataCacheRegistry.Redis.SetMetricsRegistry(redisReg)
+		m.redisMetrics = redis.NewMetrics(redisReg)
+	}
 
 	m.Logger().Warn("extra charges on Google Cloud API requests will be generated by this metricset")
 	return m, nil
@@ -252,7 +264,7 @@ func (m *MetricSet) mapToEvents(ctx context.Context, timeSeries []timeSeriesWith
 	var err error
 
 	if !m.config.ExcludeLabels {
-		if metadataService, err = NewMetadataServiceForConfig(ctx, m.config, sdc.ServiceName, m.metadataCacheRegistry, m.Logger()); err != nil {
+		if metadataService, err = NewMetadataServiceForConfig(ctx, m.config, sdc.ServiceName, m.metadataCacheRegistry, m.redisMetrics, m.Logger()); err != nil {
 			return nil, fmt.Errorf("error trying to create metadata service: %w", err)
 		}
 	}
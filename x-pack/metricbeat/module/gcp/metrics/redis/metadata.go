@@ -8,6 +8,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -20,28 +21,53 @@ import (
 	"github.com/elastic/beats/v7/libbeat/common/backoff"
 	"github.com/elastic/beats/v7/x-pack/metricbeat/module/gcp"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
-// NewMetadataService returns the specific Metadata service for a GCP Redis resource
+// Metrics reports on the enrichment of Redis metrics with instance metadata.
+type Metrics struct {
+	outOfRegion *monitoring.Uint // number of metric points for instances outside the monitored regions
+}
+
+// NewMetrics registers the Redis metadata metrics in reg.
+func NewMetrics(reg *monitoring.Registry) *Metrics {
+	return &Metrics{
+		outOfRegion: monitoring.NewUint(reg, "out_of_region_points"),
+	}
+}
+
+// NewMetadataService returns the specific Metadata service for a GCP Redis resource.
+// If enrich is false, the instances are not fetched with the ListInstances API and
+// the metrics only have the labels of their time series.
 func NewMetadataService(
 	ctx context.Context,
 	projectID, zone, region string,
 	regions []string,
 	organizationID, organizationName, projectName string,
+	normalizeMachineType bool,
+	enrich bool,
 	cacheRegistry *gcp.CacheRegistry,
+	metrics *Metrics,
 	logger *logp.Logger,
 	opt ...option.ClientOption) (gcp.MetadataService, error) {
 	mc := &metadataCollector{
-		projectID:        projectID,
-		projectName:      projectName,
-		organizationID:   organizationID,
-		organizationName: organizationName,
-		zone:             zone,
-		region:           region,
-		regions:          regions,
-		opt:              opt,
-		instanceCache:    cacheRegistry.Redis,
-		logger:           logger.Named("metrics-redis"),
+		projectID:            projectID,
+		projectName:          projectName,
+		organizationID:       organizationID,
+		organizationName:     organizationName,
+		zone:                 zone,
+		region:               region,
+		regions:              regions,
+		normalizeMachineType: normalizeMachineType,
+		enrich:               enrich,
+		opt:                  opt,
+		instanceCache:        cacheRegistry.Redis,
+		metrics:              metrics,
+		logger:               logger.Named("metrics-redis"),
+	}
+
+	if !enrich {
+		return mc, nil
 	}
 
 	// Freshen up the cache, later all we have to do is look up the instance
@@ -83,16 +109,24 @@ type metadataCollector struct {
 	zone             string
 	region           string
 	regions          []string
-	opt              []option.ClientOption
-	instanceCache    *gcp.Cache[*redispb.Instance]
-	logger           *logp.Logger
+	// enrich adds the name, tier and user labels of the instances to their metrics.
+	enrich bool
+	// normalizeMachineType maps Redis tiers to stable machine type values.
+	normalizeMachineType bool
+	opt                  []option.ClientOption
+	instanceCache        *gcp.Cache[*redispb.Instance]
+	metrics              *Metrics
+	logger               *logp.Logger
 }
 
 // Metadata implements googlecloud.MetadataCollector to the known set of labels from a Redis TimeSeries single point of data.
 func (s *metadataCollector) Metadata(ctx context.Context, resp *monitoringpb.TimeSeries) (gcp.MetadataCollectorData, error) {
-	metadata, err := s.instanceMetadata(ctx, s.instanceID(resp), s.instanceRegion(resp))
-	if err != nil {
-		return gcp.MetadataCollectorData{}, err
+	region := s.instanceRegion(resp)
+	if !s.monitoredRegion(region) {
+		s.logger.Debugf("Metrics for instance %s in region %s, which is not a monitored region.", s.instanceID(resp), region)
+		if s.metrics != nil {
+			s.metrics.outOfRegion.Inc()
+		}
 	}
 
 	stackdriverLabels := gcp.NewStackdriverMetadataServiceForTimeSeries(resp, s.organizationID, s.organizationName, s.projectName)
@@ -106,11 +140,19 @@ func (s *metadataCollector) Metadata(ctx context.Context, resp *monitoringpb.Tim
 		_, _ = metadataCollectorData.ECS.Put(gcp.ECSCloudInstanceIDKey, resp.Resource.Labels[gcp.TimeSeriesResponsePathForECSInstanceID])
 	}
 
+	if !s.enrich {
+		return metadataCollectorData, nil
+	}
+
+	metadata, err := s.instanceMetadata(ctx, s.instanceID(resp), region)
+	if err != nil {
+		return gcp.MetadataCollectorData{}, err
+	}
+
 	_, _ = metadataCollectorData.ECS.Put(gcp.ECSCloudInstanceNameKey, metadata.instanceName)
 
-	if metadata.machineType != "" {
-		lastIndex := strings.LastIndex(metadata.machineType, "/")
-		_, _ = metadataCollectorData.ECS.Put(gcp.ECSCloudMachineTypeKey, metadata.machineType[lastIndex+1:])
+	if machineType := s.machineType(metadata.machineType); machineType != "" {
+		_, _ = metadataCollectorData.ECS.Put(gcp.ECSCloudMachineTypeKey, machineType)
 	}
 
 	metadata.Metrics = metadataCollectorData.Labels[gcp.LabelMetrics]
@@ -123,6 +165,37 @@ func (s *metadataCollector) Metadata(ctx context.Context, resp *monitoringpb.Tim
 	return metadataCollectorData, nil
 }
 
+// machineType returns the machine type value for the tier of an instance,
+// which may be given either as a URL or as a plain tier name.
+func (s *metadataCollector) machineType(tier string) string {
+	machineType := tier[strings.LastIndex(tier, "/")+1:]
+	if s.normalizeMachineType {
+		return normalizeTier(machineType)
+	}
+	return machineType
+}
+
+// tierMachineTypes maps the Redis instance tiers to the values reported as
+// the machine type when normalization is enabled.
+var tierMachineTypes = map[string]string{
+	redispb.Instance_BASIC.String():       "basic",
+	redispb.Instance_STANDARD_HA.String(): "standard_ha",
+}
+
+// normalizeTier returns the machine type value for a Redis tier name. An
+// unspecified tier yields an empty string, and unknown tiers are lower cased
+// so that the value doesn't depend on the casing used by the API.
+func normalizeTier(tier string) string {
+	tier = strings.ToUpper(strings.TrimSpace(tier))
+	if machineType, ok := tierMachineTypes[tier]; ok {
+		return machineType
+	}
+	if tier == redispb.Instance_TIER_UNSPECIFIED.String() {
+		return ""
+	}
+	return strings.ToLower(tier)
+}
+
 // instanceMetadata returns the labels of an instance
 func (s *metadataCollector) instanceMetadata(ctx context.Context, instanceID, region string) (*redisMetadata, error) {
 	metadata := &redisMetadata{
@@ -168,6 +241,15 @@ func (s *metadataCollector) instanceRegion(ts *monitoringpb.TimeSeries) string {
 	return ""
 }
 
+// monitoredRegion reports whether region is one of the configured regions.
+// If no region is configured, all regions are monitored.
+func (s *metadataCollector) monitoredRegion(region string) bool {
+	if s.region == "" && len(s.regions) == 0 {
+		return true
+	}
+	return region == s.region || slices.Contains(s.regions, region)
+}
+
 func (s *metadataCollector) fetchRedisInstances(ctx context.Context) (map[string]*redispb.Instance, error) {
 	s.logger.Debug("get redis instances with ListInstances API")
 
@@ -5,13 +5,26 @@
 package redis
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"cloud.google.com/go/redis/apiv1/redispb"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
 	"google.golang.org/genproto/googleapis/api/metric"
 	"google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/elastic/beats/v7/x-pack/metricbeat/module/gcp"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	libmonitoring "github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 var fake = &monitoring.TimeSeries{
@@ -76,3 +89,117 @@ func TestInstanceRegion(t *testing.T) {
 	zone := m.instanceRegion(fake)
 	assert.Equal(t, "us-central1", zone)
 }
+
+func TestMachineType(t *testing.T) {
+	tests := map[string]struct {
+		tier      string
+		normalize bool
+		want      string
+	}{
+		"plain tier":                  {tier: "STANDARD_HA", want: "STANDARD_HA"},
+		"url tier":                    {tier: "projects/p/locations/us-central1/tiers/STANDARD_HA", want: "STANDARD_HA"},
+		"normalized plain tier":       {tier: "STANDARD_HA", normalize: true, want: "standard_ha"},
+		"normalized url tier":         {tier: "projects/p/locations/us-central1/tiers/STANDARD_HA", normalize: true, want: "standard_ha"},
+		"normalized basic":            {tier: "BASIC", normalize: true, want: "basic"},
+		"normalized lower case":       {tier: "basic", normalize: true, want: "basic"},
+		"normalized unspecified tier": {tier: "TIER_UNSPECIFIED", normalize: true, want: ""},
+		"normalized unknown tier":     {tier: "tiers/ENTERPRISE", normalize: true, want: "enterprise"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mc := &metadataCollector{normalizeMachineType: tc.normalize}
+			assert.Equal(t, tc.want, mc.machineType(tc.tier))
+		})
+	}
+}
+
+func TestMetadataOutOfRegion(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	tests := map[string]struct {
+		region  string
+		regions []string
+		want    uint64
+	}{
+		"no configured region":    {want: 0},
+		"monitored region":        {regions: []string{"us-east1", "us-central1"}, want: 0},
+		"monitored single region": {region: "us-central1", want: 0},
+		"unmonitored region":      {regions: []string{"us-east1", "europe-west1"}, want: 1},
+		"unmonitored single":      {region: "us-east1", want: 1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mc := &metadataCollector{
+				projectID:     "projectID",
+				region:        tc.region,
+				regions:       tc.regions,
+				enrich:        true,
+				instanceCache: gcp.NewCache[*redispb.Instance](logger, time.Hour),
+				metrics:       NewMetrics(libmonitoring.NewRegistry()),
+				logger:        logger,
+			}
+
+			data, err := mc.Metadata(context.Background(), fake)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, mc.metrics.outOfRegion.Get())
+
+			// The instance is not known, so the metadata is not enriched
+			// with its name and user labels.
+			name, err := data.ECS.GetValue(gcp.ECSCloudInstanceNameKey)
+			require.NoError(t, err)
+			assert.Empty(t, name)
+			assert.NotContains(t, data.Labels, gcp.LabelUser)
+		})
+	}
+}
+
+func TestMetadataEnrichDisabled(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+
+	// Count the Redis API calls and fail them instead of sending them.
+	var calls atomic.Int32
+	opts := []option.ClientOption{
+		option.WithoutAuthentication(),
+		option.WithEndpoint("localhost:1"),
+		option.WithGRPCDialOption(grpc.WithUnaryInterceptor(
+			func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				calls.Add(1)
+				return errors.New("unexpected API call")
+			},
+		)),
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		calls.Store(0)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := NewMetadataService(ctx, "projectID", "", "", nil, "", "", "", false, true, gcp.NewCacheRegistry(logger, 0), nil, logger, opts...)
+		assert.Error(t, err)
+		assert.NotZero(t, calls.Load(), "the instances must be fetched")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		calls.Store(0)
+		cacheRegistry := gcp.NewCacheRegistry(logger, 0)
+		err := cacheRegistry.Redis.EnsureFresh(func() (map[string]*redispb.Instance, error) {
+			return map[string]*redispb.Instance{
+				"4624337448093162893": {Name: "projects/p/locations/us-central1/instances/redis-1", Labels: map[string]string{"team": "a"}},
+			}, nil
+		})
+		require.NoError(t, err)
+
+		mc, err := NewMetadataService(context.Background(), "projectID", "", "", nil, "", "", "", false, false, cacheRegistry, nil, logger, opts...)
+		require.NoError(t, err)
+
+		data, err := mc.Metadata(context.Background(), fake)
+		require.NoError(t, err)
+		assert.Zero(t, calls.Load())
+
+		id, err := data.ECS.GetValue(gcp.ECSCloudInstanceIDKey)
+		require.NoError(t, err)
+		assert.Equal(t, "4624337448093162893", id)
+		_, err = data.ECS.GetValue(gcp.ECSCloudInstanceNameKey)
+		assert.Error(t, err, "the instance name comes from the instance metadata")
+		assert.NotContains(t, data.Labels, gcp.LabelUser)
+		assert.Equal(t, mapstr.M{"region": "us-central1"}, data.Labels[gcp.LabelResource])
+	})
+}